@@ -0,0 +1,88 @@
+package freecache
+
+import "unsafe"
+
+// frequencySketch is a count-min sketch of the access frequency of the keys of a segment, used
+// by the TinyLFU admission filter and the LFU eviction policy. Keys are identified by the low 32 bits of their hash, which
+// are also stored in the entry headers, so the frequency of an old entry can be estimated too.
+// The counters are halved periodically, so the frequencies reflect the recent accesses.
+type frequencySketch struct {
+	rows      [4][]uint8
+	mask      uint32
+	additions int
+	resetAt   int
+}
+
+func newFrequencySketch(segSize int) *frequencySketch {
+	width := 64
+	for width < segSize/256 {
+		width *= 2
+	}
+	fs := &frequencySketch{mask: uint32(width - 1), resetAt: width * 10}
+	for i := range fs.rows {
+		fs.rows[i] = make([]uint8, width)
+	}
+	return fs
+}
+
+func (fs *frequencySketch) idx(h uint32, i int) uint32 {
+	// mix the hash for every row, since the low 8 bits are the same in a segment.
+	h ^= h >> 8
+	h *= 0x9e3779b1 + uint32(i)*0x85ebca76
+	return (h >> 16) & fs.mask
+}
+
+func (fs *frequencySketch) increment(h uint32) {
+	for i := range fs.rows {
+		if c := &fs.rows[i][fs.idx(h, i)]; *c < 255 {
+			*c++
+		}
+	}
+	fs.additions++
+	if fs.additions >= fs.resetAt {
+		for i := range fs.rows {
+			for j := range fs.rows[i] {
+				fs.rows[i][j] >>= 1
+			}
+		}
+		fs.additions /= 2
+	}
+}
+
+// average returns the average counter value.
+func (fs *frequencySketch) average() float64 {
+	return float64(fs.additions) / float64(fs.mask+1)
+}
+
+func (fs *frequencySketch) frequency(h uint32) uint8 {
+	freq := uint8(255)
+	for i := range fs.rows {
+		freq = min(freq, fs.rows[i][fs.idx(h, i)])
+	}
+	return freq
+}
+
+// admit reports whether a new entry with the freqHash h should be inserted, when
+// inserting it evicts the oldest entry in the ring buffer. The entry is rejected if the key
+// is accessed less frequently than the key of the oldest entry.
+func (seg *segment) admit(h uint32, now uint32) bool {
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	seg.rb.ReadAt(hdrBuf[:], seg.rb.End()+seg.vacuumLen-seg.rb.Size())
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	if hdr.deleted() || hdr.expireAt != 0 && hdr.expireAt <= now {
+		return true
+	}
+	return seg.freq.frequency(h) >= seg.freq.frequency(seg.shortHash(hdr))
+}
+
+// freqHash returns the key of an entry in the frequency sketch, the low 32 bits of the hash
+// without the low 8 bits, which are the segment with 256 segments, and are not stored in the
+// header of the entry with fewer.
+func freqHash(hashVal uint64) uint32 {
+	return uint32(hashVal) &^ 0xff
+}
+
+// shortHash returns the freqHash of an entry from its slot and hash16.
+func (seg *segment) shortHash(hdr *entryHdr) uint32 {
+	return uint32(hdr.slotId)<<8 | uint32(hdr.hash16)<<16
+}
@@ -0,0 +1,142 @@
+package freecache
+
+import (
+	"slices"
+)
+
+// batchSize is the number of journal records or loaded entries set under one acquisition of the locks.
+const batchSize = 1024
+
+// Entry is an entry set by SetMulti.
+type Entry struct {
+	Key           []byte
+	Value         []byte
+	ExpireSeconds int
+}
+
+// batchEntry is an encoded entry of a batch.
+type batchEntry struct {
+	key           []byte
+	value         []byte
+	hashVal       uint64
+	expireSeconds int
+}
+
+// SetMulti sets the entries like Set, but locks each segment once for all the entries it holds
+// instead of once per entry, which is much faster for loading many entries, e.g. warming up a
+// cache. The entries of a key are set in order, so a key that appears twice gets its last value,
+// except that the values that are chunked are set first. All the entries are tried, the first
+// error is returned.
+func (cache *Cache) SetMulti(entries []Entry) (err error) {
+	_, err = cache.setMulti(entries)
+	return
+}
+
+// setMulti is SetMulti, it also returns the number of entries set.
+func (cache *Cache) setMulti(entries []Entry) (n int, err error) {
+	seeds := cache.seeds.Load()
+	batch := make([]batchEntry, 0, len(entries))
+	tunables := cache.tunables.Load()
+	for _, entry := range entries {
+		var setErr error
+		// while the seed is rotated an entry is moved by Set, which deletes it at the old position.
+		if seeds.old != nil {
+			setErr = cache.Set(entry.Key, entry.Value, entry.ExpireSeconds)
+		} else {
+			key, value := cache.encodeEntry(entry.Key, entry.Value)
+			expireSeconds, tunErr := tunables.expireSeconds(entry.ExpireSeconds)
+			switch {
+			// a chunked value is not batched, its chunks are set one by one.
+			case cache.config.ChunkLargeValues && len(key)+len(value) > cache.maxKeyValLen():
+				setErr = cache.setChunked(key, len(value), &chunkSource{value: value}, entry.ExpireSeconds, -1, 0, 0)
+			case tunErr != nil:
+				cache.countError(tunErr)
+				setErr = tunErr
+			default:
+				batch = append(batch, batchEntry{key: key, value: value, hashVal: seeds.cur.sipHash(key), expireSeconds: expireSeconds})
+				continue
+			}
+		}
+		if setErr == nil {
+			n++
+		} else if err == nil {
+			err = setErr
+		}
+	}
+	batchN, setErr := cache.setBatch(batch, true)
+	if err == nil {
+		err = setErr
+	}
+	return n + batchN, err
+}
+
+// setBatch sets the batch of encoded entries, and locks each segment once. The entries are
+// written to the journal if journal is true. The number of entries set and the first error are returned.
+func (cache *Cache) setBatch(batch []batchEntry, journal bool) (n int, err error) {
+	// a stable sort keeps the order of the entries of a key.
+	slices.SortStableFunc(batch, func(a, b batchEntry) int {
+		return int(a.hashVal&cache.segMask) - int(b.hashVal&cache.segMask)
+	})
+	var dropped [][2][]byte
+	var large []batchEntry
+	for start := 0; start < len(batch); {
+		segId := batch[start].hashVal & cache.segMask
+		end := start + 1
+		for end < len(batch) && batch[end].hashVal&cache.segMask == segId {
+			end++
+		}
+		seg := &cache.segments[segId]
+		cache.locks[segId].Lock()
+		for _, entry := range batch[start:end] {
+			var old []byte
+			if cache.config.ChunkLargeValues {
+				old = seg.manifest(entry.key, entry.hashVal)
+			}
+			setErr := cache.guarded(segId, func() error {
+				return seg.set(entry.key, entry.value, entry.hashVal, entry.expireSeconds, -1, 0, 0)
+			})
+			switch {
+			case setErr == nil:
+				n++
+				if old != nil {
+					dropped = append(dropped, [2][]byte{entry.key, old})
+				}
+				if journal && cache.logged() {
+					var expireAt uint32
+					if entry.expireSeconds > 0 {
+						expireAt = cache.now() + uint32(entry.expireSeconds)
+					}
+					cache.log(journalSet, entry.key, entry.value, expireAt)
+				}
+			case setErr == ErrLargeEntry && cache.config.Overflow != nil:
+				large = append(large, entry)
+			default:
+				cache.countError(setErr)
+				if err == nil {
+					err = setErr
+				}
+			}
+		}
+		cache.unlock(segId)
+		if cache.hotKeys != nil {
+			for _, entry := range batch[start:end] {
+				cache.hotKeys.record(entry.key, entry.hashVal)
+			}
+		}
+		start = end
+	}
+	for _, entry := range large {
+		if setErr := cache.overflowSet(entry.key, entry.value, entry.hashVal, entry.expireSeconds); setErr == nil {
+			n++
+		} else {
+			cache.countError(setErr)
+			if err == nil {
+				err = setErr
+			}
+		}
+	}
+	for _, drop := range dropped {
+		cache.dropChunks(drop[0], drop[1])
+	}
+	return
+}
@@ -0,0 +1,114 @@
+package freecache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// bloomHashes is the number of bits set for a key, it gives about 1% false positives at 10 bits per key.
+const bloomHashes = 7
+
+// bloomFilter is a bloom filter over the keys set in the cache, the bits are set and read atomically.
+type bloomFilter struct {
+	bits []atomic.Uint64
+}
+
+func newBloomFilter(keys int) *bloomFilter {
+	return &bloomFilter{bits: make([]atomic.Uint64, (keys*10+63)/64)}
+}
+
+// positions calls fn with the bits of the hash of a key, by double hashing.
+func (bf *bloomFilter) positions(hash uint64, fn func(word int, mask uint64) bool) {
+	nbits := uint64(len(bf.bits)) * 64
+	h1, h2 := hash&0xffffffff, hash>>32|1
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % nbits
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return
+		}
+	}
+}
+
+func (bf *bloomFilter) add(hash uint64) {
+	bf.positions(hash, func(word int, mask uint64) bool {
+		if bf.bits[word].Load()&mask == 0 {
+			bf.bits[word].Or(mask)
+		}
+		return true
+	})
+}
+
+func (bf *bloomFilter) mayContain(hash uint64) (found bool) {
+	found = true
+	bf.positions(hash, func(word int, mask uint64) bool {
+		found = bf.bits[word].Load()&mask != 0
+		return found
+	})
+	return
+}
+
+// bloomState is the bloom filter of a cache, shared by its segments, see Config.BloomFilter.
+type bloomState struct {
+	seed hashSeed // the keys are hashed with a seed of their own, which RotateHashSeed doesn't change.
+	keys int
+	cur  atomic.Pointer[bloomFilter]
+	next atomic.Pointer[bloomFilter] // the filter built by RebuildBloomFilter, nil if not rebuilding.
+	mu   sync.Mutex                  // serializes RebuildBloomFilter.
+}
+
+func newBloomState(keys int) *bloomState {
+	bs := &bloomState{seed: newHashSeed(), keys: keys}
+	bs.cur.Store(newBloomFilter(keys))
+	return bs
+}
+
+func (bs *bloomState) add(key []byte) {
+	hash := bs.seed.sipHash(key)
+	bs.cur.Load().add(hash)
+	if next := bs.next.Load(); next != nil {
+		next.add(hash)
+	}
+}
+
+// MayContain reports whether key may be in the cache, false means it is definitely not, without
+// locking any segment. It requires Config.BloomFilter, and is always true without it.
+// The filter has every key set since the cache is created or RebuildBloomFilter, so the
+// false positives grow as the keys change.
+func (cache *Cache) MayContain(key []byte) bool {
+	if cache.bloom == nil {
+		return true
+	}
+	bs := cache.bloom
+	return bs.cur.Load().mayContain(bs.seed.sipHash(cache.entryKey(key)))
+}
+
+// RebuildBloomFilter replaces the bloom filter with one of the keys of the live entries, so the
+// keys that are deleted, expired or evicted are not found any more. Each segment is locked in turn.
+func (cache *Cache) RebuildBloomFilter() {
+	if cache.bloom == nil {
+		return
+	}
+	cache.bloom.mu.Lock()
+	defer cache.bloom.mu.Unlock()
+	next := newBloomFilter(cache.bloom.keys)
+	// the keys set while the filter is rebuilt are added to both filters.
+	cache.bloom.next.Store(next)
+	cache.fillBloomFilter(next)
+	cache.bloom.cur.Store(next)
+	cache.bloom.next.Store(nil)
+}
+
+// fillBloomFilter adds the keys of the live entries to bf.
+func (cache *Cache) fillBloomFilter(bf *bloomFilter) {
+	now := cache.now()
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		cache.segments[i].iterateAll(now, func(key, value []byte, hdr *entryHdr) bool {
+			if !hdr.negative() {
+				bf.add(cache.bloom.seed.sipHash(key))
+			}
+			return true
+		})
+		cache.locks[i].Unlock()
+	}
+}
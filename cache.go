@@ -1,24 +1,254 @@
 package freecache
 
 import (
+	"crypto/cipher"
+	"errors"
+	"math/bits"
+	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
+	"unsafe"
 )
 
+var ErrShortTTL = errors.New("The expire seconds is shorter than the minimum TTL")
+var ErrResizeUnsupported = errors.New("Memory-mapped cache can not be resized")
+var ErrInvalidFlags = errors.New("The flags are larger than MaxFlags")
+
+// MaxFlags is the largest flags value of SetWithFlags.
+const MaxFlags = 1<<(8-userFlagsShift) - 1
+
 type Cache struct {
-	locks     [256]sync.Mutex
-	segments  [256]segment
-	hitCount  int64
-	missCount int64
+	locks         []segLock
+	segments      []segment
+	counters      []segCounters
+	segMask       uint64 // the segment of a hash is hashVal & segMask.
+	config        Config
+	tunables      atomic.Pointer[Tunables]
+	seeds         atomic.Pointer[seedState]
+	closeOnce     sync.Once
+	closeChan     chan struct{}
+	mmap          *mmapState // not nil if the ring buffers are in a memory-mapped file.
+	segmentResets int64
+	classIds      map[string]uint8 // TTL class names to the class stored in entries.
+	latency       atomic.Pointer[LatencyObserver]
+	hotKeys       *hotKeys      // nil if hot key tracking is disabled.
+	segSize       atomic.Int64  // the size of the ring buffer of a segment, it is changed by Resize.
+	hugePages     atomic.Bool   // the kernel accepted the advice to use huge pages.
+	versions      atomic.Uint64 // the last version of an entry, see Config.Versions.
+	watermark     *watermark    // nil if OnHighWatermark is not set.
+	resizeMu      sync.Mutex    // serializes Resize and the memory limit loop.
+	capacity      atomic.Int64  // the size set by NewCache or Resize, see Config.MemoryLimitInterval.
+	bloom         *bloomState   // nil if Config.BloomFilter is zero.
+	hasEvents     atomic.Bool   // Events has been called, the mutations are sent to eventChans.
+	eventsMu      sync.RWMutex
+	eventChans    []chan Event // closed by Close.
+	droppedEvents atomic.Int64
+	refreshing    sync.Map // the keys being loaded by Config.Loader.
+	// errorCounts is indexed by countedErrors.
+	errorCounts [len(countedErrors)]int64
+}
+
+// Config holds the optional settings of a cache.
+// The zero value is a valid config that disables all optional behavior.
+type Config struct {
+	// ExpireInterval is how often the background expirer runs,
+	// zero means no background expirer, expired entries are only
+	// removed when they are accessed or evacuated.
+	ExpireInterval time.Duration
+	// ExpireBudget is the max number of entries the background expirer
+	// examines in a segment in one cycle, it bounds the time a segment is locked.
+	// Defaults to 64.
+	ExpireBudget int
+	// Tunables can be changed later by Reconfigure.
+	Tunables
+	// TimerWheel enables a timer wheel in every segment that tracks upcoming expirations,
+	// expired entries are removed within a second after they expire, and ExpiringWithin can be used.
+	// It costs 16 to 32 bytes of memory for every entry that has an expire time.
+	TimerWheel bool
+	// OnExpire is called with a copy of every expired entry that is removed from the cache.
+	// It is called without holding any lock, so it is safe to access the cache in it.
+	OnExpire func(key, value []byte)
+	// StaleSeconds keeps the expired entries for StaleSeconds more, GetStale returns them with stale
+	// set during that time, zero means expired entries are not kept. The other lookups don't find
+	// them, they are removed, and passed to OnExpire, at the end of the stale period.
+	StaleSeconds int
+	// Loader loads the value and the expire seconds of a key, GetStale calls it in a goroutine
+	// to refresh a stale value, nil means stale values are not refreshed.
+	Loader func(key []byte) (value []byte, expireSeconds int, err error)
+	// RefreshAheadSeconds makes an entry found by a lookup less than RefreshAheadSeconds before it
+	// expires reloaded by Loader in a goroutine, so the keys that are read often don't expire, zero
+	// means entries are not refreshed ahead. Chunked values and the lookups of SharedReads, which
+	// don't change the segment, are not refreshed ahead.
+	RefreshAheadSeconds int
+	// Journal logs every Set, Del and Clear of the cache, nil means no journal.
+	Journal *Journal
+	// JournalCompactInterval is how often the journal is compacted, zero means
+	// it is only compacted by calling CompactJournal.
+	JournalCompactInterval time.Duration
+	// Replicator streams every Set, Del and Clear of the cache to peer caches, nil means no replication.
+	Replicator *Replicator
+	// BloomFilter is the number of distinct keys the bloom filter of MayContain is sized for, at
+	// 10 bits per key, zero means no bloom filter. The filter adds a hash of the key to every Set.
+	BloomFilter int
+	// Invalidator publishes the keys set or deleted in the cache to the caches of other instances and
+	// deletes the keys published by them, nil means the cache is not kept coherent with others.
+	Invalidator Invalidator
+	// Alignment aligns the start offset of every value in the ring buffers, so values can be
+	// cast to structs or handed to DMA without misaligned access. It must be a power of two
+	// not larger than 4096, zero or one means no alignment. Every entry is padded to the alignment.
+	Alignment int
+	// SelfHeal rebuilds a segment, dropping its entries, when it is found corrupted by an invariant
+	// violation or a panic, while the other segments keep serving. The operation that finds it
+	// returns ErrCorrupted.
+	SelfHeal bool
+	// OnSegmentReset is called when a corrupted segment is rebuilt, without holding any lock.
+	OnSegmentReset func(segId int, reason error)
+	// TTLClasses declares the TTL classes used by SetWithClass, at most MaxTTLClasses.
+	TTLClasses []TTLClass
+	// HotKeys is the number of the most frequently accessed keys tracked for Cache.HotKeys,
+	// zero disables the tracking.
+	HotKeys int
+	// HotKeySampleRate samples one in HotKeySampleRate Get and Set calls for the hot key tracking,
+	// zero or one samples every call.
+	HotKeySampleRate int
+	// Admission enables the TinyLFU admission filter: when a new entry would evict the oldest
+	// entry of a full segment, it is rejected with ErrNotAdmitted if its key has been accessed
+	// less frequently than the key of the oldest entry, so keys accessed once don't evict
+	// frequently used entries. Overwriting an existing entry is always admitted.
+	Admission bool
+	// EvictionPolicy decides which old entries are evicted to make room for new entries,
+	// nil means EvictLRU.
+	EvictionPolicy EvictionPolicy
+	// StrictLRU evicts exactly the least recently used entry of a segment, instead of the approximation
+	// of the eviction policy, at the cost of evacuating more entries: when room is needed, the oldest
+	// entries in the ring buffer are evacuated until the least recently used entry is the oldest.
+	// It can not be used with WideFingerprint, EvictionPolicy is ignored.
+	StrictLRU bool
+	// MaxEntries limits the number of entries in addition to the size, zero means no limit.
+	// Each segment holds at most its share of MaxEntries rounded up, setting a new entry into a full
+	// segment evicts old entries like a full ring buffer does.
+	MaxEntries int
+	// MaxEntrySize is the largest total length of the key and the value of an entry, larger entries
+	// are rejected with ErrLargeEntry. It is limited by the size of a segment, 1/256 of the cache size with the default Segments.
+	// Zero means 1/1024 of the cache size.
+	MaxEntrySize int
+	// ChunkLargeValues splits a value that is too large for an entry into chunks stored as separate
+	// entries, and reassembles it on Get, instead of rejecting it with ErrLargeEntry. A chunked value
+	// is not found if any of its chunks has been evicted. Values larger than 1/16 of the cache are still
+	// rejected. Chunked values are only supported by Set and the Set variants that hash the key, Get
+	// and Del, they are skipped by Scan, ToMap, FreezeCompact and journal compaction.
+	ChunkLargeValues bool
+	// ReuseFreeSpace writes a new entry over a deleted or expired entry of a similar size, instead of
+	// making room at the head of the ring buffer, so deletes don't cause old entries to be evacuated
+	// or evicted. An entry written over a deleted one is near the tail of the ring buffer, it relies on
+	// the eviction policy to be evacuated rather than evicted, which EvictFIFO never does.
+	ReuseFreeSpace bool
+	// Overflow is a secondary store for the entries that are too large for the cache and the
+	// evicted entries, nil means they are dropped. With ChunkLargeValues, large values are chunked
+	// instead, and chunked values are not moved to the store when a chunk is evicted.
+	Overflow OverflowStore
+	// Storage creates the Store of a segment from data, the memory allocated for it on the heap or
+	// in the memory-mapped file, nil means a RingBuf. A cache with another Store can not be resized,
+	// saved by SaveTo, or persisted by NewMmapCache, and it can not be used with SharedReads.
+	Storage func(data []byte) Store
+	// Compressor compresses the values of at least CompressThreshold bytes, nil means values are not
+	// compressed. Every value is stored with a leading byte that tells whether it is compressed, so a
+	// journal, snapshot or memory-mapped file must be loaded by a cache with the same Compressor, and
+	// the values in the overflow store are compressed as well.
+	Compressor Compressor
+	// CompressThreshold is the smallest value compressed by Compressor, zero means 256 bytes.
+	CompressThreshold int
+	// Encryption encrypts the values with an AEAD, see NewAESGCM, nil means values are stored in
+	// plaintext. The key of an entry is authenticated but not encrypted. Values are encrypted in the
+	// ring buffers, and so in snapshots, memory-mapped files, the journal and the overflow store,
+	// which must be loaded by a cache with the same AEAD. FreezeCompact copies decrypted values.
+	Encryption cipher.AEAD
+	// Checksum stores the CRC32 of the key and the value with every value, and verifies it when the
+	// value is read, a value that doesn't match is not returned, Get returns ErrCorrupted. It costs 4
+	// bytes per entry, and the journal, snapshots and memory-mapped files hold the checksums as well.
+	Checksum bool
+	// LongKeys allows keys longer than 65535 bytes, instead of rejecting them with ErrLargeKey. The
+	// entry of a long key is stored under the SHA-256 of the key, and the key is stored after the
+	// value, so Get compares the whole key. The journal and the overflow store see the digest.
+	LongKeys bool
+	// Clock tells the time for the expire times and the access times of entries, nil means the
+	// system clock at the creation of the cache advanced by the monotonic clock, so steps of the
+	// system clock don't expire entries early or keep them late. A FakeClock makes expiration
+	// deterministic in tests.
+	Clock Clock
+	// WallClock makes the default clock read the system clock, so the expire times follow steps of
+	// it, which keeps them comparable between processes that persist the cache by snapshots, the
+	// journal or a memory-mapped file, when the clocks of the processes have drifted apart.
+	WallClock bool
+	// ClockResolution replaces the system clock by a clock that is updated in the background at this
+	// interval, which saves the system call of reading the time in every operation. The resolution
+	// should be well below a second, since expire times are in seconds. It is ignored if Clock is set.
+	ClockResolution time.Duration
+	// WideFingerprint compares 32 more bits of the hash before comparing the key in lookups,
+	// it reduces the full key comparisons of colliding entries when there are many entries per slot.
+	WideFingerprint bool
+	// SharedReads makes Get lock a segment for reading, so Gets of one segment run in parallel, and
+	// only the operations that modify it lock it exclusively. The price is that Get doesn't record
+	// the access: the eviction policy sees an entry as recent as its last Set or evacuation, expired
+	// entries are removed by eviction or the expirer, and the hits of TTL classes and the age histogram
+	// are not counted. It can not be used with StrictLRU, Admission or EvictLFU, which count accesses.
+	SharedReads bool
+	// Segments is the number of segments, each has its own lock and ring buffer, it must be a power
+	// of two not larger than 256. Zero means 256, AutoSegments picks it from GOMAXPROCS: fewer
+	// segments cost less memory and make room for larger entries, more segments contend less.
+	Segments int
+	// HugePages advises the kernel to back the ring buffers with transparent huge pages, which
+	// reduces TLB misses for large caches. It is only supported on Linux, Stats.HugePages tells
+	// whether the kernel accepted the advice.
+	HugePages bool
+	// Versions gives every entry set a new version, which is returned by GetWithVersion and checked
+	// by SetIfVersion. The version takes 8 bytes of every entry. It can not be used with
+	// ChunkLargeValues.
+	Versions bool
+	// HighWatermark is the fraction of the ring buffers used by entries, including deleted entries not
+	// yet reclaimed, at which OnHighWatermark is called, e.g. 0.9. It must be in (0, 1] if
+	// OnHighWatermark is set.
+	HighWatermark float64
+	// OnHighWatermark is called with above true when the used bytes of the cache reach HighWatermark of
+	// its size, so an application can shed load or scale up before evictions hurt the hit rate, and with
+	// above false when they fall below it again, after Del, Compact or Clear for example. It is called
+	// without holding any lock by the operation that crossed the watermark, the calls of operations of
+	// different segments may overlap.
+	OnHighWatermark func(above bool, used, size int64)
+	// MemoryLimitInterval enables the adaptive mode: at this interval, the memory used by the Go
+	// runtime is compared with the soft memory limit, GOMEMLIMIT or debug.SetMemoryLimit, and the cache
+	// is shrunk by Resize while the memory used exceeds MemoryPressure of the limit, down to 1/8 of its
+	// size. It grows back to its size when the pressure subsides. Zero disables it, and it is ignored
+	// without a memory limit, for memory-mapped caches and with Storage, which can not be resized.
+	MemoryLimitInterval time.Duration
+	// MemoryPressure is the fraction of the memory limit above which the adaptive mode shrinks the
+	// cache, zero means 0.9.
+	MemoryPressure float64
 }
 
-func fnvaHash(data []byte) uint64 {
-	var hash uint64 = 14695981039346656037
-	for _, c := range data {
-		hash ^= uint64(c)
-		hash *= 1099511628211
+// AutoSegments is the Segments of a config that picks the number of segments from GOMAXPROCS.
+const AutoSegments = -1
+
+// segmentCount returns the number of segments of config. Automatically, there are 4 segments per
+// GOMAXPROCS rounded up to a power of two, and at least 16. The hash has 8 bits for the segment,
+// so there are at most 256.
+func segmentCount(config Config) int {
+	n := config.Segments
+	switch {
+	case n == 0:
+		return 256
+	case n == AutoSegments:
+		n = 1 << bits.Len(uint(4*runtime.GOMAXPROCS(0)-1))
+		return min(max(n, 16), 256)
+	case n < 0 || n > 256 || n&(n-1) != 0:
+		panic("freecache: Segments must be a power of two not larger than 256")
 	}
-	return hash
+	return n
+}
+
+func (cache *Cache) hash(key []byte) uint64 {
+	return cache.seeds.Load().cur.sipHash(key)
 }
 
 // The cache size will be set to 512KB at minimum.
@@ -26,72 +256,661 @@ func fnvaHash(data []byte) uint64 {
 // `debug.SetGCPercent()`, set it to a much smaller value
 // to limit the memory consumption and GC pause time.
 func NewCache(size int) (cache *Cache) {
+	return NewCacheWithConfig(size, Config{})
+}
+
+// NewCacheWithConfig creates a cache with optional settings.
+// Call Close to stop the background goroutines when the cache is no longer used.
+func NewCacheWithConfig(size int, config Config) (cache *Cache) {
+	return newCache(make([]byte, bufferSize(size, config)), config)
+}
+
+// bufferSize returns the total size of the ring buffers for the cache size, which is
+// at least 512KB, and rounded so that every segment is a multiple of the alignment.
+func bufferSize(size int, config Config) int {
 	if size < 512*1024 {
 		size = 512 * 1024
 	}
+	align := config.Alignment
+	if align <= 0 {
+		align = 1
+	}
+	if align > 4096 || align&(align-1) != 0 {
+		panic("freecache: Alignment must be a power of two not larger than 4096")
+	}
+	n := segmentCount(config)
+	return size / (n * align) * (n * align)
+}
+
+// newCache creates a cache that uses data as the memory of its ring buffers.
+func newCache(data []byte, config Config) (cache *Cache) {
+	cache = buildCache(config)
+	cache.initSegments(data)
+	cache.start()
+	return
+}
+
+// buildCache creates a cache of config without segments, initSegments or the loading of the
+// segments follows, then start.
+func buildCache(config Config) (cache *Cache) {
+	if config.ExpireBudget <= 0 {
+		config.ExpireBudget = 64
+	}
+	if err := config.Tunables.validate(); err != nil {
+		panic("freecache: invalid tunables in config")
+	}
+	if config.StrictLRU && config.WideFingerprint {
+		panic("freecache: StrictLRU can not be used with WideFingerprint")
+	}
+	if config.Versions && config.ChunkLargeValues {
+		panic("freecache: Versions can not be used with ChunkLargeValues")
+	}
+	if config.SharedReads && config.Storage != nil {
+		panic("freecache: SharedReads can not be used with Storage")
+	}
+	if config.SharedReads && (config.StrictLRU || config.Admission || config.EvictionPolicy == EvictLFU) {
+		panic("freecache: SharedReads can not be used with StrictLRU, Admission or EvictLFU")
+	}
+	if config.OnHighWatermark != nil && (config.HighWatermark <= 0 || config.HighWatermark > 1) {
+		panic("freecache: HighWatermark must be in (0, 1]")
+	}
+	if config.MemoryPressure < 0 || config.MemoryPressure > 1 {
+		panic("freecache: MemoryPressure must be in [0, 1]")
+	}
+	if config.MemoryPressure == 0 {
+		config.MemoryPressure = 0.9
+	}
+	config.Segments = segmentCount(config)
 	cache = new(Cache)
-	for i := 0; i < 256; i++ {
-		cache.segments[i] = newSegment(size/256, i)
+	cache.locks = make([]segLock, config.Segments)
+	cache.segments = make([]segment, config.Segments)
+	cache.counters = make([]segCounters, config.Segments)
+	cache.segMask = uint64(config.Segments - 1)
+	cache.versions.Store(uint64(time.Now().UnixNano()))
+	cache.seeds.Store(&seedState{cur: newHashSeed()})
+	cache.config = config
+	cache.tunables.Store(&config.Tunables)
+	cache.closeChan = make(chan struct{})
+	if config.Clock == nil {
+		cache.config.Clock = monotonicClock{start: time.Now()}
+		if config.WallClock {
+			cache.config.Clock = systemClock{}
+		}
+		if config.ClockResolution > 0 {
+			cache.config.Clock = newCoarseClock(cache.config.Clock, config.ClockResolution, cache.closeChan)
+		}
+	}
+	cache.classIds = ttlClassIds(config.TTLClasses)
+	if config.HotKeys > 0 {
+		cache.hotKeys = newHotKeys(config.HotKeys, config.HotKeySampleRate)
+	}
+	if config.BloomFilter > 0 {
+		cache.bloom = newBloomState(config.BloomFilter)
+	}
+	if config.OnHighWatermark != nil {
+		cache.watermark = &watermark{segUsed: make([]int64, config.Segments)}
 	}
 	return
 }
 
-// If the key is larger than 65535 or value is larger than 1/1024 of the cache size,
-// the entry will not be written to the cache. expireSeconds <= 0 means no expire,
+// initSegments initializes the segments with data divided evenly as their ring buffers.
+func (cache *Cache) initSegments(data []byte) {
+	if cache.config.HugePages {
+		cache.adviseHugePages(data)
+	}
+	segSize := len(data) / len(cache.segments)
+	cache.segSize.Store(int64(segSize))
+	cache.capacity.Store(int64(len(data)))
+	for i := 0; i < len(cache.segments); i++ {
+		cache.initSegment(i, data[i*segSize:(i+1)*segSize:(i+1)*segSize])
+	}
+}
+
+// start starts the background goroutines and subscribes to the invalidator, once the segments
+// are ready.
+func (cache *Cache) start() {
+	config := cache.config
+	if config.ExpireInterval > 0 {
+		go cache.expireLoop(config.ExpireInterval, config.ExpireBudget)
+	}
+	if config.TimerWheel {
+		go cache.wheelLoop()
+	}
+	if config.Invalidator != nil {
+		config.Invalidator.Subscribe(cache.invalidate)
+	}
+	if config.Journal != nil && config.JournalCompactInterval > 0 {
+		go cache.journalLoop(config.JournalCompactInterval)
+	}
+	if config.MemoryLimitInterval > 0 && config.Storage == nil {
+		go cache.memoryLimitLoop(config.MemoryLimitInterval)
+	}
+}
+
+func (cache *Cache) initSegment(segId int, data []byte) {
+	seg := newSegment(data, segId)
+	if cache.config.Storage != nil {
+		seg.rb.store = cache.config.Storage(data)
+	}
+	if cache.config.TimerWheel {
+		seg.wheel = newTimerWheel(cache.now())
+	}
+	seg.clock = cache.config.Clock
+	seg.counters = &cache.counters[segId]
+	events := cache.hasEvents.Load()
+	seg.keepExpired = cache.config.OnExpire != nil || cache.config.Overflow != nil || events
+	seg.keepEvicts = cache.config.Overflow != nil || events
+	seg.stale = uint32(cache.config.StaleSeconds)
+	if cache.config.Loader != nil {
+		seg.refreshAhead = uint32(cache.config.RefreshAheadSeconds)
+	}
+	seg.wideFp = cache.config.WideFingerprint
+	if cache.config.Versions {
+		seg.versions = &cache.versions
+	}
+	seg.maxEntrySize = cache.config.MaxEntrySize
+	seg.bloom = cache.bloom
+	seg.admission = cache.config.Admission
+	seg.policy = cache.config.EvictionPolicy
+	if seg.policy == nil {
+		seg.policy = EvictLRU
+	}
+	if cache.config.StrictLRU {
+		seg.lru = newLRUList()
+	}
+	if cache.config.ReuseFreeSpace {
+		seg.holes = make([]hole, 0, maxHoles)
+	}
+	if cache.config.MaxEntries > 0 {
+		n := len(cache.segments)
+		seg.maxEntries = int64((cache.config.MaxEntries + n - 1) / n)
+	}
+	if seg.admission || seg.policy == EvictLFU {
+		seg.freq = newFrequencySketch(len(data))
+	}
+	if cache.config.Alignment > 1 {
+		seg.align = int64(cache.config.Alignment)
+	}
+	tunables := cache.tunables.Load()
+	seg.setOccupancyTarget(tunables.OccupancyTarget)
+	seg.detailed = tunables.Instrumentation == InstrumentDetailed
+	cache.segments[segId] = seg
+}
+
+// unlock unlocks the segment, then calls the OnExpire callback for the expired entries
+// removed while the segment was locked, the OnSegmentReset callback if it was rebuilt, and
+// OnHighWatermark if the cache crossed the watermark. The evicted entries are moved to the overflow store,
+// and the entries found close to their expiration are refreshed.
+func (cache *Cache) unlock(segId uint64) {
+	seg := &cache.segments[segId]
+	if debugChecks {
+		seg.checkInvariants()
+	}
+	expired, evicted, refreshes := seg.expired, seg.evicted, seg.refreshes
+	seg.expired, seg.evicted, seg.refreshes = nil, nil, nil
+	resetReason := seg.resetReason
+	seg.resetReason = nil
+	seg.report = nil
+	var crossed, above bool
+	var used, size int64
+	if cache.watermark != nil {
+		crossed, above, used, size = cache.updateWatermark(segId)
+	}
+	cache.locks[segId].Unlock()
+	if cache.config.OnExpire != nil {
+		for _, entry := range expired {
+			if key, value, err := cache.decodeEntry(entry.key, entry.value); err == nil {
+				cache.config.OnExpire(key, value)
+			}
+		}
+	}
+	if cache.config.Overflow != nil {
+		cache.spill(evicted, expired)
+	}
+	if cache.hasEvents.Load() {
+		cache.sendEntryEvents(EventEvict, evicted)
+		cache.sendEntryEvents(EventExpire, expired)
+	}
+	cache.refreshAhead(refreshes)
+	if resetReason != nil && cache.config.OnSegmentReset != nil {
+		cache.config.OnSegmentReset(int(segId), resetReason)
+	}
+	if crossed {
+		cache.config.OnHighWatermark(above, used, size)
+	}
+}
+
+// Resize changes the size of the cache online, the oldest entries are evicted if they
+// don't fit in the new size. Memory-mapped caches can not be resized.
+func (cache *Cache) Resize(newSize int) error {
+	if cache.mmap != nil {
+		return ErrResizeUnsupported
+	}
+	if cache.config.Storage != nil {
+		return ErrUnsupportedStore
+	}
+	cache.resizeMu.Lock()
+	defer cache.resizeMu.Unlock()
+	cache.capacity.Store(int64(bufferSize(newSize, cache.config)))
+	cache.resize(newSize)
+	return nil
+}
+
+// resize changes the size of the cache, resizeMu must be locked.
+func (cache *Cache) resize(newSize int) {
+	data := make([]byte, bufferSize(newSize, cache.config))
+	if cache.config.HugePages {
+		cache.adviseHugePages(data)
+	}
+	segSize := len(data) / len(cache.segments)
+	cache.segSize.Store(int64(segSize))
+	now := cache.now()
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+		cache.segments[i].resize(data[i*segSize:(i+1)*segSize:(i+1)*segSize], now)
+		cache.unlock(uint64(i))
+	}
+}
+
+// Size returns the size of the ring buffers, it is changed by Resize and by the adaptive
+// mode of Config.MemoryLimitInterval.
+func (cache *Cache) Size() int {
+	return int(cache.segSize.Load()) * len(cache.segments)
+}
+
+// Close stops the background goroutines of the cache and closes the Events channels, the cache can still be used after Close,
+// unless it is created by NewMmapCache.
+func (cache *Cache) Close() (err error) {
+	cache.closeOnce.Do(func() {
+		close(cache.closeChan)
+		cache.closeEvents()
+		if cache.mmap != nil {
+			err = cache.closeMmap()
+		}
+	})
+	return
+}
+
+// If the key is larger than 65535, unless LongKeys is set, or value is larger than 1/1024 of
+// the cache size, the entry will not be written to the cache. expireSeconds < 0 means no expire,
+// expireSeconds == 0 means the DefaultTTL, which is no expire by default,
 // but it can be evicted when cache is full.
+//
+// The work done by Set is bounded but depends on the data, it may evict or evacuate many old
+// entries to make room for the new one, use SetBounded if a hard bound is required.
 func (cache *Cache) Set(key, value []byte, expireSeconds int) (err error) {
-	hashVal := fnvaHash(key)
-	segId := hashVal & 255
+	return cache.SetBounded(key, value, expireSeconds, -1)
+}
+
+// SetBounded is like Set, but evicts or evacuates at most maxEvictions old entries to make
+// room for the new entry, ErrWouldBlock is returned if that is not enough.
+// Old entries evicted before giving up remain evicted. A negative maxEvictions means no limit.
+func (cache *Cache) SetBounded(key, value []byte, expireSeconds int, maxEvictions int) (err error) {
+	return cache.setKey(key, value, expireSeconds, maxEvictions, 0, 0)
+}
+
+// SetWithHash is like Set, but uses hashVal as the hash of the key instead of hashing it,
+// for callers that already hash their keys. The hash must be well distributed in all 64 bits,
+// and a key must always be used with the same hash, so an entry set by SetWithHash can only be
+// read and deleted by GetWithHash and DelWithHash.
+// Journal replay hashes the keys with the hash of the cache, caches using precomputed hashes
+// should not be journaled.
+func (cache *Cache) SetWithHash(key, value []byte, hashVal uint64, expireSeconds int) (err error) {
+	key, value = cache.encodeEntry(key, value)
+	return cache.setWithHash(key, value, hashVal, expireSeconds, -1, 0, 0, nil)
+}
+
+// SetWithFlags is like Set, but stores flags with the entry, which can be matched by Scan.
+// flags must not be larger than MaxFlags. Set and SetWithHash store zero flags.
+// Flags are not recorded in the journal.
+func (cache *Cache) SetWithFlags(key, value []byte, expireSeconds int, flags uint8) (err error) {
+	if flags > MaxFlags {
+		cache.countError(ErrInvalidFlags)
+		return ErrInvalidFlags
+	}
+	return cache.setKey(key, value, expireSeconds, -1, flags<<userFlagsShift, 0)
+}
+
+// setKey sets the entry at the position of the current hash seed, and deletes the entry at
+// the position of the old seed if the seed is being rotated.
+func (cache *Cache) setKey(key, value []byte, expireSeconds int, maxEvictions int, flags uint8, state uint16) (err error) {
+	key, value = cache.encodeEntry(key, value)
+	var old []byte
+	if cache.config.ChunkLargeValues {
+		if len(key)+len(value) > cache.maxKeyValLen() {
+			return cache.setChunked(key, len(value), &chunkSource{value: value}, expireSeconds, maxEvictions, flags, state)
+		}
+		old = cache.manifest(key)
+	}
+	seeds := cache.seeds.Load()
+	err = cache.setWithHash(key, value, seeds.cur.sipHash(key), expireSeconds, maxEvictions, flags, state, nil)
+	if err == nil {
+		// the chunks of an overwritten chunked value are deleted, once it is overwritten.
+		cache.dropChunks(key, old)
+		if seeds.old != nil {
+			cache.delOld(key, seeds.old.sipHash(key))
+		}
+	}
+	return
+}
+
+// setWithHash sets the entry in the segment of hashVal, check is called first under the lock of
+// the segment if it is not nil, the entry is not set if it returns an error.
+func (cache *Cache) setWithHash(key, value []byte, hashVal uint64, expireSeconds int, maxEvictions int, flags uint8, state uint16, check func(seg *segment, hashVal uint64) error) (err error) {
+	if observe := cache.latency.Load(); observe != nil {
+		defer (*observe)(OpSet, time.Now())
+	}
+	expireSeconds, err = cache.tunables.Load().expireSeconds(expireSeconds)
+	if err != nil {
+		cache.countError(err)
+		return
+	}
+	segId := hashVal & cache.segMask
 	cache.locks[segId].Lock()
-	err = cache.segments[segId].set(key, value, hashVal, expireSeconds)
-	cache.locks[segId].Unlock()
+	return cache.setLocked(segId, key, value, hashVal, expireSeconds, maxEvictions, flags, state, check)
+}
+
+// setLocked is setWithHash after the segment of hashVal is locked, it unlocks the segment.
+func (cache *Cache) setLocked(segId uint64, key, value []byte, hashVal uint64, expireSeconds int, maxEvictions int, flags uint8, state uint16, check func(seg *segment, hashVal uint64) error) (err error) {
+	err = cache.guarded(segId, func() error {
+		if check != nil {
+			if err := check(&cache.segments[segId], hashVal); err != nil {
+				return err
+			}
+		}
+		return cache.segments[segId].set(key, value, hashVal, expireSeconds, maxEvictions, flags, state)
+	})
+	if err == nil && cache.logged() {
+		var expireAt uint32
+		if expireSeconds > 0 {
+			expireAt = cache.now() + uint32(expireSeconds)
+		}
+		if state&entryChunked != 0 {
+			// a negative entry is recorded as a Del, the key is not found either way.
+			cache.log(journalDel, key, nil, 0)
+		} else {
+			cache.log(journalSet, key, value, expireAt)
+		}
+	}
+	cache.unlock(segId)
+	if cache.hotKeys != nil {
+		cache.hotKeys.record(key, hashVal)
+	}
+	if err == ErrLargeEntry && cache.config.Overflow != nil {
+		err = cache.overflowSet(key, value, hashVal, expireSeconds)
+	}
+	if err != nil {
+		cache.countError(err)
+	}
 	return
 }
 
 // Get the value or not found error.
 func (cache *Cache) Get(key []byte) (value []byte, err error) {
-	hashVal := fnvaHash(key)
-	segId := hashVal & 255
-	cache.locks[segId].Lock()
-	value, err = cache.segments[segId].get(key, hashVal)
-	cache.locks[segId].Unlock()
-	if err == nil {
-		atomic.AddInt64(&cache.hitCount, 1)
-	} else {
-		atomic.AddInt64(&cache.missCount, 1)
+	if observe := cache.latency.Load(); observe != nil {
+		defer (*observe)(OpGet, time.Now())
+	}
+	entryKey := cache.entryKey(key)
+	value, err = cache.get(entryKey, nil)
+	if err == errChunked {
+		value, err = cache.getChunks(entryKey, value)
+	}
+	value, err = cache.overflowGet(entryKey, value, err)
+	return cache.decodeResult(key, entryKey, value, err)
+}
+
+// get is Get without reading the chunks, it returns errChunked with the manifest of a chunked value.
+// The value is appended to buf.
+func (cache *Cache) get(key, buf []byte) (value []byte, err error) {
+	seeds := cache.seeds.Load()
+	if seeds.old == nil {
+		return cache.getWithHash(key, seeds.cur.sipHash(key), buf, true)
+	}
+	// look up the position of the current seed first, then the position of the old seed.
+	value, err = cache.getWithHash(key, seeds.cur.sipHash(key), buf, false)
+	if err == ErrNotFound {
+		value, err = cache.getWithHash(key, seeds.old.sipHash(key), buf, true)
 	}
 	return
 }
 
+// GetWithHash is like Get, but uses hashVal as the hash of the key, see SetWithHash.
+func (cache *Cache) GetWithHash(key []byte, hashVal uint64) (value []byte, err error) {
+	if observe := cache.latency.Load(); observe != nil {
+		defer (*observe)(OpGet, time.Now())
+	}
+	entryKey := cache.entryKey(key)
+	value, err = cache.getWithHash(entryKey, hashVal, nil, true)
+	if err == errChunked {
+		value, err = cache.getChunks(entryKey, value)
+	}
+	value, err = cache.overflowGet(entryKey, value, err)
+	return cache.decodeResult(key, entryKey, value, err)
+}
+
+// getShared looks up the entry with the segment locked for reading, ErrBusy is returned if try is
+// true and the segment is locked for writing.
+func (cache *Cache) getShared(segId uint64, key []byte, hashVal uint64, buf []byte, try bool) (value []byte, err error) {
+	if !try {
+		cache.locks[segId].RLock()
+	} else if !cache.locks[segId].TryRLock() {
+		return nil, ErrBusy
+	}
+	defer cache.locks[segId].RUnlock()
+	if cache.config.SelfHeal {
+		defer func() {
+			if recover() != nil {
+				value, err = nil, ErrCorrupted
+			}
+		}()
+	}
+	return cache.segments[segId].getShared(key, hashVal, buf)
+}
+
+// getWithHash gets the entry and appends the value to buf, ErrNotFound is not counted as a miss if
+// countMiss is false. errChunked is returned with the manifest of a chunked value.
+func (cache *Cache) getWithHash(key []byte, hashVal uint64, buf []byte, countMiss bool) (value []byte, err error) {
+	segId := hashVal & cache.segMask
+	if cache.config.SharedReads {
+		value, err = cache.getShared(segId, key, hashVal, buf, false)
+	}
+	// a corrupted entry found by a shared read is looked up again to heal the segment.
+	if !cache.config.SharedReads || err == ErrCorrupted {
+		cache.locks[segId].Lock()
+		err = cache.guarded(segId, func() (err error) {
+			value, err = cache.segments[segId].get(key, hashVal, buf)
+			return
+		})
+		cache.unlock(segId)
+	}
+	cache.countGet(segId, key, hashVal, err, countMiss)
+	return
+}
+
+// countGet counts a lookup of getWithHash in the statistics and the hot keys.
+func (cache *Cache) countGet(segId uint64, key []byte, hashVal uint64, err error, countMiss bool) {
+	instrumented := cache.tunables.Load().instrumented() && (countMiss || err != ErrNotFound)
+	if instrumented {
+		if err == nil || err == errChunked || err == errNegative || err == errStale {
+			cache.counters[segId].hits.Add(1)
+		} else {
+			cache.counters[segId].misses.Add(1)
+		}
+	}
+	if cache.hotKeys != nil && (countMiss || err != ErrNotFound) {
+		cache.hotKeys.record(key, hashVal)
+	}
+	if instrumented && err != nil && err != errNegative && err != errStale {
+		cache.countError(err)
+	}
+}
+
 func (cache *Cache) Del(key []byte) (affected bool) {
-	hashVal := fnvaHash(key)
-	segId := hashVal & 255
+	key = cache.entryKey(key)
+	seeds := cache.seeds.Load()
+	if seeds.old != nil {
+		affected = cache.delOld(key, seeds.old.sipHash(key))
+	}
+	affected = cache.del(key, seeds.cur.sipHash(key), affected) || affected
+	cache.overflowDel(key)
+	return
+}
+
+// DelWithHash is like Del, but uses hashVal as the hash of the key, see SetWithHash.
+func (cache *Cache) DelWithHash(key []byte, hashVal uint64) (affected bool) {
+	key = cache.entryKey(key)
+	affected = cache.del(key, hashVal, false)
+	cache.overflowDel(key)
+	return
+}
+
+// del deletes the entry and records it in the journal if it is deleted or forceLog is true.
+func (cache *Cache) del(key []byte, hashVal uint64, forceLog bool) (affected bool) {
+	if observe := cache.latency.Load(); observe != nil {
+		defer (*observe)(OpDel, time.Now())
+	}
+	segId := hashVal & cache.segMask
+	var manifest []byte
 	cache.locks[segId].Lock()
-	affected = cache.segments[segId].del(key, hashVal)
-	cache.locks[segId].Unlock()
+	cache.guarded(segId, func() error {
+		seg := &cache.segments[segId]
+		if cache.config.ChunkLargeValues {
+			manifest = seg.manifest(key, hashVal)
+		}
+		affected = seg.del(key, hashVal)
+		return nil
+	})
+	if (affected || forceLog) && cache.logged() {
+		cache.log(journalDel, key, nil, 0)
+	} else if cache.config.Invalidator != nil {
+		// the other caches may hold the entry even if this one doesn't.
+		cache.config.Invalidator.Publish(append([]byte(nil), key...))
+	}
+	cache.unlock(segId)
+	cache.dropChunks(key, manifest)
 	return
 }
 
+// EvacuateCount returns the number of old entries kept when room was needed, by moving them to
+// the head of the ring buffer, see EvictionPolicy. EvictCount and ExpiredEvictCount are the
+// number of old entries removed instead.
 func (cache *Cache) EvacuateCount() (count int64) {
-	for i := 0; i < 256; i++ {
-		count += atomic.LoadInt64(&cache.segments[i].totalEvacuate)
+	for i := 0; i < len(cache.segments); i++ {
+		count += cache.counters[i].evacuations.Load()
+	}
+	return
+}
+
+// EvictCount returns the number of live entries removed to make room for new entries.
+func (cache *Cache) EvictCount() (count int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		count += cache.counters[i].evictions.Load()
+	}
+	return
+}
+
+// ForcedEvictCount returns the number of the evictions of EvictCount that were forced after 64
+// consecutive evacuations, without asking the eviction policy. If it grows, the policy keeps
+// too many entries and the cache throws away live entries it would otherwise keep.
+func (cache *Cache) ForcedEvictCount() (count int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		count += cache.counters[i].forced.Load()
+	}
+	return
+}
+
+// ExpiredEvictCount returns the number of expired entries removed to make room for new entries,
+// they are also counted by ExpiredCount.
+func (cache *Cache) ExpiredEvictCount() (count int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		count += cache.counters[i].expired.Load()
+	}
+	return
+}
+
+// CollisionCount returns the number of lookups that compared the key of an entry with the same
+// hash fingerprint but a different key.
+func (cache *Cache) CollisionCount() (count int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		count += cache.counters[i].collisions.Load()
+	}
+	return
+}
+
+// WrittenBytes returns the total size of the keys and values written by Set.
+func (cache *Cache) WrittenBytes() (n int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		n += atomic.LoadInt64(&cache.segments[i].setBytes)
+	}
+	return
+}
+
+// PhysicalWrittenBytes returns the number of bytes written to the ring buffers, that is the entries
+// including their headers and the spare value capacity, the overwritten values, and the
+// entries copied by evacuation.
+func (cache *Cache) PhysicalWrittenBytes() (n int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		n += atomic.LoadInt64(&cache.segments[i].physBytes)
+	}
+	return
+}
+
+// EvacuatedBytes returns the number of bytes copied by evacuating recently used entries.
+func (cache *Cache) EvacuatedBytes() (n int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		n += atomic.LoadInt64(&cache.segments[i].evacBytes)
+	}
+	return
+}
+
+// WriteAmplification returns PhysicalWrittenBytes divided by WrittenBytes.
+func (cache *Cache) WriteAmplification() float64 {
+	written := cache.WrittenBytes()
+	if written == 0 {
+		return 0
+	}
+	return float64(cache.PhysicalWrittenBytes()) / float64(written)
+}
+
+// ExpiredCount returns the number of entries removed because they expired,
+// unlike EvacuateCount it is not related to memory pressure.
+func (cache *Cache) ExpiredCount() (count int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		count += atomic.LoadInt64(&cache.segments[i].totalExpired)
 	}
 	return
 }
 
 func (cache *Cache) EntryCount() (entryCount int64) {
-	for i := 0; i < 256; i++ {
+	for i := 0; i < len(cache.segments); i++ {
 		entryCount += atomic.LoadInt64(&cache.segments[i].entryCount)
 	}
 	return
 }
 
+// BytesPerEntry returns the average memory used by a live entry, including the entry header,
+// key, value, the space of deleted entries not yet reclaimed, and the slot index.
+func (cache *Cache) BytesPerEntry() float64 {
+	var totalBytes, entryCount int64
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+		seg := &cache.segments[i]
+		totalBytes += seg.rb.Size() - seg.vacuumLen + int64(len(seg.slotsData))*int64(unsafe.Sizeof(entryPtr{}))
+		entryCount += seg.entryCount
+		cache.locks[i].Unlock()
+	}
+	if entryCount == 0 {
+		return 0
+	}
+	return float64(totalBytes) / float64(entryCount)
+}
+
 // The average unix timestamp when a entry being accessed.
 // Entries have greater access time will be evacuated when it
 // is about to be overwritten by new value.
 func (cache *Cache) AverageAccessTime() int64 {
 	var entryCount, totalTime int64
-	for i := 0; i < 256; i++ {
+	for i := 0; i < len(cache.segments); i++ {
 		totalTime += atomic.LoadInt64(&cache.segments[i].totalTime)
 		entryCount += atomic.LoadInt64(&cache.segments[i].totalCount)
 	}
@@ -102,12 +921,27 @@ func (cache *Cache) AverageAccessTime() int64 {
 	}
 }
 
-func (cache *Cache) HitCount() int64 {
-	return atomic.LoadInt64(&cache.hitCount)
+func (cache *Cache) HitCount() (count int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		count += cache.counters[i].hits.Load()
+	}
+	return
 }
 
-func (cache *Cache) LookupCount() int64 {
-	return atomic.LoadInt64(&cache.hitCount) + atomic.LoadInt64(&cache.missCount)
+// MissCount returns the number of lookups that did not find the entry, LookupCount is
+// the sum of HitCount and MissCount.
+func (cache *Cache) MissCount() (count int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		count += cache.counters[i].misses.Load()
+	}
+	return
+}
+
+func (cache *Cache) LookupCount() (count int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		count += cache.counters[i].hits.Load() + cache.counters[i].misses.Load()
+	}
+	return
 }
 
 func (cache *Cache) HitRate() float64 {
@@ -120,19 +954,174 @@ func (cache *Cache) HitRate() float64 {
 }
 
 func (cache *Cache) OverwriteCount() (overwriteCount int64) {
-	for i := 0; i < 256; i++ {
-		overwriteCount += atomic.LoadInt64(&cache.segments[i].overwrites)
+	for i := 0; i < len(cache.segments); i++ {
+		overwriteCount += cache.counters[i].overwrites.Load()
 	}
 	return
 }
 
+// SegmentStat is the statistics of a segment, see SegmentStats.
+type SegmentStat struct {
+	EntryCount    int64
+	UsedBytes     int64 // bytes of the ring buffer used by entries, including deleted entries not yet reclaimed.
+	DeadBytes     int64 // bytes of the deleted entries not yet reclaimed, see Compact.
+	EvacuateCount int64
+	EvictCount    int64
+	HitCount      int64
+	LookupCount   int64
+	HitRate       float64
+}
+
+// SegmentCount returns the number of segments of the cache, see Config.Segments.
+func (cache *Cache) SegmentCount() int {
+	return len(cache.segments)
+}
+
+// SegmentStats returns the statistics of the segment idx, which must be in [0, SegmentCount()),
+// so operators can detect hot segments and skew in the key distribution.
+func (cache *Cache) SegmentStats(idx int) (stat SegmentStat) {
+	cache.locks[idx].Lock()
+	seg := &cache.segments[idx]
+	stat.EntryCount = seg.entryCount
+	stat.UsedBytes = seg.rb.Size() - seg.vacuumLen
+	stat.DeadBytes = seg.deadBytes()
+	cache.locks[idx].Unlock()
+	counters := &cache.counters[idx]
+	stat.EvacuateCount = counters.evacuations.Load()
+	stat.EvictCount = counters.evictions.Load()
+	stat.HitCount = counters.hits.Load()
+	stat.LookupCount = stat.HitCount + counters.misses.Load()
+	if stat.LookupCount != 0 {
+		stat.HitRate = float64(stat.HitCount) / float64(stat.LookupCount)
+	}
+	return
+}
+
+// MemoryUsage returns the bytes of the ring buffers used by entries, including deleted entries
+// not yet reclaimed, the free bytes of the ring buffers, and the bytes of the slot arrays that
+// index the entries, which are allocated in addition to the size of the cache.
+func (cache *Cache) MemoryUsage() (used, free, slotBytes int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		segUsed, segFree, segSlotBytes := cache.SegmentMemoryUsage(i)
+		used += segUsed
+		free += segFree
+		slotBytes += segSlotBytes
+	}
+	return
+}
+
+// SegmentMemoryUsage is like MemoryUsage for the segment idx, which must be in [0, SegmentCount()).
+func (cache *Cache) SegmentMemoryUsage(idx int) (used, free, slotBytes int64) {
+	cache.locks[idx].Lock()
+	seg := &cache.segments[idx]
+	used = seg.rb.Size() - seg.vacuumLen
+	free = seg.vacuumLen
+	slotBytes = int64(cap(seg.slotsData)) * int64(unsafe.Sizeof(entryPtr{}))
+	cache.locks[idx].Unlock()
+	return
+}
+
+// ResetStatistics zeroes the hit, miss, evacuate, overwrite, expired and the other counters,
+// the entries are kept, so per-interval rates can be measured without clearing the cache.
+func (cache *Cache) ResetStatistics() {
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+		cache.segments[i].resetStatistics()
+		cache.locks[i].Unlock()
+	}
+	atomic.StoreInt64(&cache.segmentResets, 0)
+	if cache.hotKeys != nil {
+		cache.hotKeys.mu.Lock()
+		cache.hotKeys.reset()
+		cache.hotKeys.mu.Unlock()
+	}
+	for i := range cache.errorCounts {
+		atomic.StoreInt64(&cache.errorCounts[i], 0)
+	}
+}
+
 func (cache *Cache) Clear() {
-	for i := 0; i < 256; i++ {
+	if cache.logged() {
+		cache.log(journalClear, nil, nil, 0)
+	}
+	cache.clear()
+}
+
+// logged reports whether the mutations of the cache are recorded in a journal, replicated or sent as events.
+func (cache *Cache) logged() bool {
+	return cache.config.Journal != nil || cache.config.Replicator != nil || cache.config.Invalidator != nil ||
+		cache.hasEvents.Load()
+}
+
+// log records a mutation in the journal, queues it to the replicator, sends it as an event and
+// publishes its key to the invalidator.
+func (cache *Cache) log(op byte, key, value []byte, expireAt uint32) {
+	if cache.config.Journal != nil {
+		cache.config.Journal.log(op, key, value, expireAt)
+	}
+	if cache.config.Replicator != nil {
+		cache.config.Replicator.log(op, key, value, expireAt)
+	}
+	if cache.hasEvents.Load() {
+		cache.sendEvent(op, key)
+	}
+	if cache.config.Invalidator != nil && op != journalClear {
+		cache.config.Invalidator.Publish(append([]byte(nil), key...))
+	}
+}
+
+func (cache *Cache) clear() {
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+		cache.initSegment(i, cache.segments[i].rb.data)
+		cache.counters[i].reset()
+		cache.unlock(uint64(i))
+	}
+	for i := range cache.errorCounts {
+		atomic.StoreInt64(&cache.errorCounts[i], 0)
+	}
+}
+
+// Stats is a view of the counters of a cache, see Cache.Stats.
+type Stats struct {
+	HitCount       int64
+	MissCount      int64
+	EntryCount     int64
+	EvacuateCount  int64
+	OverwriteCount int64
+	ExpiredCount   int64
+	// EvictCount, ForcedEvictCount and ExpiredEvictCount break down the old entries removed to
+	// make room, see the methods of the same names.
+	EvictCount        int64
+	ForcedEvictCount  int64
+	ExpiredEvictCount int64
+	// HugePages tells whether the ring buffers are backed by huge pages, see Config.HugePages.
+	HugePages bool
+}
+
+// Stats gathers the counters of all the segments at once. All the segments are locked while
+// gathering, so it is more expensive than calling HitCount, EntryCount and the others. The
+// counters are of one point in time, except HitCount and MissCount: a lookup is counted after its
+// segment is unlocked, so the lookups in progress may be counted or not.
+func (cache *Cache) Stats() (stats Stats) {
+	for i := 0; i < len(cache.segments); i++ {
 		cache.locks[i].Lock()
-		newSeg := newSegment(len(cache.segments[i].rb.data), i)
-		cache.segments[i] = newSeg
+	}
+	for i := 0; i < len(cache.segments); i++ {
+		seg, counters := &cache.segments[i], &cache.counters[i]
+		stats.HitCount += counters.hits.Load()
+		stats.MissCount += counters.misses.Load()
+		stats.EntryCount += seg.entryCount
+		stats.EvacuateCount += counters.evacuations.Load()
+		stats.OverwriteCount += counters.overwrites.Load()
+		stats.ExpiredCount += seg.totalExpired
+		stats.EvictCount += counters.evictions.Load()
+		stats.ForcedEvictCount += counters.forced.Load()
+		stats.ExpiredEvictCount += counters.expired.Load()
+	}
+	for i := 0; i < len(cache.segments); i++ {
 		cache.locks[i].Unlock()
 	}
-	atomic.StoreInt64(&cache.hitCount, 0)
-	atomic.StoreInt64(&cache.missCount, 0)
+	stats.HugePages = cache.hugePages.Load()
+	return
 }
@@ -2,11 +2,25 @@ package freecache
 
 import (
 	"bytes"
+	"compress/flate"
 	"encoding/binary"
+	"encoding/json"
+	"expvar"
 	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
+	"unsafe"
 )
 
 func TestFreeCache(t *testing.T) {
@@ -17,6 +31,9 @@ func TestFreeCache(t *testing.T) {
 	if cache.AverageAccessTime() != 0 {
 		t.Error("initial average access time should be zero")
 	}
+	if cache.BytesPerEntry() != 0 {
+		t.Error("initial bytes per entry should be zero")
+	}
 	key := []byte("abcd")
 	val := []byte("efghijkl")
 	err := cache.Set(key, val, 0)
@@ -80,8 +97,8 @@ func TestFreeCache(t *testing.T) {
 		}
 	}
 
-	t.Logf("hit rate is %v, evacuates %v, entries %v, average time %v\n",
-		cache.HitRate(), cache.EvacuateCount(), cache.EntryCount(), cache.AverageAccessTime())
+	t.Logf("hit rate is %v, evacuates %v, entries %v, average time %v, bytes per entry %v\n",
+		cache.HitRate(), cache.EvacuateCount(), cache.EntryCount(), cache.AverageAccessTime(), cache.BytesPerEntry())
 }
 
 func TestOverwrite(t *testing.T) {
@@ -125,14 +142,18 @@ func TestOverwrite(t *testing.T) {
 }
 
 func TestExpire(t *testing.T) {
-	cache := NewCache(1024)
+	clock := NewFakeClock(time.Now())
+	cache := NewCacheWithConfig(1024, Config{Clock: clock})
 	key := []byte("abcd")
 	val := []byte("efgh")
 	err := cache.Set(key, val, 1)
 	if err != nil {
 		t.Error("err should be nil")
 	}
-	time.Sleep(time.Second)
+	if _, err = cache.Get(key); err != nil {
+		t.Fatal("key should not be expired yet", err)
+	}
+	clock.Advance(time.Second)
 	val, err = cache.Get(key)
 	if err == nil {
 		t.Fatal("key should be expired", string(val))
@@ -182,6 +203,12 @@ func TestLargeEntry(t *testing.T) {
 	if err != ErrLargeEntry {
 		t.Error("err should be ErrLargeEntry", err)
 	}
+	if count := cache.ErrorCount(ErrLargeEntry); count != 2 {
+		t.Error("large entry error count is", count, "expected", 2)
+	}
+	if count := cache.ErrorCounts()[ErrLargeKey]; count != 1 {
+		t.Error("large key error count is", count, "expected", 1)
+	}
 }
 
 func BenchmarkCacheSet(b *testing.B) {
@@ -234,3 +261,2588 @@ func BenchmarkMapGet(b *testing.B) {
 		}
 	}
 }
+
+func TestExpirer(t *testing.T) {
+	cache := NewCacheWithConfig(1024, Config{ExpireInterval: 100 * time.Millisecond})
+	defer cache.Close()
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte("value"), 1)
+	}
+	cache.Set([]byte("forever"), []byte("value"), 0)
+	time.Sleep(time.Second * 2)
+	if count := cache.EntryCount(); count != 1 {
+		t.Error("entry count is", count, "expected", 1)
+	}
+	if _, err := cache.Get([]byte("forever")); err != nil {
+		t.Error(err)
+	}
+	if count := cache.ExpiredCount(); count != 100 {
+		t.Error("expired count is", count, "expected", 100)
+	}
+}
+
+func TestMinTTL(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewCacheWithConfig(1024, Config{Tunables: Tunables{MinTTL: 60}, Clock: clock})
+	key := []byte("abcd")
+	val := []byte("efgh")
+	if err := cache.Set(key, val, 1); err != nil {
+		t.Error(err)
+	}
+	clock.Advance(time.Second)
+	if _, err := cache.Get(key); err != nil {
+		t.Error("short TTL should be raised to the minimum TTL", err)
+	}
+	cache = NewCacheWithConfig(1024, Config{Tunables: Tunables{MinTTL: 60, RejectShortTTL: true}})
+	if err := cache.Set(key, val, 1); err != ErrShortTTL {
+		t.Error("err should be ErrShortTTL", err)
+	}
+	if err := cache.Set(key, val, 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestTimerWheelExpire(t *testing.T) {
+	expired := make(chan string, 10)
+	cache := NewCacheWithConfig(1024, Config{
+		TimerWheel: true,
+		OnExpire: func(key, value []byte) {
+			expired <- string(key)
+		},
+	})
+	defer cache.Close()
+	cache.Set([]byte("a"), []byte("value"), 1)
+	cache.Set([]byte("b"), []byte("value"), 1)
+	cache.Set([]byte("c"), []byte("value"), 300)
+	cache.Set([]byte("d"), []byte("value"), 0)
+	cache.Set([]byte("b"), []byte("value"), 100000)
+	if count := cache.ExpiringWithin(2); count != 1 {
+		t.Error("expiring count is", count, "expected", 1)
+	}
+	if count := cache.ExpiringWithin(1000); count != 2 {
+		t.Error("expiring count is", count, "expected", 2)
+	}
+	select {
+	case key := <-expired:
+		if key != "a" {
+			t.Error("expired key is", key, "expected a")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("OnExpire not called")
+	}
+	if count := cache.EntryCount(); count != 3 {
+		t.Error("entry count is", count, "expected", 3)
+	}
+}
+
+func TestSetBounded(t *testing.T) {
+	cache := NewCache(1024)
+	var blocked int
+	for i := 0; i < 10000; i++ {
+		key := []byte(fmt.Sprintf("key%v", i))
+		err := cache.SetBounded(key, make([]byte, 100), 0, 0)
+		if err == ErrWouldBlock {
+			blocked++
+			if _, err = cache.Get(key); err != ErrNotFound {
+				t.Fatal("blocked entry should not be written")
+			}
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if blocked == 0 {
+		t.Error("SetBounded should be blocked when no eviction is allowed")
+	}
+	if cache.EvacuateCount() != 0 {
+		t.Error("no entry should be evacuated")
+	}
+	if err := cache.SetBounded([]byte("key"), make([]byte, 100), 0, 100); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestToMap(t *testing.T) {
+	cache := NewCache(1024)
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte(fmt.Sprintf("val%v", i)), 0)
+	}
+	m, err := cache.ToMap(10000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m) != 100 {
+		t.Error("map length is", len(m), "expected", 100)
+	}
+	if string(m["key42"]) != "val42" {
+		t.Error("value is", string(m["key42"]), "expected val42")
+	}
+	if _, err = cache.ToMap(100); err != ErrLimitExceeded {
+		t.Error("err should be ErrLimitExceeded", err)
+	}
+}
+
+func TestBytesPerEntry(t *testing.T) {
+	cache := NewCache(1024)
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%04d", i)), make([]byte, 100), 0)
+	}
+	// header, key, value and at least one entry pointer.
+	minSize := float64(ENTRY_HDR_SIZE + 7 + 100 + 16)
+	if size := cache.BytesPerEntry(); size < minSize {
+		t.Error("bytes per entry is", size, "expected at least", minSize)
+	}
+}
+
+func TestAlignment(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{Alignment: 64})
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprintf("key%v", i))
+		cache.Set(key, make([]byte, i%100), 0)
+		if i%3 == 0 {
+			cache.Get(key)
+		}
+		if i%5 == 0 {
+			cache.Set(key, make([]byte, i%200), 0)
+		}
+	}
+	if cache.EntryCount() == 20000 {
+		t.Error("entries should be evicted")
+	}
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	for i := range cache.segments {
+		seg := &cache.segments[i]
+		if uintptr(unsafe.Pointer(&seg.rb.data[0]))%64 != 0 {
+			t.Fatal("ring buffer is not aligned")
+		}
+		for slotId := int32(0); slotId < 256; slotId++ {
+			slotOff := slotId * seg.slotCap
+			for _, ptr := range seg.slotsData[slotOff : slotOff+seg.slotLens[slotId]] {
+				seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+				if off := hdr.valOff(ptr.offset); off%64 != 0 {
+					t.Fatal("value offset", off, "is not aligned")
+				}
+			}
+		}
+	}
+	value, err := cache.Get([]byte("key19999"))
+	if err != nil || len(value) != 19999%100 {
+		t.Error("value length is", len(value), err)
+	}
+}
+
+func TestResize(t *testing.T) {
+	cache := NewCacheWithConfig(2*1024*1024, Config{Alignment: 8})
+	n := 5000
+	for i := 0; i < n; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte(fmt.Sprintf("val%v", i)+strings.Repeat("v", 100)), 0)
+	}
+	if err := cache.Resize(4 * 1024 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	if count := cache.EntryCount(); count != int64(n) {
+		t.Error("entry count is", count, "expected", n)
+	}
+	for i := 0; i < n; i++ {
+		key := fmt.Sprintf("key%v", i)
+		if val, err := cache.Get([]byte(key)); err != nil || string(val) != fmt.Sprintf("val%v", i)+strings.Repeat("v", 100) {
+			t.Fatal("value of", key, "is", string(val), err)
+		}
+	}
+	cache.Resize(512 * 1024)
+	count := cache.EntryCount()
+	if count == 0 || count >= int64(n) {
+		t.Error("entry count is", count, "after shrink")
+	}
+	// the newest entry is kept.
+	if val, err := cache.Get([]byte(fmt.Sprintf("key%v", n-1))); err != nil || string(val) != fmt.Sprintf("val%v", n-1)+strings.Repeat("v", 100) {
+		t.Error("value is", string(val), err)
+	}
+	for i := 0; i < n; i++ {
+		key := []byte(fmt.Sprintf("new%v", i))
+		cache.Set(key, key, 0)
+		if val, err := cache.Get(key); err != nil || string(val) != string(key) {
+			t.Fatal("value of", string(key), "is", string(val), err)
+		}
+	}
+}
+
+func TestFreezeCompact(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte(fmt.Sprintf("val%v", i)), 0)
+	}
+	frozen := cache.FreezeCompact()
+	if frozen.EntryCount() != 1000 {
+		t.Error("entry count is", frozen.EntryCount(), "expected", 1000)
+	}
+	for i := 0; i < 1000; i++ {
+		val, err := frozen.Get([]byte(fmt.Sprintf("key%v", i)))
+		if err != nil || string(val) != fmt.Sprintf("val%v", i) {
+			t.Fatal("value is", string(val), err)
+		}
+	}
+	if _, err := frozen.Get([]byte("missing")); err != ErrNotFound {
+		t.Error("err should be ErrNotFound", err)
+	}
+	if _, err := NewCache(0).FreezeCompact().Get([]byte("missing")); err != ErrNotFound {
+		t.Error("err should be ErrNotFound", err)
+	}
+}
+
+func TestReconfigure(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	if err := cache.Reconfigure(Tunables{TTLJitter: 1}); err != ErrInvalidTunables {
+		t.Error("err should be ErrInvalidTunables", err)
+	}
+	if err := cache.Reconfigure(Tunables{DefaultTTL: 1, Instrumentation: InstrumentNone}); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Tunables().DefaultTTL != 1 {
+		t.Error("tunables are not applied")
+	}
+	cache.Set([]byte("default"), []byte("value"), 0)
+	cache.Set([]byte("forever"), []byte("value"), -1)
+	cache.Get([]byte("missing"))
+	if cache.LookupCount() != 0 {
+		t.Error("lookups should not be counted")
+	}
+	time.Sleep(time.Second)
+	if _, err := cache.Get([]byte("default")); err != ErrNotFound {
+		t.Error("entry should expire with the default TTL")
+	}
+	if _, err := cache.Get([]byte("forever")); err != nil {
+		t.Error(err)
+	}
+
+	cache.Reconfigure(Tunables{OccupancyTarget: 0.5})
+	for i := 0; i < 100000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), make([]byte, 100), 0)
+	}
+	used := int64(0)
+	for i := range cache.segments {
+		used += cache.segments[i].rb.Size() - cache.segments[i].vacuumLen
+	}
+	if used > 1024*1024/2+256*200 {
+		t.Error("used bytes", used, "exceeds the occupancy target")
+	}
+}
+
+func TestSelfHeal(t *testing.T) {
+	var resets []int
+	cache := NewCacheWithConfig(1024*1024, Config{
+		SelfHeal: true,
+		OnSegmentReset: func(segId int, reason error) {
+			resets = append(resets, segId)
+		},
+	})
+	key := []byte("key")
+	cache.Set(key, []byte("value"), 0)
+	cache.Set([]byte("other"), []byte("value"), 0)
+	hashVal := cache.hash(key)
+	seg := &cache.segments[hashVal&255]
+	slotId := uint8(hashVal >> 8)
+	ptr := seg.slotsData[int32(slotId)*seg.slotCap]
+	// corrupt the key length in the entry header.
+	seg.rb.WriteAt([]byte{0xff}, ptr.offset+8)
+	if _, err := cache.Get(key); err != ErrCorrupted {
+		t.Fatal("err should be ErrCorrupted", err)
+	}
+	if cache.SegmentResetCount() != 1 || len(resets) != 1 || resets[0] != int(hashVal&255) {
+		t.Fatal("segment should be reset once", cache.SegmentResetCount(), resets)
+	}
+	if cache.ErrorCount(ErrCorrupted) != 1 {
+		t.Error("corrupted error count", cache.ErrorCount(ErrCorrupted))
+	}
+	if _, err := cache.Get(key); err != ErrNotFound {
+		t.Error("err should be ErrNotFound after reset", err)
+	}
+	if cache.hash([]byte("other"))&255 != hashVal&255 {
+		if _, err := cache.Get([]byte("other")); err != nil {
+			t.Error("other segments should keep serving", err)
+		}
+	}
+	if err := cache.Set(key, []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(key); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWideFingerprint(t *testing.T) {
+	for _, wide := range []bool{false, true} {
+		cache := NewCacheWithConfig(1024*1024, Config{WideFingerprint: wide})
+		// the hash values differ only in the high 32 bits, so the entries share the slot and hash16.
+		seg := &cache.segments[0x34]
+		seg.set([]byte("key1"), []byte("value1"), 1<<32|0x1234, 0, -1, 0, 0)
+		seg.set([]byte("key2"), []byte("value2"), 2<<32|0x1234, 0, -1, 0, 0)
+		value, err := seg.get([]byte("key1"), 1<<32|0x1234, nil)
+		if err != nil || string(value) != "value1" {
+			t.Fatal(string(value), err)
+		}
+		if wide && cache.CollisionCount() != 0 {
+			t.Error("wide fingerprint should skip the colliding entry", cache.CollisionCount())
+		}
+		if !wide && cache.CollisionCount() != 1 {
+			t.Error("collision count should be 1", cache.CollisionCount())
+		}
+	}
+}
+
+func TestWithHash(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	key := []byte("key")
+	hashVal := uint64(0x0123456789abcdef)
+	if err := cache.SetWithHash(key, []byte("value"), hashVal, 0); err != nil {
+		t.Fatal(err)
+	}
+	value, err := cache.GetWithHash(key, hashVal)
+	if err != nil || string(value) != "value" {
+		t.Fatal(string(value), err)
+	}
+	if cache.hash(key) != hashVal {
+		if _, err := cache.Get(key); err != ErrNotFound {
+			t.Error("entry set with a precomputed hash should not be found by Get", err)
+		}
+	}
+	if !cache.DelWithHash(key, hashVal) {
+		t.Error("entry should be deleted")
+	}
+	if _, err := cache.GetWithHash(key, hashVal); err != ErrNotFound {
+		t.Error("err should be ErrNotFound", err)
+	}
+}
+
+func TestScan(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	cache.Set([]byte("forever"), []byte("v"), 0)
+	cache.Set([]byte("short"), []byte("v"), 10)
+	cache.Set([]byte("long"), []byte("long value"), 1000)
+	cache.SetWithFlags([]byte("flagged"), []byte("v"), 0, 5)
+	if err := cache.SetWithFlags([]byte("invalid"), []byte("v"), 0, MaxFlags+1); err != ErrInvalidFlags {
+		t.Error("err should be ErrInvalidFlags", err)
+	}
+	scan := func(filter ScanFilter) (keys []string) {
+		cache.Scan(filter, func(key, value []byte) bool {
+			keys = append(keys, string(key))
+			return true
+		})
+		sort.Strings(keys)
+		return
+	}
+	for _, c := range []struct {
+		filter ScanFilter
+		keys   []string
+	}{
+		{ScanFilter{}, []string{"flagged", "forever", "long", "short"}},
+		{ScanFilter{MinTTL: 1, MaxTTL: 100}, []string{"short"}},
+		{ScanFilter{MinTTL: 100}, []string{"flagged", "forever", "long"}},
+		{ScanFilter{FlagsMask: 4, Flags: 4}, []string{"flagged"}},
+		{ScanFilter{FlagsMask: 1}, []string{"forever", "long", "short"}},
+		{ScanFilter{MinValueLen: 2}, []string{"long"}},
+	} {
+		if keys := scan(c.filter); !reflect.DeepEqual(keys, c.keys) {
+			t.Errorf("filter %+v got %v, want %v", c.filter, keys, c.keys)
+		}
+	}
+	n := 0
+	cache.Scan(ScanFilter{}, func(key, value []byte) bool {
+		n++
+		return false
+	})
+	if n != 1 {
+		t.Error("scan should stop when fn returns false", n)
+	}
+}
+
+func TestSegmentStats(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	key := []byte("key")
+	cache.Set(key, []byte("value"), 0)
+	cache.Get(key)
+	cache.Get([]byte("missing"))
+	segId := int(cache.hash(key) & 255)
+	stat := cache.SegmentStats(segId)
+	if stat.EntryCount != 1 || stat.UsedBytes != ENTRY_HDR_SIZE+3+5 || stat.HitCount != 1 {
+		t.Errorf("unexpected stat %+v", stat)
+	}
+	var total SegmentStat
+	for i := 0; i < 256; i++ {
+		stat := cache.SegmentStats(i)
+		total.EntryCount += stat.EntryCount
+		total.LookupCount += stat.LookupCount
+	}
+	if total.EntryCount != 1 || total.LookupCount != 2 || cache.LookupCount() != 2 || cache.MissCount() != 1 {
+		t.Errorf("unexpected total %+v", total)
+	}
+}
+
+func TestWriteAmplification(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	cache.Set([]byte("key"), []byte("value"), 0)
+	if cache.WrittenBytes() != 8 || cache.PhysicalWrittenBytes() != ENTRY_HDR_SIZE+8 {
+		t.Fatal(cache.WrittenBytes(), cache.PhysicalWrittenBytes())
+	}
+	cache.Set([]byte("key"), []byte("VALUE"), 0)
+	if cache.WrittenBytes() != 16 || cache.PhysicalWrittenBytes() != 2*ENTRY_HDR_SIZE+8+5 {
+		t.Fatal(cache.WrittenBytes(), cache.PhysicalWrittenBytes())
+	}
+	for i := 0; i < 5000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 100), 0)
+	}
+	time.Sleep(time.Second)
+	for i := 0; i < 5000; i += 2 {
+		cache.Get([]byte(fmt.Sprintf("key%d", i)))
+	}
+	for i := 5000; i < 20000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 100), 0)
+	}
+	if cache.EvacuatedBytes() == 0 || cache.EvacuateCount() == 0 {
+		t.Error("recently used entries should be evacuated")
+	}
+	if cache.WriteAmplification() <= 1 {
+		t.Error("write amplification", cache.WriteAmplification())
+	}
+}
+
+func TestTTLClasses(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{TTLClasses: []TTLClass{{Name: "short", ExpireSeconds: 1}, {Name: "long", ExpireSeconds: 3600}}})
+	if err := cache.SetWithClass([]byte("key"), []byte("value"), "medium"); err != ErrUnknownTTLClass {
+		t.Error("err should be ErrUnknownTTLClass", err)
+	}
+	cache.SetWithClass([]byte("short"), []byte("value"), "short")
+	cache.SetWithClass([]byte("long"), []byte("value"), "long")
+	cache.Get([]byte("short"))
+	cache.Get([]byte("long"))
+	cache.Get([]byte("long"))
+	time.Sleep(time.Second)
+	if _, err := cache.Get([]byte("short")); err != ErrNotFound {
+		t.Error("entry should expire with the TTL of its class", err)
+	}
+	stats := cache.TTLClassStats()
+	want := []TTLClassStat{{Name: "short", Sets: 1, Hits: 1, Misses: 1}, {Name: "long", Sets: 1, Hits: 2}}
+	if !reflect.DeepEqual(stats, want) {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+	for i := 0; i < 20000; i++ {
+		cache.SetWithClass([]byte(fmt.Sprintf("key%d", i)), make([]byte, 100), "long")
+	}
+	if cache.TTLClassStats()[1].Evictions == 0 {
+		t.Error("evictions should be counted")
+	}
+}
+
+func TestStats(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	cache.Set([]byte("key"), []byte("value"), 0)
+	cache.Set([]byte("key"), []byte("VALUE"), 0)
+	cache.Set([]byte("expiring"), []byte("value"), 1)
+	cache.Get([]byte("key"))
+	time.Sleep(time.Second)
+	cache.Get([]byte("expiring"))
+	want := Stats{HitCount: 1, MissCount: 1, EntryCount: 1, OverwriteCount: 1, ExpiredCount: 1}
+	if stats := cache.Stats(); stats != want {
+		t.Errorf("got %+v, want %+v", stats, want)
+	}
+}
+
+func TestResetStatistics(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	cache.Set([]byte("key"), []byte("value"), 0)
+	cache.Set([]byte("key"), []byte("VALUE"), 0)
+	cache.Get([]byte("key"))
+	cache.Get([]byte("missing"))
+	cache.ResetStatistics()
+	if stats := cache.Stats(); stats != (Stats{EntryCount: 1}) {
+		t.Errorf("counters should be zero, got %+v", stats)
+	}
+	if cache.ErrorCount(ErrNotFound) != 0 || cache.WrittenBytes() != 0 {
+		t.Error("counters should be zero")
+	}
+	if value, err := cache.Get([]byte("key")); err != nil || string(value) != "VALUE" {
+		t.Error("entries should be kept", string(value), err)
+	}
+}
+
+func TestRotateHashSeed(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	oldSeed := cache.seeds.Load().cur
+	// rotate by hand, so entries can be checked at both positions before the background rehash.
+	cache.seeds.Store(&seedState{cur: newHashSeed(), old: &oldSeed})
+	if err := cache.RotateHashSeed(); err != ErrRehashInProgress {
+		t.Error("err should be ErrRehashInProgress", err)
+	}
+	for i := 0; i < 1000; i += 2 {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("new%d", i)), 0)
+	}
+	cache.Del([]byte("key1"))
+	check := func() {
+		for i := 0; i < 1000; i++ {
+			value, err := cache.Get([]byte(fmt.Sprintf("key%d", i)))
+			want := fmt.Sprintf("value%d", i)
+			if i%2 == 0 {
+				want = fmt.Sprintf("new%d", i)
+			}
+			if i == 1 {
+				if err != ErrNotFound {
+					t.Fatal("deleted entry should not be found", err)
+				}
+			} else if err != nil || string(value) != want {
+				t.Fatal(i, string(value), err)
+			}
+		}
+	}
+	check()
+	if cache.EntryCount() != 999 {
+		t.Error("entry count", cache.EntryCount())
+	}
+	cache.rehash(cache.seeds.Load())
+	if cache.Rehashing() {
+		t.Fatal("rehash should be finished")
+	}
+	check()
+	if cache.EntryCount() != 999 {
+		t.Error("entry count", cache.EntryCount())
+	}
+	if err := cache.RotateHashSeed(); err != nil {
+		t.Fatal(err)
+	}
+	for cache.Rehashing() {
+		time.Sleep(time.Millisecond)
+	}
+	check()
+}
+
+func TestRotateHashSeedClose(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	oldSeed := cache.seeds.Load().cur
+	cache.seeds.Store(&seedState{cur: newHashSeed(), old: &oldSeed})
+	cache.Close()
+	cache.rehash(cache.seeds.Load())
+	if cache.Rehashing() {
+		t.Error("the rotation should end when the cache is closed")
+	}
+}
+
+func TestExpvarStats(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	cache.Set([]byte("key"), []byte("value"), 0)
+	cache.Get([]byte("key"))
+	cache.Get([]byte("missing"))
+	want := `{"HitCount":1,"MissCount":1,"EntryCount":1,"EvacuateCount":0,"OverwriteCount":0,"ExpiredCount":0,"EvictCount":0,"ForcedEvictCount":0,"ExpiredEvictCount":0,"HugePages":false,"HitRate":0.5}`
+	var v expvar.Var = cache.ExpvarStats()
+	if s := v.String(); s != want {
+		t.Errorf("got %s, want %s", s, want)
+	}
+}
+
+func TestStatsHandler(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{Segments: 16})
+	cache.Set([]byte("key"), []byte("value"), 0)
+	cache.Get([]byte("key"))
+	cache.Get([]byte("missing"))
+	rec := httptest.NewRecorder()
+	cache.StatsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/cache", nil))
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/json" {
+		t.Fatal("unexpected response", rec.Code, rec.Header())
+	}
+	var resp statsResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.EntryCount != 1 || resp.HitRate != 0.5 || resp.Size != cache.Size() || len(resp.Segments) != 16 {
+		t.Error("unexpected stats", resp)
+	}
+	var entries int64
+	for _, stat := range resp.Segments {
+		entries += stat.EntryCount
+	}
+	if entries != 1 || resp.UsedBytes == 0 {
+		t.Error("unexpected segment stats", resp.Segments)
+	}
+	if _, ok := resp.Errors[ErrNotFound.Error()]; !ok {
+		t.Error("the error counts should be served", resp.Errors)
+	}
+	rec = httptest.NewRecorder()
+	cache.StatsHandler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug/cache", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Error("only GET should be allowed", rec.Code)
+	}
+}
+
+func TestLatencyObserver(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	var ops []Op
+	cache.SetLatencyObserver(func(op Op, start time.Time) {
+		ops = append(ops, op)
+	})
+	cache.Set([]byte("key"), []byte("value"), 0)
+	cache.Get([]byte("key"))
+	cache.Del([]byte("key"))
+	cache.SetLatencyObserver(nil)
+	cache.Get([]byte("key"))
+	if !reflect.DeepEqual(ops, []Op{OpSet, OpGet, OpDel}) {
+		t.Error("observed ops", ops)
+	}
+}
+
+func TestAccessAge(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{Tunables: Tunables{Instrumentation: InstrumentDetailed}})
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), 0)
+	}
+	for i := 0; i < 100; i++ {
+		cache.Get([]byte(fmt.Sprintf("key%d", i)))
+	}
+	if p := cache.AccessAge(); p != (Percentiles{}) {
+		t.Error("entries accessed within a second should have zero age", p)
+	}
+	time.Sleep(2 * time.Second)
+	for i := 0; i < 10; i++ {
+		cache.Get([]byte(fmt.Sprintf("key%d", i)))
+	}
+	if p := cache.AccessAge(); p.P50 != 0 || p.P95 != 3 || p.P99 != 3 {
+		t.Error("unexpected percentiles", p)
+	}
+	cache.Reconfigure(Tunables{})
+	cache.ResetStatistics()
+	cache.Get([]byte("key0"))
+	if p := cache.AccessAge(); p != (Percentiles{}) {
+		t.Error("access age should only be collected with InstrumentDetailed", p)
+	}
+}
+
+func TestEntrySizes(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{Tunables: Tunables{Instrumentation: InstrumentDetailed}})
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 100-ENTRY_HDR_SIZE-6), 0)
+	}
+	cache.Set([]byte("large"), make([]byte, 900), 0)
+	if p := cache.EntrySizes(); p.P50 != 127 || p.P99 != 127 {
+		t.Error("unexpected percentiles", p)
+	}
+	hist := cache.EntrySizeHistogram()
+	if hist[7] != 100 || hist[10] != 1 {
+		t.Error("unexpected histogram", hist)
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	cache := NewCacheWithConfig(512*1024, Config{Admission: true})
+	hot := make([][]byte, 1000)
+	for i := range hot {
+		hot[i] = []byte(fmt.Sprintf("hot%d", i))
+		cache.Set(hot[i], make([]byte, 100), 0)
+	}
+	for n := 0; n < 3; n++ {
+		for _, key := range hot {
+			cache.Get(key)
+		}
+	}
+	rejected := 0
+	for i := 0; i < 10000; i++ {
+		if err := cache.Set([]byte(fmt.Sprintf("cold%d", i)), make([]byte, 100), 0); err == ErrNotAdmitted {
+			rejected++
+		}
+	}
+	if rejected == 0 || cache.ErrorCount(ErrNotAdmitted) != int64(rejected) {
+		t.Error("keys accessed once should be rejected", rejected)
+	}
+	found := 0
+	for _, key := range hot {
+		if _, err := cache.Get(key); err == nil {
+			found++
+		}
+	}
+	if found < len(hot)/2 {
+		t.Error("frequently used entries should stay in the cache", found)
+	}
+	if err := cache.Set(hot[0], make([]byte, 100), 0); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEvictionPolicy(t *testing.T) {
+	fill := func(policy EvictionPolicy) *Cache {
+		cache := NewCacheWithConfig(512*1024, Config{EvictionPolicy: policy})
+		for i := 0; i < 1000; i++ {
+			cache.Set([]byte(fmt.Sprintf("hot%d", i)), make([]byte, 100), 0)
+		}
+		for n := 0; n < 3; n++ {
+			for i := 0; i < 1000; i++ {
+				cache.Get([]byte(fmt.Sprintf("hot%d", i)))
+			}
+		}
+		for i := 0; i < 10000; i++ {
+			cache.Set([]byte(fmt.Sprintf("cold%d", i)), make([]byte, 100), 0)
+		}
+		return cache
+	}
+	if cache := fill(EvictFIFO); cache.EvacuateCount() != 0 {
+		t.Error("FIFO should not evacuate", cache.EvacuateCount())
+	}
+	if cache := fill(EvictRandom); cache.EvacuateCount() == 0 {
+		t.Error("random should evacuate")
+	}
+	cache := fill(EvictLFU)
+	found := 0
+	for i := 0; i < 1000; i++ {
+		if _, err := cache.Get([]byte(fmt.Sprintf("hot%d", i))); err == nil {
+			found++
+		}
+	}
+	if found < 500 {
+		t.Error("LFU should keep frequently used entries", found)
+	}
+}
+
+func TestStrictLRU(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{StrictLRU: true})
+	for i := 0; i < 200; i++ {
+		cache.Set([]byte(fmt.Sprintf("hot%d", i)), make([]byte, 100), 0)
+	}
+	for n := 0; n < 100; n++ {
+		for i := 0; i < 100; i++ {
+			cache.Set([]byte(fmt.Sprintf("cold%d-%d", n, i)), make([]byte, 100), 0)
+		}
+		for i := 0; i < 200; i++ {
+			if _, err := cache.Get([]byte(fmt.Sprintf("hot%d", i))); err != nil {
+				t.Fatal("recently used entry evicted", n, i)
+			}
+		}
+		cache.Del([]byte(fmt.Sprintf("cold%d-0", n)))
+	}
+	if cache.EvacuateCount() == 0 {
+		t.Error("entries should be evacuated")
+	}
+	for i := range cache.segments {
+		seg := &cache.segments[i]
+		var count int64
+		for idx := seg.lru.head; idx >= 0; idx = seg.lru.nodes[idx].next {
+			count++
+		}
+		if count != seg.entryCount {
+			t.Fatal("list length", count, "entry count", seg.entryCount)
+		}
+	}
+}
+
+func TestPin(t *testing.T) {
+	for _, config := range []Config{{}, {StrictLRU: true}} {
+		cache := NewCacheWithConfig(512*1024, config)
+		if err := cache.Pin([]byte("missing")); err != ErrNotFound {
+			t.Fatal(err)
+		}
+		for i := 0; i < 100; i++ {
+			key := []byte(fmt.Sprintf("pinned%d", i))
+			cache.Set(key, make([]byte, 100), 0)
+			if err := cache.Pin(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+		cache.Set([]byte("pinned0"), make([]byte, 200), 0)
+		cache.Set([]byte("expiring"), []byte("v"), 1)
+		cache.Pin([]byte("expiring"))
+		cache.Set([]byte("unpinned"), []byte("v"), 0)
+		cache.Pin([]byte("unpinned"))
+		cache.Unpin([]byte("unpinned"))
+		for i := 0; i < 20000; i++ {
+			cache.Set([]byte(fmt.Sprintf("bulk%d", i)), make([]byte, 100), 0)
+		}
+		for i := 0; i < 100; i++ {
+			if _, err := cache.Get([]byte(fmt.Sprintf("pinned%d", i))); err != nil {
+				t.Fatal("pinned entry evicted", i, err)
+			}
+		}
+		if _, err := cache.Get([]byte("unpinned")); err != ErrNotFound {
+			t.Error("unpinned entry should be evicted", err)
+		}
+		time.Sleep(2 * time.Second)
+		if _, err := cache.Get([]byte("expiring")); err != ErrNotFound {
+			t.Error("pinned entry should expire", err)
+		}
+	}
+
+	cache := NewCache(512 * 1024)
+	var err error
+	for i := 0; i < 10000 && err == nil; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err = cache.Set(key, make([]byte, 100), 0); err == nil {
+			cache.Pin(key)
+		}
+	}
+	if err != ErrPinned {
+		t.Error("a segment full of pinned entries should return ErrPinned", err)
+	}
+}
+
+func TestPriority(t *testing.T) {
+	cache := NewCache(512 * 1024)
+	if err := cache.SetWithPriority([]byte("invalid"), []byte("v"), 0, MaxPriority+1); err != ErrInvalidPriority {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		cache.SetWithPriority([]byte(fmt.Sprintf("low%d", i)), make([]byte, 100), 0, PriorityLow)
+		cache.SetWithPriority([]byte(fmt.Sprintf("high%d", i)), make([]byte, 100), 0, PriorityCritical)
+	}
+	for i := 0; i < 4000; i++ {
+		cache.Set([]byte(fmt.Sprintf("normal%d", i)), make([]byte, 100), 0)
+	}
+	var low, high int
+	for i := 0; i < 1000; i++ {
+		if _, err := cache.Get([]byte(fmt.Sprintf("low%d", i))); err == nil {
+			low++
+		}
+		if _, err := cache.Get([]byte(fmt.Sprintf("high%d", i))); err == nil {
+			high++
+		}
+	}
+	if high < 900 || low > 100 {
+		t.Error("high priority entries should be kept over low priority ones", high, low)
+	}
+}
+
+func TestMaxEntries(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{MaxEntries: 2560})
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte("v"), 0)
+	}
+	if n := cache.EntryCount(); n > 2560 || n < 2000 {
+		t.Fatal("entry count", n)
+	}
+	for i := range cache.segments {
+		if n := cache.segments[i].entryCount; n > 10 {
+			t.Fatal("segment entry count", i, n)
+		}
+	}
+	if _, err := cache.Get([]byte("key9999")); err != nil {
+		t.Error(err)
+	}
+	// overwriting an entry of a full segment doesn't evict.
+	before := cache.EntryCount()
+	cache.Set([]byte("key9999"), []byte("value"), 0)
+	if cache.EntryCount() != before {
+		t.Error("overwrite evicted", before, cache.EntryCount())
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	cache := NewCache(512 * 1024)
+	used, free, slotBytes := cache.MemoryUsage()
+	if used != 0 || free != 512*1024 || slotBytes != 256*256*int64(unsafe.Sizeof(entryPtr{})) {
+		t.Fatal(used, free, slotBytes)
+	}
+	cache.Set([]byte("key"), []byte("value"), 0)
+	used, free, _ = cache.MemoryUsage()
+	if used != ENTRY_HDR_SIZE+8 || used+free != 512*1024 {
+		t.Error(used, free)
+	}
+	segId := int(cache.hash([]byte("key")) & 255)
+	if segUsed, segFree, _ := cache.SegmentMemoryUsage(segId); segUsed != used || segUsed+segFree != 2048 {
+		t.Error(segUsed, segFree)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 100), 0)
+	}
+	if cache.DeadBytes() != 0 {
+		t.Fatal(cache.DeadBytes())
+	}
+	for i := 0; i < 1000; i += 2 {
+		cache.Del([]byte(fmt.Sprintf("key%03d", i)))
+	}
+	cache.Set([]byte("key001"), make([]byte, 200), 0)
+	dead := int64(500*(ENTRY_HDR_SIZE+106) + ENTRY_HDR_SIZE + 106)
+	if cache.DeadBytes() != dead {
+		t.Fatal("dead bytes", cache.DeadBytes(), dead)
+	}
+	if f := cache.Fragmentation(); f < 0.45 || f > 0.55 {
+		t.Error("fragmentation", f)
+	}
+	if reclaimed := cache.Compact(); reclaimed != dead {
+		t.Error("reclaimed", reclaimed, dead)
+	}
+	if cache.DeadBytes() != 0 || cache.Compact() != 0 {
+		t.Error("dead bytes after compaction", cache.DeadBytes())
+	}
+	for i := 1; i < 1000; i += 2 {
+		if value, err := cache.Get([]byte(fmt.Sprintf("key%03d", i))); err != nil || i != 1 && len(value) != 100 {
+			t.Fatal(i, err)
+		}
+	}
+	for i := range cache.segments {
+		seg := &cache.segments[i]
+		if live, slack := seg.countLiveBytes(); seg.liveBytes != live || seg.slackBytes != slack {
+			t.Fatal("live bytes", i, seg.liveBytes, live, "slack", seg.slackBytes, slack)
+		}
+	}
+}
+
+func TestReuseFreeSpace(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{ReuseFreeSpace: true})
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%03d", i)), make([]byte, 100), 0)
+	}
+	for i := 0; i < 1000; i += 2 {
+		cache.Del([]byte(fmt.Sprintf("key%03d", i)))
+	}
+	reused := 0
+	for i := range cache.segments {
+		seg := &cache.segments[i]
+		if len(seg.holes) == 0 {
+			continue
+		}
+		end := seg.rb.End()
+		cache.SetWithHash([]byte(fmt.Sprintf("new%03d", i)), make([]byte, 90), uint64(i)|uint64(i)<<16, 0)
+		if seg.rb.End() != end {
+			t.Fatal("deleted space should be reused", i)
+		}
+		reused++
+	}
+	if reused < 200 {
+		t.Error("too few segments with deleted entries", reused)
+	}
+	for i := 0; i < 20000; i++ {
+		key := []byte(fmt.Sprintf("bulk%d", i))
+		cache.Set(key, make([]byte, 80+i%40), 0)
+		if i%3 == 0 {
+			cache.Del(key)
+		}
+	}
+	for i := range cache.segments {
+		seg := &cache.segments[i]
+		if live, slack := seg.countLiveBytes(); seg.liveBytes != live || seg.slackBytes != slack {
+			t.Fatal("live bytes", i, seg.liveBytes, live, "slack", seg.slackBytes, slack)
+		}
+		seg.iterate(0, nil, func(key, value []byte, hdr *entryHdr) bool {
+			if bytes.HasPrefix(key, []byte("new")) {
+				// set by SetWithHash, Get can't find them.
+				return true
+			}
+			if value, err := cache.Get(key); err != nil || len(value) < 80 {
+				t.Fatal(string(key), err)
+			}
+			return true
+		})
+	}
+}
+
+func TestSlackBytes(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	cache.Set([]byte("key"), make([]byte, 100), 0)
+	cache.Set([]byte("key"), make([]byte, 60), 0)
+	if cache.SlackBytes() != 40 {
+		t.Fatal(cache.SlackBytes())
+	}
+	cache.Set([]byte("key"), make([]byte, 90), 0)
+	if cache.SlackBytes() != 10 || cache.OverwriteCount() != 2 {
+		t.Fatal(cache.SlackBytes(), cache.OverwriteCount())
+	}
+	cache.Set([]byte("key"), make([]byte, 150), 0)
+	if cache.SlackBytes() != 50 {
+		t.Fatal("the capacity is doubled", cache.SlackBytes())
+	}
+	cache.Del([]byte("key"))
+	if cache.SlackBytes() != 0 {
+		t.Fatal(cache.SlackBytes())
+	}
+}
+
+func TestChunkLargeValues(t *testing.T) {
+	large := make([]byte, 20000)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	if err := NewCache(1024*1024).Set([]byte("large"), large, 0); err != ErrLargeEntry {
+		t.Fatal(err)
+	}
+	cache := NewCacheWithConfig(1024*1024, Config{ChunkLargeValues: true})
+	if err := cache.Set([]byte("large"), large, 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := cache.Get([]byte("large")); err != nil || !bytes.Equal(value, large) {
+		t.Fatal("chunked value", err)
+	}
+	if cache.EntryCount() < 20 {
+		t.Error("the value should be chunked", cache.EntryCount())
+	}
+	scanned := 0
+	cache.Scan(ScanFilter{}, func(key, value []byte) bool {
+		scanned++
+		return true
+	})
+	if scanned != 0 {
+		t.Error("chunks should not be scanned", scanned)
+	}
+	cache.Set([]byte("large"), large[:10000], 0)
+	cache.Set([]byte("large"), []byte("small"), 0)
+	if value, err := cache.Get([]byte("large")); err != nil || string(value) != "small" || cache.EntryCount() != 1 {
+		t.Fatal("overwritten chunks should be deleted", string(value), err, cache.EntryCount())
+	}
+	cache.Set([]byte("large"), large, 0)
+	if !cache.Del([]byte("large")) || cache.EntryCount() != 0 {
+		t.Fatal("deleted chunks", cache.EntryCount())
+	}
+	cache.Set([]byte("large"), large, 0)
+	for i := 0; i < 20000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 100), 0)
+	}
+	if _, err := cache.Get([]byte("large")); err != ErrNotFound {
+		t.Error("a value with evicted chunks should not be found", err)
+	}
+	if err := cache.Set([]byte("huge"), make([]byte, 100000), 0); err != ErrLargeEntry {
+		t.Error(err)
+	}
+}
+
+func TestChunkedOverwriteFails(t *testing.T) {
+	large := make([]byte, 20000)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	cache := NewCacheWithConfig(1024*1024, Config{ChunkLargeValues: true, Tunables: Tunables{MinTTL: 60, RejectShortTTL: true}})
+	if err := cache.Set([]byte("large"), large, 0); err != nil {
+		t.Fatal(err)
+	}
+	for name, set := range map[string]func() error{
+		"Set":         func() error { return cache.Set([]byte("large"), []byte("small"), 1) },
+		"SetNegative": func() error { return cache.SetNegative([]byte("large"), 1) },
+		"SetWithReport": func() error {
+			_, err := cache.SetWithReport([]byte("large"), []byte("small"), 1)
+			return err
+		},
+	} {
+		if err := set(); err != ErrShortTTL {
+			t.Fatal(name, "err should be ErrShortTTL", err)
+		}
+		if value, err := cache.Get([]byte("large")); err != nil || !bytes.Equal(value, large) {
+			t.Fatal(name, "the chunks should be kept if the value is not overwritten", err)
+		}
+	}
+}
+
+func TestMaxEntrySize(t *testing.T) {
+	small := NewCacheWithConfig(512*1024, Config{MaxEntrySize: 1500})
+	if err := small.Set([]byte("key"), make([]byte, 1400), 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := small.Get([]byte("key")); err != nil || len(value) != 1400 {
+		t.Fatal(err)
+	}
+	if err := small.Set([]byte("key"), make([]byte, 1500), 0); err != ErrLargeEntry {
+		t.Error(err)
+	}
+	limited := NewCacheWithConfig(16*1024*1024, Config{MaxEntrySize: 100})
+	if err := limited.Set([]byte("key"), make([]byte, 97), 0); err != nil {
+		t.Error(err)
+	}
+	if err := limited.Set([]byte("key"), make([]byte, 98), 0); err != ErrLargeEntry {
+		t.Error(err)
+	}
+}
+
+func TestStreaming(t *testing.T) {
+	large := make([]byte, 20000)
+	for i := range large {
+		large[i] = byte(i * 7)
+	}
+	cache := NewCacheWithConfig(1024*1024, Config{ChunkLargeValues: true})
+	for _, value := range [][]byte{large, large[:100]} {
+		if err := cache.SetReader([]byte("key"), bytes.NewReader(value), len(value), 0); err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := cache.GetWriter([]byte("key"), &buf); err != nil || !bytes.Equal(buf.Bytes(), value) {
+			t.Fatal("streamed value", len(value), err)
+		}
+	}
+	if err := cache.SetReader([]byte("short"), bytes.NewReader(large[:10000]), len(large), 0); err != io.ErrUnexpectedEOF {
+		t.Error(err)
+	}
+	if cache.EntryCount() != 1 {
+		t.Error("the chunks of a short value should be deleted", cache.EntryCount())
+	}
+	if err := cache.GetWriter([]byte("short"), io.Discard); err != ErrNotFound {
+		t.Error(err)
+	}
+}
+
+type mapStore struct {
+	mu sync.Mutex
+	m  map[string][]byte
+}
+
+func (s *mapStore) Set(key, value []byte, expireSeconds int) error {
+	s.mu.Lock()
+	s.m[string(key)] = append([]byte(nil), value...)
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *mapStore) Get(key []byte) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if value, ok := s.m[string(key)]; ok {
+		return value, nil
+	}
+	return nil, ErrNotFound
+}
+
+func (s *mapStore) Del(key []byte) error {
+	s.mu.Lock()
+	delete(s.m, string(key))
+	s.mu.Unlock()
+	return nil
+}
+
+func TestOverflow(t *testing.T) {
+	store := &mapStore{m: map[string][]byte{}}
+	cache := NewCacheWithConfig(512*1024, Config{Overflow: store})
+	large := bytes.Repeat([]byte("x"), 10000)
+	if err := cache.Set([]byte("large"), large, 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := cache.Get([]byte("large")); err != nil || !bytes.Equal(value, large) {
+		t.Fatal("the large value should be in the store", err)
+	}
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	if cache.EntryCount() == 10001 || len(store.m) == 1 {
+		t.Fatal("entries should have been evicted to the store", cache.EntryCount(), len(store.m))
+	}
+	for i := 0; i < 10000; i++ {
+		value, err := cache.Get([]byte(fmt.Sprintf("key%d", i)))
+		if err != nil || string(value) != fmt.Sprintf("value%d", i) {
+			t.Fatal(i, err)
+		}
+	}
+	cache.Del([]byte("key0"))
+	if _, err := cache.Get([]byte("key0")); err != ErrNotFound {
+		t.Error("the deleted key should not be in the store", err)
+	}
+	cache.Del([]byte("large"))
+	if _, ok := store.m["large"]; ok {
+		t.Error("the large value should be deleted")
+	}
+}
+
+func TestCompression(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{Compressor: FlateCompressor(flate.BestSpeed), ChunkLargeValues: true})
+	compressible := bytes.Repeat([]byte("abcdefgh"), 1000)
+	if err := cache.Set([]byte("compressible"), compressible, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set([]byte("small"), []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+	random := make([]byte, 300)
+	for i := range random {
+		random[i] = byte(i * 7919 >> 3)
+	}
+	if err := cache.Set([]byte("random"), random, 0); err != nil {
+		t.Fatal(err)
+	}
+	if used, _, _ := cache.MemoryUsage(); used > 2000 {
+		t.Error("the value should be compressed", used)
+	}
+	for key, want := range map[string][]byte{"compressible": compressible, "small": []byte("value"), "random": random} {
+		if value, err := cache.Get([]byte(key)); err != nil || !bytes.Equal(value, want) {
+			t.Error(key, err)
+		}
+		var buf bytes.Buffer
+		if err := cache.GetWriter([]byte(key), &buf); err != nil || !bytes.Equal(buf.Bytes(), want) {
+			t.Error(key, err)
+		}
+	}
+	m, err := cache.ToMap(1 << 20)
+	if err != nil || !bytes.Equal(m["compressible"], compressible) || string(m["small"]) != "value" {
+		t.Error("ToMap should return uncompressed values", err)
+	}
+	cache.Scan(ScanFilter{}, func(key, value []byte) bool {
+		if string(key) == "compressible" && !bytes.Equal(value, compressible) {
+			t.Error("Scan should return uncompressed values")
+		}
+		return true
+	})
+	if value, err := cache.FreezeCompact().Get([]byte("compressible")); err != nil || !bytes.Equal(value, compressible) {
+		t.Error("the frozen cache should hold uncompressed values", err)
+	}
+}
+
+func TestEncryption(t *testing.T) {
+	aead, err := NewAESGCM(bytes.Repeat([]byte("k"), 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewCacheWithConfig(1024*1024, Config{Encryption: aead, Compressor: FlateCompressor(flate.BestSpeed)})
+	secret := []byte("secret token value")
+	if err := cache.Set([]byte("token"), secret, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := cache.Set([]byte("large"), bytes.Repeat(secret, 100), 0); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := cache.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(buf.Bytes(), secret) {
+		t.Error("the snapshot should not contain the plaintext")
+	}
+	if value, err := cache.Get([]byte("token")); err != nil || !bytes.Equal(value, secret) {
+		t.Error(err)
+	}
+	if value, err := cache.Get([]byte("large")); err != nil || !bytes.Equal(value, bytes.Repeat(secret, 100)) {
+		t.Error(err)
+	}
+	restored, err := LoadCacheWithConfig(bytes.NewReader(buf.Bytes()), Config{Encryption: aead, Compressor: FlateCompressor(flate.BestSpeed)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, err := restored.Get([]byte("large")); err != nil || !bytes.Equal(value, bytes.Repeat(secret, 100)) {
+		t.Error(err)
+	}
+	other, _ := NewAESGCM(bytes.Repeat([]byte("o"), 32))
+	if restored, err = LoadCacheWithConfig(bytes.NewReader(buf.Bytes()), Config{Encryption: other}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restored.Get([]byte("token")); err != ErrDecrypt {
+		t.Error("err should be ErrDecrypt", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{Checksum: true})
+	value := []byte("checksummed value")
+	if err := cache.Set([]byte("key"), value, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := cache.Get([]byte("key")); err != nil || !bytes.Equal(got, value) {
+		t.Fatal(err)
+	}
+	for i := range cache.segments {
+		data := cache.segments[i].rb.data
+		if idx := bytes.Index(data, value); idx >= 0 {
+			data[idx] ^= 1
+		}
+	}
+	if _, err := cache.Get([]byte("key")); err != ErrCorrupted {
+		t.Error("err should be ErrCorrupted", err)
+	}
+	if count := cache.ErrorCount(ErrCorrupted); count != 1 {
+		t.Error("the corruption should be counted", count)
+	}
+}
+
+func TestLongKeys(t *testing.T) {
+	longKey := bytes.Repeat([]byte("k"), 70000)
+	if err := NewCache(1024*1024).Set(longKey, []byte("value"), 0); err != ErrLargeKey {
+		t.Fatal("err should be ErrLargeKey", err)
+	}
+	cache := NewCacheWithConfig(64*1024*1024, Config{LongKeys: true, MaxEntrySize: 100000})
+	if err := cache.Set(longKey, []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := cache.Get(longKey); err != nil || string(value) != "value" {
+		t.Fatal(string(value), err)
+	}
+	if _, err := cache.Get(bytes.Repeat([]byte("o"), 70000)); err != ErrNotFound {
+		t.Error("err should be ErrNotFound", err)
+	}
+	// an entry of another key with the same digest is not returned.
+	otherKey := bytes.Repeat([]byte("o"), 70000)
+	stored := cache.appendLongKey(otherKey, []byte("value"))
+	if _, err := cache.checkLongKey(longKey, cache.entryKey(longKey), stored); err != ErrNotFound {
+		t.Error("err should be ErrNotFound", err)
+	}
+	m, err := cache.ToMap(1 << 20)
+	if err != nil || string(m[string(longKey)]) != "value" {
+		t.Error("ToMap should return the long key", err)
+	}
+	if !cache.Del(longKey) {
+		t.Error("the long key should be deleted")
+	}
+	if _, err := cache.Get(longKey); err != ErrNotFound {
+		t.Error("err should be ErrNotFound", err)
+	}
+}
+
+func TestStringKeys(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	if err := cache.SetString("key", []byte("value"), 0); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := cache.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Error(err)
+	}
+	if value, err := cache.GetString("key"); err != nil || string(value) != "value" {
+		t.Error(err)
+	}
+	if _, err := cache.GetString(""); err != ErrNotFound {
+		t.Error("err should be ErrNotFound", err)
+	}
+	if !cache.DelString("key") {
+		t.Error("the key should be deleted")
+	}
+	if allocs := testing.AllocsPerRun(100, func() { cache.GetString("missing") }); allocs != 0 {
+		t.Error("GetString should not allocate", allocs)
+	}
+}
+
+func TestIntKeys(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	for i := int64(-100); i < 100; i++ {
+		if err := cache.SetInt(i, []byte(fmt.Sprint(i)), 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := int64(-100); i < 100; i++ {
+		if value, err := cache.GetInt(i); err != nil || string(value) != fmt.Sprint(i) {
+			t.Fatal(i, err)
+		}
+	}
+	if value, err := cache.Get(Int64Key.AppendKey(nil, 42)); err != nil || string(value) != "42" {
+		t.Error("the key should be encoded like Int64Key", err)
+	}
+	if !cache.DelInt(42) {
+		t.Error("the key should be deleted")
+	}
+	if _, err := cache.GetInt(42); err != ErrNotFound {
+		t.Error("err should be ErrNotFound", err)
+	}
+}
+
+func TestIntKeyAllocs(t *testing.T) {
+	j, err := OpenJournal(filepath.Join(t.TempDir(), "cache.journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	for _, config := range []Config{{}, {Journal: j}} {
+		cache := NewCacheWithConfig(1024*1024, config)
+		cache.SetInt(1, []byte("value"), 0)
+		for _, c := range []struct {
+			name   string
+			allocs float64
+			fn     func()
+		}{
+			{"Set", 0, func() { cache.Set([]byte("key"), []byte("value"), 0) }},
+			{"SetInt", 0, func() { cache.SetInt(1, []byte("value"), 0) }},
+			{"DelInt", 0, func() { cache.DelInt(2) }},
+			// the value returned.
+			{"GetInt", 1, func() { cache.GetInt(1) }},
+		} {
+			if allocs := testing.AllocsPerRun(100, c.fn); allocs != c.allocs {
+				t.Error(c.name, "allocates", allocs, "expected", c.allocs, "journal", config.Journal != nil)
+			}
+		}
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	cache.Set([]byte("key"), []byte("value"), 0)
+	var buf bytes.Buffer
+	buf.WriteString("prefix:")
+	if err := cache.GetInto([]byte("key"), &buf); err != nil || buf.String() != "prefix:value" {
+		t.Fatal(buf.String(), err)
+	}
+	if err := cache.GetInto([]byte("missing"), &buf); err != ErrNotFound || buf.String() != "prefix:value" {
+		t.Error("a missing key should not change the buffer", err)
+	}
+	buf.Grow(1024)
+	key := []byte("key")
+	if allocs := testing.AllocsPerRun(100, func() {
+		buf.Reset()
+		cache.GetInto(key, &buf)
+	}); allocs != 0 {
+		t.Error("GetInto should not allocate", allocs)
+	}
+}
+
+func TestCoarseClock(t *testing.T) {
+	cache := NewCacheWithConfig(1024, Config{ClockResolution: 10 * time.Millisecond})
+	if _, ok := cache.config.Clock.(*coarseClock); !ok {
+		t.Fatal("the cache should use the coarse clock")
+	}
+	start := cache.config.Clock.Now()
+	time.Sleep(50 * time.Millisecond)
+	if now := cache.config.Clock.Now(); !now.After(start) || time.Since(now) > time.Second {
+		t.Error("the coarse clock should be updated", start, now)
+	}
+	cache.Close()
+	time.Sleep(20 * time.Millisecond)
+	if time.Since(cache.config.Clock.Now()) > 10*time.Millisecond {
+		t.Error("the clock should read the system clock after Close")
+	}
+}
+
+func TestMonotonicClock(t *testing.T) {
+	cache := NewCache(1024)
+	clock, ok := cache.config.Clock.(monotonicClock)
+	if !ok {
+		t.Fatal("the default clock should be monotonic")
+	}
+	if d := clock.Now().Unix() - time.Now().Unix(); d < -1 || d > 1 {
+		t.Error("the clock should start at the system clock", d)
+	}
+	if _, ok := NewCacheWithConfig(1024, Config{WallClock: true}).config.Clock.(systemClock); !ok {
+		t.Error("WallClock should use the system clock")
+	}
+}
+
+func TestSharedReads(t *testing.T) {
+	clock := NewFakeClock(time.Unix(1000, 0))
+	cache := NewCacheWithConfig(1024*1024, Config{SharedReads: true, Clock: clock})
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 10)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				value, err := cache.Get([]byte(fmt.Sprintf("key%d", i)))
+				if err != nil || string(value) != fmt.Sprintf("value%d", i) {
+					t.Error("unexpected value", i, string(value), err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if cache.HitCount() != 4000 {
+		t.Error("hit count should be 4000", cache.HitCount())
+	}
+	clock.Advance(10 * time.Second)
+	if _, err := cache.Get([]byte("key1")); err != ErrNotFound {
+		t.Error("an expired entry should not be found", err)
+	}
+	if cache.EntryCount() != 1000 {
+		t.Error("a shared read should not delete the expired entry", cache.EntryCount())
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("SharedReads with StrictLRU should panic")
+		}
+	}()
+	NewCacheWithConfig(1024*1024, Config{SharedReads: true, StrictLRU: true})
+}
+
+func TestSetMulti(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	var entries []Entry
+	for i := 0; i < 1000; i++ {
+		entries = append(entries, Entry{Key: []byte(fmt.Sprintf("key%d", i)), Value: []byte(fmt.Sprintf("value%d", i))})
+	}
+	entries = append(entries, Entry{Key: []byte("key1"), Value: []byte("last"), ExpireSeconds: 10})
+	entries = append(entries, Entry{Key: []byte("large"), Value: make([]byte, 1024*1024)})
+	if err := cache.SetMulti(entries); err != ErrLargeEntry {
+		t.Error("the error of the large entry should be returned", err)
+	}
+	if cache.EntryCount() != 1000 {
+		t.Error("entry count should be 1000", cache.EntryCount())
+	}
+	for i := 0; i < 1000; i++ {
+		expected := fmt.Sprintf("value%d", i)
+		if i == 1 {
+			expected = "last"
+		}
+		if value, err := cache.Get([]byte(fmt.Sprintf("key%d", i))); err != nil || string(value) != expected {
+			t.Error("unexpected value", i, string(value), err)
+		}
+	}
+}
+
+func TestSegments(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{Segments: 16})
+	if cache.SegmentCount() != 16 {
+		t.Fatal("segment count should be 16", cache.SegmentCount())
+	}
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	// a segment is 1/16 of the cache, so an entry may be larger than 1/1024 of it.
+	if err := cache.Set([]byte("large"), make([]byte, 10000), 0); err != nil {
+		t.Error(err)
+	}
+	var buf bytes.Buffer
+	if err := cache.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCache(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.SegmentCount() != 16 || loaded.EntryCount() != 1001 {
+		t.Error("the loaded cache should have the segments of the snapshot", loaded.SegmentCount(), loaded.EntryCount())
+	}
+	if value, err := loaded.Get([]byte("key1")); err != nil || string(value) != "value1" {
+		t.Error("unexpected value", string(value), err)
+	}
+	if n := NewCache(1024 * 1024).SegmentCount(); n != 256 {
+		t.Error("the default segment count should be 256", n)
+	}
+	if n := NewCacheWithConfig(1024*1024, Config{Segments: AutoSegments}).SegmentCount(); n < 16 || n > 256 || n&(n-1) != 0 {
+		t.Error("unexpected automatic segment count", n)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("a segment count that is not a power of two should panic")
+		}
+	}()
+	NewCacheWithConfig(1024*1024, Config{Segments: 10})
+}
+
+func TestSegmentPadding(t *testing.T) {
+	if size := unsafe.Sizeof(segLock{}); size != cacheLineSize {
+		t.Error("a segment lock should fill a cache line", size)
+	}
+	if size := unsafe.Sizeof(segCounters{}); size != cacheLineSize {
+		t.Error("the counters of a segment should fill a cache line", size)
+	}
+}
+
+// countingStore is a Store that counts the bytes written to its ring buffer.
+type countingStore struct {
+	*RingBuf
+	written *int64
+}
+
+func (s countingStore) Write(p []byte) (int, error) {
+	atomic.AddInt64(s.written, int64(len(p)))
+	return s.RingBuf.Write(p)
+}
+
+func TestStorage(t *testing.T) {
+	var written int64
+	cache := NewCacheWithConfig(1024*1024, Config{Storage: func(data []byte) Store {
+		rb := newRingBuf(data, 0)
+		return countingStore{RingBuf: &rb, written: &written}
+	}})
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	for i := 0; i < 1000; i++ {
+		if value, err := cache.Get([]byte(fmt.Sprintf("key%d", i))); err != nil || string(value) != fmt.Sprintf("value%d", i) {
+			t.Fatal("unexpected value", i, string(value), err)
+		}
+	}
+	if cache.Del([]byte("key1")) != true || cache.EntryCount() != 999 {
+		t.Error("the entry should be deleted", cache.EntryCount())
+	}
+	if written == 0 || written != cache.PhysicalWrittenBytes() {
+		t.Error("the entries should be written to the store", written, cache.PhysicalWrittenBytes())
+	}
+	if err := cache.SaveTo(io.Discard); err != ErrUnsupportedStore {
+		t.Error("a cache with another store should not be saved", err)
+	}
+	if err := cache.Resize(2 * 1024 * 1024); err != ErrUnsupportedStore {
+		t.Error("a cache with another store should not be resized", err)
+	}
+}
+
+func TestHugePages(t *testing.T) {
+	cache := NewCacheWithConfig(4*1024*1024, Config{HugePages: true})
+	if runtime.GOOS == "linux" {
+		t.Log("huge pages", cache.Stats().HugePages)
+	} else if cache.Stats().HugePages {
+		t.Error("huge pages are only supported on Linux")
+	}
+	if NewCache(4 * 1024 * 1024).Stats().HugePages {
+		t.Error("huge pages should not be used unless configured")
+	}
+	cache.Set([]byte("key"), []byte("value"), 0)
+	if value, err := cache.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Error("unexpected value", string(value), err)
+	}
+}
+
+func TestWarm(t *testing.T) {
+	var lines strings.Builder
+	for i := 0; i < 3000; i++ {
+		record, _ := json.Marshal(warmRecord{Key: []byte(fmt.Sprintf("key%d", i)), Value: []byte(fmt.Sprintf("value%d", i)), TTL: 60})
+		lines.Write(record)
+		lines.WriteByte('\n')
+	}
+	cache := NewCache(1024 * 1024)
+	loaded, err := cache.Warm(strings.NewReader(lines.String()+"{malformed\n"), FormatJSONLines)
+	if err == nil || loaded != 3000 {
+		t.Error("the records before the malformed one should be loaded", loaded, err)
+	}
+	if value, err := cache.Get([]byte("key2999")); err != nil || string(value) != "value2999" {
+		t.Error("unexpected value", string(value), err)
+	}
+
+	var buf bytes.Buffer
+	if err = cache.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	warmed := NewCache(1024 * 1024)
+	if loaded, err = warmed.Warm(&buf, FormatSnapshot); err != nil || loaded != 3000 {
+		t.Fatal("all the entries of the snapshot should be loaded", loaded, err)
+	}
+	if value, err := warmed.Get([]byte("key1")); err != nil || string(value) != "value1" {
+		t.Error("unexpected value", string(value), err)
+	}
+	expiring := 0
+	warmed.Scan(ScanFilter{MinTTL: 1, MaxTTL: 60}, func(key, value []byte) bool {
+		expiring++
+		return true
+	})
+	if expiring != 3000 {
+		t.Error("the TTL of the entries should be kept", expiring)
+	}
+	if _, err = warmed.Warm(&buf, Format(100)); err != ErrUnknownFormat {
+		t.Error("unknown format", err)
+	}
+}
+
+func TestDump(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{TTLClasses: []TTLClass{{Name: "session", ExpireSeconds: 60}}})
+	cache.Set([]byte("plain"), []byte("0123456789"), 0)
+	cache.SetWithFlags([]byte("flagged"), []byte("value"), 30, 3)
+	cache.SetWithClass([]byte("session"), []byte("value"), "session")
+	var buf bytes.Buffer
+	if err := cache.Dump(&buf, DumpOptions{MaxValueLen: 4}); err != nil {
+		t.Fatal(err)
+	}
+	records := make(map[string]dumpRecord)
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record dumpRecord
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		records[string(record.Key)] = record
+	}
+	if len(records) != 3 {
+		t.Fatal("all the entries should be dumped", records)
+	}
+	if r := records["plain"]; string(r.Value) != "0123" || !r.Truncated || r.ValueLen != 10 || r.TTL != -1 {
+		t.Error("the value should be truncated", r)
+	}
+	if r := records["flagged"]; r.Flags != 3 || r.TTL != 30 {
+		t.Error("the flags and the TTL should be dumped", r)
+	}
+	if r := records["session"]; r.Class != "session" || r.TTL != 60 {
+		t.Error("the TTL class should be dumped", r)
+	}
+
+	buf.Reset()
+	if err := cache.Dump(&buf, DumpOptions{MetadataOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), `"value"`) || !strings.Contains(buf.String(), `"valueLen":10`) {
+		t.Error("only the metadata should be dumped", buf.String())
+	}
+
+	buf.Reset()
+	cache.Dump(&buf, DumpOptions{})
+	warmed := NewCache(1024 * 1024)
+	if loaded, err := warmed.Warm(&buf, FormatJSONLines); err != nil || loaded != 3 {
+		t.Fatal("a dump should be loaded by Warm", loaded, err)
+	}
+	if value, err := warmed.Get([]byte("plain")); err != nil || string(value) != "0123456789" {
+		t.Error("unexpected value", string(value), err)
+	}
+}
+
+func TestCheckInvariants(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	for i := range cache.segments {
+		cache.segments[i].checkInvariants()
+	}
+	seg := &cache.segments[cache.hash([]byte("key1"))&cache.segMask]
+	seg.entryCount++
+	defer func() {
+		if recover() == nil {
+			t.Error("an inconsistent segment should panic")
+		}
+	}()
+	seg.checkInvariants()
+}
+
+func TestVersions(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{Versions: true})
+	key := []byte("key")
+	if err := cache.SetIfVersion(key, []byte("v1"), 0, 0); err != nil {
+		t.Fatal("version zero should set a new key", err)
+	}
+	value, v1, err := cache.GetWithVersion(key)
+	if err != nil || string(value) != "v1" || v1 == 0 {
+		t.Fatal("unexpected value", string(value), v1, err)
+	}
+	if err = cache.SetIfVersion(key, []byte("v2"), 0, 0); err != ErrVersionMismatch {
+		t.Error("version zero should not overwrite a key", err)
+	}
+	if err = cache.SetIfVersion(key, []byte("v2"), 0, v1); err != nil {
+		t.Error("the current version should set the entry", err)
+	}
+	value, v2, _ := cache.GetWithVersion(key)
+	if string(value) != "v2" || v2 <= v1 {
+		t.Error("the version should increase", string(value), v1, v2)
+	}
+	if err = cache.SetIfVersion(key, []byte("v3"), 0, v1); err != ErrVersionMismatch {
+		t.Error("a stale version should not set the entry", err)
+	}
+	cache.Set(key, []byte("v3"), 0)
+	if _, v3, _ := cache.GetWithVersion(key); v3 <= v2 {
+		t.Error("Set should increase the version", v2, v3)
+	}
+	if value, err := cache.Get(key); err != nil || string(value) != "v3" {
+		t.Error("Get should return the value without the version", string(value), err)
+	}
+	if _, v, err := cache.GetWithVersion([]byte("missing")); err != ErrNotFound || v != 0 {
+		t.Error("a missing key should have version zero", v, err)
+	}
+	if _, _, err := NewCache(1024).GetWithVersion(key); err != ErrNoVersions {
+		t.Error("versions should require the config", err)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	key := []byte("key")
+	if old, err := cache.Swap(key, []byte("v1"), 0); err != nil || old != nil {
+		t.Error("a new key should have no old value", string(old), err)
+	}
+	if old, err := cache.Swap(key, []byte("v2"), 0); err != nil || string(old) != "v1" {
+		t.Error("the old value should be returned", string(old), err)
+	}
+	if value, err := cache.Get(key); err != nil || string(value) != "v2" {
+		t.Error("the new value should be set", string(value), err)
+	}
+	// concurrent swaps see every value exactly once.
+	cache.Set(key, []byte("0"), 0)
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	seen := make(map[string]bool)
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				old, _ := cache.Swap(key, []byte(fmt.Sprintf("%d-%d", g, i)), 0)
+				mu.Lock()
+				if seen[string(old)] {
+					t.Error("a value was swapped out twice", string(old))
+				}
+				seen[string(old)] = true
+				mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+	if len(seen) != 4000 {
+		t.Error("every value should be swapped out once", len(seen))
+	}
+
+	chunked := NewCacheWithConfig(1024*1024, Config{ChunkLargeValues: true})
+	large := bytes.Repeat([]byte("x"), 10000)
+	chunked.Set(key, large, 0)
+	if old, err := chunked.Swap(key, []byte("small"), 0); err != nil || !bytes.Equal(old, large) {
+		t.Error("a chunked old value should be returned", len(old), err)
+	}
+}
+
+func TestSetWithReport(t *testing.T) {
+	cache := NewCacheWithConfig(512*1024, Config{Segments: 1, EvictionPolicy: EvictFIFO})
+	value := make([]byte, 1000)
+	var report SetReport
+	var err error
+	for i := 0; len(report.Evicted) == 0; i++ {
+		if i > 1000 {
+			t.Fatal("the cache should evict entries")
+		}
+		if report, err = cache.SetWithReport([]byte(fmt.Sprintf("key%d", i)), value, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if report.EvictedBytes < int64(len(report.Evicted)*len(value)) {
+		t.Error("the evicted bytes should count the evicted entries", report.EvictedBytes, len(report.Evicted))
+	}
+	for _, key := range report.Evicted {
+		if _, err := cache.Get(key); err != ErrNotFound {
+			t.Error("an evicted key should not be found", string(key), err)
+		}
+	}
+	if report, _ = cache.SetWithReport([]byte("key0"), []byte("small"), 0); report.Evacuated != 0 || len(report.Evicted) != 0 {
+		t.Error("an entry that fits should not evict", report)
+	}
+}
+
+type keepPolicy struct{}
+
+func (keepPolicy) Evict(c EvictionCandidate) bool {
+	return false
+}
+
+func TestEvictionCounts(t *testing.T) {
+	fill := func(config Config, expireSeconds int) *Cache {
+		config.Segments = 1
+		cache := NewCacheWithConfig(512*1024, config)
+		for i := 0; i < 2000; i++ {
+			cache.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 1000), expireSeconds)
+		}
+		return cache
+	}
+	cache := fill(Config{EvictionPolicy: EvictFIFO}, 0)
+	if cache.EvictCount() == 0 || cache.ForcedEvictCount() != 0 || cache.ExpiredEvictCount() != 0 || cache.EvacuateCount() != 0 {
+		t.Error("FIFO should only evict", cache.EvictCount(), cache.ForcedEvictCount(), cache.ExpiredEvictCount(), cache.EvacuateCount())
+	}
+	if stat := cache.SegmentStats(0); stat.EvictCount != cache.EvictCount() {
+		t.Error("the segment should count the evictions", stat.EvictCount)
+	}
+	cache = fill(Config{EvictionPolicy: keepPolicy{}}, 0)
+	if cache.EvictCount() == 0 || cache.ForcedEvictCount() != cache.EvictCount() || cache.EvacuateCount() == 0 {
+		t.Error("a policy that keeps every entry should force evictions", cache.EvictCount(), cache.ForcedEvictCount(), cache.EvacuateCount())
+	}
+	stats := cache.Stats()
+	if stats.EvictCount != cache.EvictCount() || stats.ForcedEvictCount != cache.ForcedEvictCount() {
+		t.Error("the stats should have the eviction counts", stats)
+	}
+	clock := NewFakeClock(time.Now())
+	cache = NewCacheWithConfig(512*1024, Config{Segments: 1, Clock: clock})
+	for i := 0; i < 2000; i++ {
+		if i == 100 {
+			clock.Advance(2 * time.Second)
+		}
+		expireSeconds := 0
+		if i < 100 {
+			expireSeconds = 1
+		}
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 1000), expireSeconds)
+	}
+	if cache.ExpiredEvictCount() != 100 || cache.ExpiredCount() < 100 {
+		t.Error("the expired entries should be removed to make room", cache.ExpiredEvictCount(), cache.ExpiredCount())
+	}
+	cache.ResetStatistics()
+	if cache.EvictCount() != 0 || cache.ExpiredEvictCount() != 0 {
+		t.Error("the counts should be reset")
+	}
+}
+
+func TestHighWatermark(t *testing.T) {
+	type event struct {
+		above      bool
+		used, size int64
+	}
+	var events []event
+	// the segments fill unevenly, a watermark well below 1 is reached before any segment is full.
+	cache := NewCacheWithConfig(1024*1024, Config{Segments: 16, HighWatermark: 0.8, OnHighWatermark: func(above bool, used, size int64) {
+		events = append(events, event{above, used, size})
+	}})
+	for i := 0; len(events) == 0; i++ {
+		if i > 10000 {
+			t.Fatal("the watermark should be reached")
+		}
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 100), 0)
+	}
+	if !events[0].above || float64(events[0].used) < 0.8*float64(events[0].size) || cache.EvictCount() != 0 {
+		t.Error("the watermark should be reached before evictions", events[0], cache.EvictCount())
+	}
+	cache.Set([]byte("key"), make([]byte, 100), 0)
+	if len(events) != 1 {
+		t.Error("the watermark should be reported once", events)
+	}
+	cache.Clear()
+	if len(events) != 2 || events[1].above || cache.watermark.used.Load() != 0 {
+		t.Error("falling below the watermark should be reported", events)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("an invalid watermark should panic")
+		}
+	}()
+	NewCacheWithConfig(1024*1024, Config{HighWatermark: 1.5, OnHighWatermark: func(bool, int64, int64) {}})
+}
+
+func TestAdaptToMemoryLimit(t *testing.T) {
+	cache := NewCacheWithConfig(8*1024*1024, Config{Segments: 16})
+	for i := 0; i < 50000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 100), 0)
+	}
+	cache.adaptToMemoryLimit(50, 100)
+	if cache.Size() != 8*1024*1024 {
+		t.Error("the cache should not be resized without memory pressure", cache.Size())
+	}
+	cache.adaptToMemoryLimit(95, 100)
+	if cache.Size() != 6*1024*1024 {
+		t.Error("the cache should shrink under memory pressure", cache.Size())
+	}
+	for i := 0; i < 20; i++ {
+		cache.adaptToMemoryLimit(95, 100)
+	}
+	if cache.Size() != 1024*1024 || cache.EntryCount() == 0 {
+		t.Error("the cache should shrink to 1/8 of its size", cache.Size(), cache.EntryCount())
+	}
+	cache.adaptToMemoryLimit(0, 1300*1024)
+	if cache.Size() != 1024*1024 {
+		t.Error("the cache should not grow beyond the memory limit", cache.Size())
+	}
+	for i := 0; i < 20; i++ {
+		cache.adaptToMemoryLimit(0, 1<<40)
+	}
+	if cache.Size() != 8*1024*1024 {
+		t.Error("the cache should grow back to its size", cache.Size())
+	}
+	cache.Resize(4 * 1024 * 1024)
+	cache.adaptToMemoryLimit(0, 1<<40)
+	if cache.Size() != 4*1024*1024 {
+		t.Error("the cache should not grow beyond the size of Resize", cache.Size())
+	}
+}
+
+func TestTouch(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewCacheWithConfig(1024*1024, Config{Clock: clock, TimerWheel: true})
+	key := []byte("key")
+	if _, err := cache.TTL(key); err != ErrNotFound {
+		t.Error("a missing key should not be found", err)
+	}
+	if err := cache.Touch(key, 10); err != ErrNotFound {
+		t.Error("a missing key should not be touched", err)
+	}
+	cache.Set(key, []byte("value"), 0)
+	if timeLeft, err := cache.TTL(key); err != nil || timeLeft != 0 {
+		t.Error("an entry without expire time should have no TTL", timeLeft, err)
+	}
+	if err := cache.Touch(key, 10); err != nil {
+		t.Fatal(err)
+	}
+	if timeLeft, err := cache.TTL(key); err != nil || timeLeft != 10 {
+		t.Error("the touched entry should expire in 10 seconds", timeLeft, err)
+	}
+	clock.Advance(5 * time.Second)
+	if timeLeft, _ := cache.TTL(key); timeLeft != 5 {
+		t.Error("the TTL should decrease", timeLeft)
+	}
+	if count := cache.ExpiringWithin(10); count != 1 {
+		t.Error("the timer wheel should track the touched entry", count)
+	}
+	if value, err := cache.Get(key); err != nil || string(value) != "value" {
+		t.Error("the value should be kept", string(value), err)
+	}
+	cache.Touch(key, 0)
+	clock.Advance(time.Hour)
+	if _, err := cache.Get(key); err != nil {
+		t.Error("an entry touched with zero expire seconds should not expire", err)
+	}
+	cache.Touch(key, 1)
+	clock.Advance(2 * time.Second)
+	if _, err := cache.TTL(key); err != ErrNotFound {
+		t.Error("the entry should expire", err)
+	}
+
+	cache = NewCacheWithConfig(1024*1024, Config{Clock: clock, ChunkLargeValues: true})
+	large := bytes.Repeat([]byte("x"), 10*1024)
+	cache.Set(key, large, 0)
+	if err := cache.Touch(key, 10); err != nil {
+		t.Fatal(err)
+	}
+	clock.Advance(11 * time.Second)
+	if _, err := cache.Get(key); err != ErrNotFound {
+		t.Error("the chunked value should expire", err)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	cluster := NewCluster(0)
+	if _, err := cluster.Get([]byte("key")); err != ErrNoNodes {
+		t.Error("an empty cluster should have no nodes", err)
+	}
+	caches := map[string]*Cache{}
+	for _, name := range []string{"a", "b", "c"} {
+		caches[name] = NewCache(1024 * 1024)
+		cluster.Add(name, caches[name])
+	}
+	owners := map[string]string{}
+	for i := 0; i < 3000; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := cluster.Set(key, key, 0); err != nil {
+			t.Fatal(err)
+		}
+		owners[string(key)] = cluster.NodeFor(key)
+	}
+	for name, cache := range caches {
+		if count := cache.EntryCount(); count < 500 || count > 1500 {
+			t.Error("the keys should be spread over the nodes", name, count)
+		}
+	}
+	for key, owner := range owners {
+		if value, err := caches[owner].Get([]byte(key)); err != nil || string(value) != key {
+			t.Fatal("the entry should be in the node of the key", key, owner, err)
+		}
+	}
+	cluster.Remove("b")
+	moved := 0
+	for key, owner := range owners {
+		if node := cluster.NodeFor([]byte(key)); node != owner {
+			if owner != "b" {
+				t.Fatal("only the keys of the removed node should move", key, owner, node)
+			}
+			moved++
+		}
+	}
+	if moved == 0 {
+		t.Error("the keys of the removed node should move")
+	}
+	cluster.Get([]byte("key0"))
+	cluster.Get([]byte("missing"))
+	var hits, misses, sets int64
+	for _, stat := range cluster.NodeStats() {
+		hits += stat.Hits
+		misses += stat.Misses
+		sets += stat.Sets
+	}
+	if len(cluster.NodeStats()) != 2 || sets < 2000 || hits+misses != 2 {
+		t.Error("unexpected node stats", cluster.NodeStats())
+	}
+}
+
+func TestEvents(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewCacheWithConfig(512*1024, Config{Clock: clock})
+	events := cache.Events(100000)
+	cache.Set([]byte("a"), []byte("value"), 1)
+	cache.Set([]byte("b"), []byte("value"), 0)
+	cache.Del([]byte("b"))
+	cache.Del([]byte("missing"))
+	clock.Advance(2 * time.Second)
+	cache.Get([]byte("a"))
+	cache.Clear()
+	expected := []Event{{EventSet, []byte("a")}, {EventSet, []byte("b")}, {EventDel, []byte("b")},
+		{EventExpire, []byte("a")}, {EventClear, nil}}
+	for _, want := range expected {
+		if event := <-events; !reflect.DeepEqual(event, want) {
+			t.Errorf("event is %v %q, expected %v %q", event.Type, event.Key, want.Type, want.Key)
+		}
+	}
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), make([]byte, 100), 0)
+	}
+	evicted := 0
+	for len(events) > 0 {
+		if event := <-events; event.Type == EventEvict {
+			evicted++
+		}
+	}
+	if evicted == 0 || int64(evicted) != cache.EvictCount() {
+		t.Error("evict events are", evicted, "expected", cache.EvictCount())
+	}
+
+	small := cache.Events(1)
+	cache.Set([]byte("a"), []byte("value"), 0)
+	cache.Set([]byte("b"), []byte("value"), 0)
+	if dropped := cache.DroppedEvents(); dropped != 1 {
+		t.Error("dropped events is", dropped, "expected", 1)
+	}
+	cache.Close()
+	<-small
+	if _, ok := <-small; ok {
+		t.Error("the channel should be closed")
+	}
+	if _, ok := <-cache.Events(1); ok {
+		t.Error("the channel should be closed after Close")
+	}
+}
+
+// waitFor polls cond for up to a second.
+func waitFor(cond func() bool) bool {
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func testInvalidator(t *testing.T, a, b *Cache) {
+	a.Set([]byte("key"), []byte("a"), 0)
+	b.Set([]byte("key"), []byte("b"), 0)
+	if !waitFor(func() bool { _, err := a.Get([]byte("key")); return err == ErrNotFound }) {
+		t.Error("the key set in b should be invalidated in a")
+	}
+	b.Set([]byte("other"), []byte("b"), 0)
+	a.Del([]byte("other"))
+	if !waitFor(func() bool { _, err := b.Get([]byte("other")); return err == ErrNotFound }) {
+		t.Error("the key deleted in a should be invalidated in b")
+	}
+	// an invalidated key is not published again, which would delete the value set in b.
+	b.Set([]byte("stable"), []byte("b"), 0)
+	a.Set([]byte("stable"), []byte("a"), 0)
+	a.Del([]byte("stable"))
+	if !waitFor(func() bool { _, err := b.Get([]byte("stable")); return err == ErrNotFound }) {
+		t.Error("the key deleted in a should be invalidated in b")
+	}
+	b.Set([]byte("stable"), []byte("b"), 0)
+	time.Sleep(20 * time.Millisecond)
+	if value, _ := b.Get([]byte("stable")); string(value) != "b" {
+		t.Error("value is", string(value), "expected b")
+	}
+}
+
+func TestInvalidationBus(t *testing.T) {
+	bus := NewInvalidationBus()
+	a := NewCacheWithConfig(1024*1024, Config{Invalidator: bus.Join()})
+	b := NewCacheWithConfig(1024*1024, Config{Invalidator: bus.Join()})
+	testInvalidator(t, a, b)
+	if dropped := bus.Dropped(); dropped != 0 {
+		t.Error("dropped is", dropped)
+	}
+}
+
+func TestInvalidationBusLeave(t *testing.T) {
+	goroutines := runtime.NumGoroutine()
+	bus := NewInvalidationBus()
+	invA, invB := bus.Join(), bus.Join()
+	var received []string
+	invB.Subscribe(func(key []byte) { received = append(received, string(key)) })
+	invA.Publish([]byte("a"))
+	bus.Leave(invA)
+	// the keys queued before Leave are delivered.
+	if len(received) != 1 || received[0] != "a" {
+		t.Error("received", received)
+	}
+	invA.Publish([]byte("b"))
+	bus.Leave(invA)
+	bus.Leave(invB)
+	if len(received) != 1 {
+		t.Error("received", received, "after Leave")
+	}
+	for i := 0; i < 100 && runtime.NumGoroutine() > goroutines; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Error("goroutines are", n, "expected", goroutines)
+	}
+}
+
+func TestPacketInvalidator(t *testing.T) {
+	connA, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skip(err)
+	}
+	connB, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	invA := NewPacketInvalidator(connA, []net.Addr{connB.LocalAddr()})
+	defer invA.Close()
+	invB := NewPacketInvalidator(connB, []net.Addr{connA.LocalAddr()})
+	defer invB.Close()
+	a := NewCacheWithConfig(1024*1024, Config{Invalidator: invA})
+	b := NewCacheWithConfig(1024*1024, Config{Invalidator: invB})
+	testInvalidator(t, a, b)
+}
+
+type failingNode struct{ Node }
+
+func (failingNode) Set(key, value []byte, expireSeconds int) error { return ErrLargeEntry }
+
+func TestTiered(t *testing.T) {
+	front := NewCache(512 * 1024)
+	back := NewCache(4 * 1024 * 1024)
+	tiered := NewTiered(front, back, TieredConfig{PromoteExpireSeconds: 1000})
+	tiered.Set([]byte("a"), []byte("1"), 0)
+	if value, _ := front.Get([]byte("a")); string(value) != "1" {
+		t.Error("write through value is", string(value))
+	}
+	back.Set([]byte("b"), []byte("2"), 100)
+	if value, err := tiered.Get([]byte("b")); err != nil || string(value) != "2" {
+		t.Fatal(value, err)
+	}
+	if ttl, err := front.TTL([]byte("b")); err != nil || ttl == 0 || ttl > 100 {
+		t.Error("promoted ttl is", ttl, err)
+	}
+	if _, err := tiered.Get([]byte("missing")); err != ErrNotFound {
+		t.Error("err is", err, "expected", ErrNotFound)
+	}
+	if !tiered.Del([]byte("b")) {
+		t.Error("Del should be affected")
+	}
+	for _, c := range []*Cache{front, back} {
+		if _, err := c.Get([]byte("b")); err != ErrNotFound {
+			t.Error("the key should be deleted from both tiers")
+		}
+	}
+
+	tiered = NewTiered(front, back, TieredConfig{Write: WriteAround, NoPromote: true})
+	tiered.Set([]byte("a"), []byte("new"), 0)
+	if _, err := front.Get([]byte("a")); err != ErrNotFound {
+		t.Error("write around should delete the front value")
+	}
+	if value, _ := tiered.Get([]byte("a")); string(value) != "new" {
+		t.Error("value is", string(value))
+	}
+	if _, err := front.Get([]byte("a")); err != ErrNotFound {
+		t.Error("the value should not be promoted")
+	}
+
+	tiered = NewTiered(front, failingNode{back}, TieredConfig{})
+	front.Set([]byte("c"), []byte("old"), 0)
+	if err := tiered.Set([]byte("c"), []byte("new"), 0); err != ErrLargeEntry {
+		t.Error("err is", err)
+	}
+	if value, _ := front.Get([]byte("c")); string(value) != "old" {
+		t.Error("a failed write should not change the front tier")
+	}
+}
+
+func TestIncr(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewCacheWithConfig(1024*1024, Config{Clock: clock, Compressor: FlateCompressor(flate.BestSpeed)})
+	if value, err := cache.Incr([]byte("n"), 5, 10); err != nil || value != 5 {
+		t.Fatal(value, err)
+	}
+	clock.Advance(4 * time.Second)
+	if value, err := cache.Incr([]byte("n"), -7, 100); err != nil || value != -2 {
+		t.Fatal(value, err)
+	}
+	if ttl, _ := cache.TTL([]byte("n")); ttl != 6 {
+		t.Error("ttl is", ttl, "expected", 6)
+	}
+	if value, _ := cache.Get([]byte("n")); string(value) != "-2" {
+		t.Error("value is", string(value))
+	}
+	clock.Advance(6 * time.Second)
+	if value, err := cache.Incr([]byte("n"), 1, 0); err != nil || value != 1 {
+		t.Error("an expired key should be set to delta", value, err)
+	}
+	cache.Set([]byte("s"), []byte("abc"), 0)
+	if _, err := cache.Incr([]byte("s"), 1, 0); err != ErrNotInteger {
+		t.Error("err is", err, "expected", ErrNotInteger)
+	}
+	cache.Set([]byte("max"), []byte("9223372036854775807"), 0)
+	if _, err := cache.Incr([]byte("max"), 1, 0); err != ErrIntegerOverflow {
+		t.Error("err is", err, "expected", ErrIntegerOverflow)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				cache.Incr([]byte("concurrent"), 1, 0)
+			}
+		}()
+	}
+	wg.Wait()
+	if value, _ := cache.Get([]byte("concurrent")); string(value) != "8000" {
+		t.Error("value is", string(value), "expected", 8000)
+	}
+}
+
+func TestSetNegative(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	store := &mapStore{m: map[string][]byte{"key": []byte("stored")}}
+	cache := NewCacheWithConfig(1024*1024, Config{Clock: clock, Overflow: store, Versions: true})
+	if err := cache.SetNegative([]byte("key"), 10); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.m["key"]; ok {
+		t.Error("the key should be deleted from the store")
+	}
+	if _, err := cache.Get([]byte("key")); err != ErrNotFound {
+		t.Error("err is", err, "expected", ErrNotFound)
+	}
+	if _, negative, err := cache.GetNegative([]byte("key")); !negative || err != ErrNotFound {
+		t.Error("negative is", negative, "err is", err)
+	}
+	if _, negative, err := cache.GetNegative([]byte("missing")); negative || err != ErrNotFound {
+		t.Error("a miss should not be negative", negative, err)
+	}
+	if m, _ := cache.ToMap(1024); len(m) != 0 {
+		t.Error("negative entries should not be listed", m)
+	}
+	if hits := cache.HitCount(); hits != 2 {
+		t.Error("hits is", hits, "expected", 2)
+	}
+	cache.Set([]byte("key"), []byte("value"), 0)
+	if value, negative, err := cache.GetNegative([]byte("key")); negative || err != nil || string(value) != "value" {
+		t.Error(string(value), negative, err)
+	}
+	cache.SetNegative([]byte("key"), 10)
+	clock.Advance(11 * time.Second)
+	if _, negative, _ := cache.GetNegative([]byte("key")); negative {
+		t.Error("the negative entry should expire")
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{BloomFilter: 1000})
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), 0)
+	}
+	for i := 0; i < 1000; i++ {
+		if !cache.MayContain([]byte(fmt.Sprintf("key%d", i))) {
+			t.Fatal("a key set should be found", i)
+		}
+	}
+	positives := 0
+	for i := 0; i < 1000; i++ {
+		if cache.MayContain([]byte(fmt.Sprintf("missing%d", i))) {
+			positives++
+		}
+	}
+	if positives > 50 {
+		t.Error("false positives are", positives)
+	}
+	cache.SetNegative([]byte("negative"), 0)
+	if cache.MayContain([]byte("negative")) {
+		t.Error("a negative entry should not be added")
+	}
+	for i := 0; i < 500; i++ {
+		cache.Del([]byte(fmt.Sprintf("key%d", i)))
+	}
+	cache.RebuildBloomFilter()
+	found := 0
+	for i := 0; i < 500; i++ {
+		if cache.MayContain([]byte(fmt.Sprintf("key%d", i))) {
+			found++
+		}
+	}
+	if found > 50 {
+		t.Error("deleted keys found after the rebuild", found)
+	}
+	if !cache.MayContain([]byte("key999")) {
+		t.Error("a live key should be found after the rebuild")
+	}
+	var buf bytes.Buffer
+	if err := cache.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCacheWithConfig(&buf, Config{BloomFilter: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.MayContain([]byte("key999")) {
+		t.Error("a loaded key should be found")
+	}
+	if NewCache(1024*1024).MayContain([]byte("missing")) != true {
+		t.Error("MayContain should be true without a bloom filter")
+	}
+}
+
+func TestStaleWindowKept(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	expired := 0
+	cache := NewCacheWithConfig(1024*1024, Config{Clock: clock, StaleSeconds: 10,
+		OnExpire: func(key, value []byte) { expired++ }})
+	cache.Set([]byte("key"), []byte("41"), 1)
+	clock.Advance(2 * time.Second)
+	if _, err := cache.TTL([]byte("key")); err != ErrNotFound {
+		t.Error("TTL err is", err, "expected", ErrNotFound)
+	}
+	if err := cache.Touch([]byte("key"), 60); err != ErrNotFound {
+		t.Error("Touch err is", err, "expected", ErrNotFound)
+	}
+	if err := cache.Pin([]byte("key")); err != ErrNotFound {
+		t.Error("Pin err is", err, "expected", ErrNotFound)
+	}
+	if value, stale, err := cache.GetStale([]byte("key")); err != nil || !stale || string(value) != "41" {
+		t.Fatal("the stale entry should be kept", string(value), stale, err)
+	}
+	// a stale value is not found by Incr, the key is set to delta.
+	if value, err := cache.Incr([]byte("key"), 1, 0); err != nil || value != 1 {
+		t.Error(value, err)
+	}
+	if expired != 0 {
+		t.Error("OnExpire should not be called in the stale window", expired)
+	}
+	cache.Set([]byte("other"), []byte("value"), 1)
+	clock.Advance(11 * time.Second)
+	if _, err := cache.TTL([]byte("other")); err != ErrNotFound || expired != 1 {
+		t.Error("the entry should be expired after its stale window", err, expired)
+	}
+}
+
+func TestCompactStaleBoundary(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	cache := NewCacheWithConfig(1024*1024, Config{Clock: clock, StaleSeconds: 10, Segments: 1})
+	cache.Set([]byte("key"), []byte("value"), 1)
+	cache.Set([]byte("deleted"), []byte("value"), 0)
+	cache.Del([]byte("deleted"))
+	// the entry is expired by get and by compaction at the end of its stale window.
+	clock.Advance(11 * time.Second)
+	if reclaimed := cache.Compact(); reclaimed == 0 || cache.EntryCount() != 0 {
+		t.Error("the entry should be expired by compaction", reclaimed, cache.EntryCount())
+	}
+}
+
+func TestGetStale(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	loads := make(chan string, 10)
+	release := make(chan struct{})
+	cache := NewCacheWithConfig(1024*1024, Config{Clock: clock, StaleSeconds: 10, TimerWheel: true,
+		Loader: func(key []byte) ([]byte, int, error) {
+			loads <- string(key)
+			<-release
+			return []byte("fresh"), 5, nil
+		}})
+	defer cache.Close()
+	cache.Set([]byte("key"), []byte("old"), 5)
+	if count := cache.ExpiringWithin(5); count != 1 {
+		t.Error("expiring is", count, "expected", 1)
+	}
+	clock.Advance(6 * time.Second)
+	if _, err := cache.Get([]byte("key")); err != ErrNotFound {
+		t.Error("err is", err, "expected", ErrNotFound)
+	}
+	if n := cache.ExpireNow(100); n != 0 {
+		t.Error("a stale entry should not be expired", n)
+	}
+	value, stale, err := cache.GetStale([]byte("key"))
+	if err != nil || !stale || string(value) != "old" {
+		t.Fatal(string(value), stale, err)
+	}
+	if key := <-loads; key != "key" {
+		t.Error("loaded", key)
+	}
+	cache.GetStale([]byte("key"))
+	close(release)
+	if !waitFor(func() bool {
+		value, stale, _ := cache.GetStale([]byte("key"))
+		return !stale && string(value) == "fresh"
+	}) {
+		t.Error("the stale value should be refreshed")
+	}
+	if len(loads) != 0 {
+		t.Error("a key should be loaded once at a time")
+	}
+	cache.Set([]byte("other"), []byte("value"), 5)
+	clock.Advance(16 * time.Second)
+	if _, _, err := cache.GetStale([]byte("other")); err != ErrNotFound {
+		t.Error("err is", err, "expected", ErrNotFound)
+	}
+	if n := cache.ExpireNow(100); n != 1 {
+		t.Error("expired", n, "expected", 1)
+	}
+}
+
+func TestRefreshAhead(t *testing.T) {
+	clock := NewFakeClock(time.Now())
+	var loads atomic.Int32
+	longKey := bytes.Repeat([]byte("k"), 70000)
+	cache := NewCacheWithConfig(64*1024*1024, Config{Clock: clock, LongKeys: true, MaxEntrySize: 100000, RefreshAheadSeconds: 3,
+		Loader: func(key []byte) ([]byte, int, error) {
+			loads.Add(1)
+			return append([]byte("fresh"), key[0]), 10, nil
+		}})
+	for _, key := range [][]byte{[]byte("key"), longKey} {
+		cache.Set(key, []byte("old"), 10)
+		if value, _ := cache.Get(key); string(value) != "old" {
+			t.Fatal("value is", string(value))
+		}
+		if loads.Load() != 0 {
+			t.Fatal("an entry far from its expiration should not be refreshed")
+		}
+	}
+	clock.Advance(8 * time.Second)
+	for _, key := range [][]byte{[]byte("key"), longKey} {
+		cache.Get(key)
+		if !waitFor(func() bool { value, _ := cache.Get(key); return string(value) == "fresh"+string(key[0]) }) {
+			t.Error("the entry should be refreshed", len(key))
+		}
+	}
+	clock.Advance(8 * time.Second)
+	if value, err := cache.Get([]byte("key")); err != nil || string(value) != "freshk" {
+		t.Error("the refreshed entry should not expire", string(value), err)
+	}
+}
+
+func TestTryGetTrySet(t *testing.T) {
+	for _, shared := range []bool{false, true} {
+		cache := NewCacheWithConfig(1024*1024, Config{SharedReads: shared})
+		key := []byte("key")
+		if err := cache.TrySet(key, []byte("value"), 0); err != nil {
+			t.Fatal(err)
+		}
+		segId := cache.seeds.Load().cur.sipHash(key) & cache.segMask
+		cache.locks[segId].Lock()
+		if _, err := cache.TryGet(key); err != ErrBusy {
+			t.Error("err is", err, "expected", ErrBusy)
+		}
+		if err := cache.TrySet(key, []byte("other"), 0); err != ErrBusy {
+			t.Error("err is", err, "expected", ErrBusy)
+		}
+		if _, err := cache.TryGet([]byte("missing")); err != ErrNotFound && err != ErrBusy {
+			t.Error("err is", err)
+		}
+		cache.locks[segId].Unlock()
+		if value, err := cache.TryGet(key); err != nil || string(value) != "value" {
+			t.Error(string(value), err)
+		}
+		if shared {
+			cache.locks[segId].RLock()
+			if value, err := cache.TryGet(key); err != nil || string(value) != "value" {
+				t.Error("a shared lock should not make TryGet busy", string(value), err)
+			}
+			cache.locks[segId].RUnlock()
+		}
+		if count := cache.ErrorCount(ErrBusy); count < 2 {
+			t.Error("busy count is", count)
+		}
+		if hits := cache.HitCount(); hits < 1 {
+			t.Error("hits is", hits)
+		}
+	}
+}
+
+func TestRotateHashSeedSegments(t *testing.T) {
+	for _, segments := range []int{16, AutoSegments} {
+		cache := NewCacheWithConfig(1024*1024, Config{Segments: segments})
+		for i := 0; i < 1000; i++ {
+			cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+		}
+		seeds := &seedState{cur: newHashSeed(), old: &cache.seeds.Load().cur}
+		cache.seeds.Store(seeds)
+		cache.rehash(seeds)
+		for i := 0; i < 1000; i++ {
+			if value, err := cache.Get([]byte(fmt.Sprintf("key%d", i))); err != nil || string(value) != fmt.Sprintf("value%d", i) {
+				t.Fatal(segments, i, string(value), err)
+			}
+		}
+		if cache.EntryCount() != 1000 {
+			t.Error("entry count", cache.EntryCount())
+		}
+	}
+}
+
+func TestAdmissionSegments(t *testing.T) {
+	cache := NewCacheWithConfig(512*1024, Config{Admission: true, Segments: 16})
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("hot%d", i)), make([]byte, 100), 0)
+	}
+	for n := 0; n < 3; n++ {
+		for i := 0; i < 1000; i++ {
+			cache.Get([]byte(fmt.Sprintf("hot%d", i)))
+		}
+	}
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte(fmt.Sprintf("cold%d", i)), make([]byte, 100), 0)
+	}
+	found := 0
+	for i := 0; i < 1000; i++ {
+		if _, err := cache.Get([]byte(fmt.Sprintf("hot%d", i))); err == nil {
+			found++
+		}
+	}
+	if found < 500 {
+		t.Error("frequently used entries should stay in the cache", found)
+	}
+}
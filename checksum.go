@@ -0,0 +1,40 @@
+package freecache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+)
+
+const checksumLen = 4
+
+// appendChecksum returns the stored value of key followed by the CRC32 of the key and the value,
+// if the cache checksums values.
+func (cache *Cache) appendChecksum(key, value []byte) []byte {
+	if !cache.config.Checksum {
+		return value
+	}
+	// value may be the caller's slice, it is not appended to. The CRC is computed over copies, so
+	// key and value don't escape to the heap.
+	stored := make([]byte, len(value), len(value)+checksumLen)
+	copy(stored, value)
+	crc := crc32.Update(crc32.ChecksumIEEE(bytes.Clone(key)), crc32.IEEETable, stored)
+	return binary.LittleEndian.AppendUint32(stored, crc)
+}
+
+// verifyChecksum returns the stored value of key without the checksum, or ErrCorrupted if it
+// doesn't match.
+func (cache *Cache) verifyChecksum(key, value []byte) ([]byte, error) {
+	if !cache.config.Checksum {
+		return value, nil
+	}
+	if len(value) < checksumLen {
+		return nil, ErrCorrupted
+	}
+	n := len(value) - checksumLen
+	crc := crc32.Update(crc32.ChecksumIEEE(bytes.Clone(key)), crc32.IEEETable, value[:n])
+	if crc != binary.LittleEndian.Uint32(value[n:]) {
+		return nil, ErrCorrupted
+	}
+	return value[:n], nil
+}
@@ -0,0 +1,272 @@
+package freecache
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"time"
+	"unsafe"
+)
+
+// errChunked is returned by segment.get with the manifest of a chunked value as the value.
+var errChunked = errors.New("freecache: chunked value")
+
+// A chunked value is stored as a manifest entry under its key, and chunks under chunk keys, which
+// are the key followed by the generation of the value and the index of the chunk. Every Set of a
+// chunked value has a new random generation, so the chunks of different Sets never mix.
+// The manifest is the generation, the length of the value and the length of a chunk.
+const manifestLen = 20
+const chunkKeySuffixLen = 12
+
+// maxKeyValLen returns the largest key and value length of an entry.
+func (cache *Cache) maxKeyValLen() int {
+	return maxKeyValLen(int(cache.segSize.Load()), cache.config.MaxEntrySize)
+}
+
+func chunkKey(key []byte, gen uint64, idx int) []byte {
+	chunk := make([]byte, 0, len(key)+chunkKeySuffixLen)
+	chunk = append(chunk, key...)
+	chunk = binary.BigEndian.AppendUint64(chunk, gen)
+	return binary.BigEndian.AppendUint32(chunk, uint32(idx))
+}
+
+func parseManifest(manifest []byte) (gen uint64, length, chunkLen int, ok bool) {
+	if len(manifest) != manifestLen {
+		return
+	}
+	gen = binary.LittleEndian.Uint64(manifest)
+	length = int(binary.LittleEndian.Uint64(manifest[8:]))
+	chunkLen = int(binary.LittleEndian.Uint32(manifest[16:]))
+	ok = chunkLen > 0 && length >= 0
+	return
+}
+
+// chunkSource returns the chunks of a value from a byte slice, or from a reader if reader is not nil.
+type chunkSource struct {
+	value []byte
+	off   int // the offset of the next chunk in value.
+	// the reader is a struct of its own, so value doesn't escape to the heap with it.
+	reader *chunkReader
+}
+
+type chunkReader struct {
+	r   io.Reader
+	buf []byte
+}
+
+// next returns the next n bytes of the value, it is valid until the next call.
+func (src *chunkSource) next(n int) (chunk []byte, err error) {
+	if src.reader == nil {
+		chunk = src.value[src.off : src.off+n]
+		src.off += n
+		return
+	}
+	cr := src.reader
+	if cap(cr.buf) < n {
+		cr.buf = make([]byte, n)
+	}
+	buf := cr.buf[:n]
+	_, err = io.ReadFull(cr.r, buf)
+	return buf, err
+}
+
+// setChunked is setWithHash for a value that is too large for an entry. A value read from a reader
+// is not recorded in the journal or replicated, SetReader doesn't stream into a journaled cache.
+func (cache *Cache) setChunked(key []byte, length int, src *chunkSource, expireSeconds int, maxEvictions int, flags uint8, state uint16) (err error) {
+	if observe := cache.latency.Load(); observe != nil {
+		defer (*observe)(OpSet, time.Now())
+	}
+	expireSeconds, err = cache.tunables.Load().expireSeconds(expireSeconds)
+	if err == nil {
+		old := cache.manifest(key)
+		if err = cache.writeChunked(key, length, src, expireSeconds, maxEvictions, flags, state); err == nil {
+			cache.dropChunks(key, old)
+		}
+	}
+	if err == nil && cache.logged() && src.reader == nil {
+		var expireAt uint32
+		if expireSeconds > 0 {
+			expireAt = cache.now() + uint32(expireSeconds)
+		}
+		cache.log(journalSet, key, src.value, expireAt)
+	}
+	if cache.hotKeys != nil {
+		cache.hotKeys.record(key, cache.hash(key))
+	}
+	if err != nil {
+		cache.countError(err)
+	}
+	return
+}
+
+// writeChunked writes the chunks of the value of length, then the manifest.
+// The chunks written are deleted if a write fails.
+func (cache *Cache) writeChunked(key []byte, length int, src *chunkSource, expireSeconds int, maxEvictions int, flags uint8, state uint16) (err error) {
+	if len(key)+chunkKeySuffixLen > 65535 {
+		return ErrLargeKey
+	}
+	if length > 16*int(cache.segSize.Load()) {
+		return ErrLargeEntry
+	}
+	// a chunk takes at most half of the largest entry, so a chunk doesn't evict a whole segment.
+	chunkLen := (cache.maxKeyValLen() - len(key) - chunkKeySuffixLen) / 2
+	if chunkLen <= 0 {
+		return ErrLargeKey
+	}
+	state |= entryChunked
+	gen := rand.Uint64()
+	for idx := 0; idx*chunkLen < length; idx++ {
+		var chunk []byte
+		if chunk, err = src.next(min(chunkLen, length-idx*chunkLen)); err == nil {
+			err = cache.setPart(chunkKey(key, gen, idx), chunk, expireSeconds, maxEvictions, 0, state)
+		}
+		if err != nil {
+			for idx--; idx >= 0; idx-- {
+				cache.delPart(chunkKey(key, gen, idx))
+			}
+			return
+		}
+	}
+	manifest := make([]byte, manifestLen)
+	binary.LittleEndian.PutUint64(manifest, gen)
+	binary.LittleEndian.PutUint64(manifest[8:], uint64(length))
+	binary.LittleEndian.PutUint32(manifest[16:], uint32(chunkLen))
+	if err = cache.setPart(key, manifest, expireSeconds, maxEvictions, flags, state); err != nil {
+		cache.dropChunks(key, manifest)
+	}
+	return
+}
+
+// getChunks reads the chunks of the manifest and returns the value.
+func (cache *Cache) getChunks(key, manifest []byte) (value []byte, err error) {
+	err = cache.readChunks(key, manifest, func(chunk []byte, length int) error {
+		if value == nil {
+			value = make([]byte, 0, length)
+		}
+		value = append(value, chunk...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return
+}
+
+// readChunks calls fn with the chunks of the manifest in order and the length of the value,
+// ErrNotFound is returned if a chunk has been evicted. It stops if fn returns an error.
+func (cache *Cache) readChunks(key, manifest []byte, fn func(chunk []byte, length int) error) error {
+	gen, length, chunkLen, ok := parseManifest(manifest)
+	if !ok {
+		return ErrCorrupted
+	}
+	for idx := 0; idx*chunkLen < length; idx++ {
+		chunk, err := cache.getPart(chunkKey(key, gen, idx))
+		if err != nil || len(chunk) != min(chunkLen, length-idx*chunkLen) {
+			// the value is gone with the chunk.
+			return ErrNotFound
+		}
+		if err = fn(chunk, length); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// manifest returns the manifest of the key, nil if it is not a chunked value.
+func (cache *Cache) manifest(key []byte) (manifest []byte) {
+	seeds := cache.seeds.Load()
+	for _, seed := range []*hashSeed{&seeds.cur, seeds.old} {
+		if seed == nil {
+			continue
+		}
+		hashVal := seed.sipHash(key)
+		segId := hashVal & cache.segMask
+		cache.locks[segId].Lock()
+		manifest = cache.segments[segId].manifest(key, hashVal)
+		cache.locks[segId].Unlock()
+		if manifest != nil {
+			return
+		}
+	}
+	return
+}
+
+// dropChunks deletes the chunks of the manifest, if it is not nil.
+func (cache *Cache) dropChunks(key, manifest []byte) {
+	gen, length, chunkLen, ok := parseManifest(manifest)
+	if !ok {
+		return
+	}
+	for idx := 0; idx*chunkLen < length; idx++ {
+		cache.delPart(chunkKey(key, gen, idx))
+	}
+}
+
+// setPart sets the entry of a chunked value, like setKey without the tunables and the journal.
+func (cache *Cache) setPart(key, value []byte, expireSeconds int, maxEvictions int, flags uint8, state uint16) (err error) {
+	seeds := cache.seeds.Load()
+	hashVal := seeds.cur.sipHash(key)
+	segId := hashVal & cache.segMask
+	cache.locks[segId].Lock()
+	err = cache.guarded(segId, func() error {
+		return cache.segments[segId].set(key, value, hashVal, expireSeconds, maxEvictions, flags, state)
+	})
+	cache.unlock(segId)
+	if err == nil && seeds.old != nil {
+		cache.delOld(key, seeds.old.sipHash(key))
+	}
+	return
+}
+
+// getPart gets a chunk, the lookup is not counted in the statistics.
+func (cache *Cache) getPart(key []byte) (value []byte, err error) {
+	seeds := cache.seeds.Load()
+	for _, seed := range []*hashSeed{&seeds.cur, seeds.old} {
+		if seed == nil {
+			continue
+		}
+		hashVal := seed.sipHash(key)
+		segId := hashVal & cache.segMask
+		cache.locks[segId].Lock()
+		err = cache.guarded(segId, func() (err error) {
+			value, err = cache.segments[segId].get(key, hashVal, nil)
+			return
+		})
+		cache.unlock(segId)
+		if err == errChunked {
+			return value, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// delPart deletes a chunk without recording it in the journal.
+func (cache *Cache) delPart(key []byte) {
+	seeds := cache.seeds.Load()
+	cache.delOld(key, seeds.cur.sipHash(key))
+	if seeds.old != nil {
+		cache.delOld(key, seeds.old.sipHash(key))
+	}
+}
+
+// manifest returns a copy of the value of the entry if it is a live chunked value, nil otherwise.
+func (seg *segment) manifest(key []byte, hashVal uint64) []byte {
+	slotId := uint8(hashVal >> 8)
+	slotOff := int32(slotId) * seg.slotCap
+	slot := seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
+	idx, match := seg.lookup(slot, uint16(hashVal>>16), uint32(hashVal>>32), key)
+	if !match {
+		return nil
+	}
+	ptr := &slot[idx]
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	if !seg.validHdr(hdr, ptr, slotId) || !hdr.chunked() || hdr.valLen != manifestLen {
+		return nil
+	}
+	manifest := make([]byte, manifestLen)
+	seg.rb.ReadAt(manifest, hdr.valOff(ptr.offset))
+	return manifest
+}
@@ -0,0 +1,100 @@
+package freecache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Clock tells the time to a cache, for the expire times and the access times of entries,
+// see Config.Clock. The latencies of operations are measured by the system clock.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// monotonicClock is the system clock at start advanced by the monotonic clock.
+type monotonicClock struct {
+	start time.Time
+}
+
+func (c monotonicClock) Now() time.Time {
+	return c.start.Add(time.Since(c.start))
+}
+
+// coarseClock is a clock that is updated from source by a background goroutine every resolution,
+// reading it is an atomic load.
+type coarseClock struct {
+	source  Clock
+	nanos   atomic.Int64
+	stopped atomic.Bool
+}
+
+// newCoarseClock starts a coarseClock, it reads source directly when done is closed.
+func newCoarseClock(source Clock, resolution time.Duration, done <-chan struct{}) *coarseClock {
+	c := &coarseClock{source: source}
+	c.nanos.Store(source.Now().UnixNano())
+	go func() {
+		ticker := time.NewTicker(resolution)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				c.stopped.Store(true)
+				return
+			case <-ticker.C:
+				c.nanos.Store(source.Now().UnixNano())
+			}
+		}
+	}()
+	return c
+}
+
+func (c *coarseClock) Now() time.Time {
+	if c.stopped.Load() {
+		return c.source.Now()
+	}
+	return time.Unix(0, c.nanos.Load())
+}
+
+// FakeClock is a Clock that only moves when it is told to, so tests of expiration don't have to
+// sleep. It is safe for concurrent use.
+type FakeClock struct {
+	nanos atomic.Int64
+}
+
+// NewFakeClock creates a FakeClock at t.
+func NewFakeClock(t time.Time) *FakeClock {
+	c := new(FakeClock)
+	c.Set(t)
+	return c
+}
+
+// Now returns the time of the clock.
+func (c *FakeClock) Now() time.Time {
+	return time.Unix(0, c.nanos.Load())
+}
+
+// Set sets the time of the clock.
+func (c *FakeClock) Set(t time.Time) {
+	c.nanos.Store(t.UnixNano())
+}
+
+// Advance moves the clock forward by d.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.nanos.Add(int64(d))
+}
+
+// now returns the unix time of the clock of the cache.
+func (cache *Cache) now() uint32 {
+	return uint32(cache.config.Clock.Now().Unix())
+}
+
+// now returns the unix time of the clock of the segment.
+func (seg *segment) now() uint32 {
+	return uint32(seg.clock.Now().Unix())
+}
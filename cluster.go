@@ -0,0 +1,175 @@
+package freecache
+
+import (
+	"cmp"
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrNoNodes = errors.New("The cluster has no nodes")
+
+// Node is a cache of a Cluster, a *Cache, or a client of a cache in another process, like the
+// client of the grpcserver module.
+type Node interface {
+	Get(key []byte) (value []byte, err error)
+	Set(key, value []byte, expireSeconds int) error
+	Del(key []byte) (affected bool)
+}
+
+var _ Node = (*Cache)(nil)
+
+// clusterSeed hashes the keys and the nodes of clusters, it is fixed so every process maps a
+// key to the same node.
+var clusterSeed hashSeed
+
+// NodeStat is the statistics of a node of a Cluster, see Cluster.NodeStats.
+type NodeStat struct {
+	Name   string
+	Hits   int64
+	Misses int64
+	Sets   int64
+	Dels   int64
+	// Errors is the number of Get and Set calls that failed with an error other than ErrNotFound.
+	Errors int64
+}
+
+type clusterNode struct {
+	name   string
+	node   Node
+	hits   atomic.Int64
+	misses atomic.Int64
+	sets   atomic.Int64
+	dels   atomic.Int64
+	errors atomic.Int64
+}
+
+// ringPoint is a point of a node on the hash ring.
+type ringPoint struct {
+	hash uint64
+	node *clusterNode
+}
+
+// Cluster shards the keys over several nodes by consistent hashing, so the cache can grow beyond
+// one process, adding or removing a node only moves the keys of about 1/n of the ring. Every node
+// has many points on the ring so the keys are spread evenly.
+type Cluster struct {
+	mu     sync.RWMutex
+	points []ringPoint // sorted by hash.
+	nodes  []*clusterNode
+	// replicas is the number of points of a node on the ring.
+	replicas int
+}
+
+// NewCluster creates an empty cluster, every node has replicas points on the hash ring, zero means 128.
+func NewCluster(replicas int) *Cluster {
+	if replicas <= 0 {
+		replicas = 128
+	}
+	return &Cluster{replicas: replicas}
+}
+
+// Add adds the node with a name that is unique in the cluster, the node of the name is
+// replaced if it exists. The processes sharing the nodes must name them the same.
+func (c *Cluster) Add(name string, node Node) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(name)
+	n := &clusterNode{name: name, node: node}
+	c.nodes = append(c.nodes, n)
+	for i := 0; i < c.replicas; i++ {
+		c.points = append(c.points, ringPoint{hash: clusterSeed.sipHash([]byte(name + "#" + strconv.Itoa(i))), node: n})
+	}
+	slices.SortFunc(c.points, func(a, b ringPoint) int { return cmp.Compare(a.hash, b.hash) })
+}
+
+// Remove removes the node of the name, its keys map to the next nodes on the ring.
+func (c *Cluster) Remove(name string) {
+	c.mu.Lock()
+	c.remove(name)
+	c.mu.Unlock()
+}
+
+func (c *Cluster) remove(name string) {
+	c.nodes = slices.DeleteFunc(c.nodes, func(n *clusterNode) bool { return n.name == name })
+	c.points = slices.DeleteFunc(c.points, func(p ringPoint) bool { return p.node.name == name })
+}
+
+// locate returns the node of the key, the first point at or after the hash of the key.
+func (c *Cluster) locate(key []byte) *clusterNode {
+	hash := clusterSeed.sipHash(key)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.points) == 0 {
+		return nil
+	}
+	i, _ := slices.BinarySearchFunc(c.points, hash, func(p ringPoint, hash uint64) int { return cmp.Compare(p.hash, hash) })
+	if i == len(c.points) {
+		i = 0
+	}
+	return c.points[i].node
+}
+
+// NodeFor returns the name of the node of the key, an empty string if the cluster has no nodes.
+func (c *Cluster) NodeFor(key []byte) string {
+	if n := c.locate(key); n != nil {
+		return n.name
+	}
+	return ""
+}
+
+// Get gets the value from the node of the key.
+func (c *Cluster) Get(key []byte) (value []byte, err error) {
+	n := c.locate(key)
+	if n == nil {
+		return nil, ErrNoNodes
+	}
+	value, err = n.node.Get(key)
+	switch err {
+	case nil:
+		n.hits.Add(1)
+	case ErrNotFound:
+		n.misses.Add(1)
+	default:
+		n.errors.Add(1)
+	}
+	return
+}
+
+// Set sets the entry in the node of the key.
+func (c *Cluster) Set(key, value []byte, expireSeconds int) (err error) {
+	n := c.locate(key)
+	if n == nil {
+		return ErrNoNodes
+	}
+	if err = n.node.Set(key, value, expireSeconds); err != nil {
+		n.errors.Add(1)
+	} else {
+		n.sets.Add(1)
+	}
+	return
+}
+
+// Del deletes the entry from the node of the key.
+func (c *Cluster) Del(key []byte) (affected bool) {
+	n := c.locate(key)
+	if n == nil {
+		return false
+	}
+	n.dels.Add(1)
+	return n.node.Del(key)
+}
+
+// NodeStats returns the statistics of the operations of the cluster on each node, in the order
+// the nodes were added.
+func (c *Cluster) NodeStats() []NodeStat {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	stats := make([]NodeStat, len(c.nodes))
+	for i, n := range c.nodes {
+		stats[i] = NodeStat{Name: n.name, Hits: n.hits.Load(), Misses: n.misses.Load(), Sets: n.sets.Load(), Dels: n.dels.Load(), Errors: n.errors.Load()}
+	}
+	return stats
+}
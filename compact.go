@@ -0,0 +1,104 @@
+package freecache
+
+import (
+	"sync/atomic"
+	"unsafe"
+)
+
+// DeadBytes returns the bytes of the ring buffers occupied by deleted and overwritten entries,
+// which are reclaimed when the oldest entries are evacuated to make room, or by Compact.
+func (cache *Cache) DeadBytes() (n int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+		n += cache.segments[i].deadBytes()
+		cache.locks[i].Unlock()
+	}
+	return
+}
+
+// SlackBytes returns the unused value capacity of the live entries: an entry overwritten by a
+// smaller value is updated in place and keeps its capacity for a larger value later.
+func (cache *Cache) SlackBytes() (n int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		n += atomic.LoadInt64(&cache.segments[i].slackBytes)
+	}
+	return
+}
+
+// Fragmentation returns the fraction of the used bytes of the ring buffers that are dead bytes.
+func (cache *Cache) Fragmentation() float64 {
+	used, _, _ := cache.MemoryUsage()
+	if used == 0 {
+		return 0
+	}
+	return float64(cache.DeadBytes()) / float64(used)
+}
+
+// Compact reclaims the dead bytes and the expired entries of every segment that has dead bytes,
+// by moving the live entries together to the head of the ring buffer, and returns the number of
+// bytes reclaimed. Each segment is locked while it is compacted, which takes time proportional to
+// the size of the segment.
+func (cache *Cache) Compact() (reclaimed int64) {
+	for i := 0; i < len(cache.segments); i++ {
+		reclaimed += cache.CompactSegment(i)
+	}
+	return
+}
+
+// CompactSegment is like Compact for the segment idx, which must be in [0, SegmentCount()).
+func (cache *Cache) CompactSegment(idx int) (reclaimed int64) {
+	cache.locks[idx].Lock()
+	cache.guarded(uint64(idx), func() error {
+		if cache.segments[idx].deadBytes() > 0 {
+			reclaimed = cache.segments[idx].compact(cache.now())
+		}
+		return nil
+	})
+	cache.unlock(uint64(idx))
+	return
+}
+
+func (seg *segment) deadBytes() int64 {
+	return seg.rb.Size() - seg.vacuumLen - seg.liveBytes
+}
+
+// compact evacuates every live entry once from the oldest to the newest, and removes the deleted
+// and expired entries on the way.
+func (seg *segment) compact(now uint32) (reclaimed int64) {
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	for used := seg.rb.Size() - seg.vacuumLen; used > 0; {
+		oldOff := seg.rb.End() + seg.vacuumLen - seg.rb.Size()
+		seg.rb.ReadAt(hdrBuf[:], oldOff)
+		entryLen := hdr.entryLen()
+		used -= entryLen
+		expired := hdr.expireAt != 0 && hdr.expireAt+seg.stale <= now
+		if !hdr.deleted() && !expired {
+			seg.evacuateEntry(hdr, oldOff, entryLen)
+			continue
+		}
+		if !hdr.deleted() {
+			seg.delExpiredEntry(hdr, oldOff)
+		}
+		seg.totalTime -= int64(hdr.accessTime)
+		seg.totalCount--
+		seg.vacuumLen += entryLen
+		reclaimed += entryLen
+	}
+	return
+}
+
+// countLiveBytes sums the lengths and the unused value capacity of the entries referenced by the slots.
+func (seg *segment) countLiveBytes() (n, slack int64) {
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	for slotId := 0; slotId < 256; slotId++ {
+		slotOff := int32(slotId) * seg.slotCap
+		for _, ptr := range seg.slotsData[slotOff : slotOff+seg.slotLens[slotId]] {
+			seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+			n += hdr.entryLen()
+			slack += int64(hdr.valCap - hdr.valLen)
+		}
+	}
+	return
+}
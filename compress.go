@@ -0,0 +1,153 @@
+package freecache
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+)
+
+var ErrDecompress = errors.New("The value can not be decompressed")
+
+const (
+	valueRaw        = 0
+	valueCompressed = 1
+
+	// defaultCompressThreshold is the CompressThreshold if it is zero.
+	defaultCompressThreshold = 256
+)
+
+// Compressor compresses the values of a cache, see Config.Compressor. Adapters for snappy or zstd
+// are a few lines, FlateCompressor is built in. The methods may be called concurrently.
+type Compressor interface {
+	// Compress appends the compressed src to dst and returns the extended buffer.
+	Compress(dst, src []byte) []byte
+	// Decompress appends the decompressed src to dst and returns the extended buffer.
+	Decompress(dst, src []byte) ([]byte, error)
+}
+
+type flateCompressor struct {
+	level int
+}
+
+// FlateCompressor returns a Compressor that uses DEFLATE of the standard library at level,
+// see compress/flate.
+func FlateCompressor(level int) Compressor {
+	return flateCompressor{level: level}
+}
+
+func (c flateCompressor) Compress(dst, src []byte) []byte {
+	buf := bytes.NewBuffer(dst)
+	w, err := flate.NewWriter(buf, c.level)
+	if err != nil {
+		panic(err)
+	}
+	w.Write(src)
+	w.Close()
+	return buf.Bytes()
+}
+
+func (c flateCompressor) Decompress(dst, src []byte) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	r := flate.NewReader(bytes.NewReader(src))
+	_, err := io.Copy(buf, r)
+	r.Close()
+	return buf.Bytes(), err
+}
+
+// encodeEntry returns the key and the value of the entry of key and value: a long key is replaced
+// by its digest, and the value is compressed, encrypted, checksummed, then preceded by its version.
+func (cache *Cache) encodeEntry(key, value []byte) (entryKey, stored []byte) {
+	entryKey = cache.entryKey(key)
+	value = cache.appendLongKey(key, value)
+	return entryKey, cache.appendVersionSpace(cache.appendChecksum(entryKey, cache.seal(entryKey, cache.compress(value))))
+}
+
+// decodeEntry returns the key and the value of a stored entry, see encodeEntry.
+func (cache *Cache) decodeEntry(entryKey, stored []byte) (key, value []byte, err error) {
+	if value, err = cache.decodeValue(entryKey, stored); err != nil {
+		return
+	}
+	return cache.splitLongKey(entryKey, value)
+}
+
+// decodeValue returns the value of the entry of key from a stored value.
+func (cache *Cache) decodeValue(key, value []byte) ([]byte, error) {
+	value, err := cache.stripVersion(value)
+	if err != nil {
+		return nil, err
+	}
+	if value, err = cache.verifyChecksum(key, value); err != nil {
+		return nil, err
+	}
+	if value, err = cache.open(key, value); err != nil {
+		return nil, err
+	}
+	return cache.decompress(value)
+}
+
+// decodeResult decodes the value returned by a lookup of key, entryKey is the key of its entry.
+func (cache *Cache) decodeResult(key, entryKey, value []byte, err error) ([]byte, error) {
+	if err != nil || !cache.encodes() {
+		return value, err
+	}
+	if value, err = cache.decodeValue(entryKey, value); err == nil {
+		value, err = cache.checkLongKey(key, entryKey, value)
+	}
+	if err != nil {
+		cache.countError(err)
+	}
+	return value, err
+}
+
+// encodes tells whether the stored entries are encoded.
+func (cache *Cache) encodes() bool {
+	return cache.config.Compressor != nil || cache.config.Encryption != nil || cache.config.Checksum ||
+		cache.config.LongKeys || cache.config.Versions
+}
+
+// compress returns the stored value for value if the cache compresses values. The header of an
+// entry has no free bit, so the stored value starts with a byte that tells whether the rest is
+// compressed, a value is stored uncompressed if it is below the threshold or doesn't shrink.
+func (cache *Cache) compress(value []byte) []byte {
+	c := cache.config.Compressor
+	if c == nil {
+		return value
+	}
+	threshold := cache.config.CompressThreshold
+	if threshold == 0 {
+		threshold = defaultCompressThreshold
+	}
+	// the compressor is given the copy of the raw value, so value doesn't escape to the heap.
+	encoded := make([]byte, len(value)+1)
+	encoded[0] = valueRaw
+	copy(encoded[1:], value)
+	if len(value) >= threshold {
+		if compressed := c.Compress([]byte{valueCompressed}, encoded[1:]); len(compressed) <= len(value) {
+			return compressed
+		}
+	}
+	return encoded
+}
+
+// decompress returns the value of a stored value, the uncompressed value is a sub slice of it.
+func (cache *Cache) decompress(value []byte) ([]byte, error) {
+	c := cache.config.Compressor
+	if c == nil {
+		return value, nil
+	}
+	if len(value) == 0 {
+		return nil, ErrDecompress
+	}
+	switch value[0] {
+	case valueRaw:
+		return value[1:], nil
+	case valueCompressed:
+		decoded, err := c.Decompress(nil, value[1:])
+		if err != nil {
+			return nil, ErrDecompress
+		}
+		return decoded, nil
+	}
+	return nil, ErrDecompress
+}
@@ -0,0 +1,43 @@
+package freecache
+
+import (
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+// cacheLineSize is the size the state of a segment is padded to, so the state of adjacent segments,
+// which are used by different cores, is not in a shared cache line.
+const cacheLineSize = 64
+
+// segLock is the lock of a segment padded to a cache line.
+type segLock struct {
+	sync.RWMutex
+	_ [cacheLineSize - unsafe.Sizeof(sync.RWMutex{})]byte
+}
+
+// segCounters are the statistics counters of a segment, they are updated atomically, so lookups
+// count hits and misses after unlocking the segment, and the counters are read without locking it.
+// They fill a cache line, so the counters of adjacent segments don't share one, a counter added
+// must be padded to the next cache line.
+type segCounters struct {
+	hits        atomic.Int64 // number of Get calls found the entry.
+	misses      atomic.Int64 // number of Get calls did not find the entry.
+	evacuations atomic.Int64 // number of old entries moved to the head of the ring buffer to keep them.
+	overwrites  atomic.Int64
+	collisions  atomic.Int64 // number of lookups the fingerprint matched an entry of another key.
+	evictions   atomic.Int64 // number of live entries removed to make room.
+	forced      atomic.Int64 // number of evictions after maxConsecutiveEvacuations, regardless of the policy.
+	expired     atomic.Int64 // number of expired entries removed to make room.
+}
+
+func (c *segCounters) reset() {
+	c.hits.Store(0)
+	c.misses.Store(0)
+	c.evacuations.Store(0)
+	c.overwrites.Store(0)
+	c.collisions.Store(0)
+	c.evictions.Store(0)
+	c.forced.Store(0)
+	c.expired.Store(0)
+}
@@ -0,0 +1,81 @@
+package freecache
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// DumpOptions selects what Dump writes of the entries.
+type DumpOptions struct {
+	// MaxValueLen truncates the values longer than it, zero means the values are not truncated.
+	MaxValueLen int
+	// MetadataOnly omits the values.
+	MetadataOnly bool
+}
+
+// dumpRecord is a line written by Dump, it can be loaded by Warm with FormatJSONLines.
+type dumpRecord struct {
+	Key       []byte   `json:"key"`
+	Value     []byte   `json:"value,omitempty"`
+	TTL       int      `json:"ttl"` // the remaining seconds, -1 if the entry doesn't expire.
+	ValueLen  int      `json:"valueLen"`
+	Truncated bool     `json:"truncated,omitempty"`
+	Flags     uint8    `json:"flags,omitempty"`
+	Class     string   `json:"class,omitempty"`
+	Priority  Priority `json:"priority,omitempty"`
+	Pinned    bool     `json:"pinned,omitempty"`
+	AccessAge int      `json:"accessAge"` // the seconds since the last access.
+	Segment   int      `json:"segment"`
+}
+
+// Dump writes every live entry to w as a line of JSON, for inspecting offline what the cache
+// holds. The key and the value are base64 encoded like []byte in encoding/json, the record also
+// has the remaining TTL, the flags, the TTL class, the priority, whether the entry is pinned, the
+// seconds since its last access and its segment. The output can be loaded by Warm with
+// FormatJSONLines if the values are not truncated. Chunked values are skipped. Like Scan, each
+// segment is locked while it is copied, and w is written without holding any lock.
+func (cache *Cache) Dump(w io.Writer, opts DumpOptions) (err error) {
+	enc := json.NewEncoder(w)
+	now := cache.now()
+	var records []dumpRecord
+	for i := 0; i < len(cache.segments); i++ {
+		records = records[:0]
+		cache.locks[i].Lock()
+		cache.segments[i].iterate(now, nil, func(key, value []byte, hdr *entryHdr) bool {
+			record := dumpRecord{Key: key, Value: value, TTL: -1, Segment: i}
+			if hdr.expireAt != 0 {
+				record.TTL = int(hdr.expireAt - now)
+			}
+			if hdr.accessTime < now {
+				record.AccessAge = int(now - hdr.accessTime)
+			}
+			record.Flags = hdr.userFlags()
+			if class := hdr.class(); class != 0 && int(class) <= len(cache.config.TTLClasses) {
+				record.Class = cache.config.TTLClasses[class-1].Name
+			}
+			record.Priority = hdr.priority()
+			record.Pinned = hdr.pinned()
+			records = append(records, record)
+			return true
+		})
+		cache.locks[i].Unlock()
+		for _, record := range records {
+			key, value, decodeErr := cache.decodeEntry(record.Key, record.Value)
+			if decodeErr != nil {
+				continue
+			}
+			record.Key, record.ValueLen = key, len(value)
+			switch {
+			case opts.MetadataOnly:
+				value = nil
+			case opts.MaxValueLen > 0 && len(value) > opts.MaxValueLen:
+				value, record.Truncated = value[:opts.MaxValueLen], true
+			}
+			record.Value = value
+			if err = enc.Encode(&record); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
@@ -0,0 +1,56 @@
+package freecache
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+)
+
+var ErrDecrypt = errors.New("The value can not be decrypted")
+
+// NewAESGCM returns the AES-GCM AEAD of key for Config.Encryption, key must be 16, 24 or 32 bytes.
+func NewAESGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// seal encrypts the stored value of key if the cache encrypts values. The stored value is a random
+// nonce followed by the sealed value, the key is the additional data, so a value can not be moved
+// to another key.
+func (cache *Cache) seal(key, value []byte) []byte {
+	aead := cache.config.Encryption
+	if aead == nil {
+		return value
+	}
+	nonceSize := aead.NonceSize()
+	// value is sealed in place in a copy, and aead is given a copy of key, so key and value don't
+	// escape to the heap.
+	sealed := make([]byte, nonceSize+len(value), nonceSize+len(value)+aead.Overhead())
+	if _, err := rand.Read(sealed[:nonceSize]); err != nil {
+		panic(err)
+	}
+	copy(sealed[nonceSize:], value)
+	return aead.Seal(sealed[:nonceSize], sealed[:nonceSize], sealed[nonceSize:], bytes.Clone(key))
+}
+
+// open decrypts a stored value of key.
+func (cache *Cache) open(key, value []byte) ([]byte, error) {
+	aead := cache.config.Encryption
+	if aead == nil {
+		return value, nil
+	}
+	nonceSize := aead.NonceSize()
+	if len(value) < nonceSize {
+		return nil, ErrDecrypt
+	}
+	value, err := aead.Open(value[nonceSize:nonceSize], value[:nonceSize], value[nonceSize:], bytes.Clone(key))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return value, nil
+}
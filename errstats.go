@@ -0,0 +1,55 @@
+package freecache
+
+import (
+	"sync/atomic"
+)
+
+// countedErrors are the error classes counted by the cache, see ErrorCount.
+var countedErrors = [...]error{
+	ErrLargeKey,
+	ErrLargeEntry,
+	ErrNotFound,
+	ErrShortTTL,
+	ErrWouldBlock,
+	ErrCorrupted,
+	ErrInvalidFlags,
+	ErrUnknownTTLClass,
+	ErrNotAdmitted,
+	ErrPinned,
+	ErrInvalidPriority,
+	ErrDecompress,
+	ErrDecrypt,
+	ErrVersionMismatch,
+	ErrBusy,
+}
+
+func (cache *Cache) countError(err error) {
+	if !cache.tunables.Load().instrumented() {
+		return
+	}
+	for i, e := range countedErrors {
+		if e == err {
+			atomic.AddInt64(&cache.errorCounts[i], 1)
+			return
+		}
+	}
+}
+
+// ErrorCount returns the number of times err has been returned by the cache.
+func (cache *Cache) ErrorCount(err error) int64 {
+	for i, e := range countedErrors {
+		if e == err {
+			return atomic.LoadInt64(&cache.errorCounts[i])
+		}
+	}
+	return 0
+}
+
+// ErrorCounts returns the number of times every error class has been returned by the cache.
+func (cache *Cache) ErrorCounts() map[error]int64 {
+	counts := make(map[error]int64, len(countedErrors))
+	for i, e := range countedErrors {
+		counts[e] = atomic.LoadInt64(&cache.errorCounts[i])
+	}
+	return counts
+}
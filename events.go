@@ -0,0 +1,97 @@
+package freecache
+
+// EventType is the type of an Event.
+type EventType uint8
+
+const (
+	EventSet    EventType = iota // the entry is set, or its expire time is changed by Touch.
+	EventDel                     // the entry is deleted by Del.
+	EventExpire                  // the entry is removed because it is expired.
+	EventEvict                   // the entry is evicted to make room for another one.
+	EventClear                   // the cache is cleared, Key is nil.
+)
+
+// Event is a change of an entry of the cache sent to the Events channels. Key is the key of the
+// entry, which is the digest of the key for a long key, see Config.LongKeys, and a Del of it
+// deletes the key from a cache with the same config.
+type Event struct {
+	Type EventType
+	Key  []byte
+}
+
+// Events returns a channel receiving an Event for every Set, Del, expiration and eviction of the
+// cache from now on, buffer is the capacity of the channel. Events are sent without blocking,
+// an event is dropped, and counted by DroppedEvents, when the channel is full. The channel is
+// closed by Close. Every call returns a new channel, which receives all the events.
+//
+// Copies of the expired and evicted entries are kept until the segment is unlocked, like for OnExpire.
+func (cache *Cache) Events(buffer int) <-chan Event {
+	ch := make(chan Event, buffer)
+	cache.eventsMu.Lock()
+	select {
+	case <-cache.closeChan:
+		close(ch)
+		cache.eventsMu.Unlock()
+		return ch
+	default:
+	}
+	cache.eventChans = append(cache.eventChans, ch)
+	cache.hasEvents.Store(true)
+	cache.eventsMu.Unlock()
+	// the segments keep copies of the expired and evicted entries for the events from now on.
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		cache.segments[i].keepExpired = true
+		cache.segments[i].keepEvicts = true
+		cache.locks[i].Unlock()
+	}
+	return ch
+}
+
+// DroppedEvents returns the number of events that are dropped because an Events channel was full.
+func (cache *Cache) DroppedEvents() int64 {
+	return cache.droppedEvents.Load()
+}
+
+// sendEvent sends the event of a journal record.
+func (cache *Cache) sendEvent(op byte, key []byte) {
+	event := Event{Type: EventSet}
+	switch op {
+	case journalDel:
+		event.Type = EventDel
+	case journalClear:
+		event.Type = EventClear
+	}
+	if key != nil {
+		event.Key = append([]byte(nil), key...)
+	}
+	cache.broadcast(event)
+}
+
+// sendEntryEvents sends an event for every expired or evicted entry, the keys are the copies kept by the segment.
+func (cache *Cache) sendEntryEvents(typ EventType, entries []expiredEntry) {
+	for _, entry := range entries {
+		cache.broadcast(Event{Type: typ, Key: entry.key})
+	}
+}
+
+func (cache *Cache) broadcast(event Event) {
+	cache.eventsMu.RLock()
+	for _, ch := range cache.eventChans {
+		select {
+		case ch <- event:
+		default:
+			cache.droppedEvents.Add(1)
+		}
+	}
+	cache.eventsMu.RUnlock()
+}
+
+func (cache *Cache) closeEvents() {
+	cache.eventsMu.Lock()
+	for _, ch := range cache.eventChans {
+		close(ch)
+	}
+	cache.eventChans = nil
+	cache.eventsMu.Unlock()
+}
@@ -0,0 +1,82 @@
+package freecache
+
+import "math/rand/v2"
+
+// maxConsecutiveEvacuations bounds the work of making room for an entry, the oldest entry
+// is evicted regardless of the policy after that many consecutive evacuations.
+const maxConsecutiveEvacuations = 64
+
+// EvictionCandidate describes the oldest entry of a ring buffer that is not expired, when room
+// is needed for a new entry.
+type EvictionCandidate struct {
+	// AccessTime is the unix time of the last access of the entry.
+	AccessTime uint32
+	// AverageAccessTime is the average AccessTime of the entries in the segment.
+	AverageAccessTime int64
+	// Evacuations is the number of entries evacuated before this candidate since the last
+	// eviction. A policy should evict eventually, the work is bounded by evicting anyway
+	// after 64 consecutive evacuations.
+	Evacuations int
+	// Frequency is the estimated recent access count of the key, and AverageFrequency is the
+	// average of all keys. They are zero unless the admission filter or EvictLFU is enabled.
+	Frequency        int
+	AverageFrequency float64
+}
+
+// EvictionPolicy decides whether the oldest entry of a ring buffer is evicted, or evacuated,
+// that is moved to the head of the ring buffer, to make room for a new entry.
+// Expired entries are always evicted. The policy is not asked about pinned entries and entries of
+// PriorityLow, and about entries of higher priorities only after many evacuations, see SetWithPriority.
+// Evict is called with the segment locked.
+type EvictionPolicy interface {
+	Evict(c EvictionCandidate) bool
+}
+
+type lruPolicy struct{}
+
+func (lruPolicy) Evict(c EvictionCandidate) bool {
+	return int64(c.AccessTime) <= c.AverageAccessTime || c.Evacuations > 5
+}
+
+type fifoPolicy struct{}
+
+func (fifoPolicy) Evict(c EvictionCandidate) bool {
+	return true
+}
+
+type randomPolicy struct{}
+
+func (randomPolicy) Evict(c EvictionCandidate) bool {
+	return rand.Uint32()&1 == 0 || c.Evacuations > 5
+}
+
+type lfuPolicy struct{}
+
+func (lfuPolicy) Evict(c EvictionCandidate) bool {
+	return c.Frequency <= 1 || float64(c.Frequency) <= c.AverageFrequency || c.Evacuations > 5
+}
+
+var (
+	// EvictLRU evicts the oldest entry if it was accessed no later than the average entry, it is
+	// an approximation of least recently used eviction, and the default policy.
+	EvictLRU EvictionPolicy = lruPolicy{}
+	// EvictFIFO always evicts the oldest entry, it is the fastest policy, since no entry is
+	// copied, but frequently used entries are evicted as well.
+	EvictFIFO EvictionPolicy = fifoPolicy{}
+	// EvictRandom evicts the oldest entry with a probability of one half.
+	EvictRandom EvictionPolicy = randomPolicy{}
+	// EvictLFU evicts the oldest entry if its key is accessed once, or no more frequently than
+	// the average key, the frequencies are estimated by a sketch which halves them periodically.
+	EvictLFU EvictionPolicy = lfuPolicy{}
+)
+
+func (seg *segment) candidate(hdr *entryHdr, evacuations int) (c EvictionCandidate) {
+	c.AccessTime = hdr.accessTime
+	c.AverageAccessTime = seg.totalTime / seg.totalCount
+	c.Evacuations = evacuations
+	if seg.freq != nil {
+		c.Frequency = int(seg.freq.frequency(seg.shortHash(hdr)))
+		c.AverageFrequency = seg.freq.average()
+	}
+	return
+}
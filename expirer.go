@@ -0,0 +1,62 @@
+package freecache
+
+import (
+	"time"
+)
+
+func (cache *Cache) expireLoop(interval time.Duration, budget int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cache.closeChan:
+			return
+		case <-ticker.C:
+			cache.ExpireNow(budget)
+		}
+	}
+}
+
+// ExpireNow examines up to budget entries in every segment and removes the expired ones.
+// It is what the background expirer does in one cycle, the number of removed entries is returned.
+func (cache *Cache) ExpireNow(budget int) (expired int) {
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+		expired += cache.segments[i].expireScan(budget, cache.now())
+		cache.unlock(uint64(i))
+	}
+	return
+}
+
+func (cache *Cache) wheelLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cache.closeChan:
+			return
+		case <-ticker.C:
+			now := cache.now()
+			for i := 0; i < len(cache.segments); i++ {
+				cache.locks[i].Lock()
+				cache.segments[i].advanceWheel(now)
+				cache.unlock(uint64(i))
+			}
+		}
+	}
+}
+
+// ExpiringWithin returns the number of entries that will expire in the next seconds.
+// It requires the TimerWheel config, otherwise zero is returned.
+func (cache *Cache) ExpiringWithin(seconds int) (count int64) {
+	if !cache.config.TimerWheel {
+		return 0
+	}
+	now := cache.now()
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+		count += cache.segments[i].countExpiring(now, now+uint32(seconds))
+		cache.locks[i].Unlock()
+	}
+	return
+}
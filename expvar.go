@@ -0,0 +1,31 @@
+package freecache
+
+import "encoding/json"
+
+// StatsVar reports the live Stats of a cache and its hit rate as JSON. It implements expvar.Var,
+// so the package doesn't import expvar, which registers /debug/vars on http.DefaultServeMux.
+type StatsVar struct {
+	cache *Cache
+}
+
+// ExpvarStats returns a StatsVar of the cache, publish it with
+// expvar.Publish("cache", cache.ExpvarStats()) to see it in /debug/vars.
+func (cache *Cache) ExpvarStats() StatsVar {
+	return StatsVar{cache: cache}
+}
+
+func (v StatsVar) String() string {
+	stats := v.cache.Stats()
+	data, _ := json.Marshal(struct {
+		Stats
+		HitRate float64
+	}{stats, stats.hitRate()})
+	return string(data)
+}
+
+func (stats *Stats) hitRate() float64 {
+	if lookups := stats.HitCount + stats.MissCount; lookups != 0 {
+		return float64(stats.HitCount) / float64(lookups)
+	}
+	return 0
+}
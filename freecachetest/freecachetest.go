@@ -0,0 +1,50 @@
+// Package freecachetest provides helpers to test code that uses freecache.
+package freecachetest
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/coocood/freecache"
+)
+
+// Recorder records the entries removed because they expired, use its OnExpire method
+// as the OnExpire callback in the cache config.
+type Recorder struct {
+	mu      sync.Mutex
+	expired map[string][]byte
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{expired: make(map[string][]byte)}
+}
+
+func (r *Recorder) OnExpire(key, value []byte) {
+	r.mu.Lock()
+	r.expired[string(key)] = value
+	r.mu.Unlock()
+}
+
+// Expired returns the value of the key and true if the entry has expired.
+func (r *Recorder) Expired(key []byte) (value []byte, ok bool) {
+	r.mu.Lock()
+	value, ok = r.expired[string(key)]
+	r.mu.Unlock()
+	return
+}
+
+// ExpectExpired fails the test if the key is still in the cache, or if rec is not nil
+// and has not recorded the expiration of the key.
+func ExpectExpired(t testing.TB, cache *freecache.Cache, key []byte, rec *Recorder) {
+	t.Helper()
+	if _, err := cache.Get(key); err != freecache.ErrNotFound {
+		t.Errorf("key %q should be expired, got err %v", key, err)
+		return
+	}
+	if rec == nil {
+		return
+	}
+	if _, ok := rec.Expired(key); !ok {
+		t.Errorf("key %q is not found, but its expiration is not recorded", key)
+	}
+}
@@ -0,0 +1,19 @@
+package freecachetest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+func TestExpectExpired(t *testing.T) {
+	rec := NewRecorder()
+	cache := freecache.NewCacheWithConfig(1024, freecache.Config{OnExpire: rec.OnExpire})
+	cache.Set([]byte("key"), []byte("value"), 1)
+	time.Sleep(time.Second)
+	ExpectExpired(t, cache, []byte("key"), rec)
+	if value, _ := rec.Expired([]byte("key")); string(value) != "value" {
+		t.Error("expired value is", string(value))
+	}
+}
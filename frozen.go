@@ -0,0 +1,110 @@
+package freecache
+
+import "encoding/binary"
+
+// FrozenCache is an immutable copy of a cache created by FreezeCompact, it is optimized for
+// read throughput: entries are packed without eviction metadata, and the index is an open
+// addressing hash table, so Get takes no lock.
+//
+// An entry in data is the key length and the value length as uvarints, the expire time in 4 bytes,
+// followed by the key and the value.
+type FrozenCache struct {
+	data  []byte
+	index []frozenSlot // len(index) is a power of two.
+	count int64
+	seed  hashSeed
+	clock Clock
+}
+
+// frozenSlot is a slot of the index, pos is the offset of the entry plus one, zero means empty.
+type frozenSlot struct {
+	hash uint64
+	pos  uint64
+}
+
+// FreezeCompact copies all the live entries into a FrozenCache, for pipelines that populate
+// the cache once and then only read it. The cache is not modified.
+func (cache *Cache) FreezeCompact() *FrozenCache {
+	fc := new(FrozenCache)
+	fc.seed = cache.seeds.Load().cur
+	fc.clock = cache.config.Clock
+	var hashes []uint64
+	var lenBuf [binary.MaxVarintLen64]byte
+	now := cache.now()
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+		cache.segments[i].iterate(now, nil, func(key, value []byte, hdr *entryHdr) bool {
+			key, value, err := cache.decodeEntry(key, value)
+			if err != nil {
+				return true
+			}
+			hashes = append(hashes, fc.seed.sipHash(key), uint64(len(fc.data)))
+			n := binary.PutUvarint(lenBuf[:], uint64(len(key)))
+			fc.data = append(fc.data, lenBuf[:n]...)
+			n = binary.PutUvarint(lenBuf[:], uint64(len(value)))
+			fc.data = append(fc.data, lenBuf[:n]...)
+			fc.data = binary.LittleEndian.AppendUint32(fc.data, hdr.expireAt)
+			fc.data = append(fc.data, key...)
+			fc.data = append(fc.data, value...)
+			return true
+		})
+		cache.locks[i].Unlock()
+	}
+	fc.count = int64(len(hashes) / 2)
+	size := 1
+	for size < len(hashes) {
+		size *= 2
+	}
+	fc.index = make([]frozenSlot, size)
+	mask := uint64(size - 1)
+	for i := 0; i < len(hashes); i += 2 {
+		idx := hashes[i] & mask
+		for fc.index[idx].pos != 0 {
+			idx = (idx + 1) & mask
+		}
+		fc.index[idx] = frozenSlot{hash: hashes[i], pos: hashes[i+1] + 1}
+	}
+	return fc
+}
+
+// entry returns the key, value and expire time of the entry at off.
+func (fc *FrozenCache) entry(off uint64) (key, value []byte, expireAt uint32) {
+	keyLen, n := binary.Uvarint(fc.data[off:])
+	off += uint64(n)
+	valLen, n := binary.Uvarint(fc.data[off:])
+	off += uint64(n)
+	expireAt = binary.LittleEndian.Uint32(fc.data[off:])
+	off += 4
+	key = fc.data[off : off+keyLen]
+	value = fc.data[off+keyLen : off+keyLen+valLen]
+	return
+}
+
+// Get returns a copy of the value or the not found error.
+func (fc *FrozenCache) Get(key []byte) (value []byte, err error) {
+	hashVal := fc.seed.sipHash(key)
+	mask := uint64(len(fc.index) - 1)
+	for idx := hashVal & mask; fc.index[idx].pos != 0; idx = (idx + 1) & mask {
+		slot := &fc.index[idx]
+		if slot.hash != hashVal {
+			continue
+		}
+		entryKey, entryVal, expireAt := fc.entry(slot.pos - 1)
+		if string(entryKey) != string(key) {
+			continue
+		}
+		if expireAt != 0 && expireAt <= uint32(fc.clock.Now().Unix()) {
+			break
+		}
+		value = make([]byte, len(entryVal))
+		copy(value, entryVal)
+		return
+	}
+	err = ErrNotFound
+	return
+}
+
+// EntryCount returns the number of entries, including the expired ones.
+func (fc *FrozenCache) EntryCount() int64 {
+	return fc.count
+}
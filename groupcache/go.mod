@@ -0,0 +1,15 @@
+module github.com/coocood/freecache/groupcache
+
+go 1.24
+
+require (
+	github.com/coocood/freecache v0.0.0
+	github.com/golang/groupcache v0.0.0-20241129210726-2c02b8208cf8
+)
+
+require (
+	github.com/golang/protobuf v1.5.4 // indirect
+	google.golang.org/protobuf v1.33.0 // indirect
+)
+
+replace github.com/coocood/freecache => ../
@@ -0,0 +1,33 @@
+// Package groupcache adapts a freecache.Cache to be the local hot store of a groupcache group.
+package groupcache
+
+import (
+	"context"
+
+	"github.com/coocood/freecache"
+	gc "github.com/golang/groupcache"
+)
+
+// NewGetter returns a groupcache.Getter serving the values from cache, a value not found in cache
+// is loaded by getter and set in cache with expireSeconds, so the group loads it once while it's cached.
+// A nil getter makes a miss return freecache.ErrNotFound.
+//
+// groupcache.Sink can't be implemented outside of groupcache, the value populated by getter is
+// captured with groupcache.AllocatingByteSliceSink, so a value set with SetProto is cached marshaled.
+func NewGetter(cache *freecache.Cache, getter gc.Getter, expireSeconds int) gc.Getter {
+	return gc.GetterFunc(func(ctx context.Context, key string, dest gc.Sink) error {
+		value, err := cache.Get([]byte(key))
+		if err == nil {
+			return dest.SetBytes(value)
+		}
+		if err != freecache.ErrNotFound || getter == nil {
+			return err
+		}
+		if err = getter.Get(ctx, key, gc.AllocatingByteSliceSink(&value)); err != nil {
+			return err
+		}
+		// a value too large for the cache is still returned.
+		cache.Set([]byte(key), value, expireSeconds)
+		return dest.SetBytes(value)
+	})
+}
@@ -0,0 +1,61 @@
+package groupcache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/coocood/freecache"
+	gc "github.com/golang/groupcache"
+)
+
+func TestGetter(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	loads := 0
+	getter := NewGetter(cache, gc.GetterFunc(func(ctx context.Context, key string, dest gc.Sink) error {
+		loads++
+		if key == "bad" {
+			return errors.New("load failed")
+		}
+		return dest.SetString("value of " + key)
+	}), 0)
+	for i := 0; i < 2; i++ {
+		var value []byte
+		if err := getter.Get(context.Background(), "key", gc.AllocatingByteSliceSink(&value)); err != nil {
+			t.Fatal(err)
+		}
+		if string(value) != "value of key" {
+			t.Error("value is", string(value))
+		}
+	}
+	if loads != 1 {
+		t.Error("loads is", loads, "expected", 1)
+	}
+	if value, _ := cache.Get([]byte("key")); string(value) != "value of key" {
+		t.Error("cached value is", string(value))
+	}
+	var value []byte
+	if err := getter.Get(context.Background(), "bad", gc.AllocatingByteSliceSink(&value)); err == nil {
+		t.Error("load error should be returned")
+	}
+	if _, err := cache.Get([]byte("bad")); err != freecache.ErrNotFound {
+		t.Error("failed load should not be cached")
+	}
+
+	if err := NewGetter(cache, nil, 0).Get(context.Background(), "missing", gc.AllocatingByteSliceSink(&value)); err != freecache.ErrNotFound {
+		t.Error("err is", err, "expected", freecache.ErrNotFound)
+	}
+}
+
+func TestGroup(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	cache.Set([]byte("key"), []byte("cached"), 0)
+	group := gc.NewGroup("freecache-test", 1<<20, NewGetter(cache, nil, 0))
+	var value string
+	if err := group.Get(context.Background(), "key", gc.StringSink(&value)); err != nil {
+		t.Fatal(err)
+	}
+	if value != "cached" {
+		t.Error("value is", value)
+	}
+}
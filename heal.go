@@ -0,0 +1,38 @@
+package freecache
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// guarded runs op on the locked segment. If the segment is found corrupted, by an invariant
+// violation or a panic, and SelfHeal is enabled, the segment is rebuilt with its entries dropped,
+// and ErrCorrupted is returned. Without SelfHeal a panic is not recovered.
+func (cache *Cache) guarded(segId uint64, op func() error) (err error) {
+	if !cache.config.SelfHeal {
+		return op()
+	}
+	defer func() {
+		var reason error = ErrCorrupted
+		if r := recover(); r != nil {
+			reason = fmt.Errorf("freecache: segment %d panicked: %v", segId, r)
+			err = ErrCorrupted
+		}
+		if err == ErrCorrupted {
+			cache.resetSegment(segId, reason)
+		}
+	}()
+	return op()
+}
+
+// resetSegment rebuilds the locked segment, the OnSegmentReset callback is called by unlock.
+func (cache *Cache) resetSegment(segId uint64, reason error) {
+	cache.initSegment(int(segId), cache.segments[segId].rb.data)
+	cache.segments[segId].resetReason = reason
+	atomic.AddInt64(&cache.segmentResets, 1)
+}
+
+// SegmentResetCount returns the number of times a corrupted segment has been rebuilt.
+func (cache *Cache) SegmentResetCount() int64 {
+	return atomic.LoadInt64(&cache.segmentResets)
+}
@@ -0,0 +1,83 @@
+package freecache
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// histogram counts values in buckets of powers of two, bucket i counts the values
+// in [2^(i-1), 2^i), bucket 0 counts zeros.
+type histogram [33]int64
+
+func (h *histogram) add(v uint32) {
+	h[bits.Len32(v)]++
+}
+
+// Percentiles are the 50th, 95th and 99th percentiles of a distribution. They are estimated
+// from histograms of powers of two, a percentile is the upper bound of its bucket, which is
+// less than twice the exact value.
+type Percentiles struct {
+	P50 int64
+	P95 int64
+	P99 int64
+}
+
+// percentiles sums the histograms of the segments selected by hist and estimates the percentiles.
+func (cache *Cache) percentiles(hist func(seg *segment) *histogram) (p Percentiles) {
+	var sum histogram
+	var total int64
+	for i := 0; i < len(cache.segments); i++ {
+		h := hist(&cache.segments[i])
+		for j := range h {
+			n := atomic.LoadInt64(&h[j])
+			sum[j] += n
+			total += n
+		}
+	}
+	if total == 0 {
+		return
+	}
+	quantile := func(q float64) int64 {
+		rank := int64(q * float64(total))
+		var count int64
+		for i, n := range sum {
+			count += n
+			if count > rank {
+				return int64(1)<<i - 1
+			}
+		}
+		return int64(1)<<(len(sum)-1) - 1
+	}
+	return Percentiles{quantile(0.5), quantile(0.95), quantile(0.99)}
+}
+
+// AccessAge returns the percentiles of the seconds between two accesses of an entry, measured by
+// Get when it finds the entry. Unlike AverageAccessTime it shows a distribution with hot and
+// cold entries. It is only collected with the InstrumentDetailed level.
+func (cache *Cache) AccessAge() Percentiles {
+	return cache.percentiles(func(seg *segment) *histogram {
+		return &seg.ageHist
+	})
+}
+
+// EntrySizes returns the percentiles of the sizes of the entries written by Set, including the
+// entry header. It is only collected with the InstrumentDetailed level.
+func (cache *Cache) EntrySizes() Percentiles {
+	return cache.percentiles(func(seg *segment) *histogram {
+		return &seg.sizeHist
+	})
+}
+
+// EntrySizeHistogram returns the number of entries written by Set by size, element i counts the
+// entries of size in [2^(i-1), 2^i) bytes, including the entry header. It can be compared to
+// the maximum entry size of 1/1024 of the cache size. It is only collected with the
+// InstrumentDetailed level.
+func (cache *Cache) EntrySizeHistogram() []int64 {
+	counts := make([]int64, len(histogram{}))
+	for i := 0; i < len(cache.segments); i++ {
+		for j := range counts {
+			counts[j] += atomic.LoadInt64(&cache.segments[i].sizeHist[j])
+		}
+	}
+	return counts
+}
@@ -0,0 +1,77 @@
+package freecache
+
+import "unsafe"
+
+// maxHoles is the number of holes remembered by a segment.
+const maxHoles = 32
+
+// hole is a deleted entry in the used part of a ring buffer.
+type hole struct {
+	offset     int64
+	length     int64
+	accessTime uint32 // the access time of the deleted entry, it is still counted in totalTime.
+}
+
+// addHole remembers the deleted entry at offset. If the list is full, the holes that have been
+// reclaimed are dropped, then the oldest hole is replaced, it would be reclaimed first.
+func (seg *segment) addHole(offset int64) {
+	if seg.holes == nil {
+		return
+	}
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	seg.rb.ReadAt(hdrBuf[:], offset)
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	h := hole{offset: offset, length: hdr.entryLen(), accessTime: hdr.accessTime}
+	if len(seg.holes) == maxHoles {
+		seg.dropReclaimedHoles()
+	}
+	if len(seg.holes) < maxHoles {
+		seg.holes = append(seg.holes, h)
+		return
+	}
+	oldest := 0
+	for i := range seg.holes {
+		if seg.holes[i].offset < seg.holes[oldest].offset {
+			oldest = i
+		}
+	}
+	if seg.holes[oldest].offset < offset {
+		seg.holes[oldest] = h
+	}
+}
+
+// dropReclaimedHoles drops the holes that are no longer in the used part of the ring buffer.
+func (seg *segment) dropReclaimedHoles() {
+	tail := seg.rb.End() + seg.vacuumLen - seg.rb.Size()
+	holes := seg.holes[:0]
+	for _, h := range seg.holes {
+		if h.offset >= tail {
+			holes = append(holes, h)
+		}
+	}
+	seg.holes = holes
+}
+
+// takeHole removes and returns the smallest hole that fits an entry of entryLen and wastes at most
+// a quarter of it, the offset is -1 if there is no such hole. newEntry is true if the entry doesn't
+// exist, a hole is not used if the segment has reached its entry limit.
+func (seg *segment) takeHole(entryLen int64, newEntry bool) (offset int64, h hole) {
+	if len(seg.holes) == 0 || newEntry && seg.maxEntries > 0 && seg.entryCount >= seg.maxEntries {
+		return -1, h
+	}
+	seg.dropReclaimedHoles()
+	best := -1
+	for i, h := range seg.holes {
+		if h.length >= entryLen && h.length <= entryLen+entryLen/4 && (best < 0 || h.length < seg.holes[best].length) {
+			best = i
+		}
+	}
+	if best < 0 {
+		return -1, h
+	}
+	h = seg.holes[best]
+	last := len(seg.holes) - 1
+	seg.holes[best] = seg.holes[last]
+	seg.holes = seg.holes[:last]
+	return h.offset, h
+}
@@ -0,0 +1,136 @@
+package freecache
+
+import (
+	"container/heap"
+	"math/rand/v2"
+	"sort"
+	"sync"
+)
+
+const sketchDepth = 4
+
+// HotKey is a frequently accessed key reported by HotKeys.
+type HotKey struct {
+	Key []byte
+	// Count is the estimated number of Get and Set calls of the key, it may be overestimated.
+	Count int64
+}
+
+// hotKeys tracks the most frequently accessed keys, a count-min sketch estimates the access count
+// of every sampled key, and a min-heap keeps the keys of the largest counts.
+type hotKeys struct {
+	mu         sync.Mutex
+	sampleRate uint32
+	capacity   int
+	mask       uint32
+	sketch     [sketchDepth][]uint32
+	top        hotKeyHeap
+	index      map[string]int // key to its index in top.
+}
+
+func newHotKeys(capacity, sampleRate int) *hotKeys {
+	if sampleRate < 1 {
+		sampleRate = 1
+	}
+	width := 1024
+	for width < capacity*32 {
+		width *= 2
+	}
+	hk := &hotKeys{sampleRate: uint32(sampleRate), capacity: capacity, mask: uint32(width - 1)}
+	hk.reset()
+	return hk
+}
+
+func (hk *hotKeys) reset() {
+	for i := range hk.sketch {
+		hk.sketch[i] = make([]uint32, hk.mask+1)
+	}
+	hk.top = hk.top[:0]
+	hk.index = make(map[string]int, hk.capacity)
+}
+
+// record samples an access of the key.
+func (hk *hotKeys) record(key []byte, hashVal uint64) {
+	if hk.sampleRate > 1 && rand.Uint32N(hk.sampleRate) != 0 {
+		return
+	}
+	h1, h2 := uint32(hashVal), uint32(hashVal>>32)|1
+	hk.mu.Lock()
+	defer hk.mu.Unlock()
+	count := ^uint32(0)
+	for i := range hk.sketch {
+		cell := &hk.sketch[i][(h1+uint32(i)*h2)&hk.mask]
+		*cell++
+		count = min(count, *cell)
+	}
+	if idx, ok := hk.index[string(key)]; ok {
+		hk.top[idx].count = count
+		heap.Fix(&hk.top, idx)
+		return
+	}
+	if len(hk.top) < hk.capacity {
+		heap.Push(&hk.top, &hotKeyEntry{key: string(key), count: count, hk: hk})
+		return
+	}
+	if root := hk.top[0]; count > root.count {
+		delete(hk.index, root.key)
+		root.key = string(key)
+		root.count = count
+		hk.index[root.key] = 0
+		heap.Fix(&hk.top, 0)
+	}
+}
+
+type hotKeyEntry struct {
+	key   string
+	count uint32
+	hk    *hotKeys
+}
+
+// hotKeyHeap is a min-heap by count, it keeps hotKeys.index up to date.
+type hotKeyHeap []*hotKeyEntry
+
+func (h hotKeyHeap) Len() int           { return len(h) }
+func (h hotKeyHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h hotKeyHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].hk.index[h[i].key] = i
+	h[j].hk.index[h[j].key] = j
+}
+
+func (h *hotKeyHeap) Push(x any) {
+	e := x.(*hotKeyEntry)
+	e.hk.index[e.key] = len(*h)
+	*h = append(*h, e)
+}
+
+func (h *hotKeyHeap) Pop() any {
+	old := *h
+	e := old[len(old)-1]
+	*h = old[:len(old)-1]
+	delete(e.hk.index, e.key)
+	return e
+}
+
+// HotKeys returns up to n of the most frequently accessed keys, the hottest first.
+// Accesses are sampled as configured by Config.HotKeys and Config.HotKeySampleRate,
+// nil is returned if hot key tracking is disabled.
+func (cache *Cache) HotKeys(n int) []HotKey {
+	hk := cache.hotKeys
+	if hk == nil {
+		return nil
+	}
+	hk.mu.Lock()
+	keys := make([]HotKey, len(hk.top))
+	for i, e := range hk.top {
+		keys[i] = HotKey{Key: []byte(e.key), Count: int64(e.count) * int64(hk.sampleRate)}
+	}
+	hk.mu.Unlock()
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].Count > keys[j].Count
+	})
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+	return keys
+}
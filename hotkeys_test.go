@@ -0,0 +1,44 @@
+package freecache
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestHotKeys(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{HotKeys: 3})
+	if NewCache(1024*1024).HotKeys(3) != nil {
+		t.Error("hot keys should be nil if tracking is disabled")
+	}
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), 0)
+	}
+	for i := 0; i < 100; i++ {
+		if i < 80 {
+			cache.Get([]byte("hot1"))
+		}
+		cache.Get([]byte("key1"))
+		if i%2 == 0 {
+			cache.Get([]byte("key2"))
+		}
+	}
+	keys := cache.HotKeys(2)
+	if len(keys) != 2 || string(keys[0].Key) != "key1" || string(keys[1].Key) != "hot1" || keys[0].Count < 101 {
+		t.Fatal("unexpected hot keys", keys)
+	}
+	if len(cache.HotKeys(10)) != 3 {
+		t.Error("at most 3 keys should be tracked")
+	}
+	cache.ResetStatistics()
+	if len(cache.HotKeys(10)) != 0 {
+		t.Error("hot keys should be reset")
+	}
+
+	sampled := NewCacheWithConfig(1024*1024, Config{HotKeys: 1, HotKeySampleRate: 10})
+	for i := 0; i < 10000; i++ {
+		sampled.Get([]byte("key"))
+	}
+	if keys := sampled.HotKeys(1); len(keys) != 1 || keys[0].Count < 5000 || keys[0].Count > 15000 {
+		t.Error("unexpected sampled count", keys)
+	}
+}
@@ -0,0 +1,104 @@
+// Package httpapi serves the entries of a freecache.Cache over HTTP, so a process embedding the
+// cache can be used as a small cache service by programs in any language:
+//
+//	GET /key     returns the value, 404 if the key is not found.
+//	PUT /key     sets the value to the request body, the TTL header has the expire seconds,
+//	             the entry doesn't expire without it.
+//	DELETE /key  deletes the entry, 404 if the key is not found.
+//
+// The key is the unescaped path without the leading slash, use http.StripPrefix to mount the
+// handler under a prefix.
+package httpapi
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/coocood/freecache"
+)
+
+// TTLHeader is the request header of the expire seconds of PUT.
+const TTLHeader = "X-Freecache-TTL"
+
+// Handler serves the entries of a cache, see the package documentation.
+type Handler struct {
+	cache *freecache.Cache
+}
+
+func NewHandler(cache *freecache.Cache) *Handler {
+	return &Handler{cache: cache}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	if key == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		value, err := h.cache.Get([]byte(key))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(value)))
+		if r.Method == http.MethodGet {
+			w.Write(value)
+		}
+	case http.MethodPut:
+		expireSeconds := 0
+		if ttl := r.Header.Get(TTLHeader); ttl != "" {
+			var err error
+			if expireSeconds, err = strconv.Atoi(ttl); err != nil || expireSeconds < 0 {
+				http.Error(w, "invalid "+TTLHeader+" header", http.StatusBadRequest)
+				return
+			}
+		}
+		// a value larger than 1/16 of the cache is rejected even if large values are chunked.
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, int64(h.cache.Size()/16)))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeError(w, freecache.ErrLargeEntry)
+			} else {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+			}
+			return
+		}
+		if err = h.cache.Set([]byte(key), value, expireSeconds); err != nil {
+			writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		if !h.cache.Del([]byte(key)) {
+			writeError(w, freecache.ErrNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.Header().Set("Allow", "GET, HEAD, PUT, DELETE")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// writeError writes the status of an error of the cache.
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case freecache.ErrNotFound:
+		status = http.StatusNotFound
+	case freecache.ErrLargeKey, freecache.ErrLargeEntry:
+		status = http.StatusRequestEntityTooLarge
+	case freecache.ErrShortTTL:
+		status = http.StatusBadRequest
+	case freecache.ErrNotAdmitted, freecache.ErrPinned, freecache.ErrWouldBlock:
+		status = http.StatusServiceUnavailable
+	}
+	http.Error(w, err.Error(), status)
+}
@@ -0,0 +1,72 @@
+package httpapi
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+func TestHandler(t *testing.T) {
+	clock := freecache.NewFakeClock(time.Now())
+	cache := freecache.NewCacheWithConfig(1024*1024, freecache.Config{Clock: clock})
+	server := httptest.NewServer(http.StripPrefix("/cache", NewHandler(cache)))
+	defer server.Close()
+	do := func(method, key, body string, header http.Header) (status int, value string) {
+		req, err := http.NewRequest(method, server.URL+"/cache/"+key, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		data, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(data)
+	}
+	if status, _ := do(http.MethodGet, "key", "", nil); status != http.StatusNotFound {
+		t.Error("a missing key should not be found", status)
+	}
+	if status, _ := do(http.MethodPut, "key", "value", nil); status != http.StatusNoContent {
+		t.Error("the value should be set", status)
+	}
+	if status, value := do(http.MethodGet, "key", "", nil); status != http.StatusOK || value != "value" {
+		t.Error("the value should be returned", status, value)
+	}
+	if status, _ := do(http.MethodPut, "ttl", "value", http.Header{TTLHeader: {"10"}}); status != http.StatusNoContent {
+		t.Error("the value should be set with a TTL", status)
+	}
+	clock.Advance(11 * time.Second)
+	if status, _ := do(http.MethodGet, "ttl", "", nil); status != http.StatusNotFound {
+		t.Error("the entry should expire", status)
+	}
+	if status, _ := do(http.MethodPut, "key", "value", http.Header{TTLHeader: {"soon"}}); status != http.StatusBadRequest {
+		t.Error("an invalid TTL should be rejected", status)
+	}
+	if status, _ := do(http.MethodPut, "large", strings.Repeat("x", 100*1024), nil); status != http.StatusRequestEntityTooLarge {
+		t.Error("a large value should be rejected", status)
+	}
+	if status, _ := do(http.MethodDelete, "key", "", nil); status != http.StatusNoContent {
+		t.Error("the entry should be deleted", status)
+	}
+	if status, _ := do(http.MethodDelete, "key", "", nil); status != http.StatusNotFound {
+		t.Error("a deleted entry should not be found", status)
+	}
+	if status, _ := do(http.MethodPost, "key", "", nil); status != http.StatusMethodNotAllowed {
+		t.Error("POST should not be allowed", status)
+	}
+	if status, _ := do(http.MethodGet, "", "", nil); status != http.StatusBadRequest {
+		t.Error("a missing key should be rejected", status)
+	}
+}
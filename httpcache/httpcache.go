@@ -0,0 +1,192 @@
+// Package httpcache implements an http.RoundTripper that caches the responses in a freecache.Cache,
+// giving HTTP clients a private memory cache:
+//
+//	client := &http.Client{Transport: httpcache.NewTransport(cache, nil)}
+//
+// The responses of GET and HEAD requests are keyed by the method and the URL. A response is fresh
+// for the max-age of its Cache-Control header, or until its Expires header, and is returned without
+// a request while it's fresh. A stale response with an ETag or a Last-Modified header is revalidated
+// with a conditional request, and returned again if the server answers 304 Not Modified.
+//
+// Responses with a Vary header, with Cache-Control no-store, and to requests with Cache-Control
+// no-store or an Authorization header are not cached.
+package httpcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+// CacheHeader is set to "HIT" on the responses returned from the cache, and to "REVALIDATED" on
+// the cached responses a server answered 304 Not Modified to.
+const CacheHeader = "X-Freecache"
+
+// Transport is a caching http.RoundTripper, see the package documentation.
+type Transport struct {
+	cache *freecache.Cache
+	next  http.RoundTripper
+	// StaleSeconds is how long a stale response with a validator is kept for revalidation.
+	StaleSeconds int
+}
+
+// NewTransport returns a Transport caching the responses of next in cache, a nil next means
+// http.DefaultTransport. A stale response is kept for revalidation for an hour.
+func NewTransport(cache *freecache.Cache, next http.RoundTripper) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{cache: cache, next: next, StaleSeconds: 3600}
+}
+
+// cacheableStatus is the status codes of the responses that are cached.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusMovedPermanently:     true,
+	http.StatusNotFound:             true,
+	http.StatusGone:                 true,
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqDirectives := parseCacheControl(req.Header)
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || req.Header.Get("Authorization") != "" {
+		return t.next.RoundTrip(req)
+	}
+	if _, ok := reqDirectives["no-store"]; ok {
+		return t.next.RoundTrip(req)
+	}
+	key := []byte(req.Method + " " + req.URL.String())
+	cached, freshUntil := t.load(key, req)
+	if cached != nil {
+		_, noCache := reqDirectives["no-cache"]
+		if !noCache && time.Now().Unix() < freshUntil {
+			cached.Header.Set(CacheHeader, "HIT")
+			return cached, nil
+		}
+		etag, lastModified := cached.Header.Get("ETag"), cached.Header.Get("Last-Modified")
+		if etag != "" || lastModified != "" {
+			req = req.Clone(req.Context())
+			if etag != "" {
+				req.Header.Set("If-None-Match", etag)
+			}
+			if lastModified != "" {
+				req.Header.Set("If-Modified-Since", lastModified)
+			}
+		} else {
+			cached = nil
+		}
+	}
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if cached != nil && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		for name, values := range resp.Header {
+			switch name {
+			case "Cache-Control", "Date", "Expires", "ETag", "Last-Modified":
+				cached.Header[name] = values
+			}
+		}
+		t.store(key, cached)
+		cached.Header.Set(CacheHeader, "REVALIDATED")
+		return cached, nil
+	}
+	if !cacheableStatus[resp.StatusCode] {
+		return resp, nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	// the body is read again by DumpResponse, which restores it.
+	t.store(key, resp)
+	return resp, nil
+}
+
+// load returns the cached response of key and the unix time it's fresh until, or nil if it's not cached.
+func (t *Transport) load(key []byte, req *http.Request) (*http.Response, int64) {
+	value, err := t.cache.Get(key)
+	if err != nil || len(value) < 8 {
+		return nil, 0
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(value[8:])), req)
+	if err != nil {
+		return nil, 0
+	}
+	return resp, int64(binary.BigEndian.Uint64(value))
+}
+
+// store caches resp by its freshness, the body of resp is read.
+func (t *Transport) store(key []byte, resp *http.Response) {
+	directives := parseCacheControl(resp.Header)
+	if _, ok := directives["no-store"]; ok || resp.Header.Get("Vary") != "" {
+		t.cache.Del(key)
+		return
+	}
+	fresh := freshness(resp, directives)
+	if _, ok := directives["no-cache"]; ok {
+		fresh = 0
+	}
+	expireSeconds := fresh
+	if resp.Header.Get("ETag") != "" || resp.Header.Get("Last-Modified") != "" {
+		expireSeconds += t.StaleSeconds
+	}
+	if expireSeconds <= 0 {
+		t.cache.Del(key)
+		return
+	}
+	resp.Header.Del(CacheHeader)
+	dump, err := httputil.DumpResponse(resp, true)
+	if err != nil {
+		return
+	}
+	value := binary.BigEndian.AppendUint64(make([]byte, 0, 8+len(dump)), uint64(time.Now().Unix()+int64(fresh)))
+	t.cache.Set(key, append(value, dump...), expireSeconds)
+}
+
+// freshness returns the seconds resp is fresh for.
+func freshness(resp *http.Response, directives map[string]string) int {
+	age, _ := strconv.Atoi(resp.Header.Get("Age"))
+	if maxAge, ok := directives["max-age"]; ok {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil {
+			return 0
+		}
+		return max(seconds-age, 0)
+	}
+	expires, err := http.ParseTime(resp.Header.Get("Expires"))
+	if err != nil {
+		return 0
+	}
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		date = time.Now()
+	}
+	return max(int(expires.Sub(date)/time.Second)-age, 0)
+}
+
+// parseCacheControl returns the directives of the Cache-Control header, the names are lower case.
+func parseCacheControl(header http.Header) map[string]string {
+	directives := map[string]string{}
+	for _, value := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name != "" {
+				directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+			}
+		}
+	}
+	return directives
+}
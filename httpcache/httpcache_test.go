@@ -0,0 +1,85 @@
+package httpcache
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/coocood/freecache"
+)
+
+func TestTransport(t *testing.T) {
+	var requests, revalidations atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=100")
+		case "/etag":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				revalidations.Add(1)
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/nostore":
+			w.Header().Set("Cache-Control", "no-store, max-age=100")
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=100")
+			w.Header().Set("Vary", "Accept-Encoding")
+		}
+		io.WriteString(w, "body of "+r.URL.Path)
+	}))
+	defer server.Close()
+	client := &http.Client{Transport: NewTransport(freecache.NewCache(1024*1024), nil)}
+	get := func(path string, header ...string) (string, string) {
+		req, _ := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != "body of "+path {
+			t.Errorf("body of %v is %q", path, body)
+		}
+		return resp.Header.Get(CacheHeader), resp.Header.Get("Cache-Control")
+	}
+
+	get("/fresh")
+	if hit, cacheControl := get("/fresh"); hit != "HIT" || cacheControl != "max-age=100" || requests.Load() != 1 {
+		t.Error("fresh response should be returned from the cache", hit, cacheControl, requests.Load())
+	}
+	get("/fresh", "Cache-Control", "no-cache")
+	if requests.Load() != 2 {
+		t.Error("a no-cache request should be sent")
+	}
+
+	get("/etag")
+	if hit, _ := get("/etag"); hit != "REVALIDATED" || revalidations.Load() != 1 {
+		t.Error("stale response should be revalidated", hit, revalidations.Load())
+	}
+
+	for _, path := range []string{"/nostore", "/vary"} {
+		before := requests.Load()
+		get(path)
+		if hit, _ := get(path); hit != "" || requests.Load() != before+2 {
+			t.Error(path, "should not be cached")
+		}
+	}
+
+	before := requests.Load()
+	get("/fresh", "Authorization", "secret")
+	if requests.Load() != before+1 {
+		t.Error("a request with credentials should not be cached")
+	}
+}
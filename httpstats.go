@@ -0,0 +1,48 @@
+package freecache
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// statsResponse is the JSON served by StatsHandler.
+type statsResponse struct {
+	Stats
+	HitRate    float64
+	Size       int
+	UsedBytes  int64
+	FreeBytes  int64
+	SlotBytes  int64
+	DeadBytes  int64
+	Errors     map[string]int64
+	TTLClasses []TTLClassStat `json:",omitempty"`
+	Segments   []SegmentStat
+}
+
+// StatsHandler returns an http.Handler that serves the Stats of the cache, its hit rate, its memory
+// usage, the error counts, the TTL class statistics and the SegmentStats of every segment as JSON,
+// mount it on a debug port, e.g. http.Handle("/debug/cache", cache.StatsHandler()). The segments are
+// locked one by one, so the segment details are not a consistent view of the whole cache.
+func (cache *Cache) StatsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		resp := statsResponse{Stats: cache.Stats(), Size: cache.Size(), Errors: map[string]int64{}}
+		resp.HitRate = resp.Stats.hitRate()
+		resp.UsedBytes, resp.FreeBytes, resp.SlotBytes = cache.MemoryUsage()
+		resp.Segments = make([]SegmentStat, cache.SegmentCount())
+		for i := range resp.Segments {
+			resp.Segments[i] = cache.SegmentStats(i)
+			resp.DeadBytes += resp.Segments[i].DeadBytes
+		}
+		for err, count := range cache.ErrorCounts() {
+			resp.Errors[err.Error()] = count
+		}
+		resp.TTLClasses = cache.TTLClassStats()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&resp)
+	})
+}
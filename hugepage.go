@@ -0,0 +1,22 @@
+package freecache
+
+import (
+	"errors"
+	"os"
+	"unsafe"
+)
+
+var ErrHugePagesUnsupported = errors.New("Huge pages are not supported on this platform")
+
+// adviseHugePages advises the kernel to back the ring buffers in data with huge pages, and records
+// whether it accepted the advice for Stats. The advice is given for the whole pages in data.
+func (cache *Cache) adviseHugePages(data []byte) {
+	pageSize := os.Getpagesize()
+	start := int(-uintptr(unsafe.Pointer(unsafe.SliceData(data))) & uintptr(pageSize-1))
+	if start >= len(data) {
+		cache.hugePages.Store(false)
+		return
+	}
+	data = data[start:]
+	cache.hugePages.Store(madviseHugePages(data[:len(data)/pageSize*pageSize]) == nil)
+}
@@ -0,0 +1,9 @@
+//go:build linux
+
+package freecache
+
+import "syscall"
+
+func madviseHugePages(data []byte) error {
+	return syscall.Madvise(data, syscall.MADV_HUGEPAGE)
+}
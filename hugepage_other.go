@@ -0,0 +1,7 @@
+//go:build !linux
+
+package freecache
+
+func madviseHugePages(data []byte) error {
+	return ErrHugePagesUnsupported
+}
@@ -0,0 +1,73 @@
+package freecache
+
+import (
+	"errors"
+	"strconv"
+)
+
+var ErrNotInteger = errors.New("The value is not a decimal integer")
+var ErrIntegerOverflow = errors.New("The sum overflows an int64")
+
+// Incr adds delta to the integer value of key atomically, and returns the new value. The value is
+// stored as decimal text, like the incr commands of memcached and Redis, so it can be read by Get
+// and set by Set. A missing key is set to delta and expires after expireSeconds, the expire time
+// of an existing key is kept. ErrNotInteger is returned if the value is not a decimal integer,
+// and ErrIntegerOverflow if the sum overflows, the value is not changed then.
+//
+// While RotateHashSeed moves the entries, a key that is not moved yet is set to delta.
+func (cache *Cache) Incr(key []byte, delta int64, expireSeconds int) (value int64, err error) {
+	entryKey := cache.entryKey(key)
+	hashVal := cache.seeds.Load().cur.sipHash(entryKey)
+	segId := hashVal & cache.segMask
+	var expireAt uint32
+	var stored []byte
+	cache.locks[segId].Lock()
+	err = cache.guarded(segId, func() error {
+		seg := &cache.segments[segId]
+		var current int64
+		var seconds int
+		hdr, ptr, err := seg.liveEntry(entryKey, hashVal)
+		switch err {
+		case nil:
+			if hdr.chunked() {
+				return ErrNotInteger
+			}
+			old := make([]byte, hdr.valLen)
+			seg.rb.ReadAt(old, hdr.valOff(ptr.offset))
+			_, text, err := cache.decodeEntry(entryKey, old)
+			if err != nil {
+				return err
+			}
+			if current, err = strconv.ParseInt(string(text), 10, 64); err != nil {
+				return ErrNotInteger
+			}
+			if expireAt = hdr.expireAt; expireAt != 0 {
+				seconds = int(expireAt - seg.now())
+			}
+		case ErrNotFound:
+			if seconds, err = cache.tunables.Load().expireSeconds(expireSeconds); err != nil {
+				return err
+			}
+			if seconds > 0 {
+				expireAt = seg.now() + uint32(seconds)
+			}
+		default:
+			return err
+		}
+		value = current + delta
+		if (value > current) != (delta > 0) {
+			return ErrIntegerOverflow
+		}
+		_, stored = cache.encodeEntry(key, strconv.AppendInt(nil, value, 10))
+		return seg.set(entryKey, stored, hashVal, seconds, -1, 0, 0)
+	})
+	if err == nil && cache.logged() {
+		cache.log(journalSet, entryKey, stored, expireAt)
+	}
+	cache.unlock(segId)
+	if err != nil {
+		value = 0
+		cache.countError(err)
+	}
+	return
+}
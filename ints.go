@@ -0,0 +1,25 @@
+package freecache
+
+import "encoding/binary"
+
+// SetInt is like Set, but the key is an integer, stored as 8 bytes in big endian order like
+// Int64Key, so it can be read by Get with the encoded key as well.
+func (cache *Cache) SetInt(key int64, value []byte, expireSeconds int) (err error) {
+	var bKey [8]byte
+	binary.BigEndian.PutUint64(bKey[:], uint64(key))
+	return cache.Set(bKey[:], value, expireSeconds)
+}
+
+// GetInt is like Get, but the key is an integer, see SetInt.
+func (cache *Cache) GetInt(key int64) (value []byte, err error) {
+	var bKey [8]byte
+	binary.BigEndian.PutUint64(bKey[:], uint64(key))
+	return cache.Get(bKey[:])
+}
+
+// DelInt is like Del, but the key is an integer, see SetInt.
+func (cache *Cache) DelInt(key int64) (affected bool) {
+	var bKey [8]byte
+	binary.BigEndian.PutUint64(bKey[:], uint64(key))
+	return cache.Del(bKey[:])
+}
@@ -0,0 +1,259 @@
+package freecache
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// Invalidator keeps the caches of several application instances coherent, the keys set or deleted
+// in one cache are published to the others, which delete their copies of them. Set Config.Invalidator
+// to a different Invalidator for every cache, NewInvalidationBus and NewPacketInvalidator are
+// implementations in a process and over the network.
+//
+// The keys are the keys of the entries, see Event. A Del is published even if the key is not in the
+// cache, since the others may have it. A Clear is not published, and the keys deleted by
+// an invalidation are not published again, nor recorded in the journal or replicated.
+type Invalidator interface {
+	// Publish announces that the entry of key is changed, it is called with the segment of the key
+	// locked, so it must not block. The invalidator owns key.
+	Publish(key []byte)
+	// Subscribe makes the invalidator call fn with every key published by the other caches,
+	// it is called once by NewCacheWithConfig.
+	Subscribe(fn func(key []byte))
+}
+
+// invalidate deletes the entry of a key published by another cache, without recording it.
+func (cache *Cache) invalidate(key []byte) {
+	seeds := cache.seeds.Load()
+	if seeds.old != nil {
+		cache.delOld(key, seeds.old.sipHash(key))
+	}
+	hashVal := seeds.cur.sipHash(key)
+	segId := hashVal & cache.segMask
+	var manifest []byte
+	cache.locks[segId].Lock()
+	cache.guarded(segId, func() error {
+		seg := &cache.segments[segId]
+		if cache.config.ChunkLargeValues {
+			manifest = seg.manifest(key, hashVal)
+		}
+		seg.del(key, hashVal)
+		return nil
+	})
+	cache.unlock(segId)
+	cache.dropChunks(key, manifest)
+	cache.overflowDel(key)
+}
+
+// invalidationQueue is the number of keys an invalidator queues before it drops them.
+const invalidationQueue = 4096
+
+// InvalidationBus connects the Invalidators of caches in the same process, e.g. for tests or for
+// caches of different configs over the same data.
+type InvalidationBus struct {
+	mu      sync.Mutex
+	members []*busInvalidator
+	dropped atomic.Int64
+}
+
+type busInvalidator struct {
+	bus  *InvalidationBus
+	mu   sync.RWMutex // guards left and the closing of keys against Publish.
+	left bool
+	keys chan []byte
+	fn   atomic.Pointer[func(key []byte)]
+	done chan struct{} // closed when deliverLoop returns.
+}
+
+// NewInvalidationBus returns an empty bus, Join it for every cache.
+func NewInvalidationBus() *InvalidationBus {
+	return &InvalidationBus{}
+}
+
+// Join returns a new Invalidator of the bus, it receives the keys published by the other members.
+// The published keys are delivered by a goroutine of the member, up to 4096 keys are queued and
+// more are dropped. Call Leave when the cache of the member is not used anymore.
+func (bus *InvalidationBus) Join() Invalidator {
+	m := &busInvalidator{bus: bus, keys: make(chan []byte, invalidationQueue), done: make(chan struct{})}
+	bus.mu.Lock()
+	bus.members = append(bus.members, m)
+	bus.mu.Unlock()
+	go m.deliverLoop()
+	return m
+}
+
+// Leave removes inv, returned by Join, from the bus. It returns once the keys queued by inv are
+// delivered and its goroutine is stopped, the keys published by inv later are ignored and it
+// receives no more keys.
+func (bus *InvalidationBus) Leave(inv Invalidator) {
+	m, ok := inv.(*busInvalidator)
+	if !ok || m.bus != bus {
+		return
+	}
+	bus.mu.Lock()
+	for i, member := range bus.members {
+		if member == m {
+			bus.members = append(bus.members[:i:i], bus.members[i+1:]...)
+			break
+		}
+	}
+	bus.mu.Unlock()
+	m.fn.Store(nil)
+	m.mu.Lock()
+	if !m.left {
+		m.left = true
+		close(m.keys)
+	}
+	m.mu.Unlock()
+	<-m.done
+}
+
+// Dropped returns the number of keys not published because the queue of a member was full.
+func (bus *InvalidationBus) Dropped() int64 {
+	return bus.dropped.Load()
+}
+
+func (m *busInvalidator) Publish(key []byte) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.left {
+		return
+	}
+	select {
+	case m.keys <- key:
+	default:
+		m.bus.dropped.Add(1)
+	}
+}
+
+func (m *busInvalidator) Subscribe(fn func(key []byte)) {
+	m.fn.Store(&fn)
+}
+
+func (m *busInvalidator) deliverLoop() {
+	defer close(m.done)
+	for key := range m.keys {
+		m.bus.mu.Lock()
+		members := m.bus.members
+		m.bus.mu.Unlock()
+		for _, other := range members {
+			if fn := other.fn.Load(); other != m && fn != nil {
+				(*fn)(key)
+			}
+		}
+	}
+}
+
+// PacketInvalidator publishes the keys in datagrams written to the addresses of the peers, and
+// reads the keys published by them from its connection, e.g. a UDP socket of every instance.
+// Datagrams may be lost, and are not authenticated, it is meant for a trusted network.
+type PacketInvalidator struct {
+	conn    net.PacketConn
+	peers   []net.Addr
+	keys    chan []byte
+	fn      atomic.Pointer[func(key []byte)]
+	dropped atomic.Int64
+	done    chan struct{}
+}
+
+// maxInvalidationPacket is the maximum size of a datagram, small enough to not be fragmented.
+const maxInvalidationPacket = 1400
+
+// NewPacketInvalidator returns a PacketInvalidator sending to peers from conn, it reads conn until
+// it returns an error, e.g. after Close. A datagram holds as many keys as fit, every key is
+// preceded by its length as 2 bytes.
+func NewPacketInvalidator(conn net.PacketConn, peers []net.Addr) *PacketInvalidator {
+	inv := &PacketInvalidator{
+		conn:  conn,
+		peers: peers,
+		keys:  make(chan []byte, invalidationQueue),
+		done:  make(chan struct{}),
+	}
+	go inv.sendLoop()
+	go inv.readLoop()
+	return inv
+}
+
+func (inv *PacketInvalidator) Publish(key []byte) {
+	if len(key) > maxInvalidationPacket-2 {
+		inv.dropped.Add(1)
+		return
+	}
+	select {
+	case inv.keys <- key:
+	default:
+		inv.dropped.Add(1)
+	}
+}
+
+func (inv *PacketInvalidator) Subscribe(fn func(key []byte)) {
+	inv.fn.Store(&fn)
+}
+
+// Dropped returns the number of keys not published, because the queue was full or the key was too large.
+func (inv *PacketInvalidator) Dropped() int64 {
+	return inv.dropped.Load()
+}
+
+// Close stops publishing and closes the connection.
+func (inv *PacketInvalidator) Close() error {
+	close(inv.done)
+	return inv.conn.Close()
+}
+
+func (inv *PacketInvalidator) sendLoop() {
+	packet := make([]byte, 0, maxInvalidationPacket)
+	for {
+		var key []byte
+		select {
+		case <-inv.done:
+			return
+		case key = <-inv.keys:
+		}
+		packet = binary.BigEndian.AppendUint16(packet[:0], uint16(len(key)))
+		packet = append(packet, key...)
+		// the queued keys are sent in the same datagram while they fit.
+	fill:
+		for {
+			select {
+			case key = <-inv.keys:
+				if len(packet)+2+len(key) > maxInvalidationPacket {
+					inv.send(packet)
+					packet = packet[:0]
+				}
+				packet = binary.BigEndian.AppendUint16(packet, uint16(len(key)))
+				packet = append(packet, key...)
+			default:
+				break fill
+			}
+		}
+		inv.send(packet)
+	}
+}
+
+func (inv *PacketInvalidator) send(packet []byte) {
+	for _, addr := range inv.peers {
+		inv.conn.WriteTo(packet, addr)
+	}
+}
+
+func (inv *PacketInvalidator) readLoop() {
+	buf := make([]byte, 65536)
+	for {
+		n, _, err := inv.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		fn := inv.fn.Load()
+		for packet := buf[:n]; len(packet) >= 2 && fn != nil; {
+			keyLen := int(binary.BigEndian.Uint16(packet))
+			if len(packet) < 2+keyLen {
+				break
+			}
+			(*fn)(append([]byte(nil), packet[2:2+keyLen]...))
+			packet = packet[2+keyLen:]
+		}
+	}
+}
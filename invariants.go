@@ -0,0 +1,49 @@
+package freecache
+
+import (
+	"fmt"
+	"unsafe"
+)
+
+// checkInvariants panics if the segment is inconsistent: a slot is longer than its capacity or
+// not sorted by hash16, an entry pointer is outside of the ring buffer or doesn't match the header
+// of its entry, or the entry count is not the number of entry pointers. It is expensive, it reads
+// the header of every entry, and it is only called when the package is built with the
+// freecache_debug tag, after every operation that modifies a segment, so corruption and
+// concurrency bugs fail fast in testing and staging.
+func (seg *segment) checkInvariants() {
+	fail := func(format string, args ...any) {
+		panic(fmt.Sprintf("freecache: segment %d: ", seg.segId) + fmt.Sprintf(format, args...))
+	}
+	if seg.vacuumLen < 0 || seg.vacuumLen > seg.rb.Size() {
+		fail("vacuumLen %d is out of [0, %d]", seg.vacuumLen, seg.rb.Size())
+	}
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	var count int64
+	for slotId := 0; slotId < 256; slotId++ {
+		slotLen := seg.slotLens[slotId]
+		if slotLen < 0 || slotLen > seg.slotCap {
+			fail("slot %d has length %d, its capacity is %d", slotId, slotLen, seg.slotCap)
+		}
+		slotOff := int32(slotId) * seg.slotCap
+		slot := seg.slotsData[slotOff : slotOff+slotLen]
+		for i := range slot {
+			ptr := &slot[i]
+			if i > 0 && slot[i-1].hash16 > ptr.hash16 {
+				fail("slot %d is not sorted at %d", slotId, i)
+			}
+			if ptr.offset < seg.rb.Begin() || ptr.offset+ENTRY_HDR_SIZE > seg.rb.End() {
+				fail("entry %d of slot %d at %d is out of [%d, %d)", i, slotId, ptr.offset, seg.rb.Begin(), seg.rb.End())
+			}
+			seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+			if !seg.validHdr(hdr, ptr, uint8(slotId)) {
+				fail("entry %d of slot %d at %d has an invalid header %+v", i, slotId, ptr.offset, *hdr)
+			}
+		}
+		count += int64(slotLen)
+	}
+	if count != seg.entryCount {
+		fail("entry count is %d, the slots have %d entries", seg.entryCount, count)
+	}
+}
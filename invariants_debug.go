@@ -0,0 +1,6 @@
+//go:build freecache_debug
+
+package freecache
+
+// debugChecks enables checkInvariants.
+const debugChecks = true
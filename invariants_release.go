@@ -0,0 +1,6 @@
+//go:build !freecache_debug
+
+package freecache
+
+// debugChecks enables checkInvariants, build with the freecache_debug tag to enable it.
+const debugChecks = false
@@ -0,0 +1,123 @@
+package freecache
+
+import (
+	"errors"
+	"unsafe"
+)
+
+var ErrLimitExceeded = errors.New("The cache contents exceed the limit")
+
+// ScanFilter selects the entries visited by Scan, the zero value selects all the live entries.
+type ScanFilter struct {
+	// MinTTL and MaxTTL bound the remaining seconds before the entry expires, entries that never
+	// expire have an unlimited TTL. Zero MaxTTL means no upper bound.
+	MinTTL int
+	MaxTTL int
+	// An entry matches if its flags masked by FlagsMask equal Flags.
+	FlagsMask uint8
+	Flags     uint8
+	// MinValueLen is the minimum length of the value.
+	MinValueLen int
+}
+
+func (f *ScanFilter) match(hdr *entryHdr, now uint32) bool {
+	if hdr.userFlags()&f.FlagsMask != f.Flags || int(hdr.valLen) < f.MinValueLen {
+		return false
+	}
+	if hdr.expireAt == 0 {
+		return f.MaxTTL == 0
+	}
+	ttl := int(hdr.expireAt - now)
+	return ttl >= f.MinTTL && (f.MaxTTL == 0 || ttl <= f.MaxTTL)
+}
+
+// iterate calls fn with every live entry in the segment that match accepts, the key and value are copies.
+// A nil match accepts every entry. The manifests and chunks of chunked values are skipped, they are
+// not meaningful outside of the cache. It stops and returns false if fn returns false.
+func (seg *segment) iterate(now uint32, match func(hdr *entryHdr) bool, fn func(key, value []byte, hdr *entryHdr) bool) bool {
+	return seg.walk(now, false, match, fn)
+}
+
+// iterateAll is like iterate without a match, and includes the entries of chunked values.
+func (seg *segment) iterateAll(now uint32, fn func(key, value []byte, hdr *entryHdr) bool) bool {
+	return seg.walk(now, true, nil, fn)
+}
+
+func (seg *segment) walk(now uint32, chunks bool, match func(hdr *entryHdr) bool, fn func(key, value []byte, hdr *entryHdr) bool) bool {
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	for slotId := 0; slotId < 256; slotId++ {
+		slotOff := int32(slotId) * seg.slotCap
+		slot := seg.slotsData[slotOff : slotOff+seg.slotLens[slotId]]
+		for _, ptr := range slot {
+			seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+			if hdr.expireAt != 0 && hdr.expireAt <= now || !chunks && hdr.chunked() || match != nil && !match(hdr) {
+				continue
+			}
+			key := make([]byte, hdr.keyLen)
+			value := make([]byte, hdr.valLen)
+			seg.rb.ReadAt(key, ptr.offset+ENTRY_HDR_SIZE)
+			seg.rb.ReadAt(value, hdr.valOff(ptr.offset))
+			if !fn(key, value, hdr) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ToMap copies all the live entries into a map, it is meant for tests and tooling on small caches.
+// ErrLimitExceeded is returned as soon as the total size of keys and values exceeds limitBytes.
+func (cache *Cache) ToMap(limitBytes int) (m map[string][]byte, err error) {
+	m = make(map[string][]byte)
+	var total int
+	now := cache.now()
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+		ok := cache.segments[i].iterate(now, nil, func(key, value []byte, hdr *entryHdr) bool {
+			total += len(key) + len(value)
+			if total > limitBytes {
+				return false
+			}
+			if key, value, err := cache.decodeEntry(key, value); err == nil {
+				m[string(key)] = value
+			}
+			return true
+		})
+		cache.locks[i].Unlock()
+		if !ok {
+			return nil, ErrLimitExceeded
+		}
+	}
+	return
+}
+
+// Scan calls fn with the key and value of every live entry that matches filter, until fn returns false.
+// The filter is evaluated under the segment lock, only the matching entries are copied.
+// fn is called without holding any lock, after each segment is scanned, so it may use the cache.
+func (cache *Cache) Scan(filter ScanFilter, fn func(key, value []byte) bool) {
+	now := cache.now()
+	match := func(hdr *entryHdr) bool {
+		return filter.match(hdr, now)
+	}
+	var keys, values [][]byte
+	for i := 0; i < len(cache.segments); i++ {
+		keys, values = keys[:0], values[:0]
+		cache.locks[i].Lock()
+		cache.segments[i].iterate(now, match, func(key, value []byte, hdr *entryHdr) bool {
+			keys = append(keys, key)
+			values = append(values, value)
+			return true
+		})
+		cache.locks[i].Unlock()
+		for j := range keys {
+			key, value, err := cache.decodeEntry(keys[j], values[j])
+			if err != nil {
+				continue
+			}
+			if !fn(key, value) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,309 @@
+package freecache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrInvalidJournal = errors.New("Invalid journal record")
+
+const (
+	journalSet   = 'S'
+	journalDel   = 'D'
+	journalClear = 'C'
+
+	journalHdrSize = 13
+)
+
+// Journal is an append-only log of the Set, Del and Clear operations on a cache,
+// so the cache can be replayed to near-current state after a restart or a crash.
+// Writes are buffered and flushed to the file every second, the journal is compacted by
+// CompactJournal, which rewrites it to contain only the live entries.
+//
+// A record is an operation byte, the expire time, the key length and the value length,
+// followed by the key, the value and the CRC32 of all of them.
+type Journal struct {
+	mu        sync.Mutex
+	path      string
+	file      *os.File
+	w         *bufio.Writer
+	rewrite   *bytes.Buffer // records written during compaction, nil if not compacting.
+	buf       []byte        // the record being written.
+	compactMu sync.Mutex    // serializes CompactJournal, which writes path+".compact".
+	err       error         // the first write error.
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+// OpenJournal opens or creates the journal file at path, new records are appended to it.
+// Call Replay before using the journal in a cache config to load the existing records.
+func OpenJournal(path string) (j *Journal, err error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	j = &Journal{
+		path:      path,
+		file:      file,
+		w:         bufio.NewWriter(file),
+		closeChan: make(chan struct{}),
+	}
+	go j.flushLoop()
+	return
+}
+
+func (j *Journal) flushLoop() {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-j.closeChan:
+			return
+		case <-ticker.C:
+			j.Flush()
+		}
+	}
+}
+
+// Flush writes the buffered records to the file, it returns the first write error of the journal.
+func (j *Journal) Flush() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err := j.w.Flush(); err != nil && j.err == nil {
+		j.err = err
+	}
+	return j.err
+}
+
+// Close flushes the buffered records and closes the file.
+func (j *Journal) Close() (err error) {
+	j.closeOnce.Do(func() {
+		close(j.closeChan)
+		err = j.Flush()
+		j.mu.Lock()
+		if closeErr := j.file.Close(); err == nil {
+			err = closeErr
+		}
+		j.mu.Unlock()
+	})
+	return
+}
+
+func (j *Journal) log(op byte, key, value []byte, expireAt uint32) {
+	j.mu.Lock()
+	// the record is built in a buffer of the journal, so key and value don't escape to the writer.
+	j.buf = appendJournalRecord(j.buf[:0], op, key, value, expireAt)
+	if _, err := j.w.Write(j.buf); err != nil && j.err == nil {
+		j.err = err
+	}
+	if j.rewrite != nil {
+		j.rewrite.Write(j.buf)
+	}
+	j.mu.Unlock()
+}
+
+// appendJournalRecord appends the record of an operation to buf.
+func appendJournalRecord(buf []byte, op byte, key, value []byte, expireAt uint32) []byte {
+	start := len(buf)
+	buf = append(buf, op)
+	buf = binary.LittleEndian.AppendUint32(buf, expireAt)
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(key)))
+	buf = binary.LittleEndian.AppendUint32(buf, uint32(len(value)))
+	buf = append(append(buf, key...), value...)
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf[start:]))
+}
+
+// Replay applies the records in the journal file to cache, and returns the number of records applied.
+// A partially written record at the end of the file, which is left by a crash, is truncated.
+// Replayed operations are not written to the journal again.
+func (j *Journal) Replay(cache *Cache) (n int, err error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err = j.w.Flush(); err != nil {
+		return
+	}
+	file, err := os.Open(j.path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	// the Set records are applied in batches, a pending batch is applied before a Del or a Clear.
+	var batch []batchEntry
+	defer func() { cache.setBatch(batch, false) }()
+	var goodOff int64
+	n, goodOff, err = readJournal(bufio.NewReader(file), func(op byte, key, value []byte, expireAt uint32) {
+		batch = cache.replay(batch, op, key, value, expireAt)
+	})
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = j.file.Truncate(goodOff)
+	}
+	return
+}
+
+// readJournal calls fn with every record read from r, until an error or an invalid record.
+// It returns the number of records and the offset after the last valid one.
+func readJournal(r *bufio.Reader, fn func(op byte, key, value []byte, expireAt uint32)) (n int, off int64, err error) {
+	var hdr [journalHdrSize]byte
+	var crcBuf [4]byte
+	for {
+		if _, err = io.ReadFull(r, hdr[:]); err != nil {
+			return
+		}
+		keyLen := binary.LittleEndian.Uint32(hdr[5:])
+		valLen := binary.LittleEndian.Uint32(hdr[9:])
+		if keyLen > 65535 || valLen > 1<<31 {
+			return n, off, ErrInvalidJournal
+		}
+		key := make([]byte, keyLen)
+		value := make([]byte, valLen)
+		if _, err = io.ReadFull(r, key); err != nil {
+			return
+		}
+		if _, err = io.ReadFull(r, value); err != nil {
+			return
+		}
+		if _, err = io.ReadFull(r, crcBuf[:]); err != nil {
+			return
+		}
+		crc := crc32.ChecksumIEEE(hdr[:])
+		crc = crc32.Update(crc, crc32.IEEETable, key)
+		crc = crc32.Update(crc, crc32.IEEETable, value)
+		if crc != binary.LittleEndian.Uint32(crcBuf[:]) {
+			return n, off, ErrInvalidJournal
+		}
+		fn(hdr[0], key, value, binary.LittleEndian.Uint32(hdr[1:]))
+		off += journalHdrSize + int64(keyLen) + int64(valLen) + 4
+		n++
+	}
+}
+
+// replay applies a record, a Set record is appended to batch, which is applied when it is full.
+func (cache *Cache) replay(batch []batchEntry, op byte, key, value []byte, expireAt uint32) []batchEntry {
+	switch op {
+	case journalSet:
+		expireSeconds := 0
+		if expireAt != 0 {
+			now := cache.now()
+			if expireAt <= now {
+				return batch
+			}
+			expireSeconds = int(expireAt - now)
+		}
+		if cache.config.ChunkLargeValues && len(key)+len(value) > cache.maxKeyValLen() {
+			cache.setBatch(batch, false)
+			cache.writeChunked(key, len(value), &chunkSource{value: value}, expireSeconds, -1, 0, 0)
+			return batch[:0]
+		}
+		batch = append(batch, batchEntry{key: key, value: value, hashVal: cache.hash(key), expireSeconds: expireSeconds})
+		if len(batch) < batchSize {
+			return batch
+		}
+		cache.setBatch(batch, false)
+	case journalDel:
+		cache.setBatch(batch, false)
+		hashVal := cache.hash(key)
+		segId := hashVal & cache.segMask
+		cache.locks[segId].Lock()
+		cache.segments[segId].del(key, hashVal)
+		cache.locks[segId].Unlock()
+	case journalClear:
+		cache.setBatch(batch, false)
+		cache.clear()
+	}
+	return batch[:0]
+}
+
+func (cache *Cache) journalLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cache.closeChan:
+			return
+		case <-ticker.C:
+			cache.CompactJournal()
+		}
+	}
+}
+
+// CompactJournal rewrites the journal of the cache to contain only the live entries.
+// Operations on the cache are not blocked during compaction, they are written to
+// both the old journal and the compacted one. Concurrent compactions run one after another.
+func (cache *Cache) CompactJournal() (err error) {
+	j := cache.config.Journal
+	if j == nil {
+		return nil
+	}
+	j.compactMu.Lock()
+	defer j.compactMu.Unlock()
+	tmpPath := j.path + ".compact"
+	file, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0644)
+	if err != nil {
+		return
+	}
+	j.mu.Lock()
+	j.rewrite = new(bytes.Buffer)
+	j.mu.Unlock()
+	w := bufio.NewWriter(file)
+	now := cache.now()
+	var buf []byte
+	for i := 0; i < len(cache.segments) && err == nil; i++ {
+		// the chunks of the chunked values are in other segments, they are read after the segment
+		// is unlocked, a value is skipped if a chunk is evicted.
+		var manifests []expiredEntry
+		cache.locks[i].Lock()
+		cache.segments[i].iterateAll(now, func(key, value []byte, hdr *entryHdr) bool {
+			if hdr.chunked() {
+				if !hdr.negative() && len(value) == manifestLen {
+					manifests = append(manifests, expiredEntry{key: key, value: value, expireAt: hdr.expireAt})
+				}
+				return true
+			}
+			buf = appendJournalRecord(buf[:0], journalSet, key, value, hdr.expireAt)
+			_, err = w.Write(buf)
+			return err == nil
+		})
+		cache.locks[i].Unlock()
+		for _, entry := range manifests {
+			if err != nil {
+				break
+			}
+			// the last chunk of a value may look like a manifest, its chunks are not found.
+			if value, getErr := cache.getChunks(entry.key, entry.value); getErr == nil {
+				buf = appendJournalRecord(buf[:0], journalSet, entry.key, value, entry.expireAt)
+				_, err = w.Write(buf)
+			}
+		}
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if err == nil {
+		_, err = w.Write(j.rewrite.Bytes())
+	}
+	j.rewrite = nil
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, j.path)
+	}
+	if err != nil {
+		file.Close()
+		os.Remove(tmpPath)
+		return
+	}
+	j.file.Close()
+	j.file = file
+	j.w = bufio.NewWriter(file)
+	return
+}
@@ -0,0 +1,254 @@
+package freecache
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.journal")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewCacheWithConfig(1024, Config{Journal: j})
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte(fmt.Sprintf("val%v", i)), 0)
+	}
+	cache.Del([]byte("key0"))
+	cache.Set([]byte("key1"), []byte("new"), 0)
+	if err = cache.CompactJournal(); err != nil {
+		t.Fatal(err)
+	}
+	cache.Del([]byte("key2"))
+	if err = j.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a crash in the middle of writing a record.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	f.Write([]byte{journalSet, 0, 0})
+	f.Close()
+
+	j, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	replayed := NewCache(1024)
+	n, err := j.Replay(replayed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 100 {
+		t.Error("replayed records is", n, "expected", 100)
+	}
+	if count := replayed.EntryCount(); count != 98 {
+		t.Error("entry count is", count, "expected", 98)
+	}
+	if val, _ := replayed.Get([]byte("key1")); string(val) != "new" {
+		t.Error("value is", string(val), "expected new")
+	}
+	for _, key := range []string{"key0", "key2"} {
+		if _, err = replayed.Get([]byte(key)); err != ErrNotFound {
+			t.Error("deleted key", key, "should not be replayed")
+		}
+	}
+	if info, _ := os.Stat(path); info.Size() == 0 {
+		t.Error("journal should not be empty")
+	}
+}
+
+func TestCompactJournalChunked(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.journal")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := make([]byte, 20000)
+	for i := range large {
+		large[i] = byte(i)
+	}
+	cache := NewCacheWithConfig(1024*1024, Config{Journal: j, ChunkLargeValues: true})
+	cache.Set([]byte("large"), large, 0)
+	cache.Set([]byte("key"), []byte("value"), 0)
+	if err = cache.CompactJournal(); err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	replayed := NewCacheWithConfig(1024*1024, Config{ChunkLargeValues: true})
+	if n, err := j.Replay(replayed); err != nil || n != 2 {
+		t.Fatal("replayed records is", n, err)
+	}
+	if value, err := replayed.Get([]byte("large")); err != nil || !bytes.Equal(value, large) {
+		t.Error("the chunked value should be in the compacted journal", err)
+	}
+	if value, err := replayed.Get([]byte("key")); err != nil || string(value) != "value" {
+		t.Error(string(value), err)
+	}
+}
+
+func TestCompactJournalConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.journal")
+	j, err := OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := NewCacheWithConfig(1024*1024, Config{Journal: j})
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte(fmt.Sprintf("val%v", i)), 0)
+	}
+	errs := make(chan error, 2)
+	for n := 0; n < 2; n++ {
+		go func() {
+			var err error
+			for i := 0; i < 20 && err == nil; i++ {
+				err = cache.CompactJournal()
+			}
+			errs <- err
+		}()
+	}
+	for n := 0; n < 2; n++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = j.Close(); err != nil {
+		t.Fatal(err)
+	}
+	j, err = OpenJournal(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	replayed := NewCache(1024 * 1024)
+	if n, err := j.Replay(replayed); err != nil || n != 1000 {
+		t.Fatal("replayed records is", n, err)
+	}
+	for i := 0; i < 1000; i++ {
+		if value, err := replayed.Get([]byte(fmt.Sprintf("key%v", i))); err != nil || string(value) != fmt.Sprintf("val%v", i) {
+			t.Fatal(i, string(value), err)
+		}
+	}
+}
+
+func TestJournalAllocs(t *testing.T) {
+	j, err := OpenJournal(filepath.Join(t.TempDir(), "cache.journal"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	// the key and the value are on the stack unless the journal makes them escape.
+	if allocs := testing.AllocsPerRun(100, func() {
+		j.log(journalSet, []byte("key"), []byte("value"), 0)
+	}); allocs != 0 {
+		t.Error("writing a record should not allocate", allocs)
+	}
+}
+
+func TestReplicator(t *testing.T) {
+	standby := NewCache(1024 * 1024)
+	streamed := NewCache(1024 * 1024)
+	pr, pw := io.Pipe()
+	done := make(chan error)
+	go func() {
+		_, err := streamed.ReadReplication(pr)
+		done <- err
+	}()
+	r := NewReplicator(ReplicatorConfig{Peers: []Peer{standby, StreamPeer(pw)}, BatchSize: 16})
+	cache := NewCacheWithConfig(1024*1024, Config{Replicator: r})
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte(fmt.Sprintf("val%v", i)), 0)
+	}
+	cache.Del([]byte("key0"))
+	cache.Set([]byte("key1"), []byte("new"), 100)
+	r.Close()
+	pw.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for i, stat := range r.Stats() {
+		if stat.Sent != 102 || stat.Queued != 0 || stat.Dropped != 0 {
+			t.Errorf("peer %v stat is %+v", i, stat)
+		}
+	}
+	for _, peer := range []*Cache{standby, streamed} {
+		if count := peer.EntryCount(); count != 99 {
+			t.Error("entry count is", count, "expected", 99)
+		}
+		if val, _ := peer.Get([]byte("key1")); string(val) != "new" {
+			t.Error("value is", string(val), "expected new")
+		}
+		if ttl, _ := peer.TTL([]byte("key1")); ttl == 0 || ttl > 100 {
+			t.Error("ttl is", ttl)
+		}
+		if _, err := peer.Get([]byte("key0")); err != ErrNotFound {
+			t.Error("deleted key should not be replicated")
+		}
+	}
+	cache.Set([]byte("key2"), []byte("closed"), 0)
+	if stat := r.Stats()[0]; stat.Dropped != 1 {
+		t.Error("dropped is", stat.Dropped, "expected", 1)
+	}
+}
+
+type blockingPeer struct {
+	release chan struct{}
+	err     error
+}
+
+func (p *blockingPeer) Replicate(mutations []Mutation) error {
+	<-p.release
+	return p.err
+}
+
+func TestReplicatorBackpressure(t *testing.T) {
+	peer := &blockingPeer{release: make(chan struct{}), err: errors.New("peer is down")}
+	r := NewReplicator(ReplicatorConfig{Peers: []Peer{peer}, BatchSize: 1, QueueSize: 2})
+	cache := NewCacheWithConfig(1024*1024, Config{Replicator: r})
+	// the first mutation is taken by the send loop, the next two fill the queue.
+	cache.Set([]byte("key0"), []byte("val"), 0)
+	for r.Stats()[0].Queued != 0 {
+		runtime.Gosched()
+	}
+	for i := 1; i < 10; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte("val"), 0)
+	}
+	if stat := r.Stats()[0]; stat.Queued != 2 || stat.Dropped != 7 {
+		t.Errorf("stat is %+v", stat)
+	}
+	close(peer.release)
+	r.Close()
+	if stat := r.Stats()[0]; stat.Failed != 3 || stat.Errors != 3 {
+		t.Errorf("stat is %+v", stat)
+	}
+
+	peer = &blockingPeer{release: make(chan struct{})}
+	r = NewReplicator(ReplicatorConfig{Peers: []Peer{peer}, BatchSize: 1, QueueSize: 2, Block: true})
+	cache = NewCacheWithConfig(1024*1024, Config{Replicator: r})
+	set := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte("val"), 0)
+		}
+		close(set)
+	}()
+	select {
+	case <-set:
+		t.Fatal("Set should wait for the queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(peer.release)
+	<-set
+	r.Close()
+	if stat := r.Stats()[0]; stat.Sent != 10 || stat.Dropped != 0 {
+		t.Errorf("stat is %+v", stat)
+	}
+}
@@ -0,0 +1,179 @@
+package freecache
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+)
+
+var ErrLoadMany = errors.New("LoadMany returned a different number of entities than ids")
+
+// Codec encodes values of type T into the bytes stored in the cache.
+type Codec[T any] interface {
+	Marshal(value T) ([]byte, error)
+	Unmarshal(data []byte) (T, error)
+}
+
+// JSONCodec encodes values with encoding/json.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Marshal(value T) ([]byte, error) {
+	return json.Marshal(value)
+}
+
+func (JSONCodec[T]) Unmarshal(data []byte) (value T, err error) {
+	err = json.Unmarshal(data, &value)
+	return
+}
+
+// GobCodec encodes values with encoding/gob, every value carries its type description, so it
+// suits large values of types that JSON can't encode. A protobuf codec is in package protocodec.
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Marshal(value T) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(value)
+	return buf.Bytes(), err
+}
+
+func (GobCodec[T]) Unmarshal(data []byte) (value T, err error) {
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&value)
+	return
+}
+
+// KeyedOptions configures a Keyed helper.
+type KeyedOptions[K, T any] struct {
+	// Prefix is prepended to every key, so entity types sharing a cache don't collide, like "user:".
+	Prefix string
+	// Keys encodes the id of an entity, nil means StringKey, BytesKey, Int64Key or Uint64Key for
+	// these types, an int is encoded like an int64, and BinaryKey for the other fixed size types.
+	// NewKeyed panics if it is nil for another type.
+	Keys KeyEncoder[K]
+	// Codec encodes the entities, nil means JSONCodec.
+	Codec Codec[T]
+	// Load loads an entity that is not in the cache.
+	Load func(ctx context.Context, id K) (T, error)
+	// LoadMany loads the entities of ids that are not in the cache, in the order of ids, it must
+	// return an entity for every id. If it is nil, GetMany calls Load for every missing entity.
+	LoadMany func(ctx context.Context, ids []K) ([]T, error)
+	// ExpireSeconds is the expiration of the cached entities.
+	ExpireSeconds int
+	// TTL returns the expiration of a loaded entity, nil means ExpireSeconds.
+	TTL func(value T) int
+}
+
+// Keyed is a cache-aside helper for an entity type: entities are looked up in the cache by id,
+// and loaded and cached when they are missing.
+type Keyed[K, T any] struct {
+	cache *Cache
+	opts  KeyedOptions[K, T]
+}
+
+// NewKeyed creates a Keyed helper storing the entities in cache.
+func NewKeyed[K, T any](cache *Cache, opts KeyedOptions[K, T]) *Keyed[K, T] {
+	if opts.Codec == nil {
+		opts.Codec = JSONCodec[T]{}
+	}
+	if opts.Keys == nil {
+		opts.Keys = defaultKeyEncoder[K]("KeyedOptions.Keys")
+	}
+	return &Keyed[K, T]{cache: cache, opts: opts}
+}
+
+// Key returns the cache key of the entity id.
+func (k *Keyed[K, T]) Key(id K) []byte {
+	return k.opts.Keys.AppendKey([]byte(k.opts.Prefix), id)
+}
+
+// Get returns the entity from the cache, or loads and caches it. A cached value that can not be
+// decoded is loaded again, a loaded entity is returned even if it can not be cached.
+func (k *Keyed[K, T]) Get(ctx context.Context, id K) (value T, err error) {
+	key := k.Key(id)
+	if value, ok := k.cached(key); ok {
+		return value, nil
+	}
+	if value, err = k.opts.Load(ctx, id); err != nil {
+		return
+	}
+	k.set(key, value)
+	return
+}
+
+// GetMany is like Get for every id, the entities are returned in the order of ids.
+func (k *Keyed[K, T]) GetMany(ctx context.Context, ids []K) (values []T, err error) {
+	values = make([]T, len(ids))
+	var missing []int
+	for i, id := range ids {
+		var ok bool
+		if values[i], ok = k.cached(k.Key(id)); !ok {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) == 0 {
+		return
+	}
+	if k.opts.LoadMany == nil {
+		for _, i := range missing {
+			if values[i], err = k.Get(ctx, ids[i]); err != nil {
+				return nil, err
+			}
+		}
+		return
+	}
+	missingIds := make([]K, len(missing))
+	for j, i := range missing {
+		missingIds[j] = ids[i]
+	}
+	loaded, err := k.opts.LoadMany(ctx, missingIds)
+	if err != nil {
+		return nil, err
+	}
+	if len(loaded) != len(missingIds) {
+		return nil, ErrLoadMany
+	}
+	for j, i := range missing {
+		values[i] = loaded[j]
+		k.set(k.Key(ids[i]), loaded[j])
+	}
+	return
+}
+
+// Set caches the entity, it returns the error of the codec or the cache.
+func (k *Keyed[K, T]) Set(id K, value T) error {
+	return k.set(k.Key(id), value)
+}
+
+// Invalidate deletes the cached entity, it reports whether it was cached.
+func (k *Keyed[K, T]) Invalidate(id K) bool {
+	return k.cache.Del(k.Key(id))
+}
+
+// InvalidateMany deletes the cached entities of ids.
+func (k *Keyed[K, T]) InvalidateMany(ids []K) {
+	for _, id := range ids {
+		k.cache.Del(k.Key(id))
+	}
+}
+
+func (k *Keyed[K, T]) cached(key []byte) (value T, ok bool) {
+	data, err := k.cache.Get(key)
+	if err != nil {
+		return
+	}
+	value, err = k.opts.Codec.Unmarshal(data)
+	return value, err == nil
+}
+
+func (k *Keyed[K, T]) set(key []byte, value T) error {
+	data, err := k.opts.Codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	expireSeconds := k.opts.ExpireSeconds
+	if k.opts.TTL != nil {
+		expireSeconds = k.opts.TTL(value)
+	}
+	return k.cache.Set(key, data, expireSeconds)
+}
@@ -0,0 +1,146 @@
+package freecache
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+type testUser struct {
+	Id   int64
+	Name string
+}
+
+func TestKeyed(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	loads := 0
+	users := NewKeyed(cache, KeyedOptions[int64, testUser]{
+		Prefix: "user:",
+		Keys:   Int64Key,
+		Load: func(ctx context.Context, id int64) (testUser, error) {
+			loads++
+			if id < 0 {
+				return testUser{}, errors.New("invalid id")
+			}
+			return testUser{Id: id, Name: "name"}, nil
+		},
+		ExpireSeconds: 60,
+	})
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		user, err := users.Get(ctx, 1)
+		if err != nil || user != (testUser{Id: 1, Name: "name"}) {
+			t.Fatal(user, err)
+		}
+	}
+	if loads != 1 {
+		t.Error("entity should be loaded once", loads)
+	}
+	if _, err := users.Get(ctx, -1); err == nil {
+		t.Error("load error should be returned")
+	}
+	if !users.Invalidate(1) || users.Invalidate(1) {
+		t.Error("entity should be invalidated once")
+	}
+	loads = 0
+	values, err := users.GetMany(ctx, []int64{1, 2})
+	if err != nil || !reflect.DeepEqual(values, []testUser{{1, "name"}, {2, "name"}}) || loads != 2 {
+		t.Fatal(values, err, loads)
+	}
+	users.Set(3, testUser{Id: 3, Name: "set"})
+	var loadedMany []int64
+	users.opts.LoadMany = func(ctx context.Context, ids []int64) ([]testUser, error) {
+		loadedMany = ids
+		return []testUser{{Id: 4, Name: "many"}}, nil
+	}
+	values, err = users.GetMany(ctx, []int64{3, 4})
+	if err != nil || !reflect.DeepEqual(values, []testUser{{3, "set"}, {4, "many"}}) || !reflect.DeepEqual(loadedMany, []int64{4}) {
+		t.Fatal(values, err, loadedMany)
+	}
+	users.InvalidateMany([]int64{3, 4})
+	if _, err := cache.Get(users.Key(3)); err != ErrNotFound {
+		t.Error("entity should be invalidated", err)
+	}
+	users.opts.LoadMany = func(ctx context.Context, ids []int64) ([]testUser, error) {
+		return nil, nil
+	}
+	if values, err = users.GetMany(ctx, []int64{5}); err != ErrLoadMany || values != nil {
+		t.Error("err should be ErrLoadMany", values, err)
+	}
+}
+
+func TestKeyedDefaultKeys(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	load := func(ctx context.Context, id int) (string, error) { return "value", nil }
+	ints := NewKeyed(cache, KeyedOptions[int, string]{Prefix: "int:", Load: load})
+	if _, err := ints.Get(context.Background(), 42); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.Get(Int64Key.AppendKey([]byte("int:"), 42)); err != nil {
+		t.Error("an int should be encoded like Int64Key", err)
+	}
+	if key := NewKeyed(cache, KeyedOptions[string, string]{}).Key("id"); string(key) != "id" {
+		t.Error("a string should be encoded like StringKey", string(key))
+	}
+	if key := NewKeyed(cache, KeyedOptions[[2]uint16, string]{}).Key([2]uint16{1, 2}); !bytes.Equal(key, []byte{0, 1, 0, 2}) {
+		t.Error("a fixed size key should be encoded like BinaryKey", key)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("NewKeyed should panic without Keys for a key type of no fixed size")
+		}
+	}()
+	NewKeyed(cache, KeyedOptions[struct{ Id []int }, string]{})
+}
+
+func TestTyped(t *testing.T) {
+	users := NewTyped[testUser](NewCache(1024*1024), nil)
+	if err := users.Set([]byte("user:1"), testUser{Id: 1, Name: "name"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := users.Get([]byte("user:1")); err != nil || user != (testUser{Id: 1, Name: "name"}) {
+		t.Error(user, err)
+	}
+	users.Cache().Set([]byte("user:2"), []byte("not json"), 0)
+	if _, err := users.Get([]byte("user:2")); err == nil {
+		t.Error("the codec error should be returned")
+	}
+	if !users.Del([]byte("user:1")) {
+		t.Error("the value should be deleted")
+	}
+	if _, err := users.Get([]byte("user:1")); err != ErrNotFound {
+		t.Error("err should be ErrNotFound", err)
+	}
+}
+
+func TestTypedLongKeys(t *testing.T) {
+	users := NewTyped[testUser](NewCacheWithConfig(64*1024*1024, Config{LongKeys: true, MaxEntrySize: 100000}), nil)
+	key := bytes.Repeat([]byte("k"), 70000)
+	if err := users.Set(key, testUser{Id: 1, Name: "name"}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if user, err := users.Get(key); err != nil || user.Id != 1 {
+		t.Error(user, err)
+	}
+	if _, err := users.Cache().Get(key); err != nil {
+		t.Error("the key should be encoded like the keys of the cache", err)
+	}
+	if !users.Del(key) {
+		t.Error("the value should be deleted")
+	}
+}
+
+func TestCodecs(t *testing.T) {
+	user := testUser{Id: 1, Name: "name"}
+	for _, codec := range []Codec[testUser]{JSONCodec[testUser]{}, GobCodec[testUser]{}} {
+		users := NewTyped(NewCache(1024*1024), codec)
+		if err := users.Set([]byte("user:1"), user, 0); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := users.Get([]byte("user:1")); err != nil || got != user {
+			t.Error(reflect.TypeOf(codec), got, err)
+		}
+	}
+}
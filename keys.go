@@ -0,0 +1,82 @@
+package freecache
+
+import (
+	"encoding/binary"
+)
+
+// KeyEncoder encodes keys of type K into the bytes used as cache keys, it is meant for
+// typed wrappers around the cache, so keys don't have to be built with fmt.Sprintf.
+// The encoding must be canonical: equal keys are always encoded into the same bytes,
+// and different keys are always encoded into different bytes.
+type KeyEncoder[K any] interface {
+	// AppendKey appends the encoded key to dst and returns the extended slice.
+	AppendKey(dst []byte, key K) []byte
+}
+
+// KeyEncoderFunc adapts a function to the KeyEncoder interface.
+type KeyEncoderFunc[K any] func(dst []byte, key K) []byte
+
+func (f KeyEncoderFunc[K]) AppendKey(dst []byte, key K) []byte {
+	return f(dst, key)
+}
+
+// StringKey encodes a string key as its bytes.
+var StringKey KeyEncoder[string] = KeyEncoderFunc[string](func(dst []byte, key string) []byte {
+	return append(dst, key...)
+})
+
+// BytesKey encodes a byte slice key as itself.
+var BytesKey KeyEncoder[[]byte] = KeyEncoderFunc[[]byte](func(dst []byte, key []byte) []byte {
+	return append(dst, key...)
+})
+
+// Int64Key encodes an int64 key as 8 bytes in big endian order.
+var Int64Key KeyEncoder[int64] = KeyEncoderFunc[int64](func(dst []byte, key int64) []byte {
+	return binary.BigEndian.AppendUint64(dst, uint64(key))
+})
+
+// Uint64Key encodes an uint64 key as 8 bytes in big endian order.
+var Uint64Key KeyEncoder[uint64] = KeyEncoderFunc[uint64](func(dst []byte, key uint64) []byte {
+	return binary.BigEndian.AppendUint64(dst, key)
+})
+
+// BinaryKey returns an encoder for a fixed size key type, like uuid.UUID or a struct of
+// fixed size fields, the key is encoded by encoding/binary in big endian order.
+// It panics if K is not a fixed size type.
+func BinaryKey[K any]() KeyEncoder[K] {
+	var zero K
+	if binary.Size(zero) < 0 {
+		panic("freecache: BinaryKey requires a fixed size key type")
+	}
+	return KeyEncoderFunc[K](func(dst []byte, key K) []byte {
+		dst, _ = binary.Append(dst, binary.BigEndian, key)
+		return dst
+	})
+}
+
+// defaultKeyEncoder returns the encoder of K among the ones above, an int is encoded like an int64
+// and a fixed size type by BinaryKey. It panics with the name of the option if there is none.
+func defaultKeyEncoder[K any](option string) KeyEncoder[K] {
+	var zero K
+	var enc any
+	switch any(zero).(type) {
+	case string:
+		enc = StringKey
+	case []byte:
+		enc = BytesKey
+	case int64:
+		enc = Int64Key
+	case uint64:
+		enc = Uint64Key
+	case int:
+		enc = KeyEncoderFunc[int](func(dst []byte, key int) []byte {
+			return binary.BigEndian.AppendUint64(dst, uint64(key))
+		})
+	default:
+		if binary.Size(zero) < 0 {
+			panic("freecache: " + option + " is required for a key type that is not of fixed size")
+		}
+		return BinaryKey[K]()
+	}
+	return enc.(KeyEncoder[K])
+}
@@ -0,0 +1,29 @@
+package freecache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestKeyEncoder(t *testing.T) {
+	if key := Int64Key.AppendKey([]byte("user:"), 258); !bytes.Equal(key, []byte("user:\x00\x00\x00\x00\x00\x00\x01\x02")) {
+		t.Errorf("int64 key is %q", key)
+	}
+	type compositeKey struct {
+		Tenant uint32
+		ID     [16]byte
+	}
+	enc := BinaryKey[compositeKey]()
+	a := enc.AppendKey(nil, compositeKey{Tenant: 1, ID: [16]byte{2}})
+	b := enc.AppendKey(nil, compositeKey{Tenant: 1, ID: [16]byte{2}})
+	c := enc.AppendKey(nil, compositeKey{Tenant: 2, ID: [16]byte{2}})
+	if len(a) != 20 || !bytes.Equal(a, b) || bytes.Equal(a, c) {
+		t.Errorf("binary keys are %q %q %q", a, b, c)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Error("BinaryKey should panic for a variable size type")
+		}
+	}()
+	BinaryKey[string]()
+}
@@ -0,0 +1,38 @@
+package freecache
+
+import "time"
+
+// Op is an operation on the cache observed by a LatencyObserver.
+type Op int
+
+const (
+	OpGet Op = iota
+	OpSet
+	OpDel
+)
+
+func (op Op) String() string {
+	switch op {
+	case OpGet:
+		return "get"
+	case OpSet:
+		return "set"
+	case OpDel:
+		return "del"
+	}
+	return "unknown"
+}
+
+// LatencyObserver is called after every Get, Set and Del with the time the operation started.
+// It is called without holding any lock, and must be safe for concurrent use.
+type LatencyObserver func(op Op, start time.Time)
+
+// SetLatencyObserver sets the observer of the operation latencies, nil removes it.
+// Operations are not timed when there is no observer.
+func (cache *Cache) SetLatencyObserver(observe LatencyObserver) {
+	if observe == nil {
+		cache.latency.Store(nil)
+		return
+	}
+	cache.latency.Store(&observe)
+}
@@ -0,0 +1,71 @@
+package freecache
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+const (
+	// maxKeyLen is the longest key stored in an entry.
+	maxKeyLen = 65535
+	// longKeyPrefix starts the key of the entry of a long key, followed by the SHA-256 of the key.
+	longKeyPrefix = "\x00freecache.long\x00"
+	longKeyLen    = len(longKeyPrefix) + sha256.Size
+)
+
+// entryKey returns the key of the entry of key. A key longer than maxKeyLen is replaced by its
+// digest if the cache supports long keys, the key is stored at the end of the value.
+func (cache *Cache) entryKey(key []byte) []byte {
+	if !cache.config.LongKeys || len(key) <= maxKeyLen {
+		return key
+	}
+	digest := sha256.Sum256(key)
+	return append([]byte(longKeyPrefix), digest[:]...)
+}
+
+// isLongKey tells whether entryKey is the digest of a long key.
+func isLongKey(entryKey []byte) bool {
+	return len(entryKey) == longKeyLen && string(entryKey[:len(longKeyPrefix)]) == longKeyPrefix
+}
+
+// appendLongKey returns value followed by key and the length of key if key is long.
+func (cache *Cache) appendLongKey(key, value []byte) []byte {
+	if !cache.config.LongKeys || len(key) <= maxKeyLen {
+		return value
+	}
+	// value may be the caller's slice, it is not appended to.
+	stored := make([]byte, 0, len(value)+len(key)+4)
+	stored = append(append(stored, value...), key...)
+	return binary.LittleEndian.AppendUint32(stored, uint32(len(key)))
+}
+
+// splitLongKey returns the key and the value of an entry, the key is read from the end of
+// the value if entryKey is the digest of a long key.
+func (cache *Cache) splitLongKey(entryKey, value []byte) (key, val []byte, err error) {
+	if !cache.config.LongKeys || !isLongKey(entryKey) {
+		return entryKey, value, nil
+	}
+	if len(value) < 4 {
+		return nil, nil, ErrCorrupted
+	}
+	keyLen := int(binary.LittleEndian.Uint32(value[len(value)-4:]))
+	if keyLen > len(value)-4 {
+		return nil, nil, ErrCorrupted
+	}
+	keyOff := len(value) - 4 - keyLen
+	return value[keyOff : len(value)-4], value[:keyOff], nil
+}
+
+// checkLongKey returns the value of key from the value of an entry, ErrNotFound is returned if the
+// entry is of another key with the same digest.
+func (cache *Cache) checkLongKey(key, entryKey, value []byte) ([]byte, error) {
+	storedKey, stored, err := cache.splitLongKey(entryKey, value)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(storedKey, key) {
+		return nil, ErrNotFound
+	}
+	return stored, nil
+}
@@ -0,0 +1,105 @@
+package freecache
+
+import "unsafe"
+
+// lruList is a doubly-linked list of the entries of a segment in the order of access, for the
+// strict LRU mode. The nodes are indexed by entryPtr.fp32, freed nodes are reused.
+type lruList struct {
+	nodes []lruNode
+	head  int32 // the most recently used, -1 if the list is empty.
+	tail  int32 // the least recently used.
+	free  int32 // the first freed node, linked by next.
+}
+
+type lruNode struct {
+	prev   int32
+	next   int32
+	pinned bool // pinned entries are skipped by isLRU.
+}
+
+func newLRUList() *lruList {
+	return &lruList{head: -1, tail: -1, free: -1}
+}
+
+// pushFront allocates a node at the head of the list and returns its index.
+func (l *lruList) pushFront() (idx int32) {
+	if l.free >= 0 {
+		idx = l.free
+		l.free = l.nodes[idx].next
+	} else {
+		idx = int32(len(l.nodes))
+		l.nodes = append(l.nodes, lruNode{})
+	}
+	l.link(idx)
+	return
+}
+
+func (l *lruList) link(idx int32) {
+	l.nodes[idx].prev = -1
+	l.nodes[idx].next = l.head
+	if l.head >= 0 {
+		l.nodes[l.head].prev = idx
+	} else {
+		l.tail = idx
+	}
+	l.head = idx
+}
+
+func (l *lruList) unlink(idx int32) {
+	node := l.nodes[idx]
+	if node.prev >= 0 {
+		l.nodes[node.prev].next = node.next
+	} else {
+		l.head = node.next
+	}
+	if node.next >= 0 {
+		l.nodes[node.next].prev = node.prev
+	} else {
+		l.tail = node.prev
+	}
+}
+
+func (l *lruList) moveToFront(idx int32) {
+	if l.head != idx {
+		l.unlink(idx)
+		l.link(idx)
+	}
+}
+
+// remove unlinks the node and frees it.
+func (l *lruList) remove(idx int32) {
+	l.unlink(idx)
+	l.nodes[idx] = lruNode{next: l.free}
+	l.free = idx
+}
+
+// rebuildLRU links every entry of the segment into a new list, the access order is lost.
+func (seg *segment) rebuildLRU() {
+	seg.lru = newLRUList()
+	for slotId := 0; slotId < 256; slotId++ {
+		slotOff := int32(slotId) * seg.slotCap
+		slot := seg.slotsData[slotOff : slotOff+seg.slotLens[slotId]]
+		for i := range slot {
+			slot[i].fp32 = uint32(seg.lru.pushFront())
+			var hdrBuf [ENTRY_HDR_SIZE]byte
+			seg.rb.ReadAt(hdrBuf[:], slot[i].offset)
+			seg.lru.nodes[slot[i].fp32].pinned = (*entryHdr)(unsafe.Pointer(&hdrBuf[0])).pinned()
+		}
+	}
+}
+
+// isLRU reports whether the entry at offset is the least recently used entry of the segment
+// that is not pinned.
+func (seg *segment) isLRU(hdr *entryHdr, offset int64) bool {
+	slotOff := int32(hdr.slotId) * seg.slotCap
+	slot := seg.slotsData[slotOff : slotOff+seg.slotLens[hdr.slotId]]
+	idx, match := seg.lookupByOff(slot, hdr.hash16, offset)
+	if !match {
+		return true
+	}
+	lru := seg.lru.tail
+	for lru >= 0 && seg.lru.nodes[lru].pinned {
+		lru = seg.lru.nodes[lru].prev
+	}
+	return int32(slot[idx].fp32) == lru
+}
@@ -0,0 +1,59 @@
+package freecache
+
+import (
+	"math"
+	"runtime/metrics"
+	"time"
+)
+
+// memoryLimitLoop resizes the cache for the memory used by the Go runtime and the soft memory limit.
+func (cache *Cache) memoryLimitLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	samples := []metrics.Sample{
+		{Name: "/gc/gomemlimit:bytes"},
+		{Name: "/memory/classes/total:bytes"},
+		{Name: "/memory/classes/heap/released:bytes"},
+	}
+	for {
+		select {
+		case <-cache.closeChan:
+			return
+		case <-ticker.C:
+			if cache.mmap != nil {
+				return
+			}
+			metrics.Read(samples)
+			limit := samples[0].Value.Uint64()
+			if limit == math.MaxInt64 {
+				// no memory limit is set.
+				continue
+			}
+			// the memory limit applies to the memory of the runtime that is not released to the OS.
+			used := samples[1].Value.Uint64() - samples[2].Value.Uint64()
+			cache.adaptToMemoryLimit(int64(used), int64(limit))
+		}
+	}
+}
+
+// adaptToMemoryLimit shrinks the cache by a quarter if used exceeds MemoryPressure of limit, or
+// grows it by a third towards its capacity if the ring buffers of the new size can be allocated
+// below that while the old ones are alive.
+func (cache *Cache) adaptToMemoryLimit(used, limit int64) {
+	cache.resizeMu.Lock()
+	defer cache.resizeMu.Unlock()
+	high := int64(cache.config.MemoryPressure * float64(limit))
+	size, capacity := int64(cache.Size()), cache.capacity.Load()
+	var newSize int64
+	switch {
+	case used > high:
+		newSize = max(size*3/4, capacity/8)
+	case size < capacity && used+min(size*4/3, capacity) <= high:
+		newSize = min(size*4/3, capacity)
+	default:
+		return
+	}
+	if newSize = int64(bufferSize(int(newSize), cache.config)); newSize != size {
+		cache.resize(int(newSize))
+	}
+}
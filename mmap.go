@@ -0,0 +1,154 @@
+package freecache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+var ErrMmapUnsupported = errors.New("Memory-mapped cache is not supported on this platform")
+
+const mmapMetaMagic = "FREEMETA"
+
+// mmapState is the memory-mapped file backing a cache.
+type mmapState struct {
+	path    string
+	file    *os.File // nil if the memory is anonymous.
+	data    []byte
+	persist bool
+}
+
+// NewMmapCache creates a cache whose ring buffers are allocated from the memory-mapped file at path
+// instead of the Go heap, so the cache can be much larger than the Go heap.
+// If persist is true, the entries are kept across restarts: Close saves the index of the cache
+// to path+".meta", and the next NewMmapCache with the same path and size loads it.
+// A cache created by NewMmapCache must not be used after Close.
+func NewMmapCache(path string, size int, persist bool, config Config) (cache *Cache, err error) {
+	size = bufferSize(size, config)
+	if !persist {
+		os.Remove(path)
+		os.Remove(path + ".meta")
+	}
+	file, data, err := mmapFile(path, size)
+	if err != nil {
+		return
+	}
+	cache = buildCache(config)
+	cache.initSegments(data)
+	cache.mmap = &mmapState{path: path, file: file, data: data, persist: persist}
+	if persist {
+		cache.loadMmapMeta()
+	}
+	cache.start()
+	return
+}
+
+// NewOffHeapCache creates a cache whose ring buffers are allocated from anonymous memory mappings
+// instead of the Go heap, so even a cache of many gigabytes adds nothing to the heap the garbage
+// collector scans, sweeps and sizes its goal by. The memory is returned to the system by Close,
+// and a cache created by NewOffHeapCache must not be used after Close.
+func NewOffHeapCache(size int, config Config) (cache *Cache, err error) {
+	data, err := mmapAnon(bufferSize(size, config))
+	if err != nil {
+		return
+	}
+	cache = buildCache(config)
+	cache.initSegments(data)
+	// the memory limit loop reads mmap, it is set before the goroutines start.
+	cache.mmap = &mmapState{data: data}
+	cache.start()
+	return
+}
+
+// loadMmapMeta loads the index saved by the last Close, then removes it, since it would
+// not match the data any more after the cache is modified.
+func (cache *Cache) loadMmapMeta() {
+	if cache.config.Storage != nil {
+		return
+	}
+	metaPath := cache.mmap.path + ".meta"
+	file, err := os.Open(metaPath)
+	if err != nil {
+		return
+	}
+	defer os.Remove(metaPath)
+	defer file.Close()
+	r := bufio.NewReader(file)
+	var magic [len(mmapMetaMagic)]byte
+	var version uint32
+	if _, err = io.ReadFull(r, magic[:]); err != nil || string(magic[:]) != mmapMetaMagic {
+		return
+	}
+	if err = binary.Read(r, binary.LittleEndian, &version); err != nil || version != snapshotVersion {
+		return
+	}
+	var seed hashSeed
+	if err = binary.Read(r, binary.LittleEndian, &seed); err != nil {
+		return
+	}
+	cache.seeds.Store(&seedState{cur: seed})
+	for i := 0; i < len(cache.segments); i++ {
+		var meta segmentMeta
+		if meta, err = readSegmentMeta(r); err != nil {
+			break
+		}
+		if err = cache.segments[i].readFrom(r, &meta); err != nil {
+			break
+		}
+	}
+	if err != nil {
+		cache.clear()
+	} else {
+		cache.loaded()
+	}
+}
+
+func (cache *Cache) closeMmap() (err error) {
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+	}
+	defer func() {
+		for i := 0; i < len(cache.segments); i++ {
+			cache.locks[i].Unlock()
+		}
+	}()
+	if cache.mmap.persist {
+		err = cache.saveMmapMeta()
+	}
+	if unmapErr := munmap(cache.mmap.data); err == nil {
+		err = unmapErr
+	}
+	if cache.mmap.file == nil {
+		return
+	}
+	if closeErr := cache.mmap.file.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
+
+func (cache *Cache) saveMmapMeta() (err error) {
+	if err = msync(cache.mmap.data); err != nil {
+		return
+	}
+	file, err := os.Create(cache.mmap.path + ".meta")
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+	w.WriteString(mmapMetaMagic)
+	binary.Write(w, binary.LittleEndian, uint32(snapshotVersion))
+	binary.Write(w, binary.LittleEndian, cache.seeds.Load().cur)
+	for i := 0; i < len(cache.segments); i++ {
+		if err = cache.segments[i].writeTo(w, false); err != nil {
+			return
+		}
+	}
+	if err = w.Flush(); err != nil {
+		return
+	}
+	return file.Sync()
+}
@@ -0,0 +1,23 @@
+//go:build !unix
+
+package freecache
+
+import (
+	"os"
+)
+
+func mmapFile(path string, size int) (file *os.File, data []byte, err error) {
+	return nil, nil, ErrMmapUnsupported
+}
+
+func mmapAnon(size int) (data []byte, err error) {
+	return nil, ErrMmapUnsupported
+}
+
+func munmap(data []byte) error {
+	return ErrMmapUnsupported
+}
+
+func msync(data []byte) error {
+	return ErrMmapUnsupported
+}
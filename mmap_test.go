@@ -0,0 +1,89 @@
+//go:build unix
+
+package freecache
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestMmapCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.mmap")
+	cache, err := NewMmapCache(path, 1024*1024, true, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte(fmt.Sprintf("val%v", i)), 0)
+	}
+	cache.Clear()
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte(fmt.Sprintf("val%v", i)), 0)
+	}
+	entryCount := cache.EntryCount()
+	if err = cache.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = NewMmapCache(path, 1024*1024, true, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.EntryCount() != entryCount {
+		t.Error("entry count is", cache.EntryCount(), "expected", entryCount)
+	}
+	if val, err := cache.Get([]byte("key999")); err != nil || string(val) != "val999" {
+		t.Error("value is", string(val), err)
+	}
+	cache.Close()
+
+	cache, err = NewMmapCache(path, 1024*1024, false, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	if cache.EntryCount() != 0 {
+		t.Error("entries should not be kept if not persisted")
+	}
+}
+
+func TestOffHeapCache(t *testing.T) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	cache, err := NewOffHeapCache(256*1024*1024, Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	if grown := int64(after.HeapSys) - int64(before.HeapSys); grown > 64*1024*1024 {
+		t.Error("the ring buffers should not be allocated from the heap", grown)
+	}
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte(fmt.Sprintf("val%v", i)), 0)
+	}
+	if val, err := cache.Get([]byte("key999")); err != nil || string(val) != "val999" {
+		t.Error("value is", string(val), err)
+	}
+	if err = cache.Resize(512 * 1024 * 1024); err != ErrResizeUnsupported {
+		t.Error("an off-heap cache should not be resized", err)
+	}
+	if err = cache.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOffHeapCacheMemoryLimit(t *testing.T) {
+	cache, err := NewOffHeapCache(16*1024*1024, Config{MemoryLimitInterval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cache.Close()
+	// the memory limit loop sees the mapping from its first tick, and doesn't resize the cache.
+	time.Sleep(20 * time.Millisecond)
+	if capacity := cache.capacity.Load(); capacity != 16*1024*1024 {
+		t.Error("an off-heap cache should not be resized", capacity)
+	}
+}
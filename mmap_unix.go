@@ -0,0 +1,40 @@
+//go:build unix
+
+package freecache
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+func mmapFile(path string, size int) (file *os.File, data []byte, err error) {
+	file, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return
+	}
+	if err = file.Truncate(int64(size)); err == nil {
+		data, err = syscall.Mmap(int(file.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+	}
+	if err != nil {
+		file.Close()
+		file = nil
+	}
+	return
+}
+
+func mmapAnon(size int) (data []byte, err error) {
+	return syscall.Mmap(-1, 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_ANON|syscall.MAP_PRIVATE)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
+
+func msync(data []byte) error {
+	_, _, errno := syscall.Syscall(syscall.SYS_MSYNC, uintptr(unsafe.Pointer(&data[0])), uintptr(len(data)), syscall.MS_SYNC)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
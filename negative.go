@@ -0,0 +1,53 @@
+package freecache
+
+import (
+	"errors"
+	"time"
+)
+
+// errNegative is returned by segment.get for a negative entry.
+var errNegative = errors.New("freecache: negative entry")
+
+// SetNegative caches that key is not found, e.g. in the database the cache is in front of, for
+// expireSeconds, so the lookups of a key that doesn't exist are answered without going to the
+// database. Get returns ErrNotFound for the key like for a miss, GetNegative tells them apart, and
+// a Set of the key replaces the negative entry. It is counted as a hit by the statistics.
+//
+// A negative entry is recorded as a Del in the journal, replicated and published as a Del, and it
+// is skipped by Scan and ToMap like the chunks of large values.
+func (cache *Cache) SetNegative(key []byte, expireSeconds int) (err error) {
+	entryKey := cache.entryKey(key)
+	var old []byte
+	if cache.config.ChunkLargeValues {
+		old = cache.manifest(entryKey)
+	}
+	seeds := cache.seeds.Load()
+	err = cache.setWithHash(entryKey, nil, seeds.cur.sipHash(entryKey), expireSeconds, -1, 0, entryChunked, nil)
+	if err == nil {
+		cache.dropChunks(entryKey, old)
+		if seeds.old != nil {
+			cache.delOld(entryKey, seeds.old.sipHash(entryKey))
+		}
+		cache.overflowDel(entryKey)
+	}
+	return
+}
+
+// GetNegative is like Get, and reports whether the key is cached as not found by SetNegative,
+// err is ErrNotFound then.
+func (cache *Cache) GetNegative(key []byte) (value []byte, negative bool, err error) {
+	if observe := cache.latency.Load(); observe != nil {
+		defer (*observe)(OpGet, time.Now())
+	}
+	entryKey := cache.entryKey(key)
+	value, err = cache.get(entryKey, nil)
+	if err == errNegative {
+		return nil, true, ErrNotFound
+	}
+	if err == errChunked {
+		value, err = cache.getChunks(entryKey, value)
+	}
+	value, err = cache.overflowGet(entryKey, value, err)
+	value, err = cache.decodeResult(key, entryKey, value, err)
+	return
+}
@@ -0,0 +1,80 @@
+package freecache
+
+import "bytes"
+
+// OverflowStore is a secondary store, typically on disk, for the entries the cache can not hold:
+// entries that are too large for the cache, and entries that are evicted to make room for new ones.
+// Get and GetWithHash look up the store when the key is not in the cache, Del deletes the key from
+// both. A value found in the store is returned as is, it is not moved back into the cache.
+//
+// The methods are called without holding any lock of the cache, and may be called concurrently.
+type OverflowStore interface {
+	// Set stores the value of key, it expires after expireSeconds, zero means no expiry.
+	Set(key, value []byte, expireSeconds int) error
+	// Get returns the value of key, or ErrNotFound if it is not stored or has expired.
+	Get(key []byte) (value []byte, err error)
+	// Del deletes the value of key, deleting a key that is not stored is not an error.
+	Del(key []byte) error
+}
+
+// keepEvicted keeps a copy of the entry at offset, which is evicted, for the overflow store or the events.
+// The parts of chunked values are not kept, the value is lost if any of them is evicted.
+func (seg *segment) keepEvicted(hdr *entryHdr, offset int64) {
+	if !seg.keepEvicts || hdr.chunked() {
+		return
+	}
+	var entry expiredEntry
+	entry.key = make([]byte, hdr.keyLen)
+	entry.value = make([]byte, hdr.valLen)
+	seg.rb.ReadAt(entry.key, offset+ENTRY_HDR_SIZE)
+	seg.rb.ReadAt(entry.value, hdr.valOff(offset))
+	entry.expireAt = hdr.expireAt
+	seg.evicted = append(seg.evicted, entry)
+}
+
+// overflowSet deletes the key from the cache and stores the value in the overflow store,
+// it is called for an entry that is too large for the cache.
+func (cache *Cache) overflowSet(key, value []byte, hashVal uint64, expireSeconds int) error {
+	cache.del(key, hashVal, false)
+	return cache.config.Overflow.Set(bytes.Clone(key), bytes.Clone(value), expireSeconds)
+}
+
+// overflowDel deletes key from the overflow store if there is one. The store is given copies of
+// the keys, like of the values, so the keys of the callers don't escape to the heap without a store.
+func (cache *Cache) overflowDel(key []byte) {
+	if cache.config.Overflow != nil {
+		cache.config.Overflow.Del(bytes.Clone(key))
+	}
+}
+
+// spill moves the evicted entries to the overflow store, and deletes the expired entries from it,
+// so an older value of an expired key is not found in the store.
+func (cache *Cache) spill(evicted, expired []expiredEntry) {
+	store := cache.config.Overflow
+	now := cache.now()
+	for _, entry := range evicted {
+		expireSeconds := 0
+		if entry.expireAt != 0 {
+			if entry.expireAt <= now {
+				continue
+			}
+			expireSeconds = int(entry.expireAt - now)
+		}
+		store.Set(entry.key, entry.value, expireSeconds)
+	}
+	for _, entry := range expired {
+		store.Del(entry.key)
+	}
+}
+
+// overflowGet looks up key in the overflow store if it is not found in the cache. A negative entry
+// is not found, it is not looked up in the store.
+func (cache *Cache) overflowGet(key, value []byte, err error) ([]byte, error) {
+	if err == errNegative {
+		return nil, ErrNotFound
+	}
+	if err != ErrNotFound || cache.config.Overflow == nil {
+		return value, err
+	}
+	return cache.config.Overflow.Get(bytes.Clone(key))
+}
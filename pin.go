@@ -0,0 +1,91 @@
+package freecache
+
+import (
+	"errors"
+	"unsafe"
+)
+
+var ErrPinned = errors.New("The segment is full of pinned entries")
+
+// Pin protects the entry from eviction, it is evacuated instead when it is the oldest entry of
+// its segment, until Unpin is called. A pinned entry still expires, and is kept pinned when it is
+// overwritten. Set returns ErrPinned if a segment is so full of pinned entries that no room can be
+// made, so only a small part of the cache should be pinned.
+// ErrNotFound is returned if the entry doesn't exist.
+func (cache *Cache) Pin(key []byte) error {
+	return cache.setPinned(key, true)
+}
+
+// Unpin makes a pinned entry evictable again, ErrNotFound is returned if the entry doesn't exist.
+func (cache *Cache) Unpin(key []byte) error {
+	return cache.setPinned(key, false)
+}
+
+func (cache *Cache) setPinned(key []byte, pinned bool) (err error) {
+	key = cache.entryKey(key)
+	seeds := cache.seeds.Load()
+	err = cache.setPinnedWithHash(key, seeds.cur.sipHash(key), pinned)
+	if err == ErrNotFound && seeds.old != nil {
+		err = cache.setPinnedWithHash(key, seeds.old.sipHash(key), pinned)
+	}
+	return
+}
+
+func (cache *Cache) setPinnedWithHash(key []byte, hashVal uint64, pinned bool) (err error) {
+	segId := hashVal & cache.segMask
+	cache.locks[segId].Lock()
+	err = cache.guarded(segId, func() error {
+		return cache.segments[segId].setPinned(key, hashVal, pinned)
+	})
+	cache.unlock(segId)
+	return
+}
+
+// setPinned sets or clears entryPinned in the header of the entry.
+func (seg *segment) setPinned(key []byte, hashVal uint64, pinned bool) error {
+	slotId := uint8(hashVal >> 8)
+	slotOff := int32(slotId) * seg.slotCap
+	slot := seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
+	idx, match := seg.lookup(slot, uint16(hashVal>>16), uint32(hashVal>>32), key)
+	if !match {
+		return ErrNotFound
+	}
+	ptr := &slot[idx]
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	if !seg.validHdr(hdr, ptr, slotId) {
+		return ErrCorrupted
+	}
+	if now := seg.now(); hdr.expireAt != 0 && hdr.expireAt <= now {
+		// an entry in its stale window is kept for GetStale, like by get.
+		if hdr.expireAt+seg.stale <= now {
+			seg.delExpiredEntry(hdr, ptr.offset)
+		}
+		return ErrNotFound
+	}
+	if pinned {
+		hdr.pad |= entryPinned
+	} else {
+		hdr.pad &^= entryPinned
+	}
+	seg.rb.WriteAt(hdrBuf[:], ptr.offset)
+	if seg.lru != nil {
+		seg.lru.nodes[ptr.fp32].pinned = pinned
+	}
+	return nil
+}
+
+// isPinned reports whether the entry exists and is pinned.
+func (seg *segment) isPinned(key []byte, hashVal uint64) bool {
+	slotId := uint8(hashVal >> 8)
+	slotOff := int32(slotId) * seg.slotCap
+	slot := seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
+	idx, match := seg.lookup(slot, uint16(hashVal>>16), uint32(hashVal>>32), key)
+	if !match {
+		return false
+	}
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	seg.rb.ReadAt(hdrBuf[:], slot[idx].offset)
+	return (*entryHdr)(unsafe.Pointer(&hdrBuf[0])).pinned()
+}
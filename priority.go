@@ -0,0 +1,40 @@
+package freecache
+
+import "errors"
+
+var ErrInvalidPriority = errors.New("The priority is larger than MaxPriority")
+
+// Priority is the eviction priority of an entry, see SetWithPriority.
+type Priority uint8
+
+const (
+	// PriorityLow entries are evicted whenever they are the oldest entry of their segment,
+	// without asking the eviction policy.
+	PriorityLow Priority = iota
+	// PriorityNormal entries are evicted by the eviction policy, it is the priority of Set.
+	PriorityNormal
+	// PriorityHigh entries are evacuated instead of evicted, until 64 entries in a row have been
+	// evacuated to make room for a new entry.
+	PriorityHigh
+	// PriorityCritical entries are evacuated until 128 entries in a row have been evacuated.
+	PriorityCritical
+
+	MaxPriority = PriorityCritical
+)
+
+// SetWithPriority is like Set, but stores the eviction priority with the entry, so entries of
+// low priority are preferred victims when room has to be made, and entries of high priority are
+// kept longer. Overwriting the entry replaces its priority, the priority is not recorded in the journal.
+func (cache *Cache) SetWithPriority(key, value []byte, expireSeconds int, priority Priority) (err error) {
+	if priority > MaxPriority {
+		cache.countError(ErrInvalidPriority)
+		return ErrInvalidPriority
+	}
+	return cache.setKey(key, value, expireSeconds, -1, 0, priority.bits())
+}
+
+// bits returns the priority bits of the pad of an entry header, zero bits mean PriorityNormal,
+// so the entries written before priorities existed are normal.
+func (p Priority) bits() uint16 {
+	return uint16((p-PriorityNormal)&MaxPriority) << priorityShift
+}
@@ -0,0 +1,91 @@
+// Package prometheus exports the statistics of a freecache.Cache as Prometheus metrics.
+package prometheus
+
+import (
+	"time"
+
+	"github.com/coocood/freecache"
+	prom "github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector implements prometheus.Collector over a cache, register it with
+// prometheus.MustRegister(prometheus.NewCollector("myapp_cache", cache)).
+type Collector struct {
+	cache       *freecache.Cache
+	hits        *prom.Desc
+	misses      *prom.Desc
+	hitRate     *prom.Desc
+	entries     *prom.Desc
+	evacuations *prom.Desc
+	evictions   *prom.Desc
+	overwrites  *prom.Desc
+	expired     *prom.Desc
+	usedBytes   *prom.Desc
+	latency     *prom.HistogramVec
+}
+
+// NewCollector creates a collector of the cache, the metric names are prefixed by namespace.
+// It sets the latency observer of the cache to record the per-op latency histograms.
+func NewCollector(namespace string, cache *freecache.Cache) *Collector {
+	desc := func(name, help string) *prom.Desc {
+		return prom.NewDesc(prom.BuildFQName(namespace, "", name), help, nil, nil)
+	}
+	c := &Collector{
+		cache:       cache,
+		hits:        desc("hits_total", "Number of lookups that found the entry."),
+		misses:      desc("misses_total", "Number of lookups that did not find the entry."),
+		hitRate:     desc("hit_rate", "Ratio of hits to lookups."),
+		entries:     desc("entries", "Number of live entries."),
+		evacuations: desc("evacuations_total", "Number of entries evacuated in the ring buffers."),
+		evictions: prom.NewDesc(prom.BuildFQName(namespace, "", "evictions_total"),
+			"Number of entries removed to make room, by reason: policy, forced after many evacuations, or expired.", []string{"reason"}, nil),
+		overwrites: desc("overwrites_total", "Number of entries overwritten in place."),
+		expired:    desc("expired_total", "Number of entries removed because they expired."),
+		usedBytes:  desc("used_bytes", "Bytes of the ring buffers used by entries, including deleted entries not yet reclaimed."),
+		latency: prom.NewHistogramVec(prom.HistogramOpts{
+			Namespace: namespace,
+			Name:      "op_duration_seconds",
+			Help:      "Latency of the cache operations.",
+			Buckets:   prom.ExponentialBuckets(1e-7, 4, 10),
+		}, []string{"op"}),
+	}
+	observers := map[freecache.Op]prom.Observer{}
+	for _, op := range []freecache.Op{freecache.OpGet, freecache.OpSet, freecache.OpDel} {
+		observers[op] = c.latency.WithLabelValues(op.String())
+	}
+	cache.SetLatencyObserver(func(op freecache.Op, start time.Time) {
+		observers[op].Observe(time.Since(start).Seconds())
+	})
+	return c
+}
+
+func (c *Collector) Describe(ch chan<- *prom.Desc) {
+	for _, desc := range []*prom.Desc{c.hits, c.misses, c.hitRate, c.entries, c.evacuations, c.evictions, c.overwrites, c.expired, c.usedBytes} {
+		ch <- desc
+	}
+	c.latency.Describe(ch)
+}
+
+func (c *Collector) Collect(ch chan<- prom.Metric) {
+	stats := c.cache.Stats()
+	var hitRate float64
+	if lookups := stats.HitCount + stats.MissCount; lookups != 0 {
+		hitRate = float64(stats.HitCount) / float64(lookups)
+	}
+	var usedBytes int64
+	for i := 0; i < c.cache.SegmentCount(); i++ {
+		usedBytes += c.cache.SegmentStats(i).UsedBytes
+	}
+	ch <- prom.MustNewConstMetric(c.hits, prom.CounterValue, float64(stats.HitCount))
+	ch <- prom.MustNewConstMetric(c.misses, prom.CounterValue, float64(stats.MissCount))
+	ch <- prom.MustNewConstMetric(c.hitRate, prom.GaugeValue, hitRate)
+	ch <- prom.MustNewConstMetric(c.entries, prom.GaugeValue, float64(stats.EntryCount))
+	ch <- prom.MustNewConstMetric(c.evacuations, prom.CounterValue, float64(stats.EvacuateCount))
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.EvictCount-stats.ForcedEvictCount), "policy")
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.ForcedEvictCount), "forced")
+	ch <- prom.MustNewConstMetric(c.evictions, prom.CounterValue, float64(stats.ExpiredEvictCount), "expired")
+	ch <- prom.MustNewConstMetric(c.overwrites, prom.CounterValue, float64(stats.OverwriteCount))
+	ch <- prom.MustNewConstMetric(c.expired, prom.CounterValue, float64(stats.ExpiredCount))
+	ch <- prom.MustNewConstMetric(c.usedBytes, prom.GaugeValue, float64(usedBytes))
+	c.latency.Collect(ch)
+}
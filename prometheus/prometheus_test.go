@@ -0,0 +1,37 @@
+package prometheus
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/coocood/freecache"
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestCollector(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	c := NewCollector("test_cache", cache)
+	registry := prom.NewPedanticRegistry()
+	registry.MustRegister(c)
+	cache.Set([]byte("key"), []byte("value"), 0)
+	cache.Get([]byte("key"))
+	cache.Get([]byte("missing"))
+	expected := `
+# HELP test_cache_entries Number of live entries.
+# TYPE test_cache_entries gauge
+test_cache_entries 1
+# HELP test_cache_hit_rate Ratio of hits to lookups.
+# TYPE test_cache_hit_rate gauge
+test_cache_hit_rate 0.5
+# HELP test_cache_hits_total Number of lookups that found the entry.
+# TYPE test_cache_hits_total counter
+test_cache_hits_total 1
+`
+	if err := testutil.GatherAndCompare(registry, strings.NewReader(expected), "test_cache_entries", "test_cache_hit_rate", "test_cache_hits_total"); err != nil {
+		t.Error(err)
+	}
+	if n := testutil.CollectAndCount(c, "test_cache_op_duration_seconds"); n != 3 {
+		t.Error("latency histograms", n)
+	}
+}
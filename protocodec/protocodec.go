@@ -0,0 +1,19 @@
+// Package protocodec encodes protobuf messages for freecache.Typed and freecache.Keyed.
+package protocodec
+
+import "google.golang.org/protobuf/proto"
+
+// Codec encodes messages of type M, a pointer to a generated message type, like Codec[*pb.User].
+// It implements freecache.Codec[M].
+type Codec[M proto.Message] struct{}
+
+func (Codec[M]) Marshal(value M) ([]byte, error) {
+	return proto.Marshal(value)
+}
+
+func (Codec[M]) Unmarshal(data []byte) (value M, err error) {
+	// the reflection of a nil message creates a new message of its type.
+	value = value.ProtoReflect().New().Interface().(M)
+	err = proto.Unmarshal(data, value)
+	return
+}
@@ -0,0 +1,94 @@
+// Package ratelimit limits the rate of events per key with counters in a freecache.Cache, which
+// are incremented atomically by Cache.Incr and expire with their window.
+//
+// A fixed window limiter counts the events of every window, so up to twice the limit may be
+// allowed around the end of a window. A sliding window limiter weights the count of the previous
+// window by the part of it that is still in the sliding window, which smooths the bursts at the
+// cost of an approximation.
+package ratelimit
+
+import (
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+var ErrInvalidWindow = errors.New("The window must be a whole number of seconds")
+
+// Limiter allows up to a limit of events per key in a window.
+type Limiter struct {
+	cache   *freecache.Cache
+	limit   int64
+	window  time.Duration
+	sliding bool
+	// Clock tells the time of the windows, nil means the system clock.
+	Clock freecache.Clock
+	// KeyPrefix is prepended to the keys of the counters.
+	KeyPrefix string
+}
+
+// NewFixedWindow returns a Limiter allowing limit events per key in every window, which must
+// be at least a second. An error is returned if window is not a whole number of seconds.
+func NewFixedWindow(cache *freecache.Cache, limit int64, window time.Duration) (*Limiter, error) {
+	return newLimiter(cache, limit, window, false)
+}
+
+// NewSlidingWindow returns a Limiter allowing limit events per key in a window sliding over the
+// fixed windows, see the package documentation.
+func NewSlidingWindow(cache *freecache.Cache, limit int64, window time.Duration) (*Limiter, error) {
+	return newLimiter(cache, limit, window, true)
+}
+
+func newLimiter(cache *freecache.Cache, limit int64, window time.Duration, sliding bool) (*Limiter, error) {
+	if window < time.Second || window%time.Second != 0 {
+		return nil, ErrInvalidWindow
+	}
+	return &Limiter{cache: cache, limit: limit, window: window, sliding: sliding, KeyPrefix: "ratelimit_"}, nil
+}
+
+// Allow reports whether an event of key is allowed, and counts it if it is.
+func (l *Limiter) Allow(key string) (bool, error) {
+	return l.AllowN(key, 1)
+}
+
+// AllowN reports whether n events of key are allowed, and counts them if they are. The events that
+// are not allowed are not counted, so they don't delay the next allowed ones.
+func (l *Limiter) AllowN(key string, n int64) (bool, error) {
+	now := time.Now()
+	if l.Clock != nil {
+		now = l.Clock.Now()
+	}
+	windowSeconds := int64(l.window / time.Second)
+	index := now.Unix() / windowSeconds
+	counter := l.counterKey(key, index)
+	// the counter of a window is kept for the next window, which reads it when sliding.
+	expireSeconds := int(windowSeconds)
+	if l.sliding {
+		expireSeconds *= 2
+	}
+	count, err := l.cache.Incr(counter, n, expireSeconds)
+	if err != nil {
+		return false, err
+	}
+	estimate := count
+	if l.sliding {
+		if value, err := l.cache.Get(l.counterKey(key, index-1)); err == nil {
+			prev, _ := strconv.ParseInt(string(value), 10, 64)
+			elapsed := now.Sub(time.Unix(index*windowSeconds, 0))
+			estimate += int64(float64(prev) * float64(l.window-elapsed) / float64(l.window))
+		}
+	}
+	if estimate <= l.limit {
+		return true, nil
+	}
+	_, err = l.cache.Incr(counter, -n, expireSeconds)
+	return false, err
+}
+
+func (l *Limiter) counterKey(key string, index int64) []byte {
+	counter := append([]byte(l.KeyPrefix), key...)
+	counter = append(counter, '/')
+	return strconv.AppendInt(counter, index, 10)
+}
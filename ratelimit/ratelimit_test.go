@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+func TestFixedWindow(t *testing.T) {
+	clock := freecache.NewFakeClock(time.Unix(1000, 0))
+	cache := freecache.NewCacheWithConfig(1024*1024, freecache.Config{Clock: clock})
+	limiter, err := NewFixedWindow(cache, 3, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter.Clock = clock
+	for i := 0; i < 5; i++ {
+		allowed, err := limiter.Allow("user")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if allowed != (i < 3) {
+			t.Error("event", i, "allowed is", allowed)
+		}
+	}
+	if allowed, _ := limiter.Allow("other"); !allowed {
+		t.Error("the keys should be limited separately")
+	}
+	clock.Advance(10 * time.Second)
+	if allowed, _ := limiter.AllowN("user", 3); !allowed {
+		t.Error("the next window should be allowed")
+	}
+	if allowed, _ := limiter.AllowN("user", 1); allowed {
+		t.Error("the window should be full")
+	}
+	if _, err = NewFixedWindow(cache, 3, 1500*time.Millisecond); err != ErrInvalidWindow {
+		t.Error("err is", err, "expected", ErrInvalidWindow)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	clock := freecache.NewFakeClock(time.Unix(1000, 0))
+	cache := freecache.NewCacheWithConfig(1024*1024, freecache.Config{Clock: clock})
+	limiter, err := NewSlidingWindow(cache, 10, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter.Clock = clock
+	if allowed, _ := limiter.AllowN("user", 10); !allowed {
+		t.Fatal("the first events should be allowed")
+	}
+	// 3 seconds into the next window, 70% of the previous count is still in the sliding window.
+	clock.Advance(13 * time.Second)
+	if allowed, _ := limiter.AllowN("user", 3); !allowed {
+		t.Error("3 events should be allowed")
+	}
+	if allowed, _ := limiter.Allow("user"); allowed {
+		t.Error("the sliding window should be full")
+	}
+	clock.Advance(5 * time.Second)
+	if allowed, _ := limiter.AllowN("user", 4); !allowed {
+		t.Error("4 events should be allowed as the window slides")
+	}
+}
@@ -0,0 +1,138 @@
+package freecache
+
+import "errors"
+
+var ErrRehashInProgress = errors.New("The hash seed is being rotated")
+
+// seedState is the hash seed of a cache. While the seed is being rotated, old is the previous
+// seed, and an entry may be at the position of either seed.
+type seedState struct {
+	cur hashSeed
+	old *hashSeed
+}
+
+// rehashEntry is an entry copied out of a segment to be moved to the position of the new seed.
+type rehashEntry struct {
+	key      []byte
+	value    []byte
+	expireAt uint32
+	flags    uint8
+	state    uint16
+}
+
+// RotateHashSeed replaces the hash seed of the cache with a new random one without emptying
+// the cache. Until a background goroutine has moved every entry to its new position, Get looks up
+// the position of the new seed first, then the position of the old one, and Set and Del remove
+// the entry at the old position. ErrRehashInProgress is returned if a rotation is not finished.
+//
+// Entries set by SetWithHash are not moved. A snapshot saved during the rotation does not contain
+// the entries not moved yet. Close ends the rotation, the entries not moved yet are not found then.
+func (cache *Cache) RotateHashSeed() error {
+	old := cache.seeds.Load()
+	if old.old != nil {
+		return ErrRehashInProgress
+	}
+	seeds := &seedState{cur: newHashSeed(), old: &old.cur}
+	if !cache.seeds.CompareAndSwap(old, seeds) {
+		return ErrRehashInProgress
+	}
+	go cache.rehash(seeds)
+	return nil
+}
+
+// Rehashing reports whether a rotation of the hash seed is in progress.
+func (cache *Cache) Rehashing() bool {
+	return cache.seeds.Load().old != nil
+}
+
+func (cache *Cache) rehash(seeds *seedState) {
+	// the rotation ends however the rehash does, so it is not in progress forever.
+	defer cache.seeds.Store(&seedState{cur: seeds.cur})
+	for i := 0; i < len(cache.segments); i++ {
+		select {
+		case <-cache.closeChan:
+			return
+		default:
+		}
+		cache.rehashSegment(i, seeds)
+	}
+}
+
+// rehashSegment moves the entries at the position of the old seed in segment segId to the position
+// of the new seed. An entry is copied first, then with both segments locked, it is set at the new
+// position unless a newer entry is already there, and deleted from the old position, unless it has
+// been deleted in the mean time.
+func (cache *Cache) rehashSegment(segId int, seeds *seedState) {
+	now := cache.now()
+	var entries []rehashEntry
+	cache.locks[segId].Lock()
+	cache.segments[segId].iterateAll(now, func(key, value []byte, hdr *entryHdr) bool {
+		if cache.atPosition(segId, hdr, seeds.old.sipHash(key)) && !cache.atPosition(segId, hdr, seeds.cur.sipHash(key)) {
+			entries = append(entries, rehashEntry{key: key, value: value, expireAt: hdr.expireAt, flags: hdr.flags, state: hdr.pad & stateMask})
+		}
+		return true
+	})
+	cache.locks[segId].Unlock()
+	for _, entry := range entries {
+		expireSeconds := 0
+		if entry.expireAt != 0 {
+			if entry.expireAt <= now {
+				continue
+			}
+			expireSeconds = int(entry.expireAt - now)
+		}
+		oldHash := seeds.old.sipHash(entry.key)
+		newHash := seeds.cur.sipHash(entry.key)
+		oldSegId, newSegId := oldHash&cache.segMask, newHash&cache.segMask
+		first, second := oldSegId, newSegId
+		if first > second {
+			first, second = second, first
+		}
+		cache.locks[first].Lock()
+		if second != first {
+			cache.locks[second].Lock()
+		}
+		if cache.segments[oldSegId].exists(entry.key, oldHash) {
+			err := cache.guarded(newSegId, func() error {
+				seg := &cache.segments[newSegId]
+				if seg.exists(entry.key, newHash) {
+					return nil
+				}
+				err := seg.set(entry.key, entry.value, newHash, expireSeconds, -1, entry.flags, entry.state)
+				if err == nil && cache.segments[oldSegId].isPinned(entry.key, oldHash) {
+					err = seg.setPinned(entry.key, newHash, true)
+				}
+				return err
+			})
+			if err == nil {
+				cache.guarded(oldSegId, func() error {
+					cache.segments[oldSegId].del(entry.key, oldHash)
+					return nil
+				})
+			}
+		}
+		if second != first {
+			cache.unlock(second)
+		}
+		cache.unlock(first)
+	}
+}
+
+// atPosition reports whether the entry of hdr in segment segId is at the position of hashVal,
+// its segment, slot and hash16.
+func (cache *Cache) atPosition(segId int, hdr *entryHdr, hashVal uint64) bool {
+	return hashVal&cache.segMask == uint64(segId) && uint8(hashVal>>8) == hdr.slotId && uint16(hashVal>>16) == hdr.hash16
+}
+
+// delOld deletes the entry at the position of the old seed, it is not recorded in the journal,
+// since replay hashes keys with the current seed.
+func (cache *Cache) delOld(key []byte, hashVal uint64) (affected bool) {
+	segId := hashVal & cache.segMask
+	cache.locks[segId].Lock()
+	cache.guarded(segId, func() error {
+		affected = cache.segments[segId].del(key, hashVal)
+		return nil
+	})
+	cache.unlock(segId)
+	return
+}
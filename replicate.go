@@ -0,0 +1,236 @@
+package freecache
+
+import (
+	"bufio"
+	"io"
+	"sync"
+	"sync/atomic"
+)
+
+// MutationOp is the operation of a replicated mutation.
+type MutationOp byte
+
+const (
+	MutationSet   MutationOp = journalSet
+	MutationDel   MutationOp = journalDel
+	MutationClear MutationOp = journalClear
+)
+
+// Mutation is a Set, Del or Clear of a cache. Key and Value are the key and value as stored, like
+// in the journal, so a peer must use the same Compressor, Encryption, Checksum and LongKeys.
+// ExpireAt is the unix time in seconds the entry expires at, zero means it never expires.
+type Mutation struct {
+	Op       MutationOp
+	Key      []byte
+	Value    []byte
+	ExpireAt uint32
+}
+
+// Peer receives the mutations replicated from a cache, in the order they are made to every key.
+// A Cache is a Peer, StreamPeer sends the mutations to another process.
+type Peer interface {
+	Replicate(mutations []Mutation) error
+}
+
+var _ Peer = (*Cache)(nil)
+
+// ReplicatorConfig configures a Replicator.
+type ReplicatorConfig struct {
+	// Peers are the caches the mutations are replicated to.
+	Peers []Peer
+	// BatchSize is the maximum number of mutations sent to a peer at once, zero means 256.
+	BatchSize int
+	// QueueSize is the maximum number of mutations waiting to be sent to a peer, zero means 65536.
+	QueueSize int
+	// Block makes a mutation of the cache wait, holding the lock of its segment, while the queue of
+	// a peer is full. Otherwise the mutation is not sent to that peer and is counted as dropped,
+	// so the peer may hold stale entries until they are set again.
+	Block bool
+}
+
+// Replicator streams the Set, Del and Clear operations of a cache to peer caches in the background,
+// so a standby process has a warm cache when it takes over. Mutations are queued and sent to every
+// peer in batches by a goroutine of its own, a slow peer doesn't hold back the others.
+// Replicated mutations are applied like journal records, they are not recorded in the journal
+// or replicated again by the peer.
+type Replicator struct {
+	replicas  []*replica
+	block     bool
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+type replica struct {
+	peer      Peer
+	batchSize int
+	queueSize int
+	mu        sync.Mutex
+	cond      sync.Cond
+	queue     []Mutation
+	closed    bool
+	sent      atomic.Int64
+	dropped   atomic.Int64
+	failed    atomic.Int64
+	errors    atomic.Int64
+}
+
+// ReplicaStat is the counters of the replication to a peer.
+type ReplicaStat struct {
+	Queued  int64 // mutations waiting to be sent.
+	Sent    int64 // mutations the peer has applied.
+	Dropped int64 // mutations not queued because the queue was full or the replicator was closed.
+	Failed  int64 // mutations lost because the peer returned an error.
+	Errors  int64 // errors returned by the peer.
+}
+
+// NewReplicator returns a Replicator sending to config.Peers, set it as Config.Replicator.
+func NewReplicator(config ReplicatorConfig) *Replicator {
+	if config.BatchSize <= 0 {
+		config.BatchSize = batchSize
+	}
+	if config.QueueSize <= 0 {
+		config.QueueSize = 65536
+	}
+	r := &Replicator{block: config.Block}
+	for _, peer := range config.Peers {
+		rep := &replica{peer: peer, batchSize: config.BatchSize, queueSize: config.QueueSize}
+		rep.cond.L = &rep.mu
+		r.replicas = append(r.replicas, rep)
+		r.wg.Add(1)
+		go r.sendLoop(rep)
+	}
+	return r
+}
+
+// log queues a mutation to every peer, key and value are copied.
+func (r *Replicator) log(op byte, key, value []byte, expireAt uint32) {
+	buf := make([]byte, 0, len(key)+len(value))
+	buf = append(append(buf, key...), value...)
+	m := Mutation{Op: MutationOp(op), Key: buf[:len(key):len(key)], ExpireAt: expireAt}
+	if op == journalSet {
+		m.Value = buf[len(key):]
+	}
+	for _, rep := range r.replicas {
+		rep.mu.Lock()
+		for r.block && !rep.closed && len(rep.queue) >= rep.queueSize {
+			rep.cond.Wait()
+		}
+		if rep.closed || len(rep.queue) >= rep.queueSize {
+			rep.dropped.Add(1)
+		} else {
+			rep.queue = append(rep.queue, m)
+			rep.cond.Broadcast()
+		}
+		rep.mu.Unlock()
+	}
+}
+
+func (r *Replicator) sendLoop(rep *replica) {
+	defer r.wg.Done()
+	for {
+		rep.mu.Lock()
+		for len(rep.queue) == 0 && !rep.closed {
+			rep.cond.Wait()
+		}
+		if len(rep.queue) == 0 {
+			rep.mu.Unlock()
+			return
+		}
+		n := min(len(rep.queue), rep.batchSize)
+		batch := rep.queue[:n:n]
+		rep.queue = rep.queue[n:]
+		if len(rep.queue) == 0 {
+			rep.queue = nil
+		}
+		rep.cond.Broadcast()
+		rep.mu.Unlock()
+		if err := rep.peer.Replicate(batch); err != nil {
+			rep.errors.Add(1)
+			rep.failed.Add(int64(n))
+		} else {
+			rep.sent.Add(int64(n))
+		}
+	}
+}
+
+// Stats returns the counters of every peer, in the order of ReplicatorConfig.Peers.
+func (r *Replicator) Stats() []ReplicaStat {
+	stats := make([]ReplicaStat, len(r.replicas))
+	for i, rep := range r.replicas {
+		rep.mu.Lock()
+		stats[i].Queued = int64(len(rep.queue))
+		rep.mu.Unlock()
+		stats[i].Sent = rep.sent.Load()
+		stats[i].Dropped = rep.dropped.Load()
+		stats[i].Failed = rep.failed.Load()
+		stats[i].Errors = rep.errors.Load()
+	}
+	return stats
+}
+
+// Close sends the queued mutations and stops the replicator, mutations made after Close are dropped.
+func (r *Replicator) Close() {
+	r.closeOnce.Do(func() {
+		for _, rep := range r.replicas {
+			rep.mu.Lock()
+			rep.closed = true
+			rep.cond.Broadcast()
+			rep.mu.Unlock()
+		}
+		r.wg.Wait()
+	})
+}
+
+// Replicate applies mutations replicated from another cache.
+func (cache *Cache) Replicate(mutations []Mutation) error {
+	var batch []batchEntry
+	for _, m := range mutations {
+		batch = cache.replay(batch, byte(m.Op), m.Key, m.Value, m.ExpireAt)
+	}
+	cache.setBatch(batch, false)
+	return nil
+}
+
+type streamPeer struct {
+	mu sync.Mutex
+	w  *bufio.Writer
+}
+
+// StreamPeer returns a Peer writing the mutations to w as journal records, which are applied
+// to a cache by ReadReplication, e.g. over a connection to a standby process.
+func StreamPeer(w io.Writer) Peer {
+	return &streamPeer{w: bufio.NewWriter(w)}
+}
+
+func (p *streamPeer) Replicate(mutations []Mutation) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var buf []byte
+	for _, m := range mutations {
+		buf = appendJournalRecord(buf[:0], byte(m.Op), m.Key, m.Value, m.ExpireAt)
+		if _, err = p.w.Write(buf); err != nil {
+			return
+		}
+	}
+	return p.w.Flush()
+}
+
+// ReadReplication applies the mutations written by a StreamPeer read from r, until r returns
+// an error or a record is invalid. It returns the number of mutations applied, and a nil error at EOF.
+func (cache *Cache) ReadReplication(r io.Reader) (n int, err error) {
+	br := bufio.NewReader(r)
+	var batch []batchEntry
+	n, _, err = readJournal(br, func(op byte, key, value []byte, expireAt uint32) {
+		batch = cache.replay(batch, op, key, value, expireAt)
+		// the pending records are applied when there are no more to read, not only when the batch is full.
+		if br.Buffered() == 0 {
+			cache.setBatch(batch, false)
+			batch = batch[:0]
+		}
+	})
+	cache.setBatch(batch, false)
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
@@ -0,0 +1,67 @@
+package freecache
+
+// SetReport tells what SetWithReport did to make room for the entry.
+type SetReport struct {
+	// Evacuated is the number of old entries moved to the head of the ring buffer, EvacuatedBytes
+	// is their length including the headers.
+	Evacuated      int
+	EvacuatedBytes int64
+	// Evicted are the keys of the live entries evicted, EvictedBytes is the length of all the
+	// evicted entries including the headers. The keys of long keys are their digests, and the parts
+	// of chunked values are counted in EvictedBytes without listing their keys.
+	Evicted      [][]byte
+	EvictedBytes int64
+	// Expired is the number of expired entries removed.
+	Expired int
+}
+
+// SetWithReport is like Set, and also reports the entries evacuated, evicted or removed to make
+// room for the entry, so applications can see the evictions their writes cause. It only sees the
+// segment of the key. Values are not chunked, a value too large for an entry is rejected with
+// ErrLargeEntry.
+func (cache *Cache) SetWithReport(key, value []byte, expireSeconds int) (report SetReport, err error) {
+	key, value = cache.encodeEntry(key, value)
+	var old []byte
+	if cache.config.ChunkLargeValues {
+		old = cache.manifest(key)
+	}
+	seeds := cache.seeds.Load()
+	check := func(seg *segment, hashVal uint64) error {
+		seg.report = &report
+		return nil
+	}
+	err = cache.setWithHash(key, value, seeds.cur.sipHash(key), expireSeconds, -1, 0, 0, check)
+	if err == nil {
+		// the chunks of an overwritten chunked value are deleted, once it is overwritten.
+		cache.dropChunks(key, old)
+		if seeds.old != nil {
+			cache.delOld(key, seeds.old.sipHash(key))
+		}
+	}
+	return
+}
+
+func (seg *segment) reportEvacuated(entryLen int64) {
+	if seg.report != nil {
+		seg.report.Evacuated++
+		seg.report.EvacuatedBytes += entryLen
+	}
+}
+
+func (seg *segment) reportEvicted(hdr *entryHdr, offset int64) {
+	if seg.report == nil {
+		return
+	}
+	seg.report.EvictedBytes += hdr.entryLen()
+	if !hdr.chunked() {
+		key := make([]byte, hdr.keyLen)
+		seg.rb.ReadAt(key, offset+ENTRY_HDR_SIZE)
+		seg.report.Evicted = append(seg.report.Evicted, key)
+	}
+}
+
+func (seg *segment) reportExpired() {
+	if seg.report != nil {
+		seg.report.Expired++
+	}
+}
@@ -20,7 +20,12 @@ type RingBuf struct {
 }
 
 func NewRingBuf(size int, begin int64) (rb RingBuf) {
-	rb.data = make([]byte, size)
+	return newRingBuf(make([]byte, size), begin)
+}
+
+// newRingBuf creates a ring buffer that uses data as its memory.
+func newRingBuf(data []byte, begin int64) (rb RingBuf) {
+	rb.data = data
 	rb.begin = begin
 	rb.end = begin
 	rb.index = 0
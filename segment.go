@@ -2,7 +2,8 @@ package freecache
 
 import (
 	"errors"
-	"time"
+	"slices"
+	"sync/atomic"
 	"unsafe"
 )
 
@@ -10,15 +11,18 @@ const HASH_ENTRY_SIZE = 16
 const ENTRY_HDR_SIZE = 24
 
 var ErrLargeKey = errors.New("The key is larger than 65535")
-var ErrLargeEntry = errors.New("The entry size is larger than 1/1024 of cache size")
+var ErrLargeEntry = errors.New("The entry size is larger than MaxEntrySize or 1/1024 of cache size")
 var ErrNotFound = errors.New("Entry not found")
+var ErrWouldBlock = errors.New("The entry can not be written without exceeding the eviction limit")
+var ErrCorrupted = errors.New("Entry is corrupted")
+var ErrNotAdmitted = errors.New("The entry is rejected by the admission filter")
 
 // entry pointer struct points to an entry in ring buffer
 type entryPtr struct {
-	offset   int64  // entry offset in ring buffer
-	hash16   uint16 // entries are ordered by hash16 in a slot.
-	keyLen   uint16 // used to compare a key
-	reserved uint32
+	offset int64  // entry offset in ring buffer
+	hash16 uint16 // entries are ordered by hash16 in a slot.
+	keyLen uint16 // used to compare a key
+	fp32   uint32 // the high 32 bits of the hash, compared before the key if wide fingerprints are enabled.
 }
 
 // entry header struct in ring buffer, followed by key and value.
@@ -29,46 +33,210 @@ type entryHdr struct {
 	hash16     uint16
 	valLen     uint32
 	valCap     uint32
-	deleted    bool
+	flags      uint8 // entryDeleted, the TTL class and the user flags in the high bits.
 	slotId     uint8
-	reserved   uint16
+	pad        uint16 // padding between the key and the value in the low bits, entryPinned, the priority and entryChunked in the high bits.
+}
+
+const (
+	entryDeleted   = 1 << 0
+	classShift     = 1
+	classMask      = 7 << classShift
+	userFlagsShift = 4
+
+	// the padding is smaller than the maximum alignment of 4096, the high bits of pad are flags.
+	padMask       = 1<<12 - 1
+	entryPinned   = 1 << 12
+	priorityShift = 13
+	priorityMask  = 3 << priorityShift
+	entryChunked  = 1 << 15
+	// stateMask is the bits of pad set by set, entryPinned is kept when the entry is overwritten.
+	stateMask = priorityMask | entryChunked
+)
+
+// maxKeyValLen returns the largest key and value length of an entry in a segment of segSize,
+// it is maxEntrySize if it is positive, limited by the segment size, or a quarter of the segment size.
+func maxKeyValLen(segSize, maxEntrySize int) int {
+	if maxEntrySize > 0 {
+		return min(maxEntrySize, segSize-ENTRY_HDR_SIZE)
+	}
+	return segSize/4 - ENTRY_HDR_SIZE
+}
+
+// valPad returns the padding between the key and the value.
+func (hdr *entryHdr) valPad() int64 {
+	return int64(hdr.pad & padMask)
+}
+
+// priority returns the eviction priority of the entry, see Priority.bits.
+func (hdr *entryHdr) priority() Priority {
+	return (Priority(hdr.pad&priorityMask>>priorityShift) + PriorityNormal) & MaxPriority
+}
+
+// chunked reports whether the entry is the manifest or a chunk of a value split by ChunkLargeValues.
+func (hdr *entryHdr) chunked() bool {
+	return hdr.pad&entryChunked != 0
+}
+
+// negative reports whether the entry is a negative entry set by SetNegative, which is an empty
+// entry marked chunked, the manifests and the chunks of chunked values are never empty.
+func (hdr *entryHdr) negative() bool {
+	return hdr.pad&entryChunked != 0 && hdr.valLen == 0
+}
+
+// pinned reports whether the entry is pinned, see Cache.Pin.
+func (hdr *entryHdr) pinned() bool {
+	return hdr.pad&entryPinned != 0
+}
+
+// deleted reports whether the entry has been deleted or replaced.
+func (hdr *entryHdr) deleted() bool {
+	return hdr.flags&entryDeleted != 0
+}
+
+// userFlags returns the flags set by SetWithFlags.
+func (hdr *entryHdr) userFlags() uint8 {
+	return hdr.flags >> userFlagsShift
+}
+
+// class returns the TTL class of the entry, zero means no class.
+func (hdr *entryHdr) class() uint8 {
+	return (hdr.flags & classMask) >> classShift
+}
+
+// valOff returns the offset of the value of the entry at off.
+func (hdr *entryHdr) valOff(off int64) int64 {
+	return off + ENTRY_HDR_SIZE + int64(hdr.keyLen) + hdr.valPad()
+}
+
+// entryLen returns the length of the entry in the ring buffer.
+func (hdr *entryHdr) entryLen() int64 {
+	return ENTRY_HDR_SIZE + int64(hdr.keyLen) + hdr.valPad() + int64(hdr.valCap)
 }
 
 // a segment contains 256 slots, a slot is an array of entry pointers ordered by hash16 value
 // the entry can be looked up by hash value of the key.
 type segment struct {
-	rb            RingBuf // ring buffer that stores data
-	segId         int
-	entryCount    int64
-	totalCount    int64      // number of entries in ring buffer, including deleted entries.
-	totalTime     int64      // used to calculate least recent used entry.
-	totalEvacuate int64      // used for debug
-	overwrites    int64      // used for debug
-	vacuumLen     int64      // up to vacuumLen, new data can be written without overwriting old data.
-	slotLens      [256]int32 // The actual length for every slot.
-	slotCap       int32      // max number of entry pointers a slot can hold.
-	slotsData     []entryPtr // shared by all 256 slots
-}
-
-func newSegment(bufSize int, segId int) (seg segment) {
-	seg.rb = NewRingBuf(bufSize, 0)
+	rb           storage // ring buffer that stores data
+	segId        int
+	entryCount   int64
+	totalCount   int64          // number of entries in ring buffer, including deleted entries.
+	totalTime    int64          // used to calculate least recent used entry.
+	totalExpired int64          // number of expired entries removed.
+	vacuumLen    int64          // up to vacuumLen, new data can be written without overwriting old data.
+	slotLens     [256]int32     // The actual length for every slot.
+	slotCap      int32          // max number of entry pointers a slot can hold.
+	slotsData    []entryPtr     // shared by all 256 slots
+	align        int64          // entries are aligned to align bytes in the ring buffer.
+	reserved     int64          // the part of the ring buffer not used because of the occupancy target.
+	occupancy    float64        // the occupancy target of the ring buffer, zero means 1.
+	counters     *segCounters   // the counters of the segment, kept when a corrupted segment is rebuilt.
+	setBytes     int64          // bytes of keys and values written by callers.
+	physBytes    int64          // bytes written to the ring buffer, including evacuation copies.
+	evacBytes    int64          // bytes copied by evacuation.
+	wideFp       bool           // compare fp32 of entry pointers in lookups.
+	expireSlot   int            // the slot the background expirer scans next.
+	expireIdx    int32          // the index in expireSlot the background expirer scans next.
+	wheel        *timerWheel    // tracks the expire time of entries, nil if the timer wheel is disabled.
+	clock        Clock          // tells the time of the entries.
+	keepExpired  bool           // keep a copy of removed expired entries for the OnExpire callback.
+	expired      []expiredEntry // removed expired entries waiting for the OnExpire callback.
+	keepEvicts   bool           // keep a copy of evicted entries for the overflow store or the events.
+	stale        uint32         // the seconds expired entries are kept for GetStale, see Config.StaleSeconds.
+	refreshAhead uint32         // the entries found this many seconds before they expire are refreshed.
+	refreshes    []expiredEntry // entries to refresh by the Loader when the segment is unlocked.
+	evicted      []expiredEntry // evicted entries waiting to be moved to the overflow store.
+	resetReason  error          // why the segment was rebuilt, waiting for the OnSegmentReset callback.
+	versions     *atomic.Uint64 // the last version of the cache, nil if it doesn't keep versions.
+	bloom        *bloomState    // the bloom filter of the keys of the cache, nil if it has none.
+	report       *SetReport     // the report of SetWithReport, nil for other operations.
+
+	// classStats is indexed by the TTL class of entries.
+	classStats [MaxTTLClasses + 1]classCounters
+	// freq is the frequency sketch of the keys, used by the admission filter and the LFU policy.
+	freq *frequencySketch
+	// admission enables the TinyLFU admission filter.
+	admission bool
+	// policy decides whether the oldest entry is evicted or evacuated.
+	policy EvictionPolicy
+	// lru orders the entries by access in the strict LRU mode, nil if it is disabled.
+	// The node of an entry is stored in its entryPtr.fp32.
+	lru *lruList
+	// maxEntries limits entryCount, zero means no limit.
+	maxEntries int64
+	// holes are deleted entries that can be overwritten by new entries, nil if ReuseFreeSpace is disabled.
+	holes []hole
+	// maxEntrySize is Config.MaxEntrySize.
+	maxEntrySize int
+	// liveBytes is the length of the entries referenced by the slots, the rest of the used part
+	// of the ring buffer is dead bytes of deleted entries.
+	liveBytes int64
+	// slackBytes is the unused value capacity of the live entries.
+	slackBytes int64
+	// detailed enables the histograms.
+	detailed bool
+	// ageHist is the histogram of the seconds since the last access of the entries found by get.
+	ageHist histogram
+	// sizeHist is the histogram of the sizes of the entries written by set, including the header.
+	sizeHist histogram
+	// the fields of adjacent segments are at least a cache line apart.
+	_ [cacheLineSize]byte
+}
+
+type expiredEntry struct {
+	key      []byte
+	value    []byte
+	expireAt uint32
+}
+
+// newSegment creates a segment that uses data as the memory of its ring buffer.
+func newSegment(data []byte, segId int) (seg segment) {
+	seg.rb = storage{RingBuf: newRingBuf(data, 0)}
 	seg.segId = segId
-	seg.vacuumLen = int64(bufSize)
+	seg.clock = systemClock{}
+	seg.counters = new(segCounters)
+	seg.vacuumLen = int64(len(data))
 	seg.slotCap = 1
 	seg.slotsData = make([]entryPtr, 256*seg.slotCap)
+	seg.align = 1
 	return
 }
 
-func (seg *segment) set(key, value []byte, hashVal uint64, expireSeconds int) (err error) {
-	if len(key) > 65535 {
+// setOccupancyTarget reserves the part of the ring buffer beyond the occupancy target.
+func (seg *segment) setOccupancyTarget(target float64) {
+	seg.occupancy = target
+	seg.reserved = 0
+	if target > 0 {
+		seg.reserved = int64(float64(seg.rb.Size()) * (1 - target))
+	}
+}
+
+func (seg *segment) alignUp(n int64) int64 {
+	return (n + seg.align - 1) &^ (seg.align - 1)
+}
+
+// maxEvictions limits the number of old entries that can be evicted or evacuated to make room
+// for the new entry, a negative value means no limit.
+// set writes the entry, flags are the flags of the entry header, entryDeleted must not be set,
+// and state is the bits of stateMask in the pad of the entry header.
+func (seg *segment) set(key, value []byte, hashVal uint64, expireSeconds int, maxEvictions int, flags uint8, state uint16) (err error) {
+	if len(key) > maxKeyLen {
 		return ErrLargeKey
 	}
-	maxKeyValLen := len(seg.rb.data)/4 - ENTRY_HDR_SIZE
+	maxKeyValLen := maxKeyValLen(len(seg.rb.data), seg.maxEntrySize)
 	if len(key)+len(value) > maxKeyValLen {
 		// Do not accept large entry.
 		return ErrLargeEntry
 	}
-	now := uint32(time.Now().Unix())
+	if seg.freq != nil {
+		seg.freq.increment(freqHash(hashVal))
+	}
+	seg.stampVersion(value)
+	if seg.bloom != nil && !(state&entryChunked != 0 && len(value) == 0) {
+		// a negative entry is not found either way.
+		seg.bloom.add(key)
+	}
+	now := seg.now()
 	expireAt := uint32(0)
 	if expireSeconds > 0 {
 		expireAt = now + uint32(expireSeconds)
@@ -76,31 +244,54 @@ func (seg *segment) set(key, value []byte, hashVal uint64, expireSeconds int) (e
 
 	slotId := uint8(hashVal >> 8)
 	hash16 := uint16(hashVal >> 16)
+	fp32 := uint32(hashVal >> 32)
 
 	var hdrBuf [ENTRY_HDR_SIZE]byte
 	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
 
 	slotOff := int32(slotId) * seg.slotCap
 	slot := seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
-	idx, match := seg.lookup(slot, hash16, key)
+	idx, match := seg.lookup(slot, hash16, fp32, key)
 	if match {
 		matchedPtr := &slot[idx]
 		seg.rb.ReadAt(hdrBuf[:], matchedPtr.offset)
+		if !seg.validHdr(hdr, matchedPtr, slotId) {
+			return ErrCorrupted
+		}
+		if seg.lru != nil {
+			seg.lru.moveToFront(int32(matchedPtr.fp32))
+		}
+		if seg.wheel != nil && expireAt != 0 && hdr.expireAt != expireAt {
+			seg.addTimer(hashVal, expireAt)
+		}
 		hdr.slotId = slotId
 		hdr.hash16 = hash16
 		hdr.keyLen = uint16(len(key))
 		hdr.accessTime = now
 		hdr.expireAt = expireAt
+		hdr.flags = flags
+		hdr.pad = hdr.pad&^stateMask | state
+		oldValLen := hdr.valLen
 		hdr.valLen = uint32(len(value))
 		if hdr.valCap >= hdr.valLen {
-			//in place overwrite
+			//in place overwrite, a smaller value leaves slack in the capacity.
+			seg.slackBytes += int64(oldValLen) - int64(hdr.valLen)
 			seg.totalTime += int64(hdr.accessTime) - int64(now)
 			seg.rb.WriteAt(hdrBuf[:], matchedPtr.offset)
-			seg.rb.WriteAt(value, matchedPtr.offset+ENTRY_HDR_SIZE+int64(hdr.keyLen))
-			seg.overwrites++
+			seg.rb.WriteAt(value, hdr.valOff(matchedPtr.offset))
+			seg.counters.overwrites.Add(1)
+			seg.setBytes += int64(len(key) + len(value))
+			seg.physBytes += ENTRY_HDR_SIZE + int64(len(value))
+			seg.classStats[hdr.class()].sets++
+			if seg.detailed {
+				seg.sizeHist.add(uint32(ENTRY_HDR_SIZE + len(key) + len(value)))
+			}
 			return
 		}
 		// increase capacity and limit entry len.
+		if hdr.valCap == 0 {
+			hdr.valCap = 1
+		}
 		for hdr.valCap < hdr.valLen {
 			hdr.valCap *= 2
 		}
@@ -108,121 +299,500 @@ func (seg *segment) set(key, value []byte, hashVal uint64, expireSeconds int) (e
 			hdr.valCap = uint32(maxKeyValLen - len(key))
 		}
 	} else {
+		if seg.wheel != nil && expireAt != 0 {
+			seg.addTimer(hashVal, expireAt)
+		}
 		hdr.slotId = slotId
 		hdr.hash16 = hash16
 		hdr.keyLen = uint16(len(key))
 		hdr.accessTime = now
 		hdr.expireAt = expireAt
+		hdr.flags = flags
+		hdr.pad = hdr.pad&^stateMask | state
 		hdr.valLen = uint32(len(value))
 		hdr.valCap = uint32(len(value))
 	}
 
-	entryLen := ENTRY_HDR_SIZE + int64(len(key)) + int64(hdr.valCap)
-	slotModified := seg.evacuate(entryLen, slotId, now)
-	if slotModified {
-		// the slot has been modified during evacuation, we need to looked up for the 'idx' again.
-		// otherwise there would be index out of bound error.
-		slot = seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
-		idx, match = seg.lookup(slot, hash16, key)
+	if seg.align > 1 {
+		// every entry starts at an aligned offset and has an aligned length,
+		// so the value stays aligned when the entry is evacuated.
+		keyEnd := ENTRY_HDR_SIZE + int64(len(key))
+		hdr.pad = hdr.pad&^padMask | uint16(seg.alignUp(keyEnd)-keyEnd)
+		valEnd := keyEnd + hdr.valPad() + int64(hdr.valCap)
+		hdr.valCap += uint32(seg.alignUp(valEnd) - valEnd)
+	} else {
+		hdr.pad &^= padMask
+	}
+	entryLen := hdr.entryLen()
+	if !match && seg.admission && seg.needRoom(entryLen, true) && !seg.admit(freqHash(hashVal), now) {
+		return ErrNotAdmitted
+	}
+	newOff, hole := seg.takeHole(entryLen, !match)
+	if newOff < 0 {
+		var slotModified bool
+		slotModified, err = seg.evacuate(entryLen, !match, slotId, now, maxEvictions)
+		if err != nil {
+			return
+		}
+		if slotModified {
+			// the slot has been modified during evacuation, we need to looked up for the 'idx' again.
+			// otherwise there would be index out of bound error.
+			slot = seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
+			idx, match = seg.lookup(slot, hash16, fp32, key)
+		}
+		newOff = seg.rb.End()
+	} else {
+		// the value capacity fills the hole, so the ring buffer can still be walked entry by entry.
+		hdr.valCap += uint32(hole.length - entryLen)
 	}
-	newOff := seg.rb.End()
 	if match {
+		// the old copy is too small for the value.
+		seg.liveBytes -= seg.markDeleted(slot[idx].offset)
 		seg.updateEntryPtr(slotId, hash16, slot[idx].offset, newOff)
 	} else {
-		seg.insertEntryPtr(slotId, hash16, newOff, idx, hdr.keyLen)
-	}
-	seg.rb.Write(hdrBuf[:])
-	seg.rb.Write(key)
-	seg.rb.Write(value)
-	seg.rb.Skip(int64(hdr.valCap - hdr.valLen))
-	seg.totalTime += int64(now)
-	seg.totalCount++
-	seg.vacuumLen -= entryLen
+		seg.insertEntryPtr(slotId, hash16, fp32, newOff, idx, hdr.keyLen)
+	}
+	seg.slackBytes += int64(hdr.valCap - hdr.valLen)
+	if hole.length > 0 {
+		seg.rb.WriteAt(hdrBuf[:], newOff)
+		seg.rb.WriteAt(key, newOff+ENTRY_HDR_SIZE)
+		seg.rb.WriteAt(value, hdr.valOff(newOff))
+		seg.totalTime += int64(now) - int64(hole.accessTime)
+		seg.liveBytes += hole.length
+	} else {
+		seg.rb.Write(hdrBuf[:])
+		seg.rb.Write(key)
+		seg.rb.Skip(hdr.valPad())
+		seg.rb.Write(value)
+		seg.rb.Skip(int64(hdr.valCap - hdr.valLen))
+		seg.totalTime += int64(now)
+		seg.totalCount++
+		seg.vacuumLen -= entryLen
+		seg.liveBytes += entryLen
+	}
+	seg.setBytes += int64(len(key) + len(value))
+	seg.physBytes += entryLen
+	seg.classStats[hdr.class()].sets++
+	if seg.detailed {
+		seg.sizeHist.add(uint32(ENTRY_HDR_SIZE + len(key) + len(value)))
+	}
 	return
 }
 
-func (seg *segment) evacuate(entryLen int64, slotId uint8, now uint32) (slotModified bool) {
+// evictOldest decides whether the oldest entry, which is not expired, is evicted or evacuated,
+// forced is true if it is evicted by the bound of consecutive evacuations without asking the policy.
+func (seg *segment) evictOldest(hdr *entryHdr, offset int64, evacuations int) (evict, forced bool) {
+	switch priority := hdr.priority(); {
+	case priority == PriorityLow:
+		return true, false
+	case priority > PriorityNormal && evacuations < maxConsecutiveEvacuations*int(priority-PriorityNormal):
+		return false, false
+	}
+	if seg.lru != nil {
+		// the strict LRU mode evacuates entries until the least recently used one is the oldest.
+		return seg.isLRU(hdr, offset), false
+	}
+	if evacuations >= maxConsecutiveEvacuations {
+		return true, true
+	}
+	return seg.policy.Evict(seg.candidate(hdr, evacuations)), false
+}
+
+// needRoom reports whether old entries must be removed to write an entry of entryLen,
+// newEntry is true if it is not an existing entry.
+func (seg *segment) needRoom(entryLen int64, newEntry bool) bool {
+	return seg.vacuumLen-seg.reserved < entryLen || newEntry && seg.maxEntries > 0 && seg.entryCount >= seg.maxEntries
+}
+
+func (seg *segment) evacuate(entryLen int64, newEntry bool, slotId uint8, now uint32, maxEvictions int) (slotModified bool, err error) {
 	var oldHdrBuf [ENTRY_HDR_SIZE]byte
 	consecutiveEvacuate := 0
-	for seg.vacuumLen < entryLen {
+	// pinnedLen is the length of the pinned entries evacuated since an entry was removed,
+	// if it exceeds the ring buffer, every entry is pinned.
+	var pinnedLen int64
+	for evictions := 0; seg.needRoom(entryLen, newEntry); evictions++ {
+		if seg.vacuumLen == seg.rb.Size() {
+			// the entry is larger than the occupancy target allows, write it anyway.
+			break
+		}
+		if maxEvictions >= 0 && evictions >= maxEvictions {
+			return slotModified, ErrWouldBlock
+		}
 		oldOff := seg.rb.End() + seg.vacuumLen - seg.rb.Size()
 		seg.rb.ReadAt(oldHdrBuf[:], oldOff)
 		oldHdr := (*entryHdr)(unsafe.Pointer(&oldHdrBuf[0]))
-		oldEntryLen := ENTRY_HDR_SIZE + int64(oldHdr.keyLen) + int64(oldHdr.valCap)
-		if oldHdr.deleted {
+		oldEntryLen := oldHdr.entryLen()
+		if oldHdr.deleted() {
 			consecutiveEvacuate = 0
+			pinnedLen = 0
 			seg.totalTime -= int64(oldHdr.accessTime)
 			seg.totalCount--
 			seg.vacuumLen += oldEntryLen
 			continue
 		}
-		expired := oldHdr.expireAt != 0 && oldHdr.expireAt < now
-		leastRecentUsed := int64(oldHdr.accessTime)*seg.totalCount <= seg.totalTime
-		if expired || leastRecentUsed || consecutiveEvacuate > 5 {
-			seg.delEntryPtr(oldHdr.slotId, oldHdr.hash16, oldOff)
+		expired := oldHdr.expireAt != 0 && oldHdr.expireAt+seg.stale <= now
+		if !expired && oldHdr.pinned() {
+			// pinned entries are always evacuated, they don't count as consecutive evacuations.
+			if pinnedLen += oldEntryLen; pinnedLen > seg.rb.Size() {
+				return slotModified, ErrPinned
+			}
+			seg.evacuateEntry(oldHdr, oldOff, oldEntryLen)
+			continue
+		}
+		evict, forced := expired, false
+		if !expired {
+			evict, forced = seg.evictOldest(oldHdr, oldOff, consecutiveEvacuate)
+		}
+		if evict {
+			if expired {
+				seg.counters.expired.Add(1)
+				seg.reportExpired()
+				seg.delExpiredEntry(oldHdr, oldOff)
+			} else {
+				seg.counters.evictions.Add(1)
+				if forced {
+					seg.counters.forced.Add(1)
+				}
+				seg.classStats[oldHdr.class()].evictions++
+				seg.reportEvicted(oldHdr, oldOff)
+				seg.keepEvicted(oldHdr, oldOff)
+				seg.delEntryPtr(oldHdr.slotId, oldHdr.hash16, oldOff)
+			}
 			if oldHdr.slotId == slotId {
 				slotModified = true
 			}
 			consecutiveEvacuate = 0
+			pinnedLen = 0
 			seg.totalTime -= int64(oldHdr.accessTime)
 			seg.totalCount--
 			seg.vacuumLen += oldEntryLen
 		} else {
 			// evacuate an old entry that has been accessed recently for better cache hit rate.
-			newOff := seg.rb.Evacuate(oldOff, int(oldEntryLen))
-			seg.updateEntryPtr(oldHdr.slotId, oldHdr.hash16, oldOff, newOff)
+			seg.evacuateEntry(oldHdr, oldOff, oldEntryLen)
 			consecutiveEvacuate++
-			seg.totalEvacuate++
 		}
 	}
 	return
 }
 
-func (seg *segment) get(key []byte, hashVal uint64) (value []byte, err error) {
+// evacuateEntry moves the oldest entry to the end of the ring buffer.
+func (seg *segment) evacuateEntry(hdr *entryHdr, offset, entryLen int64) {
+	newOff := seg.rb.Evacuate(offset, int(entryLen))
+	seg.updateEntryPtr(hdr.slotId, hdr.hash16, offset, newOff)
+	seg.counters.evacuations.Add(1)
+	seg.reportEvacuated(entryLen)
+	seg.evacBytes += entryLen
+	seg.physBytes += entryLen
+}
+
+// resize moves the entries to data, which becomes the new ring buffer, the oldest entries
+// are evicted if they don't fit.
+func (seg *segment) resize(data []byte, now uint32) {
+	newSize := int64(len(data))
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	for seg.rb.Size()-seg.vacuumLen > newSize {
+		oldOff := seg.rb.End() + seg.vacuumLen - seg.rb.Size()
+		seg.rb.ReadAt(hdrBuf[:], oldOff)
+		if !hdr.deleted() {
+			if hdr.expireAt != 0 && hdr.expireAt+seg.stale <= now {
+				seg.delExpiredEntry(hdr, oldOff)
+			} else {
+				seg.keepEvicted(hdr, oldOff)
+				seg.delEntryPtr(hdr.slotId, hdr.hash16, oldOff)
+			}
+		}
+		seg.totalTime -= int64(hdr.accessTime)
+		seg.totalCount--
+		seg.vacuumLen += hdr.entryLen()
+	}
+	used := seg.rb.Size() - seg.vacuumLen
+	end := seg.rb.End()
+	// the new ring buffer is full from the beginning, so an offset of the data stream is at
+	// the same position modulo the buffer size, entries stay aligned.
+	newRb := RingBuf{
+		begin: end - newSize,
+		end:   end,
+		data:  data,
+		index: int(end % newSize),
+	}
+	buf := make([]byte, used)
+	seg.rb.ReadAt(buf, end-used)
+	newRb.WriteAt(buf, end-used)
+	seg.rb = storage{RingBuf: newRb}
+	seg.vacuumLen = newSize - used
+	seg.setOccupancyTarget(seg.occupancy)
+}
+
+// resetStatistics zeroes the counters that are only statistics, totalTime and totalCount are kept,
+// they are used to find the least recently used entries.
+func (seg *segment) resetStatistics() {
+	seg.counters.reset()
+	seg.totalExpired = 0
+	seg.setBytes = 0
+	seg.physBytes = 0
+	seg.evacBytes = 0
+	seg.classStats = [MaxTTLClasses + 1]classCounters{}
+	seg.ageHist = histogram{}
+	seg.sizeHist = histogram{}
+}
+
+// validHdr checks the invariants between an entry pointer and the header of the entry it points to.
+func (seg *segment) validHdr(hdr *entryHdr, ptr *entryPtr, slotId uint8) bool {
+	return hdr.slotId == slotId && hdr.hash16 == ptr.hash16 && hdr.keyLen == ptr.keyLen && !hdr.deleted() &&
+		hdr.valLen <= hdr.valCap && ptr.offset >= seg.rb.Begin() && ptr.offset+hdr.entryLen() <= seg.rb.End()
+}
+
+// get returns the value of the entry of key appended to buf.
+func (seg *segment) get(key []byte, hashVal uint64, buf []byte) (value []byte, err error) {
+	return seg.getEntry(key, hashVal, buf, false)
+}
+
+// getEntry is get, an expired entry in its stale period is returned with errStale if stale is true.
+func (seg *segment) getEntry(key []byte, hashVal uint64, buf []byte, stale bool) (value []byte, err error) {
+	if seg.freq != nil {
+		seg.freq.increment(freqHash(hashVal))
+	}
 	slotId := uint8(hashVal >> 8)
 	hash16 := uint16(hashVal >> 16)
 	slotOff := int32(slotId) * seg.slotCap
 	var slot = seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
-	idx, match := seg.lookup(slot, hash16, key)
+	idx, match := seg.lookup(slot, hash16, uint32(hashVal>>32), key)
 	if !match {
 		err = ErrNotFound
 		return
 	}
 	ptr := &slot[idx]
-	now := uint32(time.Now().Unix())
+	now := seg.now()
 
 	var hdrBuf [ENTRY_HDR_SIZE]byte
 	seg.rb.ReadAt(hdrBuf[:], ptr.offset)
 	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	if !seg.validHdr(hdr, ptr, slotId) {
+		err = ErrCorrupted
+		return
+	}
 
 	if hdr.expireAt != 0 && hdr.expireAt <= now {
-		seg.delEntryPtr(slotId, hash16, ptr.offset)
+		seg.classStats[hdr.class()].misses++
+		if hdr.expireAt+seg.stale <= now {
+			seg.delExpiredEntry(hdr, ptr.offset)
+		} else if stale && !hdr.chunked() {
+			// a stale chunked value is not found, its chunks may be evicted.
+			value, _ = seg.readValue(hdr, ptr.offset, buf)
+			return value, errStale
+		}
 		err = ErrNotFound
 		return
 	}
+	seg.classStats[hdr.class()].hits++
+	if seg.lru != nil {
+		seg.lru.moveToFront(int32(ptr.fp32))
+	}
+	if seg.detailed {
+		var age uint32
+		if now > hdr.accessTime {
+			age = now - hdr.accessTime
+		}
+		seg.ageHist.add(age)
+	}
 	seg.totalTime += int64(now - hdr.accessTime)
 	hdr.accessTime = now
 	seg.rb.WriteAt(hdrBuf[:], ptr.offset)
-	value = make([]byte, hdr.valLen)
+	if seg.refreshAhead != 0 && hdr.expireAt != 0 && hdr.expireAt-now <= seg.refreshAhead && !hdr.chunked() {
+		seg.keepRefresh(hdr, ptr.offset)
+	}
+	return seg.readValue(hdr, ptr.offset, buf)
+}
 
-	seg.rb.ReadAt(value, ptr.offset+ENTRY_HDR_SIZE+int64(hdr.keyLen))
+// getShared is get for a segment that is locked for reading, the segment is not modified: the
+// access time and the statistics of the entry are not updated, and an expired entry is left for
+// eviction or the expirer.
+func (seg *segment) getShared(key []byte, hashVal uint64, buf []byte) (value []byte, err error) {
+	slotId := uint8(hashVal >> 8)
+	slotOff := int32(slotId) * seg.slotCap
+	slot := seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
+	idx, match := seg.lookup(slot, uint16(hashVal>>16), uint32(hashVal>>32), key)
+	if !match {
+		return nil, ErrNotFound
+	}
+	ptr := &slot[idx]
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	if !seg.validHdr(hdr, ptr, slotId) {
+		return nil, ErrCorrupted
+	}
+	if hdr.expireAt != 0 && hdr.expireAt <= seg.now() {
+		return nil, ErrNotFound
+	}
+	return seg.readValue(hdr, ptr.offset, buf)
+}
+
+// readValue returns the value of the entry at offset appended to buf, errChunked is returned with
+// the manifest of a chunked value, and errNegative for a negative entry.
+func (seg *segment) readValue(hdr *entryHdr, offset int64, buf []byte) (value []byte, err error) {
+	if hdr.negative() {
+		return nil, errNegative
+	}
+	if buf == nil {
+		value = make([]byte, hdr.valLen)
+	} else {
+		value = slices.Grow(buf, int(hdr.valLen))[:len(buf)+int(hdr.valLen)]
+	}
+	seg.rb.ReadAt(value[len(buf):], hdr.valOff(offset))
+	if hdr.chunked() {
+		err = errChunked
+	}
 	return
 }
 
+// exists reports whether the segment has an entry of the key, it may be expired.
+func (seg *segment) exists(key []byte, hashVal uint64) bool {
+	slotId := uint8(hashVal >> 8)
+	slotOff := int32(slotId) * seg.slotCap
+	slot := seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
+	_, match := seg.lookup(slot, uint16(hashVal>>16), uint32(hashVal>>32), key)
+	return match
+}
+
 func (seg *segment) del(key []byte, hashVal uint64) (affected bool) {
 	slotId := uint8(hashVal >> 8)
 	hash16 := uint16(hashVal >> 16)
 	slotOff := int32(slotId) * seg.slotCap
 	slot := seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
-	idx, match := seg.lookup(slot, hash16, key)
+	idx, match := seg.lookup(slot, hash16, uint32(hashVal>>32), key)
 	if !match {
 		return false
 	}
 	ptr := &slot[idx]
-	seg.delEntryPtr(slotId, hash16, ptr.offset)
+	offset := ptr.offset
+	seg.delEntryPtr(slotId, hash16, offset)
+	seg.addHole(offset)
 	return true
 }
 
+// expireScan examines up to budget entries starting from where the last scan stopped,
+// and deletes the expired ones.
+func (seg *segment) expireScan(budget int, now uint32) (expired int) {
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	if budget > int(seg.entryCount) {
+		budget = int(seg.entryCount)
+	}
+	for scanned := 0; scanned < budget; {
+		slotId := uint8(seg.expireSlot)
+		if seg.expireIdx >= seg.slotLens[slotId] {
+			seg.expireSlot = (seg.expireSlot + 1) & 255
+			seg.expireIdx = 0
+			continue
+		}
+		ptr := &seg.slotsData[int32(slotId)*seg.slotCap+seg.expireIdx]
+		seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+		scanned++
+		if hdr.expireAt != 0 && hdr.expireAt+seg.stale <= now {
+			// the following entry pointers are shifted to expireIdx.
+			seg.delExpiredEntry(hdr, ptr.offset)
+			expired++
+		} else {
+			seg.expireIdx++
+		}
+	}
+	return
+}
+
+// delExpiredEntry deletes an expired entry, a copy of it is kept for the OnExpire callback if needed.
+func (seg *segment) delExpiredEntry(hdr *entryHdr, offset int64) {
+	if seg.keepExpired {
+		var entry expiredEntry
+		entry.key = make([]byte, hdr.keyLen)
+		entry.value = make([]byte, hdr.valLen)
+		seg.rb.ReadAt(entry.key, offset+ENTRY_HDR_SIZE)
+		seg.rb.ReadAt(entry.value, hdr.valOff(offset))
+		seg.expired = append(seg.expired, entry)
+	}
+	seg.delEntryPtr(hdr.slotId, hdr.hash16, offset)
+	seg.addHole(offset)
+	seg.totalExpired++
+}
+
+// matchTimer calls fn for every entry that is recorded by rec, that is the entry has the hash value
+// and the expire time of the record.
+func (seg *segment) matchTimer(rec timerRecord, fn func(hdr *entryHdr, offset int64) (deleted bool)) {
+	slotId := uint8(rec.hashVal >> 8)
+	hash16 := uint16(rec.hashVal >> 16)
+	slotOff := int32(slotId) * seg.slotCap
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	idx := entryPtrIdx(seg.slotsData[slotOff:slotOff+seg.slotLens[slotId]], hash16)
+	for idx < int(seg.slotLens[slotId]) {
+		ptr := &seg.slotsData[slotOff+int32(idx)]
+		if ptr.hash16 != hash16 {
+			break
+		}
+		seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+		if hdr.expireAt+seg.stale != rec.expireAt || !fn(hdr, ptr.offset) {
+			idx++
+		}
+	}
+}
+
+// advanceWheel moves the timer wheel forward to now, and deletes the expired entries.
+func (seg *segment) advanceWheel(now uint32) (expired int) {
+	seg.wheel.advance(now, func(rec timerRecord) {
+		seg.matchTimer(rec, func(hdr *entryHdr, offset int64) bool {
+			seg.delExpiredEntry(hdr, offset)
+			expired++
+			return true
+		})
+	})
+	return
+}
+
+// wheelSlack is the number of records a timer wheel may hold beyond two for every entry.
+const wheelSlack = 64
+
+// addTimer records the expire time of an entry in the timer wheel. The records of overwritten,
+// deleted and evicted entries are verified and dropped when they fire, the wheel is rebuilt from
+// the entries if they pile up, so it holds at most two records for every entry.
+func (seg *segment) addTimer(hashVal uint64, expireAt uint32) {
+	if seg.wheel.count >= 2*int(seg.entryCount)+wheelSlack {
+		seg.rebuildWheel()
+	}
+	seg.wheel.add(timerRecord{hashVal: hashVal, expireAt: expireAt + seg.stale})
+}
+
+// countExpiring returns the number of entries expire after now and at or before deadline.
+func (seg *segment) countExpiring(now, deadline uint32) (count int64) {
+	seg.wheel.forEachUntil(deadline+seg.stale, func(rec timerRecord) {
+		// the records are at the end of the stale period.
+		if expireAt := rec.expireAt - seg.stale; expireAt > now && expireAt <= deadline {
+			seg.matchTimer(rec, func(hdr *entryHdr, offset int64) bool {
+				count++
+				return false
+			})
+		}
+	})
+	return
+}
+
+// rebuildWheel adds a timer record for every entry that has an expire time to a new timer wheel,
+// the records have the slot and hash16 of the hash, which are all matchTimer uses.
+func (seg *segment) rebuildWheel() {
+	seg.wheel = newTimerWheel(seg.now())
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	for slotId := 0; slotId < 256; slotId++ {
+		slotOff := int32(slotId) * seg.slotCap
+		for _, ptr := range seg.slotsData[slotOff : slotOff+seg.slotLens[slotId]] {
+			seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+			if hdr.expireAt != 0 {
+				seg.wheel.add(timerRecord{hashVal: uint64(slotId)<<8 | uint64(ptr.hash16)<<16, expireAt: hdr.expireAt + seg.stale})
+			}
+		}
+	}
+}
+
 func (seg *segment) expand() {
 	newSlotData := make([]entryPtr, seg.slotCap*2*256)
 	for i := 0; i < 256; i++ {
@@ -244,7 +814,7 @@ func (seg *segment) updateEntryPtr(slotId uint8, hash16 uint16, oldOff, newOff i
 	ptr.offset = newOff
 }
 
-func (seg *segment) insertEntryPtr(slotId uint8, hash16 uint16, offset int64, idx int, keyLen uint16) {
+func (seg *segment) insertEntryPtr(slotId uint8, hash16 uint16, fp32 uint32, offset int64, idx int, keyLen uint16) {
 	slotOff := int32(slotId) * seg.slotCap
 	if seg.slotLens[slotId] == seg.slotCap {
 		seg.expand()
@@ -256,7 +826,11 @@ func (seg *segment) insertEntryPtr(slotId uint8, hash16 uint16, offset int64, id
 	copy(slot[idx+1:], slot[idx:])
 	slot[idx].offset = offset
 	slot[idx].hash16 = hash16
+	slot[idx].fp32 = fp32
 	slot[idx].keyLen = keyLen
+	if seg.lru != nil {
+		slot[idx].fp32 = uint32(seg.lru.pushFront())
+	}
 }
 
 func (seg *segment) delEntryPtr(slotId uint8, hash16 uint16, offset int64) {
@@ -266,14 +840,24 @@ func (seg *segment) delEntryPtr(slotId uint8, hash16 uint16, offset int64) {
 	if !match {
 		return
 	}
+	seg.liveBytes -= seg.markDeleted(offset)
+	if seg.lru != nil {
+		seg.lru.remove(int32(slot[idx].fp32))
+	}
+	copy(slot[idx:], slot[idx+1:])
+	seg.slotLens[slotId]--
+	seg.entryCount--
+}
+
+// markDeleted sets entryDeleted in the header of the live entry at offset and returns its length.
+func (seg *segment) markDeleted(offset int64) int64 {
 	var entryHdrBuf [ENTRY_HDR_SIZE]byte
 	seg.rb.ReadAt(entryHdrBuf[:], offset)
 	entryHdr := (*entryHdr)(unsafe.Pointer(&entryHdrBuf[0]))
-	entryHdr.deleted = true
+	entryHdr.flags |= entryDeleted
 	seg.rb.WriteAt(entryHdrBuf[:], offset)
-	copy(slot[idx:], slot[idx+1:])
-	seg.slotLens[slotId]--
-	seg.entryCount--
+	seg.slackBytes -= int64(entryHdr.valCap - entryHdr.valLen)
+	return entryHdr.entryLen()
 }
 
 func entryPtrIdx(slot []entryPtr, hash16 uint16) (idx int) {
@@ -290,17 +874,22 @@ func entryPtrIdx(slot []entryPtr, hash16 uint16) (idx int) {
 	return
 }
 
-func (seg *segment) lookup(slot []entryPtr, hash16 uint16, key []byte) (idx int, match bool) {
+func (seg *segment) lookup(slot []entryPtr, hash16 uint16, fp32 uint32, key []byte) (idx int, match bool) {
 	idx = entryPtrIdx(slot, hash16)
 	for idx < len(slot) {
 		ptr := &slot[idx]
 		if ptr.hash16 != hash16 {
 			break
 		}
+		if seg.wideFp && ptr.fp32 != fp32 {
+			idx++
+			continue
+		}
 		match = int(ptr.keyLen) == len(key) && seg.rb.EqualAt(key, ptr.offset+ENTRY_HDR_SIZE)
 		if match {
 			return
 		}
+		seg.counters.collisions.Add(1)
 		idx++
 	}
 	return
@@ -0,0 +1,96 @@
+// Package grpcserver serves a freecache.Cache over gRPC, with the Cache service of cachepb/cache.proto,
+// so the microservices of a host can share one large cache over localhost:
+//
+//	server := grpc.NewServer()
+//	cachepb.RegisterCacheServer(server, grpcserver.NewServer(cache))
+//	server.Serve(listener)
+//
+// Clients use cachepb.NewCacheClient, or NewNode to add the cache to a freecache.Cluster.
+package grpcserver
+
+//go:generate buf generate
+
+import (
+	"context"
+	"time"
+
+	"github.com/coocood/freecache"
+	"github.com/coocood/freecache/server/grpcserver/cachepb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// minStatsInterval bounds the rate of the Stats stream.
+const minStatsInterval = 100 * time.Millisecond
+
+// Server implements cachepb.CacheServer over a cache.
+type Server struct {
+	cachepb.UnimplementedCacheServer
+	cache *freecache.Cache
+}
+
+func NewServer(cache *freecache.Cache) *Server {
+	return &Server{cache: cache}
+}
+
+func (s *Server) Get(ctx context.Context, req *cachepb.GetRequest) (*cachepb.GetResponse, error) {
+	value, err := s.cache.Get(req.Key)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &cachepb.GetResponse{Value: value}, nil
+}
+
+func (s *Server) Set(ctx context.Context, req *cachepb.SetRequest) (*cachepb.SetResponse, error) {
+	if req.ExpireSeconds < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative expire seconds")
+	}
+	if err := s.cache.Set(req.Key, req.Value, int(req.ExpireSeconds)); err != nil {
+		return nil, statusError(err)
+	}
+	return &cachepb.SetResponse{}, nil
+}
+
+func (s *Server) Del(ctx context.Context, req *cachepb.DelRequest) (*cachepb.DelResponse, error) {
+	return &cachepb.DelResponse{Deleted: s.cache.Del(req.Key)}, nil
+}
+
+func (s *Server) Stats(req *cachepb.StatsRequest, stream cachepb.Cache_StatsServer) error {
+	interval := max(time.Duration(req.IntervalMs)*time.Millisecond, minStatsInterval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		stats := s.cache.Stats()
+		if err := stream.Send(&cachepb.StatsResponse{
+			HitCount:       stats.HitCount,
+			MissCount:      stats.MissCount,
+			EntryCount:     stats.EntryCount,
+			EvacuateCount:  stats.EvacuateCount,
+			OverwriteCount: stats.OverwriteCount,
+			ExpiredCount:   stats.ExpiredCount,
+			EvictCount:     stats.EvictCount,
+			Size:           int64(s.cache.Size()),
+		}); err != nil || req.IntervalMs == 0 {
+			return err
+		}
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// statusError converts an error of the cache to a gRPC status.
+func statusError(err error) error {
+	code := codes.Internal
+	switch err {
+	case freecache.ErrNotFound:
+		code = codes.NotFound
+	case freecache.ErrLargeKey, freecache.ErrLargeEntry, freecache.ErrShortTTL:
+		code = codes.InvalidArgument
+	case freecache.ErrNotAdmitted, freecache.ErrPinned, freecache.ErrWouldBlock:
+		code = codes.ResourceExhausted
+	}
+	return status.Error(code, err.Error())
+}
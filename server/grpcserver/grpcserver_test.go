@@ -0,0 +1,85 @@
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/coocood/freecache"
+	"github.com/coocood/freecache/server/grpcserver/cachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+func TestServer(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	listener := bufconn.Listen(1024 * 1024)
+	server := grpc.NewServer()
+	cachepb.RegisterCacheServer(server, NewServer(cache))
+	go server.Serve(listener)
+	defer server.Stop()
+	conn, err := grpc.NewClient("passthrough:///bufconn", grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+		return listener.DialContext(ctx)
+	}), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := cachepb.NewCacheClient(conn)
+	ctx := context.Background()
+	if _, err := client.Get(ctx, &cachepb.GetRequest{Key: []byte("key")}); status.Code(err) != codes.NotFound {
+		t.Error("a missing key should not be found", err)
+	}
+	if _, err := client.Set(ctx, &cachepb.SetRequest{Key: []byte("key"), Value: []byte("value"), ExpireSeconds: 60}); err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Get(ctx, &cachepb.GetRequest{Key: []byte("key")}); err != nil || string(resp.Value) != "value" {
+		t.Error("the value should be returned", resp, err)
+	}
+	if _, err := client.Set(ctx, &cachepb.SetRequest{Key: []byte("large"), Value: make([]byte, 100*1024)}); status.Code(err) != codes.InvalidArgument {
+		t.Error("a large entry should be rejected", err)
+	}
+	stream, err := client.Stats(ctx, &cachepb.StatsRequest{IntervalMs: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		stats, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.EntryCount != 1 || stats.HitCount != 1 || stats.Size != int64(cache.Size()) {
+			t.Error("unexpected stats", stats)
+		}
+	}
+	if resp, err := client.Del(ctx, &cachepb.DelRequest{Key: []byte("key")}); err != nil || !resp.Deleted {
+		t.Error("the entry should be deleted", resp, err)
+	}
+	if resp, _ := client.Del(ctx, &cachepb.DelRequest{Key: []byte("key")}); resp.Deleted {
+		t.Error("a missing entry should not be deleted")
+	}
+
+	cluster := freecache.NewCluster(0)
+	local := freecache.NewCache(1024 * 1024)
+	cluster.Add("local", local)
+	cluster.Add("remote", NewNode(conn, 0))
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%d", i))
+		if err := cluster.Set(key, key, 0); err != nil {
+			t.Fatal(err)
+		}
+		if value, err := cluster.Get(key); err != nil || string(value) != string(key) {
+			t.Fatal("the entry should be found in its node", string(key), err)
+		}
+	}
+	if local.EntryCount() == 0 || cache.EntryCount() == 0 || local.EntryCount()+cache.EntryCount() != 100 {
+		t.Error("the keys should be spread over the local and the remote node", local.EntryCount(), cache.EntryCount())
+	}
+	if _, err := NewNode(conn, 0).Get([]byte("missing")); err != freecache.ErrNotFound {
+		t.Error("a missing key should not be found", err)
+	}
+}
@@ -0,0 +1,58 @@
+package grpcserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/coocood/freecache"
+	"github.com/coocood/freecache/server/grpcserver/cachepb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Node is a freecache.Node of a remote cache served by a Server, so a freecache.Cluster can shard
+// keys over caches in other processes. Every call has the timeout of the node.
+type Node struct {
+	client  cachepb.CacheClient
+	timeout time.Duration
+}
+
+var _ freecache.Node = (*Node)(nil)
+
+// NewNode creates a node over a connection to a Server, zero timeout means one second.
+func NewNode(conn grpc.ClientConnInterface, timeout time.Duration) *Node {
+	if timeout <= 0 {
+		timeout = time.Second
+	}
+	return &Node{client: cachepb.NewCacheClient(conn), timeout: timeout}
+}
+
+// Get returns freecache.ErrNotFound if the key is not in the remote cache.
+func (n *Node) Get(key []byte) (value []byte, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	resp, err := n.client.Get(ctx, &cachepb.GetRequest{Key: key})
+	if status.Code(err) == codes.NotFound {
+		return nil, freecache.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return resp.Value, nil
+}
+
+func (n *Node) Set(key, value []byte, expireSeconds int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	_, err := n.client.Set(ctx, &cachepb.SetRequest{Key: key, Value: value, ExpireSeconds: int32(expireSeconds)})
+	return err
+}
+
+// Del returns false if the key is not in the remote cache or the call fails.
+func (n *Node) Del(key []byte) (affected bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), n.timeout)
+	defer cancel()
+	resp, err := n.client.Del(ctx, &cachepb.DelRequest{Key: key})
+	return err == nil && resp.Deleted
+}
@@ -0,0 +1,304 @@
+// Package memcache serves a freecache.Cache over the memcached text protocol, so existing memcached
+// clients in any language can use a process that embeds the cache. The storage commands set, add and
+// replace, the retrieval commands get and gets, and delete, incr, decr, touch, version and quit are
+// supported. The flags of an entry are stored in the first 4 bytes of its value, gets returns no CAS
+// unique, and cas is not supported.
+package memcache
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/maphash"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+const (
+	// maxKeyLen is the longest key of the memcached protocol.
+	maxKeyLen = 250
+	// maxExptime is the longest relative exptime, larger ones are unix times.
+	maxExptime = 30 * 24 * 3600
+	flagsLen   = 4
+)
+
+var errExpired = errors.New("expired")
+
+// Server serves a cache to memcached clients.
+type Server struct {
+	cache *freecache.Cache
+	seed  maphash.Seed
+	// locks serialize the commands that read and write an entry, like incr, by the hash of the key.
+	locks [256]sync.Mutex
+}
+
+func NewServer(cache *freecache.Cache) *Server {
+	return &Server{cache: cache, seed: maphash.MakeSeed()}
+}
+
+// Serve accepts connections on l and serves each in its own goroutine, until l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			s.ServeConn(conn)
+			conn.Close()
+		}()
+	}
+}
+
+// ServeConn serves the commands read from conn until it is closed, a quit command or a
+// protocol error.
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			if err == bufio.ErrBufferFull {
+				w.WriteString("CLIENT_ERROR line too long\r\n")
+				w.Flush()
+			}
+			return err
+		}
+		args := bytes.Fields(line)
+		if len(args) == 0 {
+			w.WriteString("ERROR\r\n")
+		} else if quit, err := s.execute(r, w, string(args[0]), args[1:]); quit || err != nil {
+			w.Flush()
+			return err
+		}
+		// the replies of pipelined commands are written together.
+		if r.Buffered() == 0 {
+			if err = w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// execute executes the command, it returns an error if the connection must be closed.
+func (s *Server) execute(r *bufio.Reader, w *bufio.Writer, cmd string, args [][]byte) (quit bool, err error) {
+	switch cmd {
+	case "get", "gets":
+		if len(args) == 0 {
+			w.WriteString("ERROR\r\n")
+			return
+		}
+		for _, key := range args {
+			s.get(w, key)
+		}
+		w.WriteString("END\r\n")
+	case "set", "add", "replace":
+		return false, s.store(r, w, cmd, args)
+	case "delete":
+		if len(args) < 1 || !validKey(args[0]) {
+			w.WriteString("CLIENT_ERROR bad command line format\r\n")
+			return
+		}
+		s.lock(args[0])
+		deleted := s.cache.Del(args[0])
+		s.unlock(args[0])
+		reply(w, args[1:], deleted, "DELETED\r\n", "NOT_FOUND\r\n")
+	case "incr", "decr":
+		s.incr(w, cmd == "decr", args)
+	case "touch":
+		s.touch(w, args)
+	case "version":
+		w.WriteString("VERSION freecache\r\n")
+	case "quit":
+		return true, nil
+	default:
+		w.WriteString("ERROR\r\n")
+	}
+	return
+}
+
+func (s *Server) get(w *bufio.Writer, key []byte) {
+	value, err := s.cache.Get(key)
+	if err != nil || len(value) < flagsLen {
+		return
+	}
+	w.WriteString("VALUE ")
+	w.Write(key)
+	w.WriteByte(' ')
+	w.WriteString(strconv.FormatUint(uint64(binary.BigEndian.Uint32(value)), 10))
+	w.WriteByte(' ')
+	w.WriteString(strconv.Itoa(len(value) - flagsLen))
+	w.WriteString("\r\n")
+	w.Write(value[flagsLen:])
+	w.WriteString("\r\n")
+}
+
+// store executes set, add and replace: <cmd> <key> <flags> <exptime> <bytes> [noreply].
+func (s *Server) store(r *bufio.Reader, w *bufio.Writer, cmd string, args [][]byte) error {
+	if len(args) < 4 || !validKey(args[0]) {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return nil
+	}
+	key := args[0]
+	flags, err1 := strconv.ParseUint(string(args[1]), 10, 32)
+	exptime, err2 := strconv.ParseInt(string(args[2]), 10, 64)
+	length, err3 := strconv.Atoi(string(args[3]))
+	if err1 != nil || err2 != nil || err3 != nil || length < 0 {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return nil
+	}
+	value := make([]byte, flagsLen+length+2)
+	binary.BigEndian.PutUint32(value, uint32(flags))
+	if _, err := io.ReadFull(r, value[flagsLen:]); err != nil {
+		return err
+	}
+	if !bytes.HasSuffix(value, []byte("\r\n")) {
+		// the rest of the data is skipped.
+		if value[len(value)-1] != '\n' {
+			if _, err := r.ReadSlice('\n'); err != nil {
+				return err
+			}
+		}
+		w.WriteString("CLIENT_ERROR bad data chunk\r\n")
+		return nil
+	}
+	value = value[:flagsLen+length]
+	s.lock(key)
+	defer s.unlock(key)
+	if cmd != "set" {
+		_, err := s.cache.Get(key)
+		if exists := err == nil; exists != (cmd == "replace") {
+			reply(w, args[4:], false, "", "NOT_STORED\r\n")
+			return nil
+		}
+	}
+	expireSeconds, err := expireSeconds(exptime)
+	if err == errExpired {
+		// an entry that expires immediately is stored and removed.
+		s.cache.Del(key)
+	} else {
+		err = s.cache.Set(key, value, expireSeconds)
+	}
+	switch err {
+	case nil, errExpired:
+		reply(w, args[4:], true, "STORED\r\n", "")
+	case freecache.ErrLargeEntry:
+		reply(w, args[4:], false, "", "SERVER_ERROR object too large for cache\r\n")
+	default:
+		reply(w, args[4:], false, "", "SERVER_ERROR "+err.Error()+"\r\n")
+	}
+	return nil
+}
+
+// incr executes incr and decr: <cmd> <key> <value> [noreply].
+func (s *Server) incr(w *bufio.Writer, decr bool, args [][]byte) {
+	if len(args) < 2 || !validKey(args[0]) {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	key := args[0]
+	delta, err := strconv.ParseUint(string(args[1]), 10, 64)
+	if err != nil {
+		w.WriteString("CLIENT_ERROR invalid numeric delta argument\r\n")
+		return
+	}
+	s.lock(key)
+	defer s.unlock(key)
+	value, err := s.cache.Get(key)
+	if err != nil || len(value) < flagsLen {
+		reply(w, args[2:], false, "", "NOT_FOUND\r\n")
+		return
+	}
+	n, err := strconv.ParseUint(string(value[flagsLen:]), 10, 64)
+	if err != nil {
+		reply(w, args[2:], false, "", "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n")
+		return
+	}
+	switch {
+	case !decr:
+		n += delta
+	case delta > n:
+		n = 0
+	default:
+		n -= delta
+	}
+	timeLeft, err := s.cache.TTL(key)
+	if err == nil {
+		err = s.cache.Set(key, strconv.AppendUint(value[:flagsLen], n, 10), int(timeLeft))
+	}
+	if err != nil {
+		reply(w, args[2:], false, "", "NOT_FOUND\r\n")
+		return
+	}
+	reply(w, args[2:], true, strconv.FormatUint(n, 10)+"\r\n", "")
+}
+
+// touch executes touch <key> <exptime> [noreply].
+func (s *Server) touch(w *bufio.Writer, args [][]byte) {
+	if len(args) < 2 || !validKey(args[0]) {
+		w.WriteString("CLIENT_ERROR bad command line format\r\n")
+		return
+	}
+	exptime, err := strconv.ParseInt(string(args[1]), 10, 64)
+	if err != nil {
+		w.WriteString("CLIENT_ERROR invalid exptime argument\r\n")
+		return
+	}
+	key := args[0]
+	s.lock(key)
+	defer s.unlock(key)
+	expireSeconds, err := expireSeconds(exptime)
+	if err == errExpired {
+		if s.cache.Del(key) {
+			err = nil
+		}
+	} else {
+		err = s.cache.Touch(key, expireSeconds)
+	}
+	reply(w, args[2:], err == nil, "TOUCHED\r\n", "NOT_FOUND\r\n")
+}
+
+// reply writes the reply of a command with the optional noreply argument.
+func reply(w *bufio.Writer, args [][]byte, ok bool, okReply, failReply string) {
+	if len(args) > 0 && string(args[0]) == "noreply" {
+		return
+	}
+	if ok {
+		w.WriteString(okReply)
+	} else {
+		w.WriteString(failReply)
+	}
+}
+
+// expireSeconds converts a memcached exptime, which is a unix time if it is longer than 30 days,
+// to the expire seconds of the cache. errExpired is returned if the entry expires immediately.
+func expireSeconds(exptime int64) (int, error) {
+	if exptime > maxExptime {
+		exptime -= time.Now().Unix()
+		if exptime <= 0 {
+			return 0, errExpired
+		}
+	}
+	if exptime < 0 {
+		return 0, errExpired
+	}
+	return int(exptime), nil
+}
+
+func validKey(key []byte) bool {
+	return len(key) <= maxKeyLen
+}
+
+func (s *Server) lock(key []byte) {
+	s.locks[maphash.Bytes(s.seed, key)&255].Lock()
+}
+
+func (s *Server) unlock(key []byte) {
+	s.locks[maphash.Bytes(s.seed, key)&255].Unlock()
+}
@@ -0,0 +1,75 @@
+package memcache
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+func TestServer(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	client, conn := net.Pipe()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	done := make(chan error)
+	go func() {
+		done <- NewServer(cache).ServeConn(conn)
+	}()
+	r := bufio.NewReader(client)
+	do := func(req string, lines int) string {
+		t.Helper()
+		if _, err := client.Write([]byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		var resp strings.Builder
+		for i := 0; i < lines; i++ {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.WriteString(line)
+		}
+		return resp.String()
+	}
+	for _, c := range []struct {
+		req   string
+		lines int
+		want  string
+	}{
+		{"get key\r\n", 1, "END\r\n"},
+		{"set key 42 0 5\r\nvalue\r\n", 1, "STORED\r\n"},
+		{"get key missing\r\n", 3, "VALUE key 42 5\r\nvalue\r\nEND\r\n"},
+		{"add key 0 0 1\r\nx\r\n", 1, "NOT_STORED\r\n"},
+		{"replace missing 0 0 1\r\nx\r\n", 1, "NOT_STORED\r\n"},
+		{"set key 0 0 5\r\nvalue!\r\n", 1, "CLIENT_ERROR bad data chunk\r\n"},
+		{"set n 0 0 2 noreply\r\n10\r\nincr n 5\r\n", 1, "15\r\n"},
+		{"decr n 20\r\n", 1, "0\r\n"},
+		{"incr key 1\r\n", 1, "CLIENT_ERROR cannot increment or decrement non-numeric value\r\n"},
+		{"incr missing 1\r\n", 1, "NOT_FOUND\r\n"},
+		{"touch key 100\r\n", 1, "TOUCHED\r\n"},
+		{"touch missing 100\r\n", 1, "NOT_FOUND\r\n"},
+		{"delete key\r\n", 1, "DELETED\r\n"},
+		{"delete key\r\n", 1, "NOT_FOUND\r\n"},
+		{"set key 0 -1 1\r\nx\r\nget key\r\n", 2, "STORED\r\nEND\r\n"},
+		{"flush_all\r\n", 1, "ERROR\r\n"},
+		{"version\r\n", 1, "VERSION freecache\r\n"},
+	} {
+		if resp := do(c.req, c.lines); resp != c.want {
+			t.Errorf("%q: got %q, want %q", c.req, resp, c.want)
+		}
+	}
+	if timeLeft, err := cache.TTL([]byte("n")); err != nil || timeLeft != 0 {
+		t.Error("incr should keep the TTL", timeLeft, err)
+	}
+	do("touch n 100\r\nincr n 1\r\n", 2)
+	if timeLeft, err := cache.TTL([]byte("n")); err != nil || timeLeft < 99 {
+		t.Error("incr should keep the TTL", timeLeft, err)
+	}
+	client.Write([]byte("quit\r\n"))
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
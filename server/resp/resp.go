@@ -0,0 +1,292 @@
+// Package resp serves a freecache.Cache over a subset of the Redis protocol, RESP, so redis-cli and
+// Redis client libraries can be used for debugging and lightweight deployments. The commands GET,
+// SET with the EX, PX, NX and XX options, DEL, EXPIRE, TTL, INCR, PING and QUIT are supported, both
+// as arrays of bulk strings and as inline commands.
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"hash/maphash"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/coocood/freecache"
+)
+
+// maxBulkLen is the longest argument of a command.
+const maxBulkLen = 512 * 1024 * 1024
+
+var errProtocol = errors.New("Protocol error")
+
+// Server serves a cache to Redis clients.
+type Server struct {
+	cache *freecache.Cache
+	seed  maphash.Seed
+	// locks serialize the commands that read and write an entry, like INCR, by the hash of the key.
+	locks [256]sync.Mutex
+}
+
+func NewServer(cache *freecache.Cache) *Server {
+	return &Server{cache: cache, seed: maphash.MakeSeed()}
+}
+
+// Serve accepts connections on l and serves each in its own goroutine, until l is closed.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go func() {
+			s.ServeConn(conn)
+			conn.Close()
+		}()
+	}
+}
+
+// ServeConn serves the commands read from conn until it is closed, a QUIT command or a
+// protocol error.
+func (s *Server) ServeConn(conn io.ReadWriter) error {
+	r := bufio.NewReader(conn)
+	w := bufio.NewWriter(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			if err == errProtocol {
+				w.WriteString("-ERR Protocol error\r\n")
+				w.Flush()
+			}
+			return err
+		}
+		if len(args) > 0 && s.execute(w, strings.ToUpper(string(args[0])), args[1:]) {
+			return w.Flush()
+		}
+		// the replies of pipelined commands are written together.
+		if r.Buffered() == 0 {
+			if err = w.Flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// readCommand reads an array of bulk strings, or an inline command separated by spaces.
+func readCommand(r *bufio.Reader) (args [][]byte, err error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		return bytes.Fields(line), nil
+	}
+	n, err := strconv.Atoi(string(line[1:]))
+	if err != nil || n > 1024*1024 {
+		return nil, errProtocol
+	}
+	args = make([][]byte, 0, max(n, 0))
+	for i := 0; i < n; i++ {
+		if line, err = readLine(r); err != nil {
+			return nil, err
+		}
+		if len(line) == 0 || line[0] != '$' {
+			return nil, errProtocol
+		}
+		length, err := strconv.Atoi(string(line[1:]))
+		if err != nil || length < 0 || length > maxBulkLen {
+			return nil, errProtocol
+		}
+		arg := make([]byte, length+2)
+		if _, err = io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		if !bytes.HasSuffix(arg, []byte("\r\n")) {
+			return nil, errProtocol
+		}
+		args = append(args, arg[:length])
+	}
+	return
+}
+
+// readLine reads a line without the trailing CRLF.
+func readLine(r *bufio.Reader) ([]byte, error) {
+	line, err := r.ReadSlice('\n')
+	if err == bufio.ErrBufferFull {
+		return nil, errProtocol
+	}
+	if err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(line[:len(line)-1], []byte("\r")), nil
+}
+
+// execute executes the command, it returns true if the connection must be closed.
+func (s *Server) execute(w *bufio.Writer, cmd string, args [][]byte) (quit bool) {
+	switch {
+	case cmd == "PING" && len(args) == 0:
+		w.WriteString("+PONG\r\n")
+	case cmd == "PING" && len(args) == 1:
+		writeBulk(w, args[0])
+	case cmd == "QUIT":
+		w.WriteString("+OK\r\n")
+		return true
+	case cmd == "GET" && len(args) == 1:
+		value, err := s.cache.Get(args[0])
+		if err != nil {
+			w.WriteString("$-1\r\n")
+		} else {
+			writeBulk(w, value)
+		}
+	case cmd == "SET" && len(args) >= 2:
+		s.set(w, args)
+	case cmd == "DEL" && len(args) >= 1:
+		var n int64
+		for _, key := range args {
+			s.lock(key)
+			if s.cache.Del(key) {
+				n++
+			}
+			s.unlock(key)
+		}
+		writeInt(w, n)
+	case cmd == "EXPIRE" && len(args) == 2:
+		s.expire(w, args[0], args[1])
+	case cmd == "TTL" && len(args) == 1:
+		timeLeft, err := s.cache.TTL(args[0])
+		switch {
+		case err != nil:
+			writeInt(w, -2)
+		case timeLeft == 0:
+			writeInt(w, -1)
+		default:
+			writeInt(w, int64(timeLeft))
+		}
+	case cmd == "INCR" && len(args) == 1:
+		s.incr(w, args[0])
+	case cmd == "GET" || cmd == "SET" || cmd == "DEL" || cmd == "EXPIRE" || cmd == "TTL" || cmd == "INCR" || cmd == "PING":
+		w.WriteString("-ERR wrong number of arguments for '" + strings.ToLower(cmd) + "' command\r\n")
+	default:
+		w.WriteString("-ERR unknown command '" + strings.ToLower(cmd) + "'\r\n")
+	}
+	return false
+}
+
+// set executes SET key value [EX seconds | PX milliseconds] [NX | XX].
+func (s *Server) set(w *bufio.Writer, args [][]byte) {
+	key, value := args[0], args[1]
+	expireSeconds := 0
+	var nx, xx bool
+	for i := 2; i < len(args); i++ {
+		switch opt := strings.ToUpper(string(args[i])); {
+		case (opt == "EX" || opt == "PX") && i+1 < len(args):
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil || n <= 0 {
+				w.WriteString("-ERR invalid expire time in 'set' command\r\n")
+				return
+			}
+			if opt == "PX" {
+				// the cache expires entries in seconds.
+				n = (n + 999) / 1000
+			}
+			expireSeconds = int(n)
+			i++
+		case opt == "NX":
+			nx = true
+		case opt == "XX":
+			xx = true
+		default:
+			w.WriteString("-ERR syntax error\r\n")
+			return
+		}
+	}
+	if nx && xx {
+		w.WriteString("-ERR syntax error\r\n")
+		return
+	}
+	s.lock(key)
+	defer s.unlock(key)
+	if nx || xx {
+		_, err := s.cache.TTL(key)
+		if exists := err == nil; exists != xx {
+			w.WriteString("$-1\r\n")
+			return
+		}
+	}
+	if err := s.cache.Set(key, value, expireSeconds); err != nil {
+		w.WriteString("-ERR " + err.Error() + "\r\n")
+		return
+	}
+	w.WriteString("+OK\r\n")
+}
+
+// expire executes EXPIRE key seconds, a key expired by a zero or negative TTL is deleted.
+func (s *Server) expire(w *bufio.Writer, key, seconds []byte) {
+	n, err := strconv.ParseInt(string(seconds), 10, 64)
+	if err != nil {
+		w.WriteString("-ERR value is not an integer or out of range\r\n")
+		return
+	}
+	s.lock(key)
+	defer s.unlock(key)
+	if n <= 0 {
+		err = freecache.ErrNotFound
+		if s.cache.Del(key) {
+			err = nil
+		}
+	} else {
+		err = s.cache.Touch(key, int(n))
+	}
+	if err != nil {
+		writeInt(w, 0)
+	} else {
+		writeInt(w, 1)
+	}
+}
+
+// incr executes INCR key, a missing key is set to 1, the TTL of an existing key is kept.
+func (s *Server) incr(w *bufio.Writer, key []byte) {
+	s.lock(key)
+	defer s.unlock(key)
+	var n int64
+	var timeLeft uint32
+	value, err := s.cache.Get(key)
+	if err == nil {
+		if n, err = strconv.ParseInt(string(value), 10, 64); err != nil || n == 1<<63-1 {
+			w.WriteString("-ERR value is not an integer or out of range\r\n")
+			return
+		}
+		timeLeft, _ = s.cache.TTL(key)
+	}
+	n++
+	if err = s.cache.Set(key, strconv.AppendInt(nil, n, 10), int(timeLeft)); err != nil {
+		w.WriteString("-ERR " + err.Error() + "\r\n")
+		return
+	}
+	writeInt(w, n)
+}
+
+func writeBulk(w *bufio.Writer, value []byte) {
+	w.WriteByte('$')
+	w.WriteString(strconv.Itoa(len(value)))
+	w.WriteString("\r\n")
+	w.Write(value)
+	w.WriteString("\r\n")
+}
+
+func writeInt(w *bufio.Writer, n int64) {
+	w.WriteByte(':')
+	w.WriteString(strconv.FormatInt(n, 10))
+	w.WriteString("\r\n")
+}
+
+func (s *Server) lock(key []byte) {
+	s.locks[maphash.Bytes(s.seed, key)&255].Lock()
+}
+
+func (s *Server) unlock(key []byte) {
+	s.locks[maphash.Bytes(s.seed, key)&255].Unlock()
+}
@@ -0,0 +1,75 @@
+package resp
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coocood/freecache"
+)
+
+func TestServer(t *testing.T) {
+	clock := freecache.NewFakeClock(time.Now())
+	cache := freecache.NewCacheWithConfig(1024*1024, freecache.Config{Clock: clock})
+	client, conn := net.Pipe()
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	done := make(chan error)
+	go func() {
+		done <- NewServer(cache).ServeConn(conn)
+	}()
+	r := bufio.NewReader(client)
+	do := func(req string, lines int) string {
+		t.Helper()
+		if _, err := client.Write([]byte(req)); err != nil {
+			t.Fatal(err)
+		}
+		var resp strings.Builder
+		for i := 0; i < lines; i++ {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.WriteString(line)
+		}
+		return resp.String()
+	}
+	for _, c := range []struct {
+		req   string
+		lines int
+		want  string
+	}{
+		{"PING\r\n", 1, "+PONG\r\n"},
+		{"*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n", 1, "$-1\r\n"},
+		{"*3\r\n$3\r\nSET\r\n$3\r\nkey\r\n$5\r\nva\r\nl\r\n", 1, "+OK\r\n"},
+		{"GET key\r\n", 3, "$5\r\nva\r\nl\r\n"},
+		{"SET key other NX\r\n", 1, "$-1\r\n"},
+		{"SET missing other XX\r\n", 1, "$-1\r\n"},
+		{"TTL key\r\n", 1, ":-1\r\n"},
+		{"TTL missing\r\n", 1, ":-2\r\n"},
+		{"EXPIRE key 100\r\nTTL key\r\n", 2, ":1\r\n:100\r\n"},
+		{"EXPIRE missing 100\r\n", 1, ":0\r\n"},
+		{"INCR n\r\nINCR n\r\n", 2, ":1\r\n:2\r\n"},
+		{"INCR key\r\n", 1, "-ERR value is not an integer or out of range\r\n"},
+		{"SET n 10 EX 50\r\nINCR n\r\nTTL n\r\n", 3, "+OK\r\n:11\r\n:50\r\n"},
+		{"DEL key n missing\r\n", 1, ":2\r\n"},
+		{"GET\r\n", 1, "-ERR wrong number of arguments for 'get' command\r\n"},
+		{"FLUSHALL\r\n", 1, "-ERR unknown command 'flushall'\r\n"},
+	} {
+		if resp := do(c.req, c.lines); resp != c.want {
+			t.Errorf("%q: got %q, want %q", c.req, resp, c.want)
+		}
+	}
+	do("SET key value EX 1\r\n", 1)
+	clock.Advance(2 * time.Second)
+	if resp := do("GET key\r\n", 1); resp != "$-1\r\n" {
+		t.Error("the entry should expire", resp)
+	}
+	if resp := do("QUIT\r\n", 1); resp != "+OK\r\n" {
+		t.Error(resp)
+	}
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,11 @@
+module github.com/coocood/freecache/sessions
+
+go 1.24
+
+require (
+	github.com/coocood/freecache v0.0.0
+	github.com/gorilla/securecookie v1.1.2
+	github.com/gorilla/sessions v1.4.0
+)
+
+replace github.com/coocood/freecache => ../
@@ -0,0 +1,120 @@
+// Package sessions implements a gorilla/sessions Store that keeps the sessions in a freecache.Cache,
+// so a web application has expiring server-side sessions without an external store. The cookie
+// holds the session ID signed by the codecs of the store, and the values expire with the MaxAge
+// of the session.
+//
+// The sessions are lost when the process restarts, and are not shared between processes.
+package sessions
+
+import (
+	"encoding/base32"
+	"net/http"
+
+	"github.com/coocood/freecache"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Store is a sessions.Store backed by a cache.
+type Store struct {
+	cache   *freecache.Cache
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // the default options of new sessions.
+	// KeyPrefix is prepended to the session IDs to make the cache keys.
+	KeyPrefix string
+}
+
+var _ sessions.Store = (*Store)(nil)
+
+var base32RawStdEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewStore returns a store of the sessions in cache, keyPairs are the keys of the session cookies
+// as in sessions.NewCookieStore. The sessions expire after 30 days by default.
+func NewStore(cache *freecache.Cache, keyPairs ...[]byte) *Store {
+	s := &Store{
+		cache:  cache,
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		KeyPrefix: "session_",
+	}
+	s.MaxAge(s.Options.MaxAge)
+	return s
+}
+
+// MaxAge sets the maximum age of the sessions and of their cookies.
+func (s *Store) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// Get returns the session of name after adding it to the registry of the request.
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New returns the session of name without adding it to the registry. A new session is returned
+// if the request has no cookie of the session or the session has expired, the error of a cookie
+// that can't be decoded is returned with a new session.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+	c, err := r.Cookie(name)
+	if err != nil {
+		return session, nil
+	}
+	if err = securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+	value, err := s.cache.Get([]byte(s.KeyPrefix + session.ID))
+	if err == freecache.ErrNotFound {
+		return session, nil
+	}
+	if err == nil {
+		err = securecookie.GobEncoder{}.Deserialize(value, &session.Values)
+	}
+	session.IsNew = err != nil
+	return session, err
+}
+
+// Save stores the session and sets its cookie, a session with a MaxAge of zero or less is deleted.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 {
+		return s.Delete(r, w, session)
+	}
+	if session.ID == "" {
+		session.ID = base32RawStdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	}
+	value, err := securecookie.GobEncoder{}.Serialize(session.Values)
+	if err != nil {
+		return err
+	}
+	if err = s.cache.Set([]byte(s.KeyPrefix+session.ID), value, session.Options.MaxAge); err != nil {
+		return err
+	}
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, sessions.NewCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// Delete deletes the session from the cache and expires its cookie.
+func (s *Store) Delete(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.ID != "" {
+		s.cache.Del([]byte(s.KeyPrefix + session.ID))
+	}
+	opts := *session.Options
+	opts.MaxAge = -1
+	http.SetCookie(w, sessions.NewCookie(session.Name(), "", &opts))
+	return nil
+}
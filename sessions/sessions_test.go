@@ -0,0 +1,55 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/coocood/freecache"
+)
+
+func TestStore(t *testing.T) {
+	cache := freecache.NewCache(1024 * 1024)
+	store := NewStore(cache, []byte("secret-hash-key"))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	session, err := store.Get(r, "sid")
+	if err != nil || !session.IsNew {
+		t.Fatal(session, err)
+	}
+	session.Values["user"] = "alice"
+	w := httptest.NewRecorder()
+	if err = session.Save(r, w); err != nil {
+		t.Fatal(err)
+	}
+	cookies := w.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatal("cookies are", cookies)
+	}
+	if ttl, err := cache.TTL([]byte("session_" + session.ID)); err != nil || ttl == 0 || ttl > 86400*30 {
+		t.Error("ttl is", ttl, err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	loaded, err := store.New(r, "sid")
+	if err != nil || loaded.IsNew || loaded.ID != session.ID || loaded.Values["user"] != "alice" {
+		t.Fatal(loaded, err)
+	}
+
+	w = httptest.NewRecorder()
+	if err = store.Delete(r, w, loaded); err != nil {
+		t.Fatal(err)
+	}
+	if cookie := w.Result().Cookies()[0]; cookie.MaxAge >= 0 {
+		t.Error("the cookie should be expired", cookie)
+	}
+	if loaded, err = store.New(r, "sid"); err != nil || !loaded.IsNew {
+		t.Error("a deleted session should be new", loaded, err)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(&http.Cookie{Name: "sid", Value: "forged"})
+	if loaded, err = store.New(r, "sid"); err == nil || !loaded.IsNew {
+		t.Error("a forged cookie should be rejected", err)
+	}
+}
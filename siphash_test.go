@@ -0,0 +1,38 @@
+package freecache
+
+import (
+	"testing"
+)
+
+func TestSipHash(t *testing.T) {
+	// test vector from the SipHash paper.
+	seed := hashSeed{0x0706050403020100, 0x0f0e0d0c0b0a0908}
+	data := make([]byte, 15)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if hash := seed.sipHash(data); hash != 0xa129ca6149be45e5 {
+		t.Errorf("hash is %x", hash)
+	}
+	if hash := seed.sipHash(nil); hash != 0x726fdb47dd0e0e31 {
+		t.Errorf("hash of empty data is %x", hash)
+	}
+	if hash := seed.sipHash(data[:8]); hash != 0x93f5f5799a932462 {
+		t.Errorf("hash of 8 bytes is %x", hash)
+	}
+}
+
+func TestHashSeed(t *testing.T) {
+	a, b := NewCache(0), NewCache(0)
+	if a.seeds.Load().cur == b.seeds.Load().cur || a.hash([]byte("key")) == b.hash([]byte("key")) {
+		t.Error("caches should have different hash seeds")
+	}
+}
+
+func BenchmarkSipHash(b *testing.B) {
+	seed := newHashSeed()
+	key := []byte("benchmark key 16")
+	for i := 0; i < b.N; i++ {
+		seed.sipHash(key)
+	}
+}
@@ -0,0 +1,345 @@
+package freecache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"slices"
+	"unsafe"
+)
+
+var ErrInvalidSnapshot = errors.New("Invalid snapshot")
+
+const snapshotMagic = "FREECACH"
+const snapshotVersion = 2
+
+// segmentMeta is the fixed size part of a segment in a snapshot.
+type segmentMeta struct {
+	BufSize       int64
+	Begin         int64
+	End           int64
+	Index         int64
+	EntryCount    int64
+	TotalCount    int64
+	TotalTime     int64
+	TotalEvacuate int64
+	Overwrites    int64
+	TotalExpired  int64
+	VacuumLen     int64
+	SlotCap       int32
+	SlotLens      [256]int32
+}
+
+// SaveTo writes a snapshot of the cache to w, LoadCache can create a cache from it.
+// Segments are locked one at a time, so the snapshot is consistent within a segment,
+// but Set and Get on other segments are not blocked.
+// The entries are stored in the byte order of the machine, a snapshot can only be
+// loaded on a machine with the same byte order.
+func (cache *Cache) SaveTo(w io.Writer) (err error) {
+	bw := bufio.NewWriter(w)
+	writeSnapshotHeader(bw, len(cache.segments), cache.seeds.Load().cur)
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+		err = cache.segments[i].writeTo(bw, true)
+		cache.locks[i].Unlock()
+		if err != nil {
+			return
+		}
+	}
+	return bw.Flush()
+}
+
+func writeSnapshotHeader(w io.Writer, segCount int, seed hashSeed) {
+	io.WriteString(w, snapshotMagic)
+	binary.Write(w, binary.LittleEndian, uint32(snapshotVersion))
+	binary.Write(w, binary.LittleEndian, uint32(segCount))
+	binary.Write(w, binary.LittleEndian, seed)
+}
+
+// Snapshot is a copy of the entries of a cache made by Cache.Snapshot, it can be scanned or
+// written in the format of SaveTo without blocking the cache.
+type Snapshot struct {
+	cache    *Cache // decodes the entries.
+	seed     hashSeed
+	now      uint32
+	segments []segment
+	err      error
+}
+
+// Snapshot copies the ring buffers and the index of the cache, each segment is locked only while
+// it is copied, so the snapshot is consistent within a segment, like SaveTo, but the cache is not
+// blocked while the snapshot is scanned or written, which may be slow. The snapshot takes as much
+// memory as the cache. A cache with another Store than RingBuf can not be copied, the snapshot
+// has no entries, and WriteTo returns ErrUnsupportedStore.
+func (cache *Cache) Snapshot() *Snapshot {
+	s := &Snapshot{cache: cache, seed: cache.seeds.Load().cur, now: cache.now()}
+	s.segments = make([]segment, len(cache.segments))
+	for i := range cache.segments {
+		cache.locks[i].Lock()
+		if cache.segments[i].rb.store != nil {
+			s.err = ErrUnsupportedStore
+		} else {
+			s.segments[i] = cache.segments[i].copy()
+		}
+		cache.locks[i].Unlock()
+		if s.err != nil {
+			s.segments = nil
+			break
+		}
+	}
+	return s
+}
+
+// copy returns a copy of the ring buffer, the index and the counters written by writeTo.
+func (seg *segment) copy() (c segment) {
+	c.segId = seg.segId
+	c.rb = storage{RingBuf: seg.rb.RingBuf}
+	c.rb.data = slices.Clone(seg.rb.data)
+	c.slotLens = seg.slotLens
+	c.slotCap = seg.slotCap
+	c.slotsData = slices.Clone(seg.slotsData)
+	c.entryCount = seg.entryCount
+	c.totalCount = seg.totalCount
+	c.totalTime = seg.totalTime
+	c.totalExpired = seg.totalExpired
+	c.vacuumLen = seg.vacuumLen
+	c.counters = new(segCounters)
+	c.counters.evacuations.Store(seg.counters.evacuations.Load())
+	c.counters.overwrites.Store(seg.counters.overwrites.Load())
+	return
+}
+
+// WriteTo writes the snapshot to w in the format of SaveTo, LoadCache can create a cache from it.
+func (s *Snapshot) WriteTo(w io.Writer) (n int64, err error) {
+	if s.err != nil {
+		return 0, s.err
+	}
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	writeSnapshotHeader(bw, len(s.segments), s.seed)
+	for i := range s.segments {
+		if err = s.segments[i].writeTo(bw, true); err != nil {
+			return cw.n, err
+		}
+	}
+	err = bw.Flush()
+	return cw.n, err
+}
+
+// Scan is like Cache.Scan on the snapshot, the TTL of the entries is the TTL when the snapshot
+// was made.
+func (s *Snapshot) Scan(filter ScanFilter, fn func(key, value []byte) bool) {
+	match := func(hdr *entryHdr) bool {
+		return filter.match(hdr, s.now)
+	}
+	for i := range s.segments {
+		ok := s.segments[i].iterate(s.now, match, func(key, value []byte, hdr *entryHdr) bool {
+			key, value, err := s.cache.decodeEntry(key, value)
+			return err != nil || fn(key, value)
+		})
+		if !ok {
+			return
+		}
+	}
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.n += int64(n)
+	return
+}
+
+// LoadCache creates a cache from a snapshot written by SaveTo, the cache has the default config.
+func LoadCache(r io.Reader) (cache *Cache, err error) {
+	return LoadCacheWithConfig(r, Config{})
+}
+
+// LoadCacheWithConfig is like LoadCache, but the cache has config, which must have the Compressor
+// and Encryption of the saved cache. The size of the cache is the size of the snapshot.
+func LoadCacheWithConfig(r io.Reader, config Config) (cache *Cache, err error) {
+	br := bufio.NewReader(r)
+	var magic [len(snapshotMagic)]byte
+	var version, segCount uint32
+	if _, err = io.ReadFull(br, magic[:]); err != nil {
+		return
+	}
+	if string(magic[:]) != snapshotMagic {
+		return nil, ErrInvalidSnapshot
+	}
+	if err = binary.Read(br, binary.LittleEndian, &version); err != nil {
+		return
+	}
+	if err = binary.Read(br, binary.LittleEndian, &segCount); err != nil {
+		return
+	}
+	if version != snapshotVersion || segCount == 0 || segCount > 256 || segCount&(segCount-1) != 0 {
+		return nil, ErrInvalidSnapshot
+	}
+	config.Segments = int(segCount)
+	cache = buildCache(config)
+	var seed hashSeed
+	if err = binary.Read(br, binary.LittleEndian, &seed); err != nil {
+		return nil, err
+	}
+	cache.seeds.Store(&seedState{cur: seed})
+	for i := 0; i < len(cache.segments); i++ {
+		var meta segmentMeta
+		if meta, err = readSegmentMeta(br); err != nil {
+			return nil, err
+		}
+		data := make([]byte, meta.BufSize)
+		if _, err = io.ReadFull(br, data); err != nil {
+			return nil, err
+		}
+		cache.initSegment(i, data)
+		if err = cache.segments[i].readFrom(br, &meta); err != nil {
+			return nil, err
+		}
+	}
+	cache.loaded()
+	cache.start()
+	return
+}
+
+// loaded derives the state kept beside the entries of the segments once they are loaded from a
+// snapshot or a memory-mapped file: the size of the cache, the LRU lists, the timer wheels, the
+// watermark and the bloom filter.
+func (cache *Cache) loaded() {
+	var capacity int64
+	for i := 0; i < len(cache.segments); i++ {
+		seg := &cache.segments[i]
+		capacity += seg.rb.Size()
+		if cache.config.StrictLRU {
+			seg.rebuildLRU()
+		}
+		if seg.wheel != nil {
+			seg.rebuildWheel()
+		}
+	}
+	cache.segSize.Store(cache.segments[0].rb.Size())
+	cache.capacity.Store(capacity)
+	if cache.watermark != nil {
+		for i := 0; i < len(cache.segments); i++ {
+			cache.updateWatermark(uint64(i))
+		}
+	}
+	if cache.bloom != nil {
+		cache.fillBloomFilter(cache.bloom.cur.Load())
+	}
+}
+
+// writeTo writes the segment to w, the data of the ring buffer is omitted if withData is false.
+func (seg *segment) writeTo(w io.Writer, withData bool) (err error) {
+	if seg.rb.store != nil {
+		return ErrUnsupportedStore
+	}
+	meta := segmentMeta{
+		BufSize:       seg.rb.Size(),
+		Begin:         seg.rb.begin,
+		End:           seg.rb.end,
+		Index:         int64(seg.rb.index),
+		EntryCount:    seg.entryCount,
+		TotalCount:    seg.totalCount,
+		TotalTime:     seg.totalTime,
+		TotalEvacuate: seg.counters.evacuations.Load(),
+		Overwrites:    seg.counters.overwrites.Load(),
+		TotalExpired:  seg.totalExpired,
+		VacuumLen:     seg.vacuumLen,
+		SlotCap:       seg.slotCap,
+		SlotLens:      seg.slotLens,
+	}
+	if err = binary.Write(w, binary.LittleEndian, &meta); err != nil {
+		return
+	}
+	if withData {
+		if _, err = w.Write(seg.rb.data); err != nil {
+			return
+		}
+	}
+	_, err = w.Write(entryPtrBytes(seg.slotsData))
+	return
+}
+
+// readSegmentMeta reads the meta of a segment written by writeTo.
+func readSegmentMeta(r io.Reader) (meta segmentMeta, err error) {
+	if err = binary.Read(r, binary.LittleEndian, &meta); err != nil {
+		return
+	}
+	used := meta.End - meta.Begin
+	if meta.BufSize <= 0 || meta.BufSize > 1<<40 || meta.SlotCap <= 0 || meta.SlotCap > 1<<24 ||
+		meta.End < meta.Begin || used > meta.BufSize ||
+		// the ring buffer is written from the start of its data until it is full.
+		meta.Index < 0 || meta.Index >= meta.BufSize || used < meta.BufSize && meta.Index != used ||
+		// the entries are in the used part of the ring buffer, before the end.
+		meta.VacuumLen < meta.BufSize-used || meta.VacuumLen > meta.BufSize {
+		return meta, ErrInvalidSnapshot
+	}
+	var entries int64
+	for _, slotLen := range meta.SlotLens {
+		if slotLen < 0 || slotLen > meta.SlotCap {
+			return meta, ErrInvalidSnapshot
+		}
+		entries += int64(slotLen)
+	}
+	if entries > meta.BufSize/ENTRY_HDR_SIZE {
+		return meta, ErrInvalidSnapshot
+	}
+	return
+}
+
+// readFrom reads the entry pointers of a segment written by writeTo, which follow the data of the
+// ring buffer. The segment is initialized with the data of the ring buffer already.
+func (seg *segment) readFrom(r io.Reader, meta *segmentMeta) (err error) {
+	data := seg.rb.data
+	if int64(len(data)) != meta.BufSize {
+		return ErrInvalidSnapshot
+	}
+	seg.rb = storage{RingBuf: newRingBuf(data, meta.Begin)}
+	seg.rb.end = meta.End
+	seg.rb.index = int(meta.Index)
+	// the capacity of the slots is the one of the longest slot, not the one saved, which is kept
+	// when the ring buffer shrinks, so the index is not larger than the entries need.
+	slotCap := int32(1)
+	for _, slotLen := range meta.SlotLens {
+		for slotCap < slotLen {
+			slotCap *= 2
+		}
+	}
+	seg.slotsData = make([]entryPtr, 256*slotCap)
+	ptrSize := int64(unsafe.Sizeof(entryPtr{}))
+	for i, slotLen := range meta.SlotLens {
+		slotOff := int32(i) * slotCap
+		if _, err = io.ReadFull(r, entryPtrBytes(seg.slotsData[slotOff:slotOff+slotLen])); err != nil {
+			return
+		}
+		if _, err = io.CopyN(io.Discard, r, int64(meta.SlotCap-slotLen)*ptrSize); err != nil {
+			return
+		}
+	}
+	seg.entryCount = meta.EntryCount
+	seg.totalCount = meta.TotalCount
+	seg.totalTime = meta.TotalTime
+	seg.counters.evacuations.Store(meta.TotalEvacuate)
+	seg.counters.overwrites.Store(meta.Overwrites)
+	seg.totalExpired = meta.TotalExpired
+	seg.vacuumLen = meta.VacuumLen
+	seg.slotCap = slotCap
+	seg.slotLens = meta.SlotLens
+	seg.liveBytes, seg.slackBytes = seg.countLiveBytes()
+	return
+}
+
+// entryPtrBytes returns the memory of the entry pointers as a byte slice.
+func entryPtrBytes(ptrs []entryPtr) []byte {
+	if len(ptrs) == 0 {
+		return nil
+	}
+	return unsafe.Slice((*byte)(unsafe.Pointer(&ptrs[0])), len(ptrs)*int(unsafe.Sizeof(ptrs[0])))
+}
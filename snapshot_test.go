@@ -0,0 +1,185 @@
+package freecache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSnapshot(t *testing.T) {
+	cache := NewCache(1024)
+	for i := 0; i < 5000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%v", i)), []byte(fmt.Sprintf("val%v", i)), 0)
+	}
+	cache.Del([]byte("key4999"))
+	var buf bytes.Buffer
+	if err := cache.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCache(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.EntryCount() != cache.EntryCount() {
+		t.Error("entry count is", loaded.EntryCount(), "expected", cache.EntryCount())
+	}
+	for i := 0; i < 4999; i++ {
+		key := []byte(fmt.Sprintf("key%v", i))
+		expected, err := cache.Get(key)
+		if err != nil {
+			continue
+		}
+		value, err := loaded.Get(key)
+		if err != nil || !bytes.Equal(value, expected) {
+			t.Fatal("value of", string(key), "is", string(value), err)
+		}
+	}
+	if _, err = loaded.Get([]byte("key4999")); err != ErrNotFound {
+		t.Error("deleted entry should not be loaded")
+	}
+	if err = loaded.Set([]byte("new"), []byte("value"), 0); err != nil {
+		t.Error(err)
+	}
+	data := buf.Bytes()
+	data[0] = 'x'
+	if _, err = LoadCache(bytes.NewReader(data)); err != ErrInvalidSnapshot {
+		t.Error("err should be ErrInvalidSnapshot", err)
+	}
+}
+
+func TestCopySnapshot(t *testing.T) {
+	cache := NewCache(1024 * 1024)
+	for i := 0; i < 1000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte(fmt.Sprintf("value%d", i)), 0)
+	}
+	snapshot := cache.Snapshot()
+	cache.Set([]byte("key1"), []byte("changed"), 0)
+	cache.Del([]byte("key2"))
+	cache.Set([]byte("new"), []byte("value"), 0)
+	values := make(map[string]string)
+	snapshot.Scan(ScanFilter{}, func(key, value []byte) bool {
+		values[string(key)] = string(value)
+		return true
+	})
+	if len(values) != 1000 || values["key1"] != "value1" || values["key2"] != "value2" {
+		t.Error("the snapshot should not see later changes", len(values), values["key1"], values["key2"])
+	}
+	var buf bytes.Buffer
+	n, err := snapshot.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatal(n, buf.Len(), err)
+	}
+	loaded, err := LoadCache(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.EntryCount() != 1000 {
+		t.Error("entry count should be 1000", loaded.EntryCount())
+	}
+	if value, err := loaded.Get([]byte("key1")); err != nil || string(value) != "value1" {
+		t.Error("unexpected value", string(value), err)
+	}
+}
+
+func TestLoadCacheDerivedState(t *testing.T) {
+	cache := NewCacheWithConfig(4*1024*1024, Config{TimerWheel: true})
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), 100)
+	}
+	var buf bytes.Buffer
+	if err := cache.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// the background goroutines must not see the segments while they are loaded.
+	loaded, err := LoadCacheWithConfig(&buf, Config{TimerWheel: true, ExpireInterval: time.Microsecond, Admission: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	if loaded.Size() != cache.Size() || loaded.capacity.Load() != int64(cache.Size()) {
+		t.Error("size is", loaded.Size(), "capacity is", loaded.capacity.Load(), "expected", cache.Size())
+	}
+	if count := loaded.ExpiringWithin(200); count != 100 {
+		t.Error("expiring is", count, "expected", 100)
+	}
+	if count := loaded.ExpiringWithin(50); count != 0 {
+		t.Error("expiring is", count, "expected", 0)
+	}
+	if value, err := loaded.Get([]byte("key1")); err != nil || string(value) != "value" {
+		t.Error(string(value), err)
+	}
+}
+
+func TestLoadCorruptSegmentMeta(t *testing.T) {
+	cache := NewCacheWithConfig(64*1024, Config{Segments: 1})
+	for i := 0; i < 100; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), 0)
+	}
+	var buf bytes.Buffer
+	if err := cache.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	metaOff := len(snapshotMagic) + 8 + binary.Size(hashSeed{})
+	var meta segmentMeta
+	if err := binary.Read(bytes.NewReader(buf.Bytes()[metaOff:]), binary.LittleEndian, &meta); err != nil {
+		t.Fatal(err)
+	}
+	for name, corrupt := range map[string]func(m *segmentMeta){
+		"vacuum too small": func(m *segmentMeta) { m.VacuumLen = m.BufSize - (m.End - m.Begin) - 1 },
+		"vacuum too large": func(m *segmentMeta) { m.VacuumLen = m.BufSize + 1 },
+		"negative vacuum":  func(m *segmentMeta) { m.VacuumLen = -1 },
+		"index not at end": func(m *segmentMeta) { m.Index++ },
+		"index too large":  func(m *segmentMeta) { m.Index = m.BufSize },
+		"slot cap too large": func(m *segmentMeta) {
+			m.SlotCap = 1<<24 + 1
+		},
+		"slot len too large": func(m *segmentMeta) { m.SlotLens[0] = m.SlotCap + 1 },
+		"too many entries": func(m *segmentMeta) {
+			m.SlotCap = 1 << 24
+			for i := range m.SlotLens {
+				m.SlotLens[i] = int32(m.BufSize / ENTRY_HDR_SIZE / 128)
+			}
+		},
+	} {
+		m := meta
+		corrupt(&m)
+		var metaBuf bytes.Buffer
+		binary.Write(&metaBuf, binary.LittleEndian, &m)
+		data := append([]byte(nil), buf.Bytes()...)
+		copy(data[metaOff:], metaBuf.Bytes())
+		if _, err := LoadCache(bytes.NewReader(data)); err != ErrInvalidSnapshot {
+			t.Error(name, "err should be ErrInvalidSnapshot", err)
+		}
+	}
+}
+
+func TestLoadShrunkCache(t *testing.T) {
+	cache := NewCacheWithConfig(16*1024*1024, Config{Segments: 1})
+	for i := 0; i < 100000; i++ {
+		cache.Set([]byte(fmt.Sprintf("key%d", i)), []byte("value"), 0)
+	}
+	if err := cache.Resize(512 * 1024); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := cache.SaveTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := LoadCache(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer loaded.Close()
+	// the slot capacity kept by the shrink is not loaded.
+	if seg := &loaded.segments[0]; seg.slotCap >= cache.segments[0].slotCap {
+		t.Error("slot cap is", seg.slotCap, "saved", cache.segments[0].slotCap)
+	}
+	if loaded.EntryCount() != cache.EntryCount() {
+		t.Error("entry count is", loaded.EntryCount(), "expected", cache.EntryCount())
+	}
+	if value, err := loaded.Get([]byte("key99999")); err != nil || string(value) != "value" {
+		t.Error(string(value), err)
+	}
+}
@@ -0,0 +1,94 @@
+package freecache
+
+import (
+	"errors"
+	"time"
+)
+
+// errStale is returned by segment.getEntry with the value of an expired entry in its stale period.
+var errStale = errors.New("freecache: stale entry")
+
+// GetStale is like Get, and returns the value of an entry that is expired for less than
+// Config.StaleSeconds with stale set, instead of ErrNotFound. A stale value is refreshed by
+// Config.Loader in a goroutine, once at a time for a key, the stale value is kept if it fails.
+// Serving the stale value while it is reloaded avoids the latency of a miss when a popular entry
+// expires. A stale chunked value is not found.
+func (cache *Cache) GetStale(key []byte) (value []byte, stale bool, err error) {
+	if observe := cache.latency.Load(); observe != nil {
+		defer (*observe)(OpGet, time.Now())
+	}
+	entryKey := cache.entryKey(key)
+	seeds := cache.seeds.Load()
+	value, err = cache.getStale(entryKey, seeds.cur.sipHash(entryKey), seeds.old == nil)
+	if err == ErrNotFound && seeds.old != nil {
+		value, err = cache.getStale(entryKey, seeds.old.sipHash(entryKey), true)
+	}
+	if err == errStale {
+		stale, err = true, nil
+		cache.refresh(key)
+	}
+	if err == errChunked {
+		value, err = cache.getChunks(entryKey, value)
+	}
+	value, err = cache.overflowGet(entryKey, value, err)
+	value, err = cache.decodeResult(key, entryKey, value, err)
+	return
+}
+
+// getStale is getWithHash for GetStale, a stale value is counted as a hit.
+func (cache *Cache) getStale(key []byte, hashVal uint64, countMiss bool) (value []byte, err error) {
+	segId := hashVal & cache.segMask
+	cache.locks[segId].Lock()
+	err = cache.guarded(segId, func() (err error) {
+		value, err = cache.segments[segId].getEntry(key, hashVal, nil, true)
+		return
+	})
+	cache.unlock(segId)
+	cache.countGet(segId, key, hashVal, err, countMiss)
+	return
+}
+
+// refresh sets the value of key loaded by Config.Loader in a goroutine, unless the key is being
+// loaded already.
+func (cache *Cache) refresh(key []byte) {
+	if cache.config.Loader == nil {
+		return
+	}
+	if _, loading := cache.refreshing.LoadOrStore(string(key), struct{}{}); loading {
+		return
+	}
+	key = append([]byte(nil), key...)
+	go func() {
+		defer cache.refreshing.Delete(string(key))
+		if value, expireSeconds, err := cache.config.Loader(key); err == nil {
+			cache.Set(key, value, expireSeconds)
+		}
+	}()
+}
+
+// keepRefresh keeps the key of an entry found close to its expiration for refreshAhead, the value
+// is kept as well for a long key, whose key is stored with the value.
+func (seg *segment) keepRefresh(hdr *entryHdr, offset int64) {
+	var entry expiredEntry
+	entry.key = make([]byte, hdr.keyLen)
+	seg.rb.ReadAt(entry.key, offset+ENTRY_HDR_SIZE)
+	if isLongKey(entry.key) {
+		entry.value = make([]byte, hdr.valLen)
+		seg.rb.ReadAt(entry.value, hdr.valOff(offset))
+	}
+	seg.refreshes = append(seg.refreshes, entry)
+}
+
+// refreshAhead refreshes the entries kept by keepRefresh, see Config.RefreshAheadSeconds.
+func (cache *Cache) refreshAhead(entries []expiredEntry) {
+	for _, entry := range entries {
+		key := entry.key
+		if entry.value != nil {
+			var err error
+			if key, _, err = cache.decodeEntry(entry.key, entry.value); err != nil {
+				continue
+			}
+		}
+		cache.refresh(key)
+	}
+}
@@ -0,0 +1,120 @@
+package freecache
+
+import "errors"
+
+var ErrUnsupportedStore = errors.New("The operation is not supported by the Store of the cache")
+
+// Store is the storage of the entries of a segment, see Config.Storage. It holds the end of a
+// stream of bytes: new bytes are written at End, and once the stream is longer than Size, the
+// oldest bytes are dropped, so only the offsets from Begin to End can be read.
+// RingBuf is the default Store, alternative stores can keep the bytes in a memory-mapped file or
+// persistent memory. A Store is used under the lock of its segment, it is not used concurrently.
+type Store interface {
+	// Size returns the largest number of bytes the store holds.
+	Size() int64
+	// Begin returns the offset of the oldest byte in the store.
+	Begin() int64
+	// End returns the offset after the newest byte in the store.
+	End() int64
+	// ReadAt reads len(p) bytes at off, ErrOutOfRange is returned if they are not in the store.
+	ReadAt(p []byte, off int64) (n int, err error)
+	// WriteAt overwrites len(p) bytes at off, ErrOutOfRange is returned if they are not in the store.
+	WriteAt(p []byte, off int64) (n int, err error)
+	// Write appends p to the stream.
+	Write(p []byte) (n int, err error)
+	// EqualAt tells whether the bytes at off are equal to p.
+	EqualAt(p []byte, off int64) bool
+	// Evacuate appends the length bytes at off to the stream and returns their new offset, or -1 if
+	// they are not in the store.
+	Evacuate(off int64, length int) (newOff int64)
+	// Skip appends length bytes of any value to the stream.
+	Skip(length int64)
+}
+
+var _ Store = (*RingBuf)(nil)
+
+// storage is the store of a segment. It calls the RingBuf directly unless the cache has another
+// Store, which is called with copies of the buffers, so the buffers of the segment, often arrays
+// on the stack, don't escape to the heap because of the interface calls.
+type storage struct {
+	RingBuf
+	store   Store  // nil if the RingBuf is used.
+	scratch []byte // a copy of the buffer passed to store.
+}
+
+// copyBuf returns a copy of p in the scratch buffer.
+func (s *storage) copyBuf(p []byte) []byte {
+	if cap(s.scratch) < len(p) {
+		s.scratch = make([]byte, len(p))
+	}
+	buf := s.scratch[:len(p)]
+	copy(buf, p)
+	return buf
+}
+
+func (s *storage) Size() int64 {
+	if s.store == nil {
+		return s.RingBuf.Size()
+	}
+	return s.store.Size()
+}
+
+func (s *storage) Begin() int64 {
+	if s.store == nil {
+		return s.RingBuf.Begin()
+	}
+	return s.store.Begin()
+}
+
+func (s *storage) End() int64 {
+	if s.store == nil {
+		return s.RingBuf.End()
+	}
+	return s.store.End()
+}
+
+func (s *storage) ReadAt(p []byte, off int64) (n int, err error) {
+	if s.store == nil {
+		return s.RingBuf.ReadAt(p, off)
+	}
+	buf := s.copyBuf(p)
+	n, err = s.store.ReadAt(buf, off)
+	copy(p, buf[:n])
+	return
+}
+
+func (s *storage) WriteAt(p []byte, off int64) (n int, err error) {
+	if s.store == nil {
+		return s.RingBuf.WriteAt(p, off)
+	}
+	return s.store.WriteAt(s.copyBuf(p), off)
+}
+
+func (s *storage) Write(p []byte) (n int, err error) {
+	if s.store == nil {
+		return s.RingBuf.Write(p)
+	}
+	return s.store.Write(s.copyBuf(p))
+}
+
+func (s *storage) EqualAt(p []byte, off int64) bool {
+	if s.store == nil {
+		return s.RingBuf.EqualAt(p, off)
+	}
+	return s.store.EqualAt(s.copyBuf(p), off)
+}
+
+func (s *storage) Evacuate(off int64, length int) (newOff int64) {
+	if s.store == nil {
+		return s.RingBuf.Evacuate(off, length)
+	}
+	return s.store.Evacuate(off, length)
+}
+
+func (s *storage) Skip(length int64) {
+	if s.store == nil {
+		s.RingBuf.Skip(length)
+		return
+	}
+	s.store.Skip(length)
+}
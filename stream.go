@@ -0,0 +1,79 @@
+package freecache
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"time"
+)
+
+var ErrInvalidLength = errors.New("The length is negative")
+
+// SetReader is like Set, but reads the value of length from r. A value that is chunked, see
+// ChunkLargeValues, is read and written one chunk at a time, so it is never held in memory as a
+// whole, unless the cache has a journal or a replicator, which record whole values, or compresses or encrypts
+// values, which is done to whole values. A smaller value is read into a buffer first, r is not read while a
+// segment is locked.
+// If r returns an error or less than length bytes, the error is returned and the entry is not set.
+func (cache *Cache) SetReader(key []byte, r io.Reader, length int, expireSeconds int) error {
+	if length < 0 {
+		return ErrInvalidLength
+	}
+	if !cache.config.ChunkLargeValues || cache.logged() || cache.encodes() ||
+		len(key)+length <= cache.maxKeyValLen() {
+		value := make([]byte, length)
+		if _, err := io.ReadFull(r, value); err != nil {
+			return err
+		}
+		return cache.Set(key, value, expireSeconds)
+	}
+	return cache.setChunked(key, length, &chunkSource{reader: &chunkReader{r: r}}, expireSeconds, -1, 0, 0)
+}
+
+// GetWriter is like Get, but writes the value to w. A chunked value is written one chunk at a time,
+// ErrNotFound is returned after some chunks have been written if a chunk has been evicted.
+// w is not called while a segment is locked.
+func (cache *Cache) GetWriter(key []byte, w io.Writer) error {
+	if observe := cache.latency.Load(); observe != nil {
+		defer (*observe)(OpGet, time.Now())
+	}
+	entryKey := cache.entryKey(key)
+	value, err := cache.get(entryKey, nil)
+	if err == errChunked && cache.encodes() {
+		// an encoded value is decoded as a whole.
+		value, err = cache.getChunks(entryKey, value)
+	}
+	if err == errChunked {
+		return cache.readChunks(entryKey, value, func(chunk []byte, length int) error {
+			_, err := w.Write(chunk)
+			return err
+		})
+	}
+	value, err = cache.overflowGet(entryKey, value, err)
+	if value, err = cache.decodeResult(key, entryKey, value, err); err != nil {
+		return err
+	}
+	_, err = w.Write(value)
+	return err
+}
+
+// GetInto is like Get, but writes the value to dst. The value is read from the cache directly into
+// the available space of dst, so there is no allocation if dst has room for it, unless the value is
+// chunked, compressed or encrypted, or in the overflow store.
+func (cache *Cache) GetInto(key []byte, dst *bytes.Buffer) error {
+	if observe := cache.latency.Load(); observe != nil {
+		defer (*observe)(OpGet, time.Now())
+	}
+	entryKey := cache.entryKey(key)
+	value, err := cache.get(entryKey, dst.AvailableBuffer())
+	if err == errChunked {
+		value, err = cache.getChunks(entryKey, value)
+	}
+	value, err = cache.overflowGet(entryKey, value, err)
+	if value, err = cache.decodeResult(key, entryKey, value, err); err != nil {
+		return err
+	}
+	// writing the available buffer of dst doesn't copy.
+	dst.Write(value)
+	return nil
+}
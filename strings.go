@@ -0,0 +1,24 @@
+package freecache
+
+import "unsafe"
+
+// stringBytes returns the bytes of s without copying, they must not be modified.
+// The cache never modifies or retains a key passed to it.
+func stringBytes(s string) []byte {
+	return unsafe.Slice(unsafe.StringData(s), len(s))
+}
+
+// SetString is like Set, but the key is a string, it is not copied to a []byte.
+func (cache *Cache) SetString(key string, value []byte, expireSeconds int) error {
+	return cache.Set(stringBytes(key), value, expireSeconds)
+}
+
+// GetString is like Get, but the key is a string, it is not copied to a []byte.
+func (cache *Cache) GetString(key string) (value []byte, err error) {
+	return cache.Get(stringBytes(key))
+}
+
+// DelString is like Del, but the key is a string, it is not copied to a []byte.
+func (cache *Cache) DelString(key string) (affected bool) {
+	return cache.Del(stringBytes(key))
+}
@@ -0,0 +1,43 @@
+package freecache
+
+// Swap sets the entry like Set and returns the value it replaces, atomically, so no other Set of
+// the key can come between reading the old value and writing the new one. old is nil if the key
+// was not in the cache. The overflow store is not looked up for the old value.
+//
+// While RotateHashSeed moves the entries, the old value of a key that is not moved yet is taken
+// from its old position after the new value is set.
+func (cache *Cache) Swap(key, value []byte, expireSeconds int) (old []byte, err error) {
+	entryKey, stored := cache.encodeEntry(key, value)
+	seeds := cache.seeds.Load()
+	oldErr := ErrNotFound
+	check := func(seg *segment, hashVal uint64) error {
+		old, oldErr = seg.getShared(entryKey, hashVal, nil)
+		return nil
+	}
+	if err = cache.setWithHash(entryKey, stored, seeds.cur.sipHash(entryKey), expireSeconds, -1, 0, 0, check); err != nil {
+		return nil, err
+	}
+	if seeds.old != nil {
+		hashVal := seeds.old.sipHash(entryKey)
+		segId := hashVal & cache.segMask
+		cache.locks[segId].Lock()
+		seg := &cache.segments[segId]
+		if oldErr == ErrNotFound {
+			old, oldErr = seg.getShared(entryKey, hashVal, nil)
+		}
+		seg.del(entryKey, hashVal)
+		cache.unlock(segId)
+	}
+	if oldErr == errChunked {
+		manifest := old
+		old, oldErr = cache.getChunks(entryKey, manifest)
+		cache.dropChunks(entryKey, manifest)
+	}
+	if oldErr != nil {
+		return nil, nil
+	}
+	if old, oldErr = cache.decodeResult(key, entryKey, old, nil); oldErr != nil {
+		return nil, nil
+	}
+	return old, nil
+}
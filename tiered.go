@@ -0,0 +1,98 @@
+package freecache
+
+// WritePolicy is how a Tiered writes a value to its tiers.
+type WritePolicy int
+
+const (
+	// WriteThrough sets the value in the back tier, then in the front one.
+	WriteThrough WritePolicy = iota
+	// WriteAround sets the value in the back tier and deletes the key from the front one, so a value
+	// that is written but not read doesn't take room in the front tier.
+	WriteAround
+)
+
+// TieredConfig configures a Tiered.
+type TieredConfig struct {
+	// Write is the write policy, the default is WriteThrough.
+	Write WritePolicy
+	// NoPromote makes a value found in the back tier not copied to the front one.
+	NoPromote bool
+	// PromoteExpireSeconds is the expire seconds of a value copied to the front tier, zero means the
+	// DefaultTTL of the front cache. A shorter expire time is used if the back tier has a
+	// TTL(key []byte) (uint32, error) method like Cache and the value expires sooner there.
+	PromoteExpireSeconds int
+}
+
+// Tiered chains a small fast cache in front of a larger or slower Node, like a Cache with a larger
+// size, a Cluster or a client of a cache in another process. Get looks up the front tier first and
+// copies the values found in the back tier to the front one, Set and Del write both. A Tiered is a
+// Node, so tiers can be stacked.
+//
+// A value set in the back tier by another client is not seen while the front tier holds an older
+// value, use a short PromoteExpireSeconds or an Invalidator to bound the staleness.
+type Tiered struct {
+	front  *Cache
+	back   Node
+	config TieredConfig
+}
+
+var _ Node = (*Tiered)(nil)
+
+// NewTiered returns a Tiered of front in front of back.
+func NewTiered(front *Cache, back Node, config TieredConfig) *Tiered {
+	return &Tiered{front: front, back: back, config: config}
+}
+
+// Get returns the value of key from the front tier, or from the back tier if it's not in the front.
+func (t *Tiered) Get(key []byte) (value []byte, err error) {
+	if value, err = t.front.Get(key); err != ErrNotFound {
+		return
+	}
+	if value, err = t.back.Get(key); err != nil || t.config.NoPromote {
+		return
+	}
+	expireSeconds := t.config.PromoteExpireSeconds
+	if back, ok := t.back.(interface {
+		TTL(key []byte) (uint32, error)
+	}); ok {
+		if ttl, ttlErr := back.TTL(key); ttlErr == nil && ttl > 0 && (expireSeconds <= 0 || int(ttl) < expireSeconds) {
+			expireSeconds = int(ttl)
+		}
+	}
+	// a value the front tier can't hold is still returned.
+	t.front.Set(key, value, expireSeconds)
+	return
+}
+
+// Set sets the value in the back tier, and in the front tier by the write policy. The front tier
+// is not changed if the back tier returns an error.
+func (t *Tiered) Set(key, value []byte, expireSeconds int) (err error) {
+	if err = t.back.Set(key, value, expireSeconds); err != nil {
+		return
+	}
+	if t.config.Write == WriteAround {
+		t.front.Del(key)
+		return
+	}
+	if t.front.Set(key, value, expireSeconds) != nil {
+		// an older value must not be left in the front tier.
+		t.front.Del(key)
+	}
+	return
+}
+
+// Del deletes the key from both tiers, affected is true if it's deleted from either.
+func (t *Tiered) Del(key []byte) (affected bool) {
+	affected = t.front.Del(key)
+	return t.back.Del(key) || affected
+}
+
+// Front returns the front tier.
+func (t *Tiered) Front() *Cache {
+	return t.front
+}
+
+// Back returns the back tier.
+func (t *Tiered) Back() Node {
+	return t.back
+}
@@ -0,0 +1,177 @@
+package freecache
+
+const (
+	wheelBits0  = 8
+	wheelBits1  = 6
+	wheelBits2  = 6
+	wheelSpan0  = 1 << wheelBits0
+	wheelSpan1  = 1 << (wheelBits0 + wheelBits1)
+	wheelSpan2  = 1 << (wheelBits0 + wheelBits1 + wheelBits2)
+	wheelSize1  = 1 << wheelBits1
+	wheelSize2  = 1 << wheelBits2
+	wheelShift1 = wheelBits0
+	wheelShift2 = wheelBits0 + wheelBits1
+)
+
+// timerRecord records that the entry of the key with hashVal expires at expireAt.
+// The entry may be deleted or overwritten later, so a record is verified before it is used.
+type timerRecord struct {
+	hashVal  uint64
+	expireAt uint32
+}
+
+// timerWheel is a hierarchical timer wheel that tracks the expire time of the entries in a segment.
+// Level 0 has 256 buckets of one second, level 1 has 64 buckets of 256 seconds,
+// level 2 has 64 buckets of 16384 seconds, records expire even later are kept in overflow.
+// Records in higher levels are cascaded to lower levels when time advances.
+type timerWheel struct {
+	now      uint32 // every record expires at or before now has been fired.
+	count    int    // the number of records in the wheel.
+	level0   [wheelSpan0][]timerRecord
+	level1   [wheelSize1][]timerRecord
+	level2   [wheelSize2][]timerRecord
+	overflow []timerRecord
+}
+
+func newTimerWheel(now uint32) *timerWheel {
+	return &timerWheel{now: now}
+}
+
+func (tw *timerWheel) add(rec timerRecord) {
+	tw.count++
+	tw.insert(rec)
+}
+
+// insert puts a record in the bucket of its expire time relative to the current time.
+func (tw *timerWheel) insert(rec timerRecord) {
+	if rec.expireAt <= tw.now {
+		// fire it with the next second.
+		idx := (tw.now + 1) & (wheelSpan0 - 1)
+		tw.level0[idx] = append(tw.level0[idx], rec)
+		return
+	}
+	d := rec.expireAt - tw.now
+	switch {
+	case d < wheelSpan0:
+		idx := rec.expireAt & (wheelSpan0 - 1)
+		tw.level0[idx] = append(tw.level0[idx], rec)
+	case d < wheelSpan1:
+		idx := (rec.expireAt >> wheelShift1) & (wheelSize1 - 1)
+		tw.level1[idx] = append(tw.level1[idx], rec)
+	case d < wheelSpan2:
+		idx := (rec.expireAt >> wheelShift2) & (wheelSize2 - 1)
+		tw.level2[idx] = append(tw.level2[idx], rec)
+	default:
+		tw.overflow = append(tw.overflow, rec)
+	}
+}
+
+// cascade takes all the records out of bucket and adds them again relative to the current time.
+func (tw *timerWheel) cascade(bucket *[]timerRecord) {
+	recs := *bucket
+	*bucket = nil
+	for _, rec := range recs {
+		tw.insert(rec)
+	}
+}
+
+// advance moves the wheel forward to now, fire is called for every record expires up to now.
+func (tw *timerWheel) advance(now uint32, fire func(rec timerRecord)) {
+	if now <= tw.now {
+		return
+	}
+	if now-tw.now > wheelSpan2 {
+		// the wheel has not been advanced for a long time, rebuild it instead of stepping through every second.
+		var recs []timerRecord
+		tw.forEach(func(rec timerRecord) {
+			recs = append(recs, rec)
+		})
+		*tw = timerWheel{now: now}
+		for _, rec := range recs {
+			if rec.expireAt <= now {
+				fire(rec)
+			} else {
+				tw.add(rec)
+			}
+		}
+		return
+	}
+	for tw.now < now {
+		tw.now++
+		t := tw.now
+		if t&(wheelSpan0-1) == 0 {
+			if t&(wheelSpan1-1) == 0 {
+				if t&(wheelSpan2-1) == 0 {
+					tw.cascade(&tw.overflow)
+				}
+				tw.cascade(&tw.level2[(t>>wheelShift2)&(wheelSize2-1)])
+			}
+			tw.cascade(&tw.level1[(t>>wheelShift1)&(wheelSize1-1)])
+		}
+		bucket := &tw.level0[t&(wheelSpan0-1)]
+		recs := *bucket
+		*bucket = nil
+		for _, rec := range recs {
+			if rec.expireAt <= t {
+				tw.count--
+				fire(rec)
+			} else {
+				tw.insert(rec)
+			}
+		}
+	}
+}
+
+// forEachUntil calls fn for the records of the buckets that may hold records that expire at or
+// before deadline, some records may expire later. A level holds the blocks after the block of now,
+// up to the span of the level, the block of a record is its expire time shifted by the level.
+func (tw *timerWheel) forEachUntil(deadline uint32, fn func(rec timerRecord)) {
+	if deadline <= tw.now {
+		return
+	}
+	for t := tw.now + 1; t-tw.now < wheelSpan0 && t <= deadline; t++ {
+		for _, rec := range tw.level0[t&(wheelSpan0-1)] {
+			fn(rec)
+		}
+	}
+	tw.forEachBlock(tw.level1[:], wheelShift1, deadline, fn)
+	tw.forEachBlock(tw.level2[:], wheelShift2, deadline, fn)
+	// the records in overflow expire after the next cascade of overflow.
+	if deadline >= tw.now&^(wheelSpan2-1)+wheelSpan2 {
+		for _, rec := range tw.overflow {
+			fn(rec)
+		}
+	}
+}
+
+// forEachBlock calls fn for the records of the buckets of a level for the blocks up to deadline.
+func (tw *timerWheel) forEachBlock(level [][]timerRecord, shift uint32, deadline uint32, fn func(rec timerRecord)) {
+	size := uint32(len(level))
+	cur, last := tw.now>>shift, deadline>>shift
+	for b := cur + 1; b <= last && b-cur <= size; b++ {
+		for _, rec := range level[b&(size-1)] {
+			fn(rec)
+		}
+	}
+}
+
+func (tw *timerWheel) forEach(fn func(rec timerRecord)) {
+	for _, bucket := range tw.level0 {
+		for _, rec := range bucket {
+			fn(rec)
+		}
+	}
+	for _, bucket := range tw.level1 {
+		for _, rec := range bucket {
+			fn(rec)
+		}
+	}
+	for _, bucket := range tw.level2 {
+		for _, rec := range bucket {
+			fn(rec)
+		}
+	}
+	for _, rec := range tw.overflow {
+		fn(rec)
+	}
+}
@@ -0,0 +1,82 @@
+package freecache
+
+import (
+	"testing"
+)
+
+func TestTimerWheel(t *testing.T) {
+	start := uint32(1000000)
+	tw := newTimerWheel(start)
+	expires := []uint32{start, start + 1, start + 255, start + 256, start + 1000, start + 20000, start + 2000000}
+	for i, expireAt := range expires {
+		tw.add(timerRecord{hashVal: uint64(i), expireAt: expireAt})
+	}
+	fired := make(map[uint64]uint32)
+	fire := func(rec timerRecord) {
+		fired[rec.hashVal] = tw.now
+	}
+	for now := start; now < start+30000; now += 7 {
+		tw.advance(now, fire)
+	}
+	tw.advance(start+3000000, fire)
+	for i, expireAt := range expires {
+		firedAt, ok := fired[uint64(i)]
+		if !ok {
+			t.Fatalf("record %v not fired", i)
+		}
+		if firedAt < expireAt || (i < 6 && firedAt > expireAt+7) {
+			t.Errorf("record %v expires at %v, fired at %v", i, expireAt, firedAt)
+		}
+	}
+}
+
+func TestTimerWheelForEachUntil(t *testing.T) {
+	start := uint32(1000000)
+	tw := newTimerWheel(start)
+	for i := uint32(0); i < 3000; i++ {
+		tw.add(timerRecord{hashVal: uint64(i), expireAt: start + i*i})
+	}
+	for now := start; now < start+50000; now += 997 {
+		tw.advance(now, func(rec timerRecord) {})
+		for _, d := range []uint32{1, 255, 256, 1000, 16384, 20000, 1 << 20, 3 << 20} {
+			want, got := 0, 0
+			tw.forEach(func(rec timerRecord) {
+				if rec.expireAt > now && rec.expireAt <= now+d {
+					want++
+				}
+			})
+			tw.forEachUntil(now+d, func(rec timerRecord) {
+				if rec.expireAt > now && rec.expireAt <= now+d {
+					got++
+				}
+			})
+			if got != want {
+				t.Fatalf("%d records expire within %d seconds after %d, expected %d", got, d, now-start, want)
+			}
+		}
+	}
+	count := 0
+	tw.forEach(func(rec timerRecord) { count++ })
+	if count != tw.count {
+		t.Error("count is", tw.count, "expected", count)
+	}
+}
+
+func TestTimerWheelRecords(t *testing.T) {
+	cache := NewCacheWithConfig(1024*1024, Config{TimerWheel: true, Segments: 1})
+	for i := 0; i < 10000; i++ {
+		cache.Set([]byte("key"), []byte("value"), 100+i)
+		cache.Set([]byte("deleted"), []byte("value"), 100+i)
+		cache.Del([]byte("deleted"))
+	}
+	if count := cache.segments[0].wheel.count; count > 2+wheelSlack {
+		t.Error("the wheel holds", count, "records for one entry")
+	}
+	if count := cache.ExpiringWithin(20000); count != 1 {
+		t.Error("expiring is", count, "expected", 1)
+	}
+	cache.Set([]byte("soon"), []byte("value"), 10)
+	if count := cache.ExpiringWithin(10); count != 1 {
+		t.Error("expiring is", count, "expected", 1)
+	}
+}
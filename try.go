@@ -0,0 +1,97 @@
+package freecache
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrBusy = errors.New("The segment of the key is locked by another operation")
+
+// TryGet is like Get, but returns ErrBusy instead of waiting if the segment of the key is locked,
+// so a latency-critical caller can skip the cache rather than queue behind a slow writer.
+// Only the lock of the segment of the key is tried, the chunks of a chunked value and the
+// overflow store are read like Get.
+func (cache *Cache) TryGet(key []byte) (value []byte, err error) {
+	if observe := cache.latency.Load(); observe != nil {
+		defer (*observe)(OpGet, time.Now())
+	}
+	entryKey := cache.entryKey(key)
+	seeds := cache.seeds.Load()
+	value, err = cache.tryGet(entryKey, seeds.cur.sipHash(entryKey), seeds.old == nil)
+	if err == ErrNotFound && seeds.old != nil {
+		value, err = cache.tryGet(entryKey, seeds.old.sipHash(entryKey), true)
+	}
+	if err == ErrBusy {
+		cache.countError(err)
+		return nil, err
+	}
+	if err == errChunked {
+		value, err = cache.getChunks(entryKey, value)
+	}
+	value, err = cache.overflowGet(entryKey, value, err)
+	return cache.decodeResult(key, entryKey, value, err)
+}
+
+// tryGet is getWithHash with the segment lock tried, ErrBusy is not counted as a lookup.
+func (cache *Cache) tryGet(key []byte, hashVal uint64, countMiss bool) (value []byte, err error) {
+	segId := hashVal & cache.segMask
+	if cache.config.SharedReads {
+		if value, err = cache.getShared(segId, key, hashVal, nil, true); err == ErrBusy {
+			return
+		}
+	}
+	if !cache.config.SharedReads || err == ErrCorrupted {
+		if !cache.locks[segId].TryLock() {
+			return nil, ErrBusy
+		}
+		err = cache.guarded(segId, func() (err error) {
+			value, err = cache.segments[segId].get(key, hashVal, nil)
+			return
+		})
+		cache.unlock(segId)
+	}
+	cache.countGet(segId, key, hashVal, err, countMiss)
+	return
+}
+
+// TrySet is like Set, but returns ErrBusy instead of waiting if the segment of the key is locked,
+// the entry is not set then. Only the lock of the segment of the key is tried: a value that is
+// chunked by ChunkLargeValues is set like Set, and while RotateHashSeed moves the entries, the
+// entry at the old position of the key is deleted like Set does.
+func (cache *Cache) TrySet(key, value []byte, expireSeconds int) (err error) {
+	key, value = cache.encodeEntry(key, value)
+	if cache.config.ChunkLargeValues && len(key)+len(value) > cache.maxKeyValLen() {
+		return cache.setChunked(key, len(value), &chunkSource{value: value}, expireSeconds, -1, 0, 0)
+	}
+	if observe := cache.latency.Load(); observe != nil {
+		defer (*observe)(OpSet, time.Now())
+	}
+	if expireSeconds, err = cache.tunables.Load().expireSeconds(expireSeconds); err != nil {
+		cache.countError(err)
+		return
+	}
+	seeds := cache.seeds.Load()
+	hashVal := seeds.cur.sipHash(key)
+	segId := hashVal & cache.segMask
+	if !cache.locks[segId].TryLock() {
+		cache.countError(ErrBusy)
+		return ErrBusy
+	}
+	var old []byte
+	var check func(seg *segment, hashVal uint64) error
+	if cache.config.ChunkLargeValues {
+		check = func(seg *segment, hashVal uint64) error {
+			old = seg.manifest(key, hashVal)
+			return nil
+		}
+	}
+	err = cache.setLocked(segId, key, value, hashVal, expireSeconds, -1, 0, 0, check)
+	if err == nil {
+		// the chunks of an overwritten chunked value are deleted.
+		cache.dropChunks(key, old)
+		if seeds.old != nil {
+			cache.delOld(key, seeds.old.sipHash(key))
+		}
+	}
+	return
+}
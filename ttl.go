@@ -0,0 +1,112 @@
+package freecache
+
+import "unsafe"
+
+// TTL returns the seconds left before the entry expires, zero if it doesn't expire.
+// ErrNotFound is returned if the entry doesn't exist.
+func (cache *Cache) TTL(key []byte) (timeLeft uint32, err error) {
+	err = cache.atEntry(cache.entryKey(key), func(seg *segment, key []byte, hashVal uint64) error {
+		hdr, _, err := seg.liveEntry(key, hashVal)
+		if err == nil && hdr.expireAt != 0 {
+			timeLeft = hdr.expireAt - seg.now()
+		}
+		return err
+	})
+	return
+}
+
+// Touch changes the expire seconds of the entry like Set does, without writing the value again.
+// ErrNotFound is returned if the entry doesn't exist. A chunked value is set again, since every
+// chunk has the expire time.
+func (cache *Cache) Touch(key []byte, expireSeconds int) (err error) {
+	seconds, err := cache.tunables.Load().expireSeconds(expireSeconds)
+	if err != nil {
+		cache.countError(err)
+		return
+	}
+	logged := cache.logged()
+	err = cache.atEntry(cache.entryKey(key), func(seg *segment, key []byte, hashVal uint64) error {
+		var expireAt uint32
+		if seconds > 0 {
+			expireAt = seg.now() + uint32(seconds)
+		}
+		value, err := seg.touch(key, hashVal, expireAt, logged)
+		if err == nil && logged {
+			cache.log(journalSet, key, value, expireAt)
+		}
+		return err
+	})
+	if err == errChunked {
+		var value []byte
+		if value, err = cache.Get(key); err == nil {
+			err = cache.Set(key, value, expireSeconds)
+		}
+	}
+	return
+}
+
+// atEntry calls fn with the segment of the entry key locked, at the position of the current hash
+// seed, and at the position of the old seed if the entry is not found while the seed is rotated.
+func (cache *Cache) atEntry(key []byte, fn func(seg *segment, key []byte, hashVal uint64) error) (err error) {
+	seeds := cache.seeds.Load()
+	err = cache.atEntryWithHash(key, seeds.cur.sipHash(key), fn)
+	if err == ErrNotFound && seeds.old != nil {
+		err = cache.atEntryWithHash(key, seeds.old.sipHash(key), fn)
+	}
+	return
+}
+
+func (cache *Cache) atEntryWithHash(key []byte, hashVal uint64, fn func(seg *segment, key []byte, hashVal uint64) error) (err error) {
+	segId := hashVal & cache.segMask
+	cache.locks[segId].Lock()
+	err = cache.guarded(segId, func() error {
+		return fn(&cache.segments[segId], key, hashVal)
+	})
+	cache.unlock(segId)
+	return
+}
+
+// liveEntry returns the header and the pointer of the entry, an expired entry is deleted.
+func (seg *segment) liveEntry(key []byte, hashVal uint64) (hdr entryHdr, ptr *entryPtr, err error) {
+	slotId := uint8(hashVal >> 8)
+	slotOff := int32(slotId) * seg.slotCap
+	slot := seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
+	idx, match := seg.lookup(slot, uint16(hashVal>>16), uint32(hashVal>>32), key)
+	if !match {
+		return hdr, nil, ErrNotFound
+	}
+	ptr = &slot[idx]
+	seg.rb.ReadAt((*[ENTRY_HDR_SIZE]byte)(unsafe.Pointer(&hdr))[:], ptr.offset)
+	if !seg.validHdr(&hdr, ptr, slotId) {
+		return hdr, nil, ErrCorrupted
+	}
+	if now := seg.now(); hdr.expireAt != 0 && hdr.expireAt <= now {
+		// an entry in its stale window is kept for GetStale, like by get.
+		if hdr.expireAt+seg.stale <= now {
+			seg.delExpiredEntry(&hdr, ptr.offset)
+		}
+		return hdr, nil, ErrNotFound
+	}
+	return
+}
+
+// touch sets the expire time of the entry in its header, and returns its value if withValue is
+// true. errChunked is returned for a chunked value, which is not changed.
+func (seg *segment) touch(key []byte, hashVal uint64, expireAt uint32, withValue bool) (value []byte, err error) {
+	hdr, ptr, err := seg.liveEntry(key, hashVal)
+	if err != nil {
+		return
+	}
+	if hdr.chunked() {
+		return nil, errChunked
+	}
+	if seg.wheel != nil && expireAt != 0 && hdr.expireAt != expireAt {
+		seg.addTimer(hashVal, expireAt)
+	}
+	hdr.expireAt = expireAt
+	seg.rb.WriteAt((*[ENTRY_HDR_SIZE]byte)(unsafe.Pointer(&hdr))[:], ptr.offset)
+	if withValue {
+		return seg.readValue(&hdr, ptr.offset, nil)
+	}
+	return
+}
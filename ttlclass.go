@@ -0,0 +1,80 @@
+package freecache
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+var ErrUnknownTTLClass = errors.New("The TTL class is not declared in the config")
+
+// MaxTTLClasses is the maximum number of TTL classes of a cache.
+const MaxTTLClasses = classMask >> classShift
+
+// TTLClass is a named expiration, such as "short" for 30 seconds, entries set in a class are
+// counted in the statistics of the class.
+type TTLClass struct {
+	Name          string
+	ExpireSeconds int
+}
+
+// TTLClassStat is the statistics of a TTL class, see TTLClassStats.
+type TTLClassStat struct {
+	Name string
+	Sets int64
+	Hits int64
+	// Misses is the number of lookups found the entry expired, a lookup of a key that
+	// is not in the cache is not counted in any class.
+	Misses int64
+	// Evictions is the number of entries evicted before they expired. Many evictions in a class
+	// means its entries don't live for their TTL, the cache is too small for it.
+	Evictions int64
+}
+
+type classCounters struct {
+	sets      int64
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+func ttlClassIds(classes []TTLClass) map[string]uint8 {
+	if len(classes) > MaxTTLClasses {
+		panic("freecache: too many TTL classes")
+	}
+	ids := make(map[string]uint8, len(classes))
+	for i, class := range classes {
+		if _, ok := ids[class.Name]; ok || class.ExpireSeconds <= 0 {
+			panic("freecache: invalid TTL class " + class.Name)
+		}
+		ids[class.Name] = uint8(i + 1)
+	}
+	return ids
+}
+
+// SetWithClass is like Set, but the entry expires with the TTL of the class declared in
+// Config.TTLClasses, and it is counted in the statistics of the class.
+func (cache *Cache) SetWithClass(key, value []byte, class string) (err error) {
+	id, ok := cache.classIds[class]
+	if !ok {
+		cache.countError(ErrUnknownTTLClass)
+		return ErrUnknownTTLClass
+	}
+	expireSeconds := cache.config.TTLClasses[id-1].ExpireSeconds
+	return cache.setKey(key, value, expireSeconds, -1, id<<classShift, 0)
+}
+
+// TTLClassStats returns the statistics of the TTL classes, in the order of Config.TTLClasses.
+func (cache *Cache) TTLClassStats() []TTLClassStat {
+	stats := make([]TTLClassStat, len(cache.config.TTLClasses))
+	for i := range stats {
+		stats[i].Name = cache.config.TTLClasses[i].Name
+		for j := 0; j < len(cache.segments); j++ {
+			counters := &cache.segments[j].classStats[i+1]
+			stats[i].Sets += atomic.LoadInt64(&counters.sets)
+			stats[i].Hits += atomic.LoadInt64(&counters.hits)
+			stats[i].Misses += atomic.LoadInt64(&counters.misses)
+			stats[i].Evictions += atomic.LoadInt64(&counters.evictions)
+		}
+	}
+	return stats
+}
@@ -0,0 +1,100 @@
+package freecache
+
+import (
+	"errors"
+	"math/rand"
+)
+
+var ErrInvalidTunables = errors.New("Invalid tunables")
+
+// InstrumentLevel controls which statistics are collected in the hot paths of the cache.
+type InstrumentLevel int
+
+const (
+	// InstrumentDefault is the same as InstrumentBasic.
+	InstrumentDefault InstrumentLevel = iota
+	// InstrumentNone does not update the hit, miss and error counters.
+	InstrumentNone
+	// InstrumentBasic updates the hit, miss and error counters.
+	InstrumentBasic
+	// InstrumentDetailed updates the basic counters, and the access age and entry size histograms.
+	InstrumentDetailed
+)
+
+// Tunables are the settings of a cache that can be changed at runtime by Reconfigure.
+// The zero value disables all of them.
+type Tunables struct {
+	// DefaultTTL is the expire seconds used when Set is called with zero expire seconds,
+	// zero means no default, negative expire seconds always mean no expire.
+	DefaultTTL int
+	// TTLJitter randomizes positive expire seconds by up to the fraction in both directions,
+	// so entries set at the same time don't expire at the same time. It must be in [0, 1).
+	TTLJitter float64
+	// MinTTL is the minimum expire seconds accepted by Set, zero means no minimum.
+	// Shorter positive expire seconds are raised to MinTTL, or rejected with ErrShortTTL
+	// if RejectShortTTL is true. Entries that never expire are not affected.
+	MinTTL         int
+	RejectShortTTL bool
+	// OccupancyTarget is the fraction of the ring buffers filled before old entries are evicted,
+	// it lowers the effective size of the cache. Zero means 1, the whole ring buffers are used.
+	OccupancyTarget float64
+	// Instrumentation is the level of statistics collected.
+	Instrumentation InstrumentLevel
+}
+
+func (t *Tunables) validate() error {
+	if t.DefaultTTL < 0 || t.MinTTL < 0 || t.TTLJitter < 0 || t.TTLJitter >= 1 ||
+		t.OccupancyTarget < 0 || t.OccupancyTarget > 1 ||
+		t.Instrumentation < InstrumentDefault || t.Instrumentation > InstrumentDetailed {
+		return ErrInvalidTunables
+	}
+	return nil
+}
+
+// Reconfigure validates the tunables and applies them to the cache atomically, operations
+// started after Reconfigure returns use the new tunables.
+func (cache *Cache) Reconfigure(tunables Tunables) error {
+	if err := tunables.validate(); err != nil {
+		return err
+	}
+	cache.tunables.Store(&tunables)
+	for i := 0; i < len(cache.segments); i++ {
+		cache.locks[i].Lock()
+		cache.segments[i].setOccupancyTarget(tunables.OccupancyTarget)
+		cache.segments[i].detailed = tunables.Instrumentation == InstrumentDetailed
+		cache.locks[i].Unlock()
+	}
+	return nil
+}
+
+// Tunables returns the current tunables of the cache.
+func (cache *Cache) Tunables() Tunables {
+	return *cache.tunables.Load()
+}
+
+// expireSeconds applies the default TTL, jitter and minimum TTL to the expire seconds passed to Set.
+func (t *Tunables) expireSeconds(expireSeconds int) (int, error) {
+	if expireSeconds == 0 {
+		expireSeconds = t.DefaultTTL
+	}
+	if expireSeconds <= 0 {
+		return 0, nil
+	}
+	if t.TTLJitter > 0 {
+		expireSeconds += int(float64(expireSeconds) * t.TTLJitter * (2*rand.Float64() - 1))
+		if expireSeconds < 1 {
+			expireSeconds = 1
+		}
+	}
+	if expireSeconds < t.MinTTL {
+		if t.RejectShortTTL {
+			return 0, ErrShortTTL
+		}
+		expireSeconds = t.MinTTL
+	}
+	return expireSeconds, nil
+}
+
+func (t *Tunables) instrumented() bool {
+	return t.Instrumentation != InstrumentNone
+}
@@ -0,0 +1,48 @@
+package freecache
+
+// Typed is a view of a cache that stores values of type V, encoded by a Codec. The keys are
+// encoded by the cache like the keys of Get and Set, e.g. a long key is replaced by its digest
+// with Config.LongKeys.
+type Typed[V any] struct {
+	cache *Cache
+	codec Codec[V]
+}
+
+// CodecCache is Typed, a cache of values encoded by a Codec.
+type CodecCache[V any] = Typed[V]
+
+// NewTyped creates a Typed view of cache, a nil codec means JSONCodec.
+func NewTyped[V any](cache *Cache, codec Codec[V]) *Typed[V] {
+	if codec == nil {
+		codec = JSONCodec[V]{}
+	}
+	return &Typed[V]{cache: cache, codec: codec}
+}
+
+// Cache returns the underlying cache.
+func (t *Typed[V]) Cache() *Cache {
+	return t.cache
+}
+
+// Get returns the decoded value of key, the error of the cache, or the error of the codec.
+func (t *Typed[V]) Get(key []byte) (value V, err error) {
+	data, err := t.cache.Get(key)
+	if err != nil {
+		return
+	}
+	return t.codec.Unmarshal(data)
+}
+
+// Set encodes the value and sets it, see Cache.Set.
+func (t *Typed[V]) Set(key []byte, value V, expireSeconds int) error {
+	data, err := t.codec.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return t.cache.Set(key, data, expireSeconds)
+}
+
+// Del deletes the value of key, it reports whether it was cached.
+func (t *Typed[V]) Del(key []byte) bool {
+	return t.cache.Del(key)
+}
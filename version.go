@@ -0,0 +1,122 @@
+package freecache
+
+import (
+	"encoding/binary"
+	"errors"
+	"unsafe"
+)
+
+var ErrVersionMismatch = errors.New("The version of the entry does not match")
+var ErrNoVersions = errors.New("The cache does not keep versions")
+
+// versionLen is the length of the version stored before the value with Config.Versions.
+const versionLen = 8
+
+// GetWithVersion is like Get, and also returns the version of the entry, see SetIfVersion.
+// It requires the Versions config.
+func (cache *Cache) GetWithVersion(key []byte) (value []byte, version uint64, err error) {
+	if !cache.config.Versions {
+		return nil, 0, ErrNoVersions
+	}
+	entryKey := cache.entryKey(key)
+	value, err = cache.get(entryKey, nil)
+	value, err = cache.overflowGet(entryKey, value, err)
+	if err == nil && len(value) >= versionLen {
+		version = binary.LittleEndian.Uint64(value)
+	}
+	value, err = cache.decodeResult(key, entryKey, value, err)
+	if err != nil {
+		version = 0
+	}
+	return
+}
+
+// SetIfVersion is like Set, but only sets the entry if the version of the entry of key is version,
+// so concurrent read-modify-write cycles can be serialized without comparing the old values.
+// ErrVersionMismatch is returned if the entry has changed since GetWithVersion returned version.
+// A key that is not in the cache has version zero, so SetIfVersion with version zero only sets
+// a new key. It requires the Versions config.
+//
+// While RotateHashSeed moves the entries, the version of a key that is not moved yet is read
+// before the segment of its new position is locked, so a concurrent Set of the key may be missed.
+func (cache *Cache) SetIfVersion(key, value []byte, expireSeconds int, version uint64) (err error) {
+	if !cache.config.Versions {
+		return ErrNoVersions
+	}
+	key, value = cache.encodeEntry(key, value)
+	seeds := cache.seeds.Load()
+	var oldVersion uint64
+	if seeds.old != nil {
+		hashVal := seeds.old.sipHash(key)
+		segId := hashVal & cache.segMask
+		cache.locks[segId].Lock()
+		oldVersion = cache.segments[segId].version(key, hashVal)
+		cache.locks[segId].Unlock()
+	}
+	check := func(seg *segment, hashVal uint64) error {
+		current := seg.version(key, hashVal)
+		if current == 0 {
+			current = oldVersion
+		}
+		if current != version {
+			return ErrVersionMismatch
+		}
+		return nil
+	}
+	err = cache.setWithHash(key, value, seeds.cur.sipHash(key), expireSeconds, -1, 0, 0, check)
+	if err == nil && seeds.old != nil {
+		cache.delOld(key, seeds.old.sipHash(key))
+	}
+	return
+}
+
+// appendVersionSpace returns value after the space for its version, the version is written by set.
+func (cache *Cache) appendVersionSpace(value []byte) []byte {
+	if !cache.config.Versions {
+		return value
+	}
+	stored := make([]byte, versionLen+len(value))
+	copy(stored[versionLen:], value)
+	return stored
+}
+
+// stripVersion returns the value of a stored value without its version.
+func (cache *Cache) stripVersion(value []byte) ([]byte, error) {
+	if !cache.config.Versions {
+		return value, nil
+	}
+	if len(value) < versionLen {
+		return nil, ErrCorrupted
+	}
+	return value[versionLen:], nil
+}
+
+// stampVersion writes the next version into the space before value. Every entry set gets a new
+// version, the first version is the creation time of the cache in nanoseconds, so versions are not
+// reused after a restart.
+func (seg *segment) stampVersion(value []byte) {
+	if seg.versions != nil && len(value) >= versionLen {
+		binary.LittleEndian.PutUint64(value, seg.versions.Add(1))
+	}
+}
+
+// version returns the version of the entry of key, zero if it is not found or expired.
+func (seg *segment) version(key []byte, hashVal uint64) uint64 {
+	slotId := uint8(hashVal >> 8)
+	slotOff := int32(slotId) * seg.slotCap
+	slot := seg.slotsData[slotOff : slotOff+seg.slotLens[slotId] : slotOff+seg.slotCap]
+	idx, match := seg.lookup(slot, uint16(hashVal>>16), uint32(hashVal>>32), key)
+	if !match {
+		return 0
+	}
+	ptr := &slot[idx]
+	var hdrBuf [ENTRY_HDR_SIZE]byte
+	seg.rb.ReadAt(hdrBuf[:], ptr.offset)
+	hdr := (*entryHdr)(unsafe.Pointer(&hdrBuf[0]))
+	if !seg.validHdr(hdr, ptr, slotId) || hdr.expireAt != 0 && hdr.expireAt <= seg.now() || hdr.valLen < versionLen {
+		return 0
+	}
+	var versionBuf [versionLen]byte
+	seg.rb.ReadAt(versionBuf[:], hdr.valOff(ptr.offset))
+	return binary.LittleEndian.Uint64(versionBuf[:])
+}
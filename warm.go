@@ -0,0 +1,99 @@
+package freecache
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var ErrUnknownFormat = errors.New("Unknown format")
+
+// Format is the format of the records loaded by Warm.
+type Format int
+
+const (
+	// FormatJSONLines is a JSON object per line with the key, the value and the expire seconds
+	// like Set, the key and the value are base64 encoded like []byte in encoding/json:
+	// {"key":"a2V5","value":"dmFsdWU=","ttl":60}
+	FormatJSONLines Format = iota
+	// FormatSnapshot is the format written by SaveTo. The snapshot must be saved by a cache with
+	// the same Compressor, Encryption, Checksum and LongKeys, the remaining TTL of the entries is kept.
+	FormatSnapshot
+)
+
+// warmRecord is a record of FormatJSONLines.
+type warmRecord struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+	TTL   int    `json:"ttl"`
+}
+
+// Warm sets the entries read from r in format, in batches that lock each segment once like
+// SetMulti, to prime a cache quickly at deploy time. It returns the number of entries set, the
+// entries the cache rejects, too large ones for example, are skipped. Loading stops at the first
+// malformed record.
+func (cache *Cache) Warm(r io.Reader, format Format) (loaded int, err error) {
+	switch format {
+	case FormatJSONLines:
+		return cache.warmJSONLines(r)
+	case FormatSnapshot:
+		return cache.warmSnapshot(r)
+	}
+	return 0, ErrUnknownFormat
+}
+
+func (cache *Cache) warmJSONLines(r io.Reader) (loaded int, err error) {
+	dec := json.NewDecoder(r)
+	entries := make([]Entry, 0, batchSize)
+	for {
+		var record warmRecord
+		if err = dec.Decode(&record); err != nil {
+			break
+		}
+		entries = append(entries, Entry{Key: record.Key, Value: record.Value, ExpireSeconds: record.TTL})
+		if len(entries) == batchSize {
+			n, _ := cache.setMulti(entries)
+			loaded += n
+			entries = entries[:0]
+		}
+	}
+	n, _ := cache.setMulti(entries)
+	loaded += n
+	if err == io.EOF {
+		err = nil
+	}
+	return
+}
+
+func (cache *Cache) warmSnapshot(r io.Reader) (loaded int, err error) {
+	snapshot, err := LoadCacheWithConfig(r, Config{
+		Compressor: cache.config.Compressor,
+		Encryption: cache.config.Encryption,
+		Checksum:   cache.config.Checksum,
+		LongKeys:   cache.config.LongKeys,
+		Clock:      cache.config.Clock,
+	})
+	if err != nil {
+		return
+	}
+	now := cache.now()
+	var entries []Entry
+	for i := range snapshot.segments {
+		entries = entries[:0]
+		snapshot.segments[i].iterate(now, nil, func(key, value []byte, hdr *entryHdr) bool {
+			key, value, err := snapshot.decodeEntry(key, value)
+			if err != nil {
+				return true
+			}
+			expireSeconds := -1
+			if hdr.expireAt != 0 {
+				expireSeconds = int(hdr.expireAt - now)
+			}
+			entries = append(entries, Entry{Key: key, Value: value, ExpireSeconds: expireSeconds})
+			return true
+		})
+		n, _ := cache.setMulti(entries)
+		loaded += n
+	}
+	return
+}
@@ -0,0 +1,29 @@
+package freecache
+
+import "sync/atomic"
+
+// watermark tracks the bytes of the ring buffers used by entries for Config.OnHighWatermark.
+type watermark struct {
+	segUsed []int64 // the used bytes of every segment last added to used, guarded by the lock of the segment.
+	used    atomic.Int64
+	above   atomic.Bool
+}
+
+// updateWatermark adds the change of the used bytes of the segment segId, which is locked, since
+// it was last updated. It returns whether the cache crossed the watermark, whether it is above it
+// now, and the used bytes and the size of the cache.
+func (cache *Cache) updateWatermark(segId uint64) (crossed, above bool, used, size int64) {
+	w := cache.watermark
+	seg := &cache.segments[segId]
+	segUsed := seg.rb.Size() - seg.vacuumLen
+	delta := segUsed - w.segUsed[segId]
+	if delta == 0 {
+		return
+	}
+	w.segUsed[segId] = segUsed
+	used = w.used.Add(delta)
+	size = cache.segSize.Load() * int64(len(cache.segments))
+	above = float64(used) >= cache.config.HighWatermark*float64(size)
+	crossed = w.above.CompareAndSwap(!above, above)
+	return
+}